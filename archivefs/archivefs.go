@@ -0,0 +1,193 @@
+// Package archivefs provides read-only fsync backends for tar and zip
+// archives, so that files can be synced out of an archive without
+// extracting it first.
+//
+// Importing the package registers the "tar" and "zip" URL schemes, whose
+// path is the local path of the archive, which is the root of the tree in
+// it. Tar archives may be compressed with gzip:
+//
+//	import _ "github.com/mostafah/fsync/archivefs"
+//
+//	err := fsync.Sync("site", "zip:///var/backups/site.zip")
+//
+// Only regular files and directories are read from archives. Directories
+// that are only implied by the paths of files get the modification time of
+// the archive.
+package archivefs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mostafah/fsync"
+)
+
+var (
+	ErrReadOnly = errors.New("archivefs: archives are read-only")
+)
+
+func init() {
+	fsync.Register("tar", Open)
+	fsync.Register("zip", Open)
+}
+
+// Open returns an FS for the archive at the path of u. Names passed to the
+// FS are under that path, as the path of u is.
+func Open(u *url.URL) (fsync.FS, error) {
+	var fs *FS
+	var err error
+	if u.Scheme == "zip" {
+		fs, err = OpenZip(u.Path)
+	} else {
+		fs, err = OpenTar(u.Path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fs.root = path.Clean("/" + u.Path)
+	return fs, nil
+}
+
+// FS is a read-only fsync.FS on an archive. Names are slash-separated paths
+// in the archive; "/" is its root.
+type FS struct {
+	root     string              // name of the root of the archive
+	entries  map[string]*entry   // by path relative to the root
+	children map[string][]string // names of entries by parent
+	modTime  time.Time           // of implied directories
+	closer   io.Closer           // closed by Close; may be nil
+}
+
+// entry is a file or directory in an archive.
+type entry struct {
+	info os.FileInfo
+	open func() (io.ReadCloser, error) // nil for directories
+}
+
+func newFS(modTime time.Time, closer io.Closer) *FS {
+	fs := &FS{
+		root:     "/",
+		entries:  make(map[string]*entry),
+		children: make(map[string][]string),
+		modTime:  modTime,
+		closer:   closer,
+	}
+	fs.entries["."] = &entry{info: dirInfo{".", modTime}}
+	return fs
+}
+
+// add adds the entry at the path p of the archive, and its parents if they
+// aren't in it.
+func (fs *FS) add(p string, e *entry) {
+	p = path.Clean(strings.TrimLeft(p, "/"))
+	if p == "." || p == ".." || strings.HasPrefix(p, "../") {
+		return
+	}
+	dir := path.Dir(p)
+	if fs.entries[dir] == nil {
+		fs.add(dir, &entry{info: dirInfo{path.Base(dir), fs.modTime}})
+	}
+	// a later entry replaces an earlier one, as it does when extracting
+	if fs.entries[p] == nil {
+		fs.children[dir] = append(fs.children[dir], path.Base(p))
+	}
+	fs.entries[p] = e
+}
+
+// sort sorts the children of directories by name, once they're all added.
+func (fs *FS) sort() {
+	for _, c := range fs.children {
+		sort.Strings(c)
+	}
+}
+
+// lookup returns the entry of name and its path relative to the root.
+func (fs *FS) lookup(op, name string) (*entry, string, error) {
+	p := path.Clean("/" + filepath.ToSlash(name))
+	rel := "."
+	if p != fs.root {
+		prefix := strings.TrimSuffix(fs.root, "/") + "/"
+		if !strings.HasPrefix(p, prefix) {
+			return nil, "", &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+		}
+		rel = p[len(prefix):]
+	}
+	e := fs.entries[rel]
+	if e == nil {
+		return nil, "", &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return e, rel, nil
+}
+
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	e, _, err := fs.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return e.info, nil
+}
+
+func (fs *FS) ReadDir(name string) ([]os.FileInfo, error) {
+	e, rel, err := fs.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.info.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: fmt.Errorf("not a directory")}
+	}
+	var infos []os.FileInfo
+	for _, c := range fs.children[rel] {
+		infos = append(infos, fs.entries[path.Join(rel, c)].info)
+	}
+	return infos, nil
+}
+
+func (fs *FS) Open(name string) (io.ReadCloser, error) {
+	e, _, err := fs.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if e.open == nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: fmt.Errorf("is a directory")}
+	}
+	return e.open()
+}
+
+// Close closes the archive.
+func (fs *FS) Close() error {
+	if fs.closer == nil {
+		return nil
+	}
+	return fs.closer.Close()
+}
+
+func (fs *FS) Create(name string) (io.WriteCloser, error)   { return nil, ErrReadOnly }
+func (fs *FS) MkdirAll(name string, perm os.FileMode) error { return ErrReadOnly }
+func (fs *FS) Remove(name string) error                     { return ErrReadOnly }
+func (fs *FS) RemoveAll(name string) error                  { return ErrReadOnly }
+func (fs *FS) Rename(oldname, newname string) error         { return ErrReadOnly }
+func (fs *FS) Chmod(name string, mode os.FileMode) error    { return ErrReadOnly }
+func (fs *FS) Chtimes(name string, atime, mtime time.Time) error {
+	return ErrReadOnly
+}
+
+// dirInfo is the os.FileInfo of a directory that isn't in the archive.
+type dirInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (i dirInfo) Name() string       { return i.name }
+func (i dirInfo) Size() int64        { return 0 }
+func (i dirInfo) Mode() os.FileMode  { return os.ModeDir | 0755 }
+func (i dirInfo) ModTime() time.Time { return i.modTime }
+func (i dirInfo) IsDir() bool        { return true }
+func (i dirInfo) Sys() interface{}   { return nil }
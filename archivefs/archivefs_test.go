@@ -0,0 +1,125 @@
+package archivefs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mostafah/fsync"
+)
+
+var files = []struct{ name, data string }{
+	{"c", "file c"},
+	{"a/b", "file b"},
+	{"a/d/e", "file e"},
+}
+
+func writeZip(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for _, file := range files {
+		w, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		io.WriteString(w, file.data)
+	}
+	return zw.Close()
+}
+
+func writeTar(name string, compress bool) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var w io.Writer = f
+	if compress {
+		zw := gzip.NewWriter(f)
+		defer zw.Close()
+		w = zw
+	}
+	tw := tar.NewWriter(w)
+	tt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "a/", Mode: 0700, ModTime: tt})
+	for _, file := range files {
+		tw.WriteHeader(&tar.Header{Name: file.name, Size: int64(len(file.data)), Mode: 0600, ModTime: tt})
+		io.WriteString(tw, file.data)
+	}
+	return tw.Close()
+}
+
+func testTree(dir string, t *testing.T) {
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.name))
+		if err != nil {
+			t.Error(err)
+		} else if string(data) != file.data {
+			t.Errorf("%s contains %q, should contain %q", file.name, data, file.data)
+		}
+	}
+}
+
+func TestArchives(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "archivefs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := writeZip(filepath.Join(dir, "a.zip")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTar(filepath.Join(dir, "a.tar"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTar(filepath.Join(dir, "a.tgz"), true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, u := range []string{"zip://" + dir + "/a.zip", "tar://" + dir + "/a.tar", "tar://" + dir + "/a.tgz"} {
+		dst := filepath.Join(dir, filepath.Base(u)+".out")
+		if err := fsync.Sync(dst, u); err != nil {
+			t.Fatalf("%s: %v", u, err)
+		}
+		testTree(dst, t)
+	}
+	// modes and times in tar headers are kept
+	fi, err := os.Stat(filepath.Join(dir, "a.tar.out/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0700 || !fi.ModTime().Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("a has mode %v and time %v", fi.Mode(), fi.ModTime())
+	}
+
+	// files of compressed archives can be read in any order
+	fs, err := OpenTar(filepath.Join(dir, "a.tgz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	for _, i := range []int{2, 0, 1, 2} {
+		r, err := fs.Open(files[i].name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || string(data) != files[i].data {
+			t.Errorf("reading %s: %q, %v", files[i].name, data, err)
+		}
+	}
+	if err := fs.Remove("c"); err != ErrReadOnly {
+		t.Errorf("expecting ErrReadOnly, got %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package archivefs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// OpenTar returns an FS for the tar archive name, which may be compressed
+// with gzip. The archive is read once to list its entries, which are then
+// read from their offsets. Compressed archives can only be read from the
+// start, so reading their files out of order is slow. The FS must be
+// closed.
+func OpenTar(name string) (*FS, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fs, err := newTar(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return fs, nil
+}
+
+func newTar(f *os.File) (*FS, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 2)
+	if _, err := f.ReadAt(magic, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	var s stream = plain{f}
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		s = &gzipped{f: f}
+	}
+	r, err := s.open(0, 1<<62)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	cr := &countReader{r: r}
+	tr := tar.NewReader(cr)
+	fs := newFS(fi.ModTime(), f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		// the data of the entry follows its headers
+		off, size := cr.n, hdr.Size
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			fs.add(strings.TrimSuffix(hdr.Name, "/"), &entry{info: hdr.FileInfo()})
+		case tar.TypeReg:
+			fs.add(hdr.Name, &entry{info: hdr.FileInfo(), open: func() (io.ReadCloser, error) {
+				return s.open(off, size)
+			}})
+		}
+	}
+	fs.sort()
+	return fs, nil
+}
+
+// countReader counts the bytes read from r.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// stream is the uncompressed contents of an archive.
+type stream interface {
+	// open returns a reader for the size bytes at off.
+	open(off, size int64) (io.ReadCloser, error)
+}
+
+// plain is an archive that isn't compressed.
+type plain struct {
+	f *os.File
+}
+
+func (p plain) open(off, size int64) (io.ReadCloser, error) {
+	return ioutil.NopCloser(io.NewSectionReader(p.f, off, size)), nil
+}
+
+// gzipped is an archive compressed with gzip. Files are usually opened in
+// the order they are stored, so the decompressor of the last one is kept
+// where it ended, to be used for the next one if it's further on.
+type gzipped struct {
+	f *os.File
+
+	mu  sync.Mutex
+	zr  *gzip.Reader // nil if none is free
+	pos int64        // offset of zr in the uncompressed contents
+}
+
+func (g *gzipped) open(off, size int64) (io.ReadCloser, error) {
+	g.mu.Lock()
+	zr, pos := g.zr, g.pos
+	g.zr = nil
+	g.mu.Unlock()
+	if zr == nil || pos > off {
+		var err error
+		zr, err = gzip.NewReader(io.NewSectionReader(g.f, 0, 1<<62))
+		if err != nil {
+			return nil, err
+		}
+		pos = 0
+	}
+	if _, err := io.CopyN(ioutil.Discard, zr, off-pos); err != nil {
+		return nil, err
+	}
+	return &gzipFile{g: g, zr: zr, pos: off, end: off + size}, nil
+}
+
+// gzipFile reads a file in a gzipped archive.
+type gzipFile struct {
+	g        *gzipped
+	zr       *gzip.Reader
+	pos, end int64
+	err      error // of zr, which can't be used again then
+}
+
+func (f *gzipFile) Read(p []byte) (int, error) {
+	if f.pos >= f.end {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > f.end-f.pos {
+		p = p[:f.end-f.pos]
+	}
+	n, err := f.zr.Read(p)
+	f.pos += int64(n)
+	if err == io.EOF && f.pos < f.end {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil && err != io.EOF {
+		f.err = err
+	}
+	return n, err
+}
+
+// Close gives the decompressor back for the next file.
+func (f *gzipFile) Close() error {
+	if f.zr == nil || f.err != nil {
+		return nil
+	}
+	f.g.mu.Lock()
+	f.g.zr, f.g.pos = f.zr, f.pos
+	f.g.mu.Unlock()
+	f.zr = nil
+	return nil
+}
@@ -0,0 +1,45 @@
+package archivefs
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// OpenZip returns an FS for the zip archive name. It must be closed.
+func OpenZip(name string) (*FS, error) {
+	zr, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		zr.Close()
+		return nil, err
+	}
+	fs := NewZip(&zr.Reader, fi.ModTime())
+	fs.closer = zr
+	return fs, nil
+}
+
+// NewZip returns an FS for the archive read by zr. Directories that aren't
+// in the archive get modTime.
+func NewZip(zr *zip.Reader, modTime time.Time) *FS {
+	fs := newFS(modTime, nil)
+	for _, f := range zr.File {
+		fi := f.FileInfo()
+		switch {
+		case fi.IsDir():
+			fs.add(strings.TrimSuffix(f.Name, "/"), &entry{info: fi})
+		case fi.Mode().IsRegular():
+			f := f
+			fs.add(f.Name, &entry{info: fi, open: func() (io.ReadCloser, error) {
+				return f.Open()
+			}})
+		}
+	}
+	fs.sort()
+	return fs
+}
@@ -0,0 +1,25 @@
+package fsync
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+)
+
+// tempPrefix starts the names of the files Atomic copies to.
+const tempPrefix = ".fsync-tmp-"
+
+// tempName returns a new name for copying to dst with Atomic, in the same
+// directory.
+func tempName(dst string) string {
+	b := make([]byte, 4)
+	_, err := rand.Read(b)
+	check(err)
+	return filepath.Join(filepath.Dir(dst), tempPrefix+hex.EncodeToString(b))
+}
+
+// isTemp returns true if name is one tempName returns.
+func isTemp(name string) bool {
+	return strings.HasPrefix(filepath.Base(name), tempPrefix)
+}
@@ -0,0 +1,47 @@
+package fsync
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// failRenameFS fails to rename files.
+type failRenameFS struct{ osFS }
+
+func (failRenameFS) Rename(oldname, newname string) error {
+	return errors.New("rename failed")
+}
+
+func TestAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(os.MkdirAll(dst, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("new a"), 0644))
+	check(ioutil.WriteFile(filepath.Join(dst, "a"), []byte("old a"), 0644))
+	check(os.Link(filepath.Join(dst, "a"), filepath.Join(dir, "link")))
+
+	s := NewSyncer()
+	s.Atomic = true
+	s.Delete = true
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "a"), []byte("new a"), t)
+	// the old file was replaced, not written over
+	testFile(filepath.Join(dir, "link"), []byte("old a"), t)
+	testDirContents(dst, 1, t)
+
+	// temporary files are removed on errors
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("newer a"), 0644))
+	s.DstFS = failRenameFS{}
+	if err := s.Sync(dst, src); err == nil {
+		t.Error("expecting an error")
+	}
+	testFile(filepath.Join(dst, "a"), []byte("new a"), t)
+	testDirContents(dst, 1, t)
+}
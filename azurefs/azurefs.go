@@ -0,0 +1,227 @@
+// Package azurefs provides a backend for fsync that stores files as block
+// blobs in an Azure Blob Storage container.
+//
+// Importing the package registers the "azblob" URL scheme. The host of the
+// URL is the container and its path is the prefix to sync to. The storage
+// account is given with the account query parameter or the
+// AZURE_STORAGE_ACCOUNT environment variable. For instance, to deploy a
+// static website:
+//
+//	import _ "github.com/mostafah/fsync/azurefs"
+//
+//	s := fsync.NewSyncer()
+//	s.Delete = true // delete orphaned blobs
+//	err := s.Sync("azblob://$web/?account=mysite", "build")
+//
+// Clients opened from URLs use the connection string in
+// AZURE_STORAGE_CONNECTION_STRING if set, then the shared key in
+// AZURE_STORAGE_KEY, and otherwise the default Azure credential chain.
+//
+// Files are compared by size and Content-MD5, which is set on upload, so
+// unchanged files are never downloaded.
+package azurefs
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/mostafah/fsync"
+	"github.com/mostafah/fsync/internal/objfs"
+)
+
+// BlockSize is the size of the blocks files are uploaded in.
+var BlockSize int64 = 8 << 20
+
+func init() {
+	fsync.Register("azblob", Open)
+}
+
+// New returns an FS that stores files in the container c. Names are used as
+// blob names, without the leading slash.
+func New(c *container.Client) fsync.FS {
+	return objfs.New(&store{c: c})
+}
+
+// Open returns an FS for the container in u. The path of u is not used.
+func Open(u *url.URL) (fsync.FS, error) {
+	name := u.Host
+	if s := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); s != "" {
+		c, err := container.NewClientFromConnectionString(s, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		return New(c), nil
+	}
+
+	account := u.Query().Get("account")
+	if account == "" {
+		account = os.Getenv("AZURE_STORAGE_ACCOUNT")
+	}
+	if account == "" {
+		return nil, errors.New("azurefs: no storage account given")
+	}
+	curl := fmt.Sprintf("https://%s.blob.core.windows.net/%s", account, name)
+	if key := os.Getenv("AZURE_STORAGE_KEY"); key != "" {
+		cred, err := container.NewSharedKeyCredential(account, key)
+		if err != nil {
+			return nil, err
+		}
+		c, err := container.NewClientWithSharedKeyCredential(curl, cred, nil)
+		if err != nil {
+			return nil, err
+		}
+		return New(c), nil
+	}
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, err
+	}
+	c, err := container.NewClient(curl, cred, nil)
+	if err != nil {
+		return nil, err
+	}
+	return New(c), nil
+}
+
+// store implements objfs.Store for a container.
+type store struct {
+	c *container.Client
+}
+
+func (s *store) Stat(key string) (objfs.Object, error) {
+	props, err := s.c.NewBlobClient(key).GetProperties(context.Background(), nil)
+	if err != nil {
+		return objfs.Object{}, notExist(err)
+	}
+	obj := objfs.Object{Key: key, Sum: props.ContentMD5}
+	if props.ContentLength != nil {
+		obj.Size = *props.ContentLength
+	}
+	if props.LastModified != nil {
+		obj.ModTime = *props.LastModified
+	}
+	return obj, nil
+}
+
+func (s *store) List(prefix string, recursive bool) ([]objfs.Object, error) {
+	var objs []objfs.Object
+	ctx := context.Background()
+	if recursive {
+		pager := s.c.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+			Prefix: &prefix,
+		})
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, item := range page.Segment.BlobItems {
+				objs = append(objs, object(item))
+			}
+		}
+		return objs, nil
+	}
+
+	pager := s.c.NewListBlobsHierarchyPager("/",
+		&container.ListBlobsHierarchyOptions{Prefix: &prefix})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range page.Segment.BlobPrefixes {
+			objs = append(objs, objfs.Object{Key: *p.Name})
+		}
+		for _, item := range page.Segment.BlobItems {
+			objs = append(objs, object(item))
+		}
+	}
+	return objs, nil
+}
+
+func (s *store) Get(key string) (io.ReadCloser, error) {
+	resp, err := s.c.NewBlobClient(key).DownloadStream(context.Background(), nil)
+	if err != nil {
+		return nil, notExist(err)
+	}
+	return resp.Body, nil
+}
+
+func (s *store) Put(key string, r io.Reader, size int64, sum []byte) error {
+	_, err := s.c.NewBlockBlobClient(key).UploadStream(context.Background(), r,
+		&blockblob.UploadStreamOptions{
+			BlockSize:   BlockSize,
+			HTTPHeaders: &blob.HTTPHeaders{BlobContentMD5: sum},
+		})
+	return err
+}
+
+func (s *store) Delete(key string) error {
+	_, err := s.c.NewBlobClient(key).Delete(context.Background(), nil)
+	if os.IsNotExist(notExist(err)) {
+		return nil
+	}
+	return err
+}
+
+// Copy starts a copy on the server and waits for it to finish.
+func (s *store) Copy(dst, src string) error {
+	ctx := context.Background()
+	b := s.c.NewBlobClient(dst)
+	resp, err := b.StartCopyFromURL(ctx, s.c.NewBlobClient(src).URL(), nil)
+	if err != nil {
+		return err
+	}
+	status := resp.CopyStatus
+	for status != nil && *status == blob.CopyStatusTypePending {
+		time.Sleep(time.Second)
+		props, err := b.GetProperties(ctx, nil)
+		if err != nil {
+			return err
+		}
+		status = props.CopyStatus
+	}
+	if status != nil && *status != blob.CopyStatusTypeSuccess {
+		return fmt.Errorf("azurefs: copying %s to %s: %s", src, dst, *status)
+	}
+	return nil
+}
+
+func (s *store) NewHash() hash.Hash {
+	return md5.New()
+}
+
+// object converts a blob listing item.
+func object(item *container.BlobItem) objfs.Object {
+	obj := objfs.Object{Key: *item.Name}
+	if p := item.Properties; p != nil {
+		obj.Sum = p.ContentMD5
+		if p.ContentLength != nil {
+			obj.Size = *p.ContentLength
+		}
+		if p.LastModified != nil {
+			obj.ModTime = *p.LastModified
+		}
+	}
+	return obj
+}
+
+// notExist converts "not found" errors to os.ErrNotExist.
+func notExist(err error) error {
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return os.ErrNotExist
+	}
+	return err
+}
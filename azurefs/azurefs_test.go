@@ -0,0 +1,257 @@
+package azurefs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/mostafah/fsync"
+)
+
+// fakeBlob is a blob of fakeAzure.
+type fakeBlob struct {
+	data []byte
+	md5  []byte // Content-MD5, if set
+	time time.Time
+}
+
+// fakeAzure serves the few Blob Storage requests store makes, for a single
+// container and with no authentication.
+type fakeAzure struct {
+	mu     sync.Mutex
+	blobs  map[string]*fakeBlob
+	blocks map[string][]byte // staged blocks by ID
+	copies int
+}
+
+func (s *fakeAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	q := r.URL.Query()
+	if len(parts) == 1 {
+		s.list(w, r)
+		return
+	}
+	name := parts[1]
+	blob := s.blobs[name]
+	switch {
+	case r.Method == "PUT" && q.Get("comp") == "block":
+		data, _ := ioutil.ReadAll(r.Body)
+		s.blocks[q.Get("blockid")] = data
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "PUT" && (q.Get("comp") == "blocklist" || r.Header.Get("X-Ms-Blob-Type") != ""):
+		blob := &fakeBlob{data: []byte{}, time: time.Now()}
+		if q.Get("comp") == "blocklist" {
+			var list struct {
+				Latest []string
+			}
+			if err := xml.NewDecoder(r.Body).Decode(&list); err != nil {
+				s.error(w, http.StatusBadRequest, "InvalidXmlDocument")
+				return
+			}
+			for _, id := range list.Latest {
+				blob.data = append(blob.data, s.blocks[id]...)
+				delete(s.blocks, id)
+			}
+		} else {
+			blob.data, _ = ioutil.ReadAll(r.Body)
+		}
+		if v := r.Header.Get("X-Ms-Blob-Content-Md5"); v != "" {
+			blob.md5, _ = base64.StdEncoding.DecodeString(v)
+		}
+		s.blobs[name] = blob
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "PUT" && r.Header.Get("X-Ms-Copy-Source") != "":
+		u, err := url.Parse(r.Header.Get("X-Ms-Copy-Source"))
+		if err != nil {
+			s.error(w, http.StatusBadRequest, "InvalidHeaderValue")
+			return
+		}
+		src := s.blobs[strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)[1]]
+		if src == nil {
+			s.error(w, http.StatusNotFound, "CannotVerifyCopySource")
+			return
+		}
+		s.blobs[name] = &fakeBlob{data: src.data, md5: src.md5, time: time.Now()}
+		s.copies++
+		w.Header().Set("X-Ms-Copy-Id", fmt.Sprint(s.copies))
+		w.Header().Set("X-Ms-Copy-Status", "success")
+		w.WriteHeader(http.StatusAccepted)
+	case blob == nil:
+		s.error(w, http.StatusNotFound, "BlobNotFound")
+	case r.Method == "HEAD" || r.Method == "GET":
+		w.Header().Set("Content-Length", fmt.Sprint(len(blob.data)))
+		w.Header().Set("Last-Modified", blob.time.UTC().Format(http.TimeFormat))
+		w.Header().Set("X-Ms-Blob-Type", "BlockBlob")
+		if blob.md5 != nil {
+			w.Header().Set("Content-Md5", base64.StdEncoding.EncodeToString(blob.md5))
+		}
+		if r.Method == "GET" {
+			w.Write(blob.data)
+		}
+	case r.Method == "DELETE":
+		delete(s.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		s.error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// list answers blob listings, flat or by hierarchy.
+func (s *fakeAzure) list(w http.ResponseWriter, r *http.Request) {
+	type props struct {
+		LastModified  string `xml:"Last-Modified"`
+		ContentLength int    `xml:"Content-Length"`
+		ContentMD5    string `xml:"Content-MD5,omitempty"`
+		BlobType      string
+	}
+	type item struct {
+		Name       string
+		Properties props
+	}
+	type prefix struct{ Name string }
+	var res struct {
+		XMLName  xml.Name `xml:"EnumerationResults"`
+		Blobs    []item   `xml:"Blobs>Blob"`
+		Prefixes []prefix `xml:"Blobs>BlobPrefix"`
+	}
+	pre, delim := r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter")
+	var names []string
+	for name := range s.blobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if !strings.HasPrefix(name, pre) {
+			continue
+		}
+		if i := strings.Index(name[len(pre):], delim); delim != "" && i >= 0 {
+			p := name[:len(pre)+i+1]
+			if !seen[p] {
+				seen[p] = true
+				res.Prefixes = append(res.Prefixes, prefix{p})
+			}
+			continue
+		}
+		blob := s.blobs[name]
+		res.Blobs = append(res.Blobs, item{name, props{
+			LastModified:  blob.time.UTC().Format(http.TimeFormat),
+			ContentLength: len(blob.data),
+			ContentMD5:    base64.StdEncoding.EncodeToString(blob.md5),
+			BlobType:      "BlockBlob",
+		}})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(res)
+}
+
+func (s *fakeAzure) error(w http.ResponseWriter, code int, errCode string) {
+	w.Header().Set("X-Ms-Error-Code", errCode)
+	w.WriteHeader(code)
+}
+
+func TestSync(t *testing.T) {
+	src, err := ioutil.TempDir(os.TempDir(), "azurefs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	if err := os.MkdirAll(filepath.Join(src, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "a/b"), []byte("file b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "c"), []byte("file c"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// small blocks so files are uploaded in several
+	defer func(n int64) { BlockSize = n }(BlockSize)
+	BlockSize = 4
+
+	srv := &fakeAzure{blobs: make(map[string]*fakeBlob), blocks: make(map[string][]byte)}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	c, err := container.NewClientWithNoCredential(ts.URL+"/container", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := New(c)
+
+	s := fsync.NewSyncer()
+	s.DstFS = fs
+	s.Delete = true
+	if err := s.Sync("/site", src); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"site/a/b": "file b", "site/c": "file c"} {
+		blob := srv.blobs[name]
+		if blob == nil {
+			t.Errorf("%s wasn't uploaded", name)
+			continue
+		}
+		if string(blob.data) != content {
+			t.Errorf("%s is %q, should be %q", name, blob.data, content)
+		}
+		if sum := md5.Sum(blob.data); !bytes.Equal(blob.md5, sum[:]) {
+			t.Errorf("%s has Content-MD5 %x, should be %x", name, blob.md5, sum)
+		}
+	}
+
+	// files are read back, and renamed with copies on the server
+	r, err := fs.Open("/site/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "file c" {
+		t.Errorf("read %q from site/c, should be %q", b, "file c")
+	}
+	if err := fs.Rename("/site/c", "/site/d"); err != nil {
+		t.Fatal(err)
+	}
+	if srv.copies != 1 {
+		t.Errorf("expecting a copy on the server, got %d", srv.copies)
+	}
+	if _, ok := srv.blobs["site/c"]; ok {
+		t.Errorf("site/c should be removed after the rename")
+	}
+
+	// a second sync deletes extra files, and compares the rest by MD5
+	if err := os.Remove(filepath.Join(src, "a/b")); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := s.SyncStats("/site", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"site/a/b", "site/d"} {
+		if _, ok := srv.blobs[name]; ok {
+			t.Errorf("%s should be deleted", name)
+		}
+	}
+	if blob := srv.blobs["site/c"]; blob == nil || string(blob.data) != "file c" || stats.Files != 1 {
+		t.Errorf("expecting site/c to be copied again, got %+v", stats)
+	}
+}
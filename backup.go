@@ -0,0 +1,60 @@
+package fsync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// backup moves dst, which is about to be overwritten or deleted, to its
+// backup with BackupDir or BackupSuffix, replacing an older one, and
+// returns true if it did.
+func (r *run) backup(dst string) bool {
+	if r.BackupDir == "" && r.BackupSuffix == "" {
+		return false
+	}
+	name := dst
+	if r.BackupDir != "" {
+		rel, err := filepath.Rel(r.dstRoot, dst)
+		check(err)
+		name = filepath.Join(r.backupDir(), rel)
+	}
+	name += r.BackupSuffix
+	if _, err := r.dfs.Stat(dst); os.IsNotExist(err) {
+		return false
+	}
+	r.mkdirAll(filepath.Dir(name))
+	check(r.dfs.RemoveAll(name))
+	check(r.dfs.Rename(dst, name))
+	return true
+}
+
+// backupDir returns the name of BackupDir in the destination file system.
+func (r *run) backupDir() string {
+	if filepath.IsAbs(r.BackupDir) {
+		return r.BackupDir
+	}
+	return filepath.Join(r.dstRoot, r.BackupDir)
+}
+
+// isBackup returns true if the destination name dst is a backup, DeleteTo
+// or a SumsFile kept with WriteSums, which Delete leaves alone.
+func (r *run) isBackup(dst string) bool {
+	if r.WriteSums && filepath.Base(dst) == SumsFile {
+		return true
+	}
+	if r.DeleteTo != "" && r.DeleteTo != Trash && dst == r.deleteTo() {
+		return true
+	}
+	if r.BackupDir != "" {
+		return dst == r.backupDir()
+	}
+	return r.BackupSuffix != "" && strings.HasSuffix(dst, r.BackupSuffix)
+}
+
+// discard backs up or removes dst.
+func (r *run) discard(dst string) {
+	if !r.backup(dst) {
+		check(r.dfs.RemoveAll(dst))
+	}
+}
@@ -0,0 +1,77 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestBackup(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "a"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a/b"), []byte("new b"), 0644))
+	check(os.MkdirAll(filepath.Join(dst, "a"), 0755))
+	check(ioutil.WriteFile(filepath.Join(dst, "a/b"), []byte("old b"), 0644))
+	check(ioutil.WriteFile(filepath.Join(dst, "a/c"), []byte("old c"), 0644))
+
+	s := NewSyncer()
+	s.Delete = true
+	s.BackupDir = "backup"
+	s.BackupSuffix = ".bak"
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "a/b"), []byte("new b"), t)
+	testFile(filepath.Join(dst, "backup/a/b.bak"), []byte("old b"), t)
+	testFile(filepath.Join(dst, "backup/a/c.bak"), []byte("old c"), t)
+	testDirContents(filepath.Join(dst, "a"), 1, t)
+	// the backups are left alone
+	check(s.Sync(dst, src))
+	testDirContents(filepath.Join(dst, "backup/a"), 2, t)
+
+	// backups next to the files
+	s.BackupDir = ""
+	s.BackupSuffix = "~"
+	check(ioutil.WriteFile(filepath.Join(src, "a/b"), []byte("newer b"), 0644))
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "a/b~"), []byte("new b"), t)
+	check(s.Sync(dst, src))
+	testDirContents(filepath.Join(dst, "a"), 2, t)
+}
+
+func TestDeleteTo(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(os.MkdirAll(filepath.Join(dst, "a"), 0755))
+	check(ioutil.WriteFile(filepath.Join(dst, "a/b"), []byte("file b"), 0644))
+
+	s := NewSyncer()
+	s.Delete = true
+	s.DeleteTo = "deleted"
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "deleted/a/b"), []byte("file b"), t)
+	testDirContents(dst, 1, t)
+	// names already taken get a number
+	check(os.MkdirAll(filepath.Join(dst, "a"), 0755))
+	check(s.Sync(dst, src))
+	testDirContents(filepath.Join(dst, "deleted"), 2, t)
+	testDirContents(filepath.Join(dst, "deleted/a.1"), 0, t)
+
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		return
+	}
+	t.Setenv("XDG_DATA_HOME", filepath.Join(dir, "data"))
+	s.DeleteTo = Trash
+	check(ioutil.WriteFile(filepath.Join(dst, "c"), []byte("file c"), 0644))
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dir, "data/Trash/files/c"), []byte("file c"), t)
+	testExistence(filepath.Join(dir, "data/Trash/info/c.trashinfo"), true, t)
+}
@@ -0,0 +1,379 @@
+package fsync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ConflictPolicy decides which side of SyncBoth wins when a path changed on
+// both since the last sync.
+type ConflictPolicy int
+
+const (
+	// NewerWins keeps the version modified last. Changes win over
+	// deletions.
+	NewerWins ConflictPolicy = iota
+	// SourceWins keeps the version in the source, even if it was deleted.
+	SourceWins
+	// DestWins keeps the version in the destination, even if it was
+	// deleted.
+	DestWins
+	// RenameBoth keeps both versions on both sides, renamed by
+	// ConflictName. Changes win over deletions.
+	RenameBoth
+)
+
+// Conflict is a path changed on both sides since the last SyncBoth.
+type Conflict struct {
+	Path     string      // relative to the roots, slash-separated
+	Src, Dst os.FileInfo // nil if deleted
+}
+
+// ConflictName returns the name a version of the file name is renamed to by
+// RenameBoth; side is "src" or "dst". The extension is kept, so that
+// "notes.txt" becomes "notes.conflict-src.txt".
+func ConflictName(name, side string) string {
+	ext := path.Ext(name)
+	if strings.HasPrefix(path.Base(name), ".") && path.Base(name) == ext {
+		ext = "" // a dotfile without extension
+	}
+	return strings.TrimSuffix(name, ext) + ".conflict-" + side + ext
+}
+
+// SyncBoth syncs dst and src with each other: changes made in either since
+// the last SyncBoth, including deletions, are made in the other. state is the
+// path of a local file where the trees are recorded after each sync, as a
+// State, so that changes can be told apart from files that are missing on
+// one side. Without it, as on the first sync, nothing is deleted. Paths changed on both sides
+// are resolved by OnConflict if set, and by Conflicts otherwise, unless both
+// ended up the same. Exclude, DryRun, Comparison and NoTimes apply as they do
+// to Sync, and Delete is ignored.
+func (s *Syncer) SyncBoth(dst, src, state string) error {
+	return s.syncBoth(dst, src, state, false)
+}
+
+// Sync3 syncs dst with src as a three-way merge with base, the path of a
+// local file recording the last state common to both, which Sync3 keeps up
+// to date. Changes made in src since then, including deletions, are made in
+// dst, but changes made only in dst are kept: files added there aren't
+// deleted and files deleted there aren't copied again. Paths changed on both
+// sides are resolved as by SyncBoth, except that the version in dst is kept
+// instead of being copied to src. Without base, as on the first sync,
+// nothing is deleted.
+func (s *Syncer) Sync3(dst, src, base string) error {
+	return s.syncBoth(dst, src, base, true)
+}
+
+// syncBoth does SyncBoth, or Sync3 if oneway is true.
+func (s *Syncer) syncBoth(dst, src, state string, oneway bool) (err error) {
+	fwd, dst, src, err := s.newRun(dst, src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := fwd.close(); err == nil {
+			err = err2
+		}
+	}()
+	if err := checkPatterns(s.Exclude, s.Include); err != nil {
+		return err
+	}
+	b := &bisync{fwd: fwd, dst: dst, src: src, oneway: oneway}
+	b.bwd = &run{Syncer: s, ctx: fwd.ctx, dfs: fwd.sfs, sfs: fwd.dfs, cmp: fwd.cmp,
+		window: fwd.window, DryRun: fwd.DryRun, dryDirs: make(map[string]bool)}
+	if b.state, err = LoadState(state); err != nil {
+		return err
+	}
+	if oneway {
+		// a missing source isn't one with everything deleted
+		if _, err := fwd.sfs.Stat(src); err != nil {
+			return err
+		}
+	}
+
+	fwd.start(src)
+	defer fwd.finish()
+	b.bwd.start(dst)
+	defer b.bwd.finish()
+	err = fwd.scan(b.sync)
+	if err2 := b.bwd.scan(func() {}); err == nil {
+		err = err2
+	}
+	if err != nil || s.DryRun {
+		return err
+	}
+	if err := catch(b.record); err != nil {
+		return err
+	}
+	return b.state.Save(state)
+}
+
+// bisync holds the state of a call to SyncBoth or Sync3. fwd copies from
+// the source to the destination and bwd the other way.
+type bisync struct {
+	fwd, bwd *run
+	dst, src string
+	state    *State
+	oneway   bool     // for Sync3; bwd doesn't copy
+	kept     []string // paths left as they are in the destination by Sync3
+}
+
+// list returns the files and directories in the source of r under root, by
+// slash-separated path relative to it, leaving out the excluded ones.
+func (r *run) list(root string) map[string]os.FileInfo {
+	infos := make(map[string]os.FileInfo)
+	var walk func(dir, rel string)
+	walk = func(dir, rel string) {
+		files, err := r.sfs.ReadDir(dir)
+		check(err)
+		for _, fi := range files {
+			name := filepath.Join(dir, fi.Name())
+			if r.excluded(name, fi) && !r.descend(name, fi) {
+				continue
+			}
+			p := path.Join(rel, fi.Name())
+			infos[p] = fi
+			if fi.IsDir() {
+				walk(name, p)
+			}
+		}
+	}
+	fi, err := r.sfs.Stat(root)
+	if os.IsNotExist(err) {
+		return infos
+	}
+	check(err)
+	if fi.IsDir() {
+		walk(root, "")
+	}
+	return infos
+}
+
+// changed returns true if the file or directory fi, at p in the source of
+// r, changed since it was recorded as e, last modified at t.
+func (r *run) changed(p string, fi os.FileInfo, e *StateEntry, t time.Time) bool {
+	switch {
+	case e == nil || fi == nil:
+		return e != nil || fi != nil
+	case fi.IsDir() || e.Dir:
+		return fi.IsDir() != e.Dir
+	case fi.Size() != e.Size:
+		return true
+	case r.sameTime(fi.ModTime(), t):
+		return false
+	}
+	// only touched if the contents are the same
+	sum := hashFile(r.sfs, filepath.Join(r.root, filepath.FromSlash(p)), sha256.New())
+	return hex.EncodeToString(sum) != e.Hash
+}
+
+// sync makes the changes on each side on the other.
+func (b *bisync) sync() {
+	if !b.fwd.DryRun {
+		b.fwd.mkdirAll(b.dst)
+		if !b.oneway {
+			b.bwd.mkdirAll(b.src)
+		}
+	}
+	srcs := b.fwd.list(b.src)
+	dsts := b.bwd.list(b.dst)
+	all := make(map[string]bool)
+	for p := range srcs {
+		all[p] = true
+	}
+	for p := range dsts {
+		all[p] = true
+	}
+	var paths []string
+	srcChanged := make(map[string]bool)
+	dstChanged := make(map[string]bool)
+	for p := range all {
+		paths = append(paths, p)
+		e := b.state.Files[p]
+		var st, dt time.Time
+		if e != nil {
+			st, dt = e.SrcTime, e.DstTime
+		}
+		srcChanged[p] = b.fwd.changed(p, srcs[p], e, st)
+		dstChanged[p] = b.bwd.changed(p, dsts[p], e, dt)
+	}
+	sort.Strings(paths)
+
+	// changedIn returns true if p or anything in it changed on a side
+	changedIn := func(changed map[string]bool, p string) bool {
+		for q := range changed {
+			if changed[q] && (q == p || strings.HasPrefix(q, p+"/")) {
+				return true
+			}
+		}
+		return false
+	}
+	done := "" // directory synced as a whole
+	for _, p := range paths {
+		if done != "" && strings.HasPrefix(p, done+"/") {
+			continue
+		}
+		si, di := srcs[p], dsts[p]
+		sc, dc := srcChanged[p], dstChanged[p]
+		// deletions lose to changes inside deleted directories
+		if si == nil && sc && !dc && changedIn(dstChanged, p) {
+			sc, dc = false, true
+		} else if di == nil && dc && !sc && changedIn(srcChanged, p) {
+			sc, dc = true, false
+		}
+		var whole bool
+		switch {
+		case sc && dc:
+			whole = b.conflict(p, si, di)
+		case sc:
+			whole = b.push(b.fwd, b.dst, p, si, di)
+		case dc && b.oneway:
+			whole = b.keep(p)
+		case dc:
+			whole = b.push(b.bwd, b.src, p, di, si)
+		}
+		if whole {
+			done = p
+		}
+	}
+}
+
+// push makes p on the other side of r, under root, like fi on its source
+// side; other is the file on the other side. It returns true if p was
+// handled as a whole, including what's in it.
+func (b *bisync) push(r *run, root, p string, fi, other os.FileInfo) bool {
+	to := filepath.Join(root, filepath.FromSlash(p))
+	from := filepath.Join(r.root, filepath.FromSlash(p))
+	if fi == nil {
+		if other != nil {
+			r.stats.Deleted++
+			r.emit(Verbose, from, Event{Op: OpDelete, Item: itemDeleting})
+			if !r.DryRun {
+				check(r.dfs.RemoveAll(to))
+			}
+		}
+		return true
+	}
+	if fi.IsDir() && other != nil && other.IsDir() {
+		return false // what's in it is synced on its own
+	}
+	r.sync(to, from)
+	return true
+}
+
+// keep leaves p as it is in the destination, for Sync3, and returns true.
+func (b *bisync) keep(p string) bool {
+	b.kept = append(b.kept, p)
+	b.fwd.emit(Trace, filepath.Join(b.src, filepath.FromSlash(p)), Event{Op: OpSkip, Reason: DestChanged})
+	return true
+}
+
+// isKept returns true if p, or a directory it's in, was passed to keep.
+func (b *bisync) isKept(p string) bool {
+	for _, k := range b.kept {
+		if p == k || strings.HasPrefix(p, k+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// conflict resolves a conflict at p and returns true if p was handled as a
+// whole.
+func (b *bisync) conflict(p string, si, di os.FileInfo) bool {
+	switch {
+	case si == nil && di == nil:
+		return true
+	case si != nil && di != nil && si.IsDir() && di.IsDir():
+		return false
+	case si != nil && di != nil && !si.IsDir() && !di.IsDir() &&
+		b.fwd.equal(filepath.Join(b.dst, filepath.FromSlash(p)), filepath.Join(b.src, filepath.FromSlash(p))):
+		return true // both changed the same way
+	}
+	b.fwd.emit(Verbose, filepath.Join(b.src, filepath.FromSlash(p)), Event{Op: OpConflict})
+	policy := b.fwd.Conflicts
+	if b.fwd.OnConflict != nil {
+		policy = b.fwd.OnConflict(Conflict{Path: p, Src: si, Dst: di})
+	}
+	srcWins := true
+	switch policy {
+	case NewerWins, RenameBoth:
+		if si == nil || di != nil && di.ModTime().After(si.ModTime()) {
+			srcWins = false
+		}
+		if policy == RenameBoth && si != nil && di != nil {
+			b.renameBoth(p)
+			return true
+		}
+	case DestWins:
+		srcWins = false
+	}
+	switch {
+	case srcWins:
+		return b.push(b.fwd, b.dst, p, si, di)
+	case b.oneway:
+		return b.keep(p)
+	}
+	return b.push(b.bwd, b.src, p, di, si)
+}
+
+// renameBoth renames the versions of p on each side by ConflictName and
+// copies them to the other. For Sync3, only the version in the destination
+// is renamed, and the one in the source is copied as p.
+func (b *bisync) renameBoth(p string) {
+	ps, pd := ConflictName(p, "src"), ConflictName(p, "dst")
+	name := func(root, p string) string { return filepath.Join(root, filepath.FromSlash(p)) }
+	if b.oneway {
+		if !b.fwd.DryRun {
+			check(b.fwd.dfs.Rename(name(b.dst, p), name(b.dst, pd)))
+		}
+		b.fwd.sync(name(b.dst, p), name(b.src, p))
+		return
+	}
+	if !b.fwd.DryRun {
+		check(b.fwd.sfs.Rename(name(b.src, p), name(b.src, ps)))
+		check(b.fwd.dfs.Rename(name(b.dst, p), name(b.dst, pd)))
+	}
+	b.fwd.sync(name(b.dst, ps), name(b.src, ps))
+	b.bwd.sync(name(b.src, pd), name(b.dst, pd))
+}
+
+// record records the trees as they are after the sync. Paths kept by Sync3
+// keep their last common state.
+func (b *bisync) record() {
+	srcs := b.fwd.list(b.src)
+	dsts := b.bwd.list(b.dst)
+	entries := make(map[string]*StateEntry)
+	for p, e := range b.state.Files {
+		if b.isKept(p) {
+			entries[p] = e
+		}
+	}
+	for p, si := range srcs {
+		di := dsts[p]
+		if b.isKept(p) {
+			continue
+		}
+		if di == nil || si.IsDir() != di.IsDir() {
+			continue // not synced; new on the next sync
+		}
+		e := &StateEntry{Dir: si.IsDir(), SrcTime: si.ModTime(), DstTime: di.ModTime()}
+		if !e.Dir {
+			e.Size = si.Size()
+			if old := b.state.Files[p]; old != nil && old.Size == e.Size && old.SrcTime.Equal(e.SrcTime) {
+				e.Hash = old.Hash
+			} else {
+				sum := hashFile(b.fwd.sfs, filepath.Join(b.src, filepath.FromSlash(p)), sha256.New())
+				e.Hash = hex.EncodeToString(sum)
+			}
+		}
+		entries[p] = e
+	}
+	b.state.Files = entries
+}
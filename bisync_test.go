@@ -0,0 +1,136 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSyncBoth(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	state := filepath.Join(dir, "state")
+	write := func(name, data string, mtime time.Time) {
+		check(os.MkdirAll(filepath.Dir(name), 0755))
+		check(ioutil.WriteFile(name, []byte(data), 0644))
+		check(os.Chtimes(name, mtime, mtime))
+	}
+	tt := time.Now().Add(-time.Hour)
+	write(filepath.Join(src, "a"), "file a", tt)
+	write(filepath.Join(src, "d/e"), "file e", tt)
+	write(filepath.Join(dst, "b"), "file b", tt)
+
+	// without a state, nothing is deleted
+	s := NewSyncer()
+	check(s.SyncBoth(dst, src, state))
+	for _, root := range []string{src, dst} {
+		testFile(filepath.Join(root, "a"), []byte("file a"), t)
+		testFile(filepath.Join(root, "b"), []byte("file b"), t)
+		testFile(filepath.Join(root, "d/e"), []byte("file e"), t)
+	}
+
+	// changes go both ways
+	write(filepath.Join(dst, "a"), "file a changed", tt.Add(time.Minute))
+	check(os.Remove(filepath.Join(src, "b")))
+	check(s.SyncBoth(dst, src, state))
+	testFile(filepath.Join(src, "a"), []byte("file a changed"), t)
+	testDirContents(dst, 2, t)
+
+	// the newer change wins
+	write(filepath.Join(src, "a"), "file a from src", tt.Add(2*time.Minute))
+	write(filepath.Join(dst, "a"), "file a from dst", tt.Add(3*time.Minute))
+	check(s.SyncBoth(dst, src, state))
+	testFile(filepath.Join(src, "a"), []byte("file a from dst"), t)
+	testFile(filepath.Join(dst, "a"), []byte("file a from dst"), t)
+
+	// changes in deleted directories are kept
+	check(os.RemoveAll(filepath.Join(src, "d")))
+	write(filepath.Join(dst, "d/e"), "file e changed", tt.Add(time.Minute))
+	check(s.SyncBoth(dst, src, state))
+	testFile(filepath.Join(src, "d/e"), []byte("file e changed"), t)
+
+	// both versions are kept
+	s.Conflicts = RenameBoth
+	write(filepath.Join(src, "a"), "file a from src", tt.Add(4*time.Minute))
+	write(filepath.Join(dst, "a"), "file a from dst again", tt.Add(5*time.Minute))
+	check(s.SyncBoth(dst, src, state))
+	for _, root := range []string{src, dst} {
+		testFile(filepath.Join(root, "a.conflict-src"), []byte("file a from src"), t)
+		testFile(filepath.Join(root, "a.conflict-dst"), []byte("file a from dst again"), t)
+		if _, err := os.Stat(filepath.Join(root, "a")); !os.IsNotExist(err) {
+			t.Errorf("a should be renamed in %s", root)
+		}
+	}
+
+	// the callback decides
+	s.OnConflict = func(c Conflict) ConflictPolicy {
+		if c.Path != "d/e" || c.Src != nil {
+			t.Errorf("wrong conflict: %+v", c)
+		}
+		return SourceWins
+	}
+	check(os.Remove(filepath.Join(src, "d/e")))
+	write(filepath.Join(dst, "d/e"), "file e changed again", tt.Add(2*time.Minute))
+	check(s.SyncBoth(dst, src, state))
+	testDirContents(filepath.Join(dst, "d"), 0, t)
+}
+
+func TestSync3(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	base := filepath.Join(dir, "base")
+	write := func(name, data string, mtime time.Time) {
+		check(os.MkdirAll(filepath.Dir(name), 0755))
+		check(ioutil.WriteFile(name, []byte(data), 0644))
+		check(os.Chtimes(name, mtime, mtime))
+	}
+	tt := time.Now().Add(-time.Hour)
+	write(filepath.Join(src, "a"), "file a", tt)
+	write(filepath.Join(src, "b"), "file b", tt)
+	write(filepath.Join(src, "c"), "file c", tt)
+	s := NewSyncer()
+	check(s.Sync3(dst, src, base))
+	testDirContents(dst, 3, t)
+
+	// deletions in the source are made, changes in the destination kept
+	check(os.Remove(filepath.Join(src, "b")))
+	check(os.Remove(filepath.Join(dst, "a")))
+	write(filepath.Join(dst, "new"), "new file", tt)
+	write(filepath.Join(dst, "c"), "file c in dst", tt.Add(2*time.Minute))
+	for i := 0; i < 2; i++ {
+		check(s.Sync3(dst, src, base))
+		testFile(filepath.Join(dst, "new"), []byte("new file"), t)
+		testFile(filepath.Join(dst, "c"), []byte("file c in dst"), t)
+		testDirContents(dst, 2, t)
+	}
+
+	// the newer change wins
+	write(filepath.Join(src, "c"), "file c in src", tt.Add(time.Minute))
+	check(s.Sync3(dst, src, base))
+	testFile(filepath.Join(dst, "c"), []byte("file c in dst"), t)
+	write(filepath.Join(src, "c"), "file c in src", tt.Add(3*time.Minute))
+	check(s.Sync3(dst, src, base))
+	testFile(filepath.Join(dst, "c"), []byte("file c in src"), t)
+	testFile(filepath.Join(src, "c"), []byte("file c in src"), t)
+	testDirContents(src, 2, t)
+}
+
+func TestConflictName(t *testing.T) {
+	for name, want := range map[string]string{
+		"a":         "a.conflict-src",
+		"b/c.txt":   "b/c.conflict-src.txt",
+		"b/.config": "b/.config.conflict-src",
+	} {
+		if got := ConflictName(name, "src"); got != want {
+			t.Errorf("%s: expecting %s, got %s", name, want, got)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package fsync
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// boosts holds the paths passed to Syncer.Boost during a run.
+type boosts struct {
+	mu    sync.Mutex
+	paths []string // relative to the source of the run
+	gen   int      // incremented by each Boost
+}
+
+// Boost asks the syncs in progress to handle paths, relative to their
+// source, before anything else: pending copies of files under them move to
+// the front of the queue of Workers, and they are scanned before their
+// siblings that haven't been scanned yet. It's meant to be called while Sync
+// runs in another goroutine, e.g. when a user asks for a file that hasn't
+// been synced yet. Boosts last until the end of the sync.
+func (s *Syncer) Boost(paths ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for r := range s.runs {
+		r.boost(paths)
+	}
+}
+
+// start starts the workers of r, if any, and registers it as in progress,
+// so it can be boosted, tuned and reported by Status.
+func (r *run) start(root string) {
+	r.root = root
+	r.progress.begin(root, r.clock().Now())
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Workers > 0 {
+		r.startWorkers(r.Workers)
+	}
+	r.limit.rate = r.RateLimit
+	r.limit.clock = r.clock()
+	r.verbosity = int32(r.Verbosity)
+	if r.runs == nil {
+		r.runs = make(map[*run]bool)
+	}
+	r.runs[r] = true
+}
+
+// finish undoes start.
+func (r *run) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.runs, r)
+}
+
+// boost adds paths to the boosted paths of r and reorders its queue.
+func (r *run) boost(paths []string) {
+	r.boosts.mu.Lock()
+	for _, p := range paths {
+		p = filepath.Clean(filepath.FromSlash(strings.TrimLeft(p, "/")))
+		r.boosts.paths = append(r.boosts.paths, p)
+	}
+	r.boosts.gen++
+	r.boosts.mu.Unlock()
+	if q := r.jobs; q != nil {
+		q.mu.Lock()
+		q.promote(r.boosted)
+		q.mu.Unlock()
+	}
+}
+
+// boosted returns true if the source name src is under a boosted path.
+func (r *run) boosted(src string) bool {
+	r.boosts.mu.Lock()
+	defer r.boosts.mu.Unlock()
+	rel, err := filepath.Rel(r.root, src)
+	if err != nil {
+		return false
+	}
+	for _, p := range r.boosts.paths {
+		if p == "." || within(rel, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// boostOrder sorts the entries of the source directory dir so that those
+// that are boosted or contain a boosted path come first. gen is the result
+// of the last call for the same entries, which are only sorted again if
+// Boost was called since.
+func (r *run) boostOrder(dir string, files []os.FileInfo, gen int) int {
+	r.boosts.mu.Lock()
+	defer r.boosts.mu.Unlock()
+	if gen == r.boosts.gen || len(r.boosts.paths) == 0 {
+		return r.boosts.gen
+	}
+	rel, err := filepath.Rel(r.root, dir)
+	if err != nil {
+		return r.boosts.gen
+	}
+	first := func(fi os.FileInfo) bool {
+		name := filepath.Join(rel, fi.Name())
+		for _, p := range r.boosts.paths {
+			if within(name, p) || within(p, name) {
+				return true
+			}
+		}
+		return false
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return first(files[i]) && !first(files[j])
+	})
+	return r.boosts.gen
+}
+
+// within returns true if the relative path name is dir or is in it.
+func within(name, dir string) bool {
+	return name == dir || strings.HasPrefix(name, dir+string(filepath.Separator))
+}
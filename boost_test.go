@@ -0,0 +1,53 @@
+package fsync
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// boostFS records the files created in it and boosts a path when the first
+// one is.
+type boostFS struct {
+	FS
+	s       *Syncer
+	path    string
+	created []string
+}
+
+func (fs *boostFS) Create(name string) (io.WriteCloser, error) {
+	if len(fs.created) == 0 {
+		fs.s.Boost(fs.path)
+	}
+	fs.created = append(fs.created, filepath.Base(filepath.Dir(name)))
+	return fs.FS.Create(name)
+}
+
+func TestBoost(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	for i := 0; i < 5; i++ {
+		sub := filepath.Join(src, fmt.Sprint(i))
+		check(os.MkdirAll(sub, 0755))
+		check(ioutil.WriteFile(filepath.Join(sub, "f"), []byte(sub), 0644))
+	}
+
+	s := NewSyncer()
+	fs := &boostFS{FS: OS, s: s, path: "/4/f"}
+	s.DstFS = fs
+	check(s.Sync(filepath.Join(dir, "dst"), src))
+	if got := fmt.Sprint(fs.created); got != "[0 4 1 2 3]" {
+		t.Errorf("expecting 4 to be synced second, got %s.\n", got)
+	}
+
+	q := &queue{jobs: []job{{"d", "a"}, {"d", "b"}, {"d", "c"}, {"d", "b2"}}}
+	q.promote(func(src string) bool { return src[0] == 'b' })
+	if got := fmt.Sprint(q.jobs); got != "[{d b} {d b2} {d a} {d c}]" {
+		t.Errorf("wrong queue order %s.\n", got)
+	}
+}
@@ -0,0 +1,61 @@
+package fsync
+
+// queuedFileSize is about how much memory a file queued for the workers
+// takes, and recordSize one recorded for StateFile, DetectRenames or
+// MaxDelete.
+const (
+	queuedFileSize = 256
+	recordSize     = 256
+)
+
+// queueLimit returns how many files the scan may get ahead of the workers,
+// within MemoryBudget.
+func (r *run) queueLimit() int {
+	if r.MemoryBudget <= 0 {
+		return queueSize
+	}
+	n := r.MemoryBudget / 4 / queuedFileSize
+	if n < 1 {
+		return 1
+	} else if n > queueSize {
+		return queueSize
+	}
+	return int(n)
+}
+
+// maxBuffers returns how many copy buffers of size bytes may be in use at
+// once, as MaxBuffers or MemoryBudget allow, or 0 for no limit.
+func (r *run) maxBuffers(size int) int {
+	if r.MaxBuffers > 0 || r.MemoryBudget <= 0 {
+		return r.MaxBuffers
+	}
+	if n := r.MemoryBudget / 4 / int64(size); n > 1 {
+		return int(n)
+	}
+	return 1
+}
+
+// deltaFits returns true if the checksums of a destination file of size
+// bytes, which updating it with DeltaMinSize needs, fit in MemoryBudget.
+func (r *run) deltaFits(size int64) bool {
+	if r.MemoryBudget <= 0 {
+		return true
+	}
+	blocks := size / int64(blockSize(size))
+	// per block, an offset in weak and an entry in strong, with map overhead
+	return blocks*96+2*maxLiteral <= r.MemoryBudget/4
+}
+
+// recordLimit returns how many files each of the old and new states of
+// StateFile, the files DetectRenames looks for and the deletions left for
+// the end may keep in memory, within MemoryBudget, or 0 for no limit.
+func (r *run) recordLimit() int {
+	if r.MemoryBudget <= 0 {
+		return 0
+	}
+	// the four share a quarter of the budget
+	if n := r.MemoryBudget / 16 / recordSize; n > 1 {
+		return int(n)
+	}
+	return 1
+}
@@ -0,0 +1,164 @@
+package fsync
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	for i := 0; i < 20; i++ {
+		check(ioutil.WriteFile(filepath.Join(src, fmt.Sprint(i)), []byte("file"), 0644))
+	}
+
+	var mu sync.Mutex
+	var last Progress
+	s := NewSyncer()
+	s.Workers = 4
+	s.BufferSize = 1000
+	s.MemoryBudget = 1000
+	// changed on the way, so that files are copied through buffers
+	s.LineEndings = UnixLineEndings
+	s.OnProgress = func(p Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		if p.Files > last.Files {
+			last = p
+		}
+	}
+	check(s.Sync(dst, src))
+	testDirContents(dst, 20, t)
+	if last.Buffers != 1 {
+		t.Errorf("expecting a single buffer within the budget, got %d", last.Buffers)
+	}
+
+	r := &run{Syncer: s}
+	if n := r.queueLimit(); n != 1 {
+		t.Errorf("expecting a queue of 1 within the budget, got %d", n)
+	}
+	if r.deltaFits(1 << 30) {
+		t.Errorf("expecting the checksums of 1GB not to fit in the budget")
+	}
+	s.MemoryBudget = 0
+	if n := r.queueLimit(); n != queueSize || !r.deltaFits(1<<30) {
+		t.Errorf("expecting no limits without a budget, got a queue of %d", n)
+	}
+}
+
+func TestMemoryBudgetRecords(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "a"), 0755))
+	check(os.MkdirAll(filepath.Join(src, "b"), 0755))
+	for i := 0; i < 40; i++ {
+		check(ioutil.WriteFile(filepath.Join(src, "a", fmt.Sprintf("%02d", i)), []byte(fmt.Sprint("file ", i)), 0644))
+	}
+
+	s := NewSyncer()
+	s.StateFile = filepath.Join(dir, "state")
+	s.DetectRenames = true
+	s.Delete = true
+	s.MaxDelete = 10
+	s.MemoryBudget = 4 * 16 * recordSize // 4 files of each kind in memory
+	check(s.Sync(dst, src))
+	st, err := LoadState(s.StateFile)
+	check(err)
+	files := 0
+	for p, e := range st.Files {
+		if !e.Dir {
+			files++
+			if e.Hash == "" {
+				t.Errorf("%s was recorded without its hash", p)
+			}
+		}
+	}
+	if files != 40 {
+		t.Errorf("expecting 40 files in the state, got %d", files)
+	}
+
+	// the first files recorded are looked for when moved
+	check(os.Rename(filepath.Join(src, "a/00"), filepath.Join(src, "b/00")))
+	for i := 1; i <= 6; i++ {
+		check(os.Remove(filepath.Join(src, "a", fmt.Sprintf("%02d", i))))
+	}
+	stats, err := s.SyncStats(dst, src)
+	check(err)
+	if stats.Moved != 1 || stats.Files != 0 || stats.Deleted != 6 {
+		t.Errorf("expecting a/00 to be moved and 6 files deleted, got %+v", stats)
+	}
+	testDirContents(filepath.Join(dst, "a"), 33, t)
+
+	// and the state spilled is found unchanged
+	stats, err = s.SyncStats(dst, src)
+	check(err)
+	if !stats.UpToDate || stats.Unchanged != 34 {
+		t.Errorf("expecting 34 files to be up to date, got %+v", stats)
+	}
+}
+
+func TestSpill(t *testing.T) {
+	es := newStateEntries(3)
+	defer es.close()
+	for _, i := range rand.Perm(50) {
+		check(es.add(fmt.Sprintf("%02d", i), &StateEntry{Size: int64(i)}))
+	}
+	check(es.add("07", &StateEntry{Size: 70})) // later ones win
+	if !es.spilled() {
+		t.Fatal("expecting entries past the limit to be spilled")
+	}
+	check(es.seal())
+	if n := es.len(); n != 50 {
+		t.Errorf("expecting 50 entries, got %d", n)
+	}
+	for i := 0; i < 50; i++ {
+		want := int64(i)
+		if i == 7 {
+			want = 70
+		}
+		e, err := es.get(fmt.Sprintf("%02d", i))
+		check(err)
+		if e == nil || e.Size != want {
+			t.Errorf("expecting entry %02d to have size %d, got %+v", i, want, e)
+		}
+	}
+	if e, err := es.get("7"); e != nil || err != nil {
+		t.Errorf("expecting no entry 7, got %+v, %v", e, err)
+	}
+	last := ""
+	check(es.each(func(p string, e *StateEntry) error {
+		if p <= last {
+			t.Errorf("%s came after %s", p, last)
+		}
+		last = p
+		return nil
+	}))
+
+	l := jobList{limit: 2}
+	defer l.close()
+	for i := 0; i < 5; i++ {
+		l.add(job{fmt.Sprint("dst", i), fmt.Sprint("src", i)})
+	}
+	i := 0
+	l.each(func(j job) {
+		if j.dst != fmt.Sprint("dst", i) || j.src != fmt.Sprint("src", i) {
+			t.Errorf("expecting job %d, got %+v", i, j)
+		}
+		i++
+	})
+	if i != 5 {
+		t.Errorf("expecting 5 jobs, got %d", i)
+	}
+}
@@ -0,0 +1,66 @@
+package fsync
+
+import (
+	"os"
+	"sync"
+)
+
+// defaultBufferSize is the size of the copy buffers without BufferSize.
+const defaultBufferSize = 32 << 10
+
+// buffers are the copy buffers of a run, kept for reuse.
+type buffers struct {
+	once  sync.Once
+	size  int
+	free  chan []byte
+	slots chan struct{} // one per buffer in use, with MaxBuffers
+}
+
+// buffer returns a copy buffer, waiting for one to be put back if
+// MaxBuffers are in use, and counts it in the progress.
+func (r *run) buffer() []byte {
+	b := &r.bufs
+	b.once.Do(func() {
+		b.size = roundPage(r.BufferSize)
+		if b.size <= 0 {
+			b.size = defaultBufferSize
+		}
+		n := r.maxBuffers(b.size)
+		if n > 0 {
+			b.slots = make(chan struct{}, n)
+		} else if n = r.Workers; n < 1 {
+			n = 1
+		}
+		b.free = make(chan []byte, n)
+	})
+	if b.slots != nil {
+		b.slots <- struct{}{}
+	}
+	select {
+	case buf := <-b.free:
+		r.progress.buffered(true)
+		return buf
+	default:
+		r.progress.buffered(false)
+		return make([]byte, b.size)
+	}
+}
+
+// release puts back the buffer buf, to be reused.
+func (r *run) release(buf []byte) {
+	b := &r.bufs
+	select {
+	case b.free <- buf:
+	default:
+	}
+	if b.slots != nil {
+		<-b.slots
+	}
+}
+
+// roundPage rounds n up to a multiple of the memory page size, so that
+// buffers fill the pages they take.
+func roundPage(n int) int {
+	ps := os.Getpagesize()
+	return (n + ps - 1) / ps * ps
+}
@@ -0,0 +1,45 @@
+package fsync
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestBuffers(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	for i := 0; i < 20; i++ {
+		check(ioutil.WriteFile(filepath.Join(src, fmt.Sprint(i)), []byte("file"), 0644))
+	}
+
+	var mu sync.Mutex
+	var last Progress
+	s := NewSyncer()
+	s.Workers = 4
+	s.MaxBuffers = 2
+	s.BufferSize = 1000
+	// changed on the way, so that files are copied through buffers
+	s.LineEndings = UnixLineEndings
+	s.OnProgress = func(p Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		if p.Files > last.Files {
+			last = p
+		}
+	}
+	check(s.Sync(dst, src))
+	if last.Buffers == 0 || last.Buffers > 2 || last.Buffers+last.BufferReuses != 20 {
+		t.Errorf("expecting at most 2 buffers for 20 files, got %d and %d reuses", last.Buffers, last.BufferReuses)
+	}
+	if n := roundPage(1000); n%os.Getpagesize() != 0 || n < 1000 {
+		t.Errorf("1000 rounded to %d", n)
+	}
+}
@@ -0,0 +1,25 @@
+package fsync
+
+import "time"
+
+// Clock tells the time to a Syncer, and waits for it to pass. Tests can
+// replace it to make time pass without waiting for it.
+type Clock interface {
+	Now() time.Time
+	// After returns a channel the time is sent on once d has passed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// wallClock is the Clock of the time package.
+type wallClock struct{}
+
+func (wallClock) Now() time.Time                         { return time.Now() }
+func (wallClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// clock returns Clock, or the wall clock if it's nil.
+func (s *Syncer) clock() Clock {
+	if s.Clock != nil {
+		return s.Clock
+	}
+	return wallClock{}
+}
@@ -0,0 +1,72 @@
+package fsync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stepClock jumps to the end of each wait instead of waiting.
+type stepClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *stepClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *stepClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d > 0 {
+		c.now = c.now.Add(d)
+	}
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func TestClock(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), make([]byte, 100<<10), 0644))
+
+	start := time.Unix(1e9, 0)
+	clock := &stepClock{now: start}
+	s := NewSyncer()
+	s.Clock = clock
+	s.RateLimit = 1 << 10
+	check(s.Sync(dst, src))
+	if d := clock.Now().Sub(start); d < 99*time.Second || d > 101*time.Second {
+		t.Errorf("copying 100KB at 1KB/s took %v", d)
+	}
+
+	start = clock.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	runs := 0
+	var took time.Duration
+	s.OnRun = func(stats Stats, err error) {
+		check(err)
+		if runs++; runs == 3 {
+			took = clock.Now().Sub(start)
+			cancel()
+		}
+	}
+	if err := s.Run(ctx, dst, src, time.Hour); err != context.Canceled {
+		t.Errorf("expecting context.Canceled, got %v", err)
+	}
+	if took < 2*time.Hour || took > 2*time.Hour+6*time.Minute {
+		t.Errorf("3 hourly runs took %v", took)
+	}
+}
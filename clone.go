@@ -0,0 +1,70 @@
+package fsync
+
+import (
+	"crypto/sha256"
+	"os"
+	"reflect"
+	"sync/atomic"
+)
+
+// Cloner is implemented by file systems that can copy a file within
+// themselves without copying its data, as copy-on-write clones (reflinks),
+// such as the local file system on Linux, with Btrfs, XFS and others, and on
+// macOS with APFS. When the source and destination are the same Cloner,
+// files are cloned instead of copied, as long as they aren't changed on the
+// way or updated in place with InPlace or DeltaMinSize, and copied as usual
+// if that fails.
+type Cloner interface {
+	// Clone makes the new file dst a clone of src, or fails and leaves
+	// nothing behind.
+	Clone(dst, src string) error
+}
+
+// clone clones the source file src to dst, if both file systems are the
+// same Cloner, and returns true if it did. Once a clone fails, as the file
+// system doesn't support it or they're on different devices, files are
+// only copied for the rest of the sync.
+func (r *run) clone(dst, src string) bool {
+	c, ok := r.dfs.(Cloner)
+	if !ok || !sameFS(r.dfs, r.sfs) || r.transformed(src) || atomic.LoadInt32(&r.noclone) != 0 {
+		return false
+	}
+	sstat, err := r.sfs.Stat(src)
+	if os.IsNotExist(err) {
+		return false
+	}
+	check(err)
+	name := tempName(dst)
+	if err := c.Clone(name, src); err != nil {
+		atomic.StoreInt32(&r.noclone, 1)
+		return false
+	}
+	renamed := false
+	defer func() {
+		if !renamed {
+			r.dfs.Remove(name)
+		}
+	}()
+	var sum []byte
+	if r.hashing() {
+		sum = hashFile(r.dfs, name, sha256.New())
+		r.matchSidecar(src, sum)
+		r.summed(dst, sum)
+	}
+	r.backup(dst)
+	check(r.dfs.Rename(name, dst))
+	renamed = true
+	if sum != nil {
+		r.hashed(src, sum)
+	}
+	r.wrote(dst, src, sstat.Size(), sum)
+	r.copied(src, sstat.Size())
+	r.hook(r.AfterCopy, dst, src)
+	return true
+}
+
+// sameFS returns true if a and b are the same file system.
+func sameFS(a, b FS) bool {
+	t := reflect.TypeOf(a)
+	return t == reflect.TypeOf(b) && t.Comparable() && a == b
+}
@@ -0,0 +1,15 @@
+package fsync
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Clone clones src to dst with clonefile, which APFS supports.
+func (osFS) Clone(dst, src string) error {
+	if err := unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW); err != nil {
+		return &os.PathError{Op: "clonefile", Path: dst, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package fsync
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Clone clones src to dst with the FICLONE ioctl.
+func (osFS) Clone(dst, src string) error {
+	sf, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+	df, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	err = unix.IoctlFileClone(int(df.Fd()), int(sf.Fd()))
+	if cerr := df.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return &os.PathError{Op: "ficlone", Path: dst, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCloneInPlace(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(os.MkdirAll(dst, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("new file a"), 0644))
+	check(ioutil.WriteFile(filepath.Join(dst, "a"), []byte("file a"), 0644))
+	check(os.Link(filepath.Join(dst, "a"), filepath.Join(dir, "link")))
+	ino := func(name string) (uint64, uint64) {
+		fi, err := os.Stat(name)
+		check(err)
+		st := fi.Sys().(*syscall.Stat_t)
+		return uint64(st.Ino), uint64(st.Nlink)
+	}
+	before, _ := ino(filepath.Join(dst, "a"))
+
+	// files updated in place aren't cloned over
+	fs := &cloneFS{}
+	s := NewSyncer()
+	s.SrcFS, s.DstFS = fs, fs
+	s.InPlace = true
+	check(s.Sync(dst, src))
+	if fs.clones != 0 {
+		t.Errorf("expecting no clones with InPlace, got %d", fs.clones)
+	}
+	after, links := ino(filepath.Join(dst, "a"))
+	if after != before || links != 2 {
+		t.Errorf("expecting inode %d with 2 links, got %d with %d", before, after, links)
+	}
+	testFile(filepath.Join(dir, "link"), []byte("new file a"), t)
+}
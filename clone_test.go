@@ -0,0 +1,86 @@
+package fsync
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// cloneFS clones by copying, and counts the clones, or fails them with fail.
+type cloneFS struct {
+	osFS
+	clones int
+	fail   bool
+}
+
+func (fs *cloneFS) Clone(dst, src string) error {
+	if fs.fail {
+		return errors.New("clone not supported")
+	}
+	fs.clones++
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, b, 0644)
+}
+
+func TestClone(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("file a"), 0600))
+	check(ioutil.WriteFile(filepath.Join(src, "b.txt"), []byte("b\n"), 0644))
+
+	fs := &cloneFS{}
+	s := NewSyncer()
+	s.SrcFS, s.DstFS = fs, fs
+	s.WriteSums = true
+	stats, err := s.SyncStats(dst, src)
+	check(err)
+	if fs.clones != 2 || stats.Files != 2 {
+		t.Errorf("expecting 2 files cloned, got %d clones and %+v", fs.clones, stats)
+	}
+	testFile(filepath.Join(dst, "a"), []byte("file a"), t)
+	testPerms(filepath.Join(dst, "a"), 0600, t)
+	testExistence(filepath.Join(dst, SumsFile), true, t)
+
+	// files changed on the way are copied
+	fs.clones = 0
+	check(os.RemoveAll(dst))
+	s.LineEndings = WindowsLineEndings
+	check(s.Sync(dst, src))
+	if fs.clones != 0 {
+		t.Errorf("expecting no clones of transformed files, got %d", fs.clones)
+	}
+	testFile(filepath.Join(dst, "b.txt"), []byte("b\r\n"), t)
+
+	// so are files between different file systems
+	s.LineEndings = PreserveLineEndings
+	s.SrcFS = OS
+	check(os.RemoveAll(dst))
+	check(s.Sync(dst, src))
+	if fs.clones != 0 {
+		t.Errorf("expecting no clones between file systems, got %d", fs.clones)
+	}
+
+	// and everything after a clone fails
+	fs.fail = true
+	s.SrcFS = fs
+	check(os.RemoveAll(dst))
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "a"), []byte("file a"), t)
+	files, err := ioutil.ReadDir(dst)
+	check(err)
+	for _, fi := range files {
+		if strings.HasPrefix(fi.Name(), tempPrefix) {
+			t.Errorf("temporary file %s left behind", fi.Name())
+		}
+	}
+}
@@ -0,0 +1,357 @@
+// Command fsync syncs a destination with a source, using package
+// github.com/mostafah/fsync.
+//
+//	fsync [-delete] [-dry-run] [-exclude PAT]... [-include PAT]... [-workers N] [-progress] [-itemize|-events] [-json] SRC DST
+//	fsync [-delete] [-exclude PAT]... -explain PATH SRC DST
+//	fsync [-exclude PAT]... -verify SRC DST
+//	fsync [-exclude PAT]... -show-excludes SRC
+//
+// Paths may be URLs of the backends compiled in, such as sftp://host/path.
+// Those are sftp, s3, gs, azblob, ftp, ftps, smb, dav, davs, rsync,
+// fsyncd, mirror, mirrors, tar and zip.
+// With -progress, the progress is shown on standard error as files are
+// copied. With -itemize, each change is printed as it's made, in the format
+// of rsync -i, and with -events, each action is printed as a line of JSON.
+// When it's done, fsync prints what it did, or would do with -dry-run, and
+// with -json it prints that as a JSON object instead. With -explain, nothing
+// is synced; fsync prints what a sync would do to PATH, a path relative to
+// SRC and DST, and why. With -verify, it only lists how DST differs from
+// SRC, and fails if it does. With -show-excludes, it only lists what each
+// pattern excludes in SRC.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mostafah/fsync"
+	_ "github.com/mostafah/fsync/archivefs"
+	_ "github.com/mostafah/fsync/azurefs"
+	"github.com/mostafah/fsync/console"
+	_ "github.com/mostafah/fsync/davfs"
+	_ "github.com/mostafah/fsync/fsyncd"
+	_ "github.com/mostafah/fsync/ftpfs"
+	_ "github.com/mostafah/fsync/gcsfs"
+	_ "github.com/mostafah/fsync/mirror"
+	_ "github.com/mostafah/fsync/rsyncfs"
+	_ "github.com/mostafah/fsync/s3fs"
+	_ "github.com/mostafah/fsync/sftpfs"
+	_ "github.com/mostafah/fsync/smbfs"
+)
+
+// patterns is a flag that can be given more than once.
+type patterns []string
+
+func (p *patterns) String() string     { return strings.Join(*p, ",") }
+func (p *patterns) Set(s string) error { *p = append(*p, s); return nil }
+
+// mode is a flag of permissions in octal.
+type mode struct{ m *os.FileMode }
+
+func (f mode) String() string {
+	if f.m == nil {
+		return "0"
+	}
+	return fmt.Sprintf("%#o", uint32(*f.m))
+}
+
+func (f mode) Set(s string) error {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return err
+	}
+	*f.m = os.FileMode(n).Perm()
+	return nil
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("fsync: ")
+	s := fsync.NewSyncer()
+	var exclude, include, protect, suspects patterns
+	flag.BoolVar(&s.Delete, "delete", false, "delete files in DST that aren't in SRC")
+	flag.StringVar(&s.DeleteTo, "delete-to", "", "move what -delete deletes to `DIR`, or to the trash if it's "+fsync.Trash)
+	flag.Var(&protect, "protect", "never delete files matching `PAT` in DST; may be repeated")
+	deleteMode := flag.String("delete-mode", "during", "delete `before`, during or after copying")
+	flag.IntVar(&s.MaxDelete, "max-delete", 0, "fail before deleting anything if -delete would delete more than `N` files")
+	flag.Int64Var(&s.MaxBytes, "max-bytes", 0, "stop before copying more than `N` bytes, leaving the rest for the next run")
+	timeout := flag.Duration("timeout", 0, "stop starting on files after `D`")
+	flag.BoolVar(&s.Strict, "strict", false, "fail rather than lose links, special files, xattrs, permissions or time precision")
+	flag.BoolVar(&s.ContinueOnError, "continue", false, "go on when files fail, and list them at the end")
+	flag.IntVar(&s.Retries, "retries", 0, "try files that fail with transient errors `N` more times")
+	flag.DurationVar(&s.RetryDelay, "retry-delay", time.Second, "wait `D` before the first retry, twice as long before the next")
+	flag.Int64Var(&s.ConfirmBytes, "confirm-bytes", 0, "ask for -confirm before copying more than `N` bytes")
+	flag.IntVar(&s.ConfirmDeletes, "confirm-deletes", 0, "ask for -confirm before deleting more than `N` files")
+	flag.StringVar(&s.ConfirmToken, "confirm", "", "go ahead with the sync whose confirmation token is `TOKEN`")
+	flag.BoolVar(&s.DryRun, "dry-run", false, "only show what would be done")
+	flag.BoolVar(&s.NoPerms, "no-perms", false, "leave the permissions in DST alone")
+	flag.BoolVar(&s.NoSpecialBits, "no-special-bits", false, "clear the setuid, setgid and sticky bits in DST")
+	flag.Var(mode{&s.FileMode}, "file-mode", "give files the permissions `MODE` instead of those in SRC")
+	flag.Var(mode{&s.DirMode}, "dir-mode", "give directories the permissions `MODE` instead of those in SRC")
+	flag.Var(mode{&s.MkdirMode}, "mkdir-mode", "make directories, and the missing parents of DST, with the permissions `MODE`")
+	flag.Var(mode{&s.PermMask}, "perm-mask", "clear the permissions `MODE` in DST, as a umask")
+	flag.Var(&exclude, "exclude", "skip files matching `PAT`; may be repeated")
+	flag.Var(&include, "include", "sync files matching `PAT` even if excluded; may be repeated")
+	flag.BoolVar(&s.ExcludeMarked, "exclude-marked", false, "skip files marked with the "+fsync.SkipXattr+" attribute or nodump flag")
+	flag.BoolVar(&s.Sidecars, "sidecars", false, "trust the checksums in FILE.sha256 and "+fsync.SumsFile+" files of SRC")
+	flag.BoolVar(&s.WriteSums, "write-sums", false, "keep a "+fsync.SumsFile+" file in each directory of DST")
+	compare := flag.String("compare", "", "compare files of the same size by `content`, checksum, quick (time) or chained (time, content for suspects)")
+	flag.Var(&suspects, "suspect", "compare files matching `PAT` by content with -compare chained; may be repeated")
+	flag.DurationVar(&s.SuspectAge, "suspect-age", 0, "compare files modified less than `D` ago by content with -compare chained")
+	lineEndings := flag.String("line-endings", "", "change the line endings of text files to `lf` or crlf")
+	flag.BoolVar(&s.InPlace, "inplace", false, "update changed files in place, writing only the blocks that differ")
+	flag.BoolVar(&s.Atomic, "atomic", false, "copy files to a temporary file renamed over the old one")
+	flag.StringVar(&s.BackupDir, "backup-dir", "", "move files overwritten or deleted in DST to `DIR`")
+	flag.StringVar(&s.BackupSuffix, "suffix", "", "append `SUFFIX` to the names of backups")
+	flag.BoolVar(&s.PreserveHardlinks, "hard-links", false, "keep files hard-linked in SRC hard-linked in DST")
+	flag.StringVar(&s.LinkDest, "link-dest", "", "hard-link files that are unchanged in `DIR`, relative to DST, instead of copying them")
+	specials := flag.String("specials", "skip", "`skip`, recreate or fail on named pipes, sockets and devices in SRC")
+	rootLink := flag.String("root-link", "follow", "when SRC is a symbolic link, `follow` it, resolve it first or copy it")
+	swap := flag.String("swap", "", "sync into a tree next to DST and swap it in by `rename` or link")
+	interference := flag.String("interference", "", "when DST files change before they're written, `overwrite`, skip or fail")
+	flag.Float64Var(&s.Recheck, "recheck", 0, "check `PCT`% of the files written again at the end, for changes by others")
+	flag.Int64Var(&s.Seed, "seed", 0, "seed the random choices of -recheck with `N`, to make them again")
+	auto := flag.Bool("auto", false, "choose -workers, -buffer-size and -memory-budget, unless given, for the CPUs and memory of the container or host")
+	flag.IntVar(&s.Workers, "workers", 0, "copy `N` files at the same time")
+	flag.IntVar(&s.BufferSize, "buffer-size", 0, "copy files with buffers of `N` bytes (default 32768)")
+	flag.IntVar(&s.MaxBuffers, "max-buffers", 0, "use at most `N` copy buffers at once")
+	flag.BoolVar(&s.Sparse, "sparse", false, "keep the holes of sparse files")
+	flag.BoolVar(&s.Preallocate, "preallocate", false, "allocate the space of each file before copying it")
+	flag.Int64Var(&s.MemoryBudget, "memory-budget", 0, "keep buffers, queued files and checksums within about `N` bytes")
+	progress := flag.Bool("progress", false, "show progress while copying")
+	itemize := flag.Bool("itemize", false, "print each change, as rsync -i does")
+	events := flag.Bool("events", false, "print each action as a line of JSON")
+	flag.StringVar(&s.ProgressFile, "progress-file", "", "keep the progress in `FILE`, as JSON, for other programs")
+	asJSON := flag.Bool("json", false, "print the stats as JSON")
+	showExcludes := flag.Bool("show-excludes", false, "only list what each -exclude pattern matches in SRC")
+	deleteOnly := flag.Bool("delete-only", false, "only delete what's in DST and not in SRC, copying nothing")
+	metadataOnly := flag.Bool("metadata-only", false, "only sync the permissions and modification times of what's in both SRC and DST")
+	var meta fsync.MetadataOptions
+	flag.BoolVar(&meta.Owners, "owners", false, "with -metadata-only, also sync owners and groups")
+	flag.BoolVar(&meta.Xattrs, "xattrs", false, "with -metadata-only, also sync extended attributes")
+	snapshot := flag.Bool("snapshot", false, "sync SRC into a new timestamped snapshot in DST, linking unchanged files to the last one")
+	plan := flag.Bool("plan", false, "only summarize what would be done, by directory")
+	verifyAll := flag.Bool("verify", false, "only list the differences between SRC and DST")
+	explain := flag.String("explain", "", "only explain what would be done to `PATH` and why")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: fsync [flags] SRC DST\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *showExcludes && flag.NArg() == 1 {
+		f := fsync.Filters{Exclude: exclude, Include: include}
+		matches, err := f.MatchTree(nil, flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, m := range matches {
+			fmt.Printf("%s: %d matches\n", m.Pattern, len(m.Paths))
+			for _, p := range m.Paths {
+				fmt.Printf("\t%s\n", p)
+			}
+		}
+		return
+	}
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *auto {
+		s.AutoTune()
+	}
+	s.Exclude = exclude
+	s.Include = include
+	s.Protect = protect
+	s.Suspects = suspects
+	switch *rootLink {
+	case "follow":
+	case "resolve":
+		s.RootLink = fsync.ResolveRootLink
+	case "copy":
+		s.RootLink = fsync.CopyRootLink
+	default:
+		log.Fatalf("unknown -root-link %q", *rootLink)
+	}
+	switch *specials {
+	case "skip":
+	case "recreate":
+		s.Specials = fsync.RecreateSpecials
+	case "fail":
+		s.Specials = fsync.FailOnSpecials
+	default:
+		log.Fatalf("unknown -specials %q", *specials)
+	}
+	switch *deleteMode {
+	case "during":
+	case "before":
+		s.DeleteMode = fsync.DeleteBefore
+	case "after":
+		s.DeleteMode = fsync.DeleteAfter
+	default:
+		log.Fatalf("unknown -delete-mode %q", *deleteMode)
+	}
+	switch *compare {
+	case "":
+	case "content":
+		s.Comparison = fsync.CompareContent
+	case "checksum":
+		s.Comparison = fsync.CompareChecksum
+	case "quick":
+		s.Comparison = fsync.CompareQuick
+	case "chained":
+		s.Comparison = fsync.CompareChained
+	default:
+		log.Fatalf("unknown -compare %q", *compare)
+	}
+	switch *lineEndings {
+	case "":
+	case "lf":
+		s.LineEndings = fsync.UnixLineEndings
+	case "crlf":
+		s.LineEndings = fsync.WindowsLineEndings
+	default:
+		log.Fatalf("unknown -line-endings %q", *lineEndings)
+	}
+	switch *interference {
+	case "":
+	case "overwrite":
+		s.Interference = fsync.OverwriteInterference
+	case "skip":
+		s.Interference = fsync.SkipInterference
+	case "fail":
+		s.Interference = fsync.FailOnInterference
+	default:
+		log.Fatalf("unknown -interference %q", *interference)
+	}
+	switch *swap {
+	case "":
+	case "rename":
+		s.Swap = fsync.SwapRename
+	case "link":
+		s.Swap = fsync.SwapLink
+	default:
+		log.Fatalf("unknown -swap %q", *swap)
+	}
+	if *deleteOnly {
+		stats, err := s.DeleteExtras(flag.Arg(1), flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("deleted %d\n", stats.Deleted)
+		return
+	}
+	if *metadataOnly {
+		if _, err := s.SyncMetadata(flag.Arg(1), flag.Arg(0), meta); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *snapshot {
+		snap, stats, err := s.Snapshot(flag.Arg(1), flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s: copied %d, linked %d\n", snap.Path, stats.Files, stats.Linked)
+		return
+	}
+	if *plan {
+		p, err := s.Plan(flag.Arg(1), flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		p.WriteSummary(os.Stdout)
+		return
+	}
+	if *verifyAll {
+		d, err := s.VerifyAll(flag.Arg(1), flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, m := range d.Mismatches {
+			fmt.Printf("%s: %s\n", m.Path, m.Kind)
+		}
+		if len(d.Mismatches) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	if *explain != "" {
+		e, err := s.Explain(flag.Arg(1), flag.Arg(0), *explain)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(e)
+		return
+	}
+	var d *console.Display
+	if *progress {
+		d = console.New(os.Stderr)
+		s.OnProgress = d.Update
+	}
+	if *itemize {
+		s.Verbosity = fsync.Trace
+		s.OnEvent = func(e fsync.Event) {
+			if e.Item != "" {
+				fmt.Printf("%s %s\n", e.Item, e.Path)
+			}
+		}
+	}
+	if *events {
+		s.Verbosity = fsync.Trace
+		s.OnEvent = fsync.WriteEvents(os.Stdout)
+	}
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	stats, err := s.SyncContext(ctx, flag.Arg(1), flag.Arg(0))
+	if d != nil {
+		d.Finish()
+	}
+	if errs, ok := err.(fsync.SyncErrors); ok {
+		for _, e := range errs {
+			log.Print(e)
+		}
+		os.Exit(1)
+	}
+	if err != nil {
+		if stats.Last != "" {
+			log.Printf("copied %d files (%s), created %d directories and deleted %d before failing at %s",
+				stats.Files, console.Bytes(stats.Bytes), stats.Dirs, stats.Deleted, stats.Last)
+		}
+		if stats.Stopped.Retryable() {
+			log.Printf("stopped early (%v); running again may go further", stats.Stopped)
+		}
+		log.Fatal(err)
+	}
+	if *asJSON {
+		json.NewEncoder(os.Stdout).Encode(struct {
+			fsync.Stats
+			DryRun bool `json:"dry_run"`
+		}{stats, s.DryRun})
+		return
+	}
+	if stats.UpToDate {
+		fmt.Printf("up to date, %d files unchanged\n", stats.Unchanged)
+		return
+	}
+	verb := "copied"
+	if s.DryRun {
+		verb = "would copy"
+	}
+	fmt.Printf("%s %d files (%d new, %s), created %d directories, deleted %d, %d unchanged\n",
+		verb, stats.Files, stats.Created, console.Bytes(stats.Bytes), stats.Dirs, stats.Deleted, stats.Unchanged)
+	if stats.Inconsistent > 0 {
+		fmt.Printf("%d of %d files rechecked changed after they were written\n", stats.Inconsistent, stats.Rechecked)
+	}
+}
@@ -0,0 +1,48 @@
+// Command fsyncd serves a directory to fsync clients over gRPC.
+//
+//	FSYNCD_TOKEN=secret fsyncd -root /srv/backup -cert cert.pem -key key.pem
+//
+// Clients sync to it with URLs like fsyncd://secret@host/site; see package
+// github.com/mostafah/fsync/fsyncd.
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"os"
+
+	"github.com/mostafah/fsync/fsyncd"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+func main() {
+	addr := flag.String("addr", ":"+fsyncd.DefaultPort, "address to listen on")
+	root := flag.String("root", ".", "directory to serve")
+	cert := flag.String("cert", "", "TLS certificate file")
+	key := flag.String("key", "", "TLS key file")
+	insecure := flag.Bool("insecure", false, "serve without TLS")
+	flag.Parse()
+
+	token := os.Getenv("FSYNCD_TOKEN")
+	if token == "" {
+		log.Fatal("fsyncd: FSYNCD_TOKEN is not set")
+	}
+	var opts []grpc.ServerOption
+	if !*insecure {
+		if *cert == "" || *key == "" {
+			log.Fatal("fsyncd: -cert and -key are needed unless -insecure is set")
+		}
+		creds, err := credentials.NewServerTLSFromFile(*cert, *key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(fsyncd.NewServer(*root, token, opts...).Serve(ln))
+}
@@ -0,0 +1,130 @@
+package fsync
+
+import (
+	"os"
+	"time"
+)
+
+// Comparison is a way of deciding whether a file in the destination is equal
+// to its source, and so doesn't need to be copied. Files of different sizes
+// are never equal.
+type Comparison int
+
+const (
+	// CompareDefault uses the comparison the destination or source file
+	// system asks for if it is a Comparer, and CompareContent otherwise.
+	CompareDefault Comparison = iota
+	// CompareContent compares the contents of the files. Destinations that
+	// are Hashers are compared by checksum instead of being read.
+	CompareContent
+	// CompareQuick considers files with the same size and modification time
+	// equal without reading them.
+	CompareQuick
+	// CompareChained compares files as CompareQuick does, except for those
+	// that are suspect, which are compared as CompareContent does: those
+	// whose modification times are the same only within the modify window,
+	// that were modified less than SuspectAge before, and those matching a
+	// pattern in Suspects.
+	CompareChained
+	// CompareChecksum compares the SHA-256 checksums of the files, so
+	// that each is read on its own, unless a Hasher or sidecar has one.
+	// Files changed by Transformers or LineEndings are compared by content.
+	CompareChecksum
+)
+
+// Comparer is implemented by file systems that need their files compared
+// differently from local files, e.g. because reading them back is slow or
+// their modification times are stored with less precision. A Syncer uses the
+// values they return unless its own fields are set.
+type Comparer interface {
+	// Comparison returns how files in the file system should be compared.
+	Comparison() Comparison
+	// ModifyWindow returns the precision of modification times in the file
+	// system.
+	ModifyWindow() time.Duration
+}
+
+// Equal returns true if the files a and b have the same contents. It's
+// Compare with CompareContent.
+func Equal(a, b string) (bool, error) {
+	return Compare(a, b, CompareContent)
+}
+
+// Compare returns true if the files a and b are equal by the comparison c,
+// as a sync finds a destination file a and its source b to be, without
+// syncing them. Either may be the URL of a registered backend, whose
+// Comparison is used with CompareDefault. It fails with ErrNotFile unless
+// both are regular files.
+func Compare(a, b string, c Comparison) (bool, error) {
+	s := NewSyncer()
+	s.Comparison = c
+	r, a, b, err := s.newRun(a, b)
+	if err != nil {
+		return false, err
+	}
+	defer r.close()
+	for _, f := range []struct {
+		fs   FS
+		name string
+	}{{r.dfs, a}, {r.sfs, b}} {
+		fi, err := f.fs.Stat(f.name)
+		if err != nil {
+			return false, err
+		}
+		if !fi.Mode().IsRegular() {
+			return false, ErrNotFile
+		}
+	}
+	r.root, r.dstRoot = b, a
+	eq := false
+	err = catch(func() { eq = r.equal(a, b) })
+	return eq, err
+}
+
+// comparison returns the comparison and modify window a run uses.
+func (r *run) comparison() (Comparison, time.Duration) {
+	c, window := r.Comparison, r.ModifyWindow
+	for _, fs := range []FS{r.dfs, r.sfs} {
+		cmp, ok := fs.(Comparer)
+		if !ok {
+			continue
+		}
+		if c == CompareDefault {
+			c = cmp.Comparison()
+		}
+		if w := cmp.ModifyWindow(); w > window {
+			window = w
+		}
+	}
+	if c == CompareDefault {
+		c = CompareContent
+	}
+	return c, window
+}
+
+// suspect returns true if the source file src, with the file info sstat,
+// and its destination, with dstat, are to be compared by content with
+// CompareChained although their sizes and modification times are the same.
+func (r *run) suspect(src string, dstat, sstat os.FileInfo) bool {
+	if !dstat.ModTime().Equal(sstat.ModTime()) {
+		return true
+	}
+	if r.SuspectAge > 0 && r.clock().Now().Sub(sstat.ModTime()) < r.SuspectAge {
+		return true
+	}
+	for _, p := range r.Suspects {
+		if matchPattern(p, r.rel(src), false) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameTime returns true if a and b are within the modify window of a run.
+func (r *run) sameTime(a, b time.Time) bool {
+	d := a.Sub(b)
+	if d < 0 {
+		d = -d
+	}
+	return d <= r.window
+}
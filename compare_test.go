@@ -0,0 +1,111 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompareQuick(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+
+	// same size and time, different content
+	tt := time.Now().Add(-time.Hour)
+	check(ioutil.WriteFile(src, []byte("file a"), 0644))
+	check(ioutil.WriteFile(dst, []byte("file b"), 0644))
+	check(os.Chtimes(src, tt, tt))
+	check(os.Chtimes(dst, tt, tt))
+
+	s := NewSyncer()
+	s.Comparison = CompareQuick
+	check(s.Sync(dst, src))
+	testFile(dst, []byte("file b"), t)
+
+	// times within the modify window are equal too
+	check(os.Chtimes(dst, tt.Add(time.Second), tt.Add(time.Second)))
+	s.ModifyWindow = time.Second
+	check(s.Sync(dst, src))
+	testFile(dst, []byte("file b"), t)
+	testModTime(dst, tt.Add(time.Second), t)
+
+	s.Comparison = CompareContent
+	check(s.Sync(dst, src))
+	testFile(dst, []byte("file a"), t)
+}
+
+func TestCompareChained(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(os.MkdirAll(dst, 0755))
+
+	// same sizes and times, different contents
+	tt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, name := range []string{"a", "b", "c.db", "d"} {
+		check(ioutil.WriteFile(filepath.Join(src, name), []byte("file a"), 0644))
+		check(ioutil.WriteFile(filepath.Join(dst, name), []byte("file b"), 0644))
+		check(os.Chtimes(filepath.Join(src, name), tt, tt))
+		check(os.Chtimes(filepath.Join(dst, name), tt, tt))
+	}
+	// b only within the modify window, d just modified
+	check(os.Chtimes(filepath.Join(dst, "b"), tt.Add(time.Second), tt.Add(time.Second)))
+	now := time.Now()
+	check(os.Chtimes(filepath.Join(src, "d"), now, now))
+	check(os.Chtimes(filepath.Join(dst, "d"), now, now))
+
+	s := NewSyncer()
+	s.Comparison = CompareChained
+	s.ModifyWindow = time.Second
+	s.Suspects = []string{"*.db"}
+	s.SuspectAge = time.Minute
+	stats, err := s.SyncStats(dst, src)
+	check(err)
+	testFile(filepath.Join(dst, "a"), []byte("file b"), t)
+	testFile(filepath.Join(dst, "b"), []byte("file a"), t)
+	testFile(filepath.Join(dst, "c.db"), []byte("file a"), t)
+	testFile(filepath.Join(dst, "d"), []byte("file a"), t)
+	if stats.Files != 3 || stats.Unchanged != 1 {
+		t.Errorf("expecting the 3 suspects to be copied, got %+v", stats)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	tt := time.Now().Add(-time.Hour)
+	check(ioutil.WriteFile(a, []byte("file a"), 0644))
+	check(ioutil.WriteFile(b, []byte("file b"), 0644))
+	check(os.Chtimes(a, tt, tt))
+	check(os.Chtimes(b, tt, tt))
+
+	for _, c := range []struct {
+		cmp  Comparison
+		want bool
+	}{{CompareContent, false}, {CompareChecksum, false}, {CompareQuick, true}, {CompareChained, true}} {
+		if eq, err := Compare(a, b, c.cmp); err != nil || eq != c.want {
+			t.Errorf("expecting %v with comparison %d, got %v, %v", c.want, c.cmp, eq, err)
+		}
+	}
+	check(ioutil.WriteFile(b, []byte("file a"), 0644))
+	if eq, err := Equal(a, b); err != nil || !eq {
+		t.Errorf("expecting a and b to be equal, got %v, %v", eq, err)
+	}
+	if _, err := Equal(a, dir); err != ErrNotFile {
+		t.Errorf("expecting ErrNotFile, got %v", err)
+	}
+	if _, err := Equal(a, filepath.Join(dir, "c")); !os.IsNotExist(err) {
+		t.Errorf("expecting c not to exist, got %v", err)
+	}
+}
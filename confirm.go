@@ -0,0 +1,49 @@
+package fsync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// ConfirmError is what a sync fails with when it's larger than
+// ConfirmBytes or ConfirmDeletes allow and wasn't confirmed.
+type ConfirmError struct {
+	Stats Stats  // what the sync would do
+	Token string // the ConfirmToken that lets it go ahead
+}
+
+func (e *ConfirmError) Error() string {
+	return fmt.Sprintf("fsync: copying %d files (%d bytes) and deleting %d needs confirmation with token %s",
+		e.Stats.Files, e.Stats.Bytes, e.Stats.Deleted, e.Token)
+}
+
+// confirm goes through a sync of dst with src in a dry run, and returns a
+// ConfirmError if it's too large and isn't confirmed.
+func (s *Syncer) confirm(dst, src string) error {
+	if s.ConfirmBytes <= 0 && s.ConfirmDeletes <= 0 || s.DryRun {
+		return nil
+	}
+	p, err := s.Plan(dst, src)
+	if err != nil {
+		return err
+	}
+	if (s.ConfirmBytes <= 0 || p.Stats.Bytes <= s.ConfirmBytes) &&
+		(s.ConfirmDeletes <= 0 || p.Stats.Deleted <= s.ConfirmDeletes) {
+		return nil
+	}
+	token := p.token()
+	if s.ConfirmToken == token || s.Confirm != nil && s.Confirm(p.Stats) {
+		return nil
+	}
+	return &ConfirmError{Stats: p.Stats, Token: token}
+}
+
+// token returns the token of p for ConfirmToken, a checksum of its events.
+func (p *Plan) token() string {
+	h := sha256.New()
+	for _, e := range p.Events {
+		fmt.Fprintf(h, "%v %q %d %q\n", e.Op, e.Path, e.Size, e.From)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
@@ -0,0 +1,57 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfirm(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(os.MkdirAll(dst, 0755))
+	for _, name := range []string{"a", "b", "c"} {
+		check(ioutil.WriteFile(filepath.Join(src, name), []byte("file "+name), 0644))
+	}
+	check(ioutil.WriteFile(filepath.Join(dst, "d"), []byte("file d"), 0644))
+
+	s := NewSyncer()
+	s.Delete = true
+	s.ConfirmBytes = 12
+	err = s.Sync(dst, src)
+	ce, ok := err.(*ConfirmError)
+	if !ok || ce.Stats.Files != 3 || ce.Stats.Bytes != 18 || ce.Stats.Deleted != 1 || ce.Token == "" {
+		t.Fatalf("expecting a ConfirmError, got %v", err)
+	}
+	testDirContents(dst, 1, t)
+
+	// another token doesn't confirm it
+	s.ConfirmToken = "0123456789abcdef"
+	if err := s.Sync(dst, src); err == nil {
+		t.Error("expecting the wrong token to be refused")
+	}
+	s.ConfirmToken = ce.Token
+	check(s.Sync(dst, src))
+	testDirContents(dst, 3, t)
+
+	// within ConfirmBytes, deleting more than ConfirmDeletes
+	s.ConfirmToken = ""
+	s.ConfirmDeletes = 1
+	check(os.Remove(filepath.Join(src, "a")))
+	check(os.Remove(filepath.Join(src, "b")))
+	var plan Stats
+	s.Confirm = func(p Stats) bool {
+		plan = p
+		return true
+	}
+	check(s.Sync(dst, src))
+	if plan.Deleted != 2 {
+		t.Errorf("expecting Confirm to be asked about 2 deletions, got %+v", plan)
+	}
+	testDirContents(dst, 1, t)
+}
@@ -0,0 +1,130 @@
+// Package console renders the progress of syncs on a terminal, on a line
+// that is rewritten as the sync goes on, like rsync --info=progress2:
+//
+//	120/348 files   1.2/3.5 GiB   24.1 MiB/s   ETA 1:38   photos/2019/img_0042.jpg
+//
+// A Display is meant to be set as the OnProgress of a Syncer:
+//
+//	d := console.New(os.Stderr)
+//	s.OnProgress = d.Update
+//	err := s.Sync(dst, src)
+//	d.Finish()
+package console
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mostafah/fsync"
+)
+
+// Display writes progress lines to a terminal.
+type Display struct {
+	// Width is the number of columns lines are cut to. It's 80 by default.
+	Width int
+	// Interval is the least time between two lines. Updates coming sooner
+	// are dropped, except the last one, written by Finish. It's a tenth of a
+	// second by default.
+	Interval time.Duration
+
+	w       io.Writer
+	mu      sync.Mutex
+	written time.Time       // when the last line was written
+	n       int             // length of the last line
+	pending *fsync.Progress // dropped update
+}
+
+// New returns a Display writing to w.
+func New(w io.Writer) *Display {
+	return &Display{Width: 80, Interval: time.Second / 10, w: w}
+}
+
+// Update shows p. It's safe for concurrent use, so it can be the OnProgress
+// of a Syncer with Workers.
+func (d *Display) Update(p fsync.Progress) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now := time.Now(); now.Sub(d.written) >= d.Interval {
+		d.written = now
+		d.pending = nil
+		d.write(Line(p))
+	} else {
+		d.pending = &p
+	}
+}
+
+// Finish shows the last update, if it was dropped, and ends the line.
+func (d *Display) Finish() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pending != nil {
+		d.write(Line(*d.pending))
+		d.pending = nil
+	}
+	if d.n > 0 {
+		fmt.Fprintln(d.w)
+		d.n = 0
+	}
+}
+
+// write replaces the last line with line.
+func (d *Display) write(line string) {
+	r := []rune(line)
+	if w := d.Width; w > 0 && len(r) > w-1 {
+		r = r[:w-1] // keep the cursor off the edge
+	}
+	pad := ""
+	if len(r) < d.n {
+		pad = strings.Repeat(" ", d.n-len(r))
+	}
+	fmt.Fprintf(d.w, "\r%s%s", string(r), pad)
+	d.n = len(r)
+}
+
+// Line formats p as a progress line.
+func Line(p fsync.Progress) string {
+	total := fmt.Sprint(p.TotalFiles)
+	if p.Scanning {
+		total += "+"
+	}
+	line := fmt.Sprintf("%5d/%s files  %s/%s  %s/s  ETA %s",
+		p.Files, total, Bytes(p.Bytes), Bytes(p.TotalBytes), Bytes(int64(p.Rate)), eta(p.ETA))
+	if p.File != "" {
+		name := p.File
+		if rel, err := filepath.Rel(p.Src, p.File); err == nil && rel != "." {
+			name = rel
+		}
+		line += "  " + filepath.ToSlash(name)
+	}
+	return line
+}
+
+// eta formats d as h:mm:ss or m:ss.
+func eta(d time.Duration) string {
+	if d < 0 {
+		return "-:--"
+	}
+	s := int64(d.Round(time.Second) / time.Second)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// Bytes formats n bytes with binary prefixes, as in "3.5 GiB".
+func Bytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,48 @@
+package console
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mostafah/fsync"
+)
+
+func TestLine(t *testing.T) {
+	p := fsync.Progress{
+		Src:        "/src",
+		File:       "/src/a/b",
+		Files:      3,
+		TotalFiles: 10,
+		Bytes:      1536,
+		TotalBytes: 3 << 20,
+		Rate:       2048,
+		ETA:        95 * time.Second,
+		Scanning:   true,
+	}
+	want := "    3/10+ files  1.5 KiB/3.0 MiB  2.0 KiB/s  ETA 1:35  a/b"
+	if line := Line(p); line != want {
+		t.Errorf("expecting %q, got %q", want, line)
+	}
+	p.ETA = -1
+	if line := Line(p); !strings.Contains(line, "ETA -:--") {
+		t.Errorf("expecting an unknown ETA, got %q", line)
+	}
+}
+
+func TestDisplay(t *testing.T) {
+	var buf bytes.Buffer
+	d := New(&buf)
+	d.Interval = time.Hour
+	d.Update(fsync.Progress{Src: "/src", File: "/src/long-name", Files: 1, TotalFiles: 2})
+	d.Update(fsync.Progress{Src: "/src", File: "/src/b", Files: 2, TotalFiles: 2})
+	if strings.Contains(buf.String(), "2/2") || strings.Count(buf.String(), "\r") != 1 {
+		t.Errorf("expecting the second update to be dropped, got %q", buf.String())
+	}
+	d.Finish()
+	out := buf.String()
+	if strings.Count(out, "\r") != 2 || !strings.HasSuffix(out, "  b        \n") {
+		t.Errorf("expecting the last update over the first, got %q", out)
+	}
+}
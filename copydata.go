@@ -0,0 +1,23 @@
+package fsync
+
+import "io"
+
+// copyData copies in to out. When direct is true, as nothing needs to see
+// the data on the way, and out is an io.ReaderFrom, out copies it itself:
+// the local file system does so in the kernel, without bouncing the data
+// through user space, with copy_file_range or sendfile on Linux, as the os
+// package does. Otherwise it's copied through a buffer of BufferSize.
+func (r *run) copyData(out io.Writer, in io.Reader, direct bool) (int64, error) {
+	if rf, ok := out.(io.ReaderFrom); ok && direct {
+		return rf.ReadFrom(in)
+	}
+	buf := r.buffer()
+	defer r.release(buf)
+	// hide ReadFrom and WriteTo, which io.CopyBuffer would use instead
+	// of buf
+	return io.CopyBuffer(writerOnly{out}, readerOnly{in}, buf)
+}
+
+type writerOnly struct{ io.Writer }
+
+type readerOnly struct{ io.Reader }
@@ -0,0 +1,75 @@
+package fsync
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readFromFS records how the files created in it are written.
+type readFromFS struct {
+	osFS
+	readFroms int
+	writes    []int
+}
+
+func (fs *readFromFS) Create(name string) (io.WriteCloser, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &readFromFile{f, fs}, nil
+}
+
+type readFromFile struct {
+	*os.File
+	fs *readFromFS
+}
+
+func (f *readFromFile) ReadFrom(r io.Reader) (int64, error) {
+	f.fs.readFroms++
+	return f.File.ReadFrom(r)
+}
+
+func (f *readFromFile) Write(b []byte) (int, error) {
+	f.fs.writes = append(f.fs.writes, len(b))
+	return f.File.Write(b)
+}
+
+func TestCopyData(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<12)
+	check(ioutil.WriteFile(filepath.Join(src, "a"), data, 0644))
+
+	// plain copies are left to the destination
+	fs := &readFromFS{}
+	s := NewSyncer()
+	s.DstFS = fs
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "a"), data, t)
+	if fs.readFroms != 1 || len(fs.writes) != 0 {
+		t.Errorf("expecting a to be copied with ReadFrom, got %d calls and %d writes", fs.readFroms, len(fs.writes))
+	}
+
+	// the rest go through buffers of BufferSize
+	fs.readFroms = 0
+	check(os.RemoveAll(dst))
+	s.WriteSums = true
+	s.BufferSize = roundPage(8 << 10)
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "a"), data, t)
+	if fs.readFroms != 0 {
+		t.Errorf("expecting no ReadFrom calls, got %d", fs.readFroms)
+	}
+	if len(fs.writes) == 0 || fs.writes[0] != s.BufferSize {
+		t.Errorf("expecting writes of %d bytes, got %v", s.BufferSize, fs.writes)
+	}
+}
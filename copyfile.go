@@ -0,0 +1,101 @@
+package fsync
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+var (
+	ErrNotFile = errors.New("fsync: the source isn't a regular file")
+	ErrNotDir  = errors.New("fsync: the source isn't a directory")
+)
+
+// CopyFile copies the file src to dst with a new Syncer; see
+// Syncer.CopyFile.
+func CopyFile(ctx context.Context, dst, src string) error {
+	return NewSyncer().CopyFile(ctx, dst, src)
+}
+
+// CopyDir copies the directory src to dst with a new Syncer; see
+// Syncer.CopyDir.
+func CopyDir(ctx context.Context, dst, src string) error {
+	return NewSyncer().CopyDir(ctx, dst, src)
+}
+
+// CopyFile copies the regular file src to dst, whether or not they're the
+// same already, as a sync copies files: with Atomic, backups, InPlace and
+// DeltaMinSize, Transformers and LineEndings, the hooks, RateLimit and the
+// buffers, Sidecars, Interference and Recheck, and then the permissions
+// and modification time, as the Syncer's fields say. What's about a tree,
+// such as Delete, Exclude and StateFile, is ignored. It fails with
+// ErrNotFile if src isn't a regular file, and with ctx.Err() if ctx is done
+// before it starts.
+func (s *Syncer) CopyFile(ctx context.Context, dst, src string) (err error) {
+	r, dst, src, err := s.newRun(dst, src)
+	if err != nil {
+		return err
+	}
+	r.ctx = ctx
+	defer func() {
+		if err2 := r.close(); err == nil {
+			err = err2
+		}
+	}()
+	sstat, err := r.sfs.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !sstat.Mode().IsRegular() {
+		return ErrNotFile
+	}
+	if b, err := r.checkDir(dst, src); err != nil {
+		return err
+	} else if b {
+		return ErrFileOverDir
+	}
+
+	r.dstRoot = dst
+	r.start(src)
+	defer r.finish()
+	err = r.scan(func() {
+		r.wait()
+		r.strict(src, sstat)
+		dstat, err := r.dfs.Stat(dst)
+		if err != nil && !os.IsNotExist(err) {
+			panic(err)
+		}
+		if err == nil && dstat.IsDir() {
+			r.stats.Deleted++
+			r.emit(Verbose, src, Event{Op: OpDelete, Item: itemDeleting})
+			if !r.DryRun {
+				r.discard(dst)
+			}
+			dstat = nil
+		}
+		r.progress.found(sstat.Size())
+		r.progress.changing(src, itemNew)
+		if r.DryRun {
+			r.copied(src, sstat.Size())
+			return
+		}
+		if dstat != nil {
+			r.compared(dst, dstat)
+		}
+		if r.copy(dst, src) {
+			r.syncstats(dst, src)
+		}
+	})
+	if err == nil && r.Recheck > 0 {
+		err = catch(r.recheck)
+	}
+	return err
+}
+
+// CopyDir copies the directory src to dst as SyncContext does, with all
+// the options of the Syncer, but fails with ErrNotDir if src isn't a
+// directory, rather than copying a file to dst.
+func (s *Syncer) CopyDir(ctx context.Context, dst, src string) error {
+	_, err := s.syncContext(ctx, dst, src, func(r *run) { r.dirOnly = true })
+	return err
+}
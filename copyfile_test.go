@@ -0,0 +1,57 @@
+package fsync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCopyFile(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "d"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("file a"), 0600))
+	tt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	check(os.Chtimes(filepath.Join(src, "a"), tt, tt))
+
+	ctx := context.Background()
+	check(CopyFile(ctx, filepath.Join(dir, "b"), filepath.Join(src, "a")))
+	testFile(filepath.Join(dir, "b"), []byte("file a"), t)
+	testPerms(filepath.Join(dir, "b"), 0600, t)
+	testModTime(filepath.Join(dir, "b"), tt, t)
+
+	// copied again, although the same
+	copies := 0
+	s := NewSyncer()
+	s.Atomic = true
+	s.AfterCopy = func(dst, src string, fi os.FileInfo) error {
+		copies++
+		return nil
+	}
+	check(s.CopyFile(ctx, filepath.Join(dir, "b"), filepath.Join(src, "a")))
+	if copies != 1 {
+		t.Errorf("expecting a copy, got %d", copies)
+	}
+
+	if err := CopyFile(ctx, filepath.Join(dir, "c"), filepath.Join(src, "d")); err != ErrNotFile {
+		t.Errorf("expecting ErrNotFile, got %v", err)
+	}
+	if err := CopyDir(ctx, dst, filepath.Join(src, "a")); err != ErrNotDir {
+		t.Errorf("expecting ErrNotDir, got %v", err)
+	}
+	check(CopyDir(ctx, dst, src))
+	testFile(filepath.Join(dst, "a"), []byte("file a"), t)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := CopyFile(canceled, filepath.Join(dir, "c"), filepath.Join(src, "a")); err != context.Canceled {
+		t.Errorf("expecting context.Canceled, got %v", err)
+	}
+	testExistence(filepath.Join(dir, "c"), false, t)
+}
@@ -0,0 +1,73 @@
+package davfs
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mostafah/fsync"
+	"golang.org/x/net/webdav"
+)
+
+func TestSync(t *testing.T) {
+	src, err := ioutil.TempDir(os.TempDir(), "davfs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	srv, err := ioutil.TempDir(os.TempDir(), "davfs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srv)
+	if err := os.MkdirAll(filepath.Join(src, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "a/b"), []byte("file b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "c"), []byte("file c"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(srv, "site"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(&webdav.Handler{
+		FileSystem: webdav.Dir(srv),
+		LockSystem: webdav.NewMemLS(),
+	})
+	defer ts.Close()
+	fs, err := New(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := fsync.NewSyncer()
+	s.DstFS = fs
+	s.Delete = true
+	if err := s.Sync("/site/deploy", src); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"a/b": "file b", "c": "file c"} {
+		b, err := ioutil.ReadFile(filepath.Join(srv, "site/deploy", name))
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != content {
+			t.Errorf("%s is %q, should be %q", name, b, content)
+		}
+	}
+
+	// a second sync deletes extra files
+	if err := os.Remove(filepath.Join(src, "c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Sync("/site/deploy", src); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(srv, "site/deploy/c")); !os.IsNotExist(err) {
+		t.Errorf("site/deploy/c should be deleted, got %v", err)
+	}
+}
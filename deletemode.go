@@ -0,0 +1,109 @@
+package fsync
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// DeleteMode is when Delete deletes files in the destination.
+type DeleteMode int
+
+const (
+	// DeleteDuring deletes them directory by directory, after syncing
+	// each one. Nothing is deleted in a directory before the files copied
+	// into it are, even by Workers, nor at all if one of them failed with
+	// ContinueOnError or OnError.
+	DeleteDuring DeleteMode = iota
+	// DeleteBefore deletes them all before anything is copied, which
+	// frees space for the copies. DetectRenames can't move files deleted
+	// this way.
+	DeleteBefore
+	// DeleteAfter deletes them once all the files are copied, so that
+	// nothing is deleted if the sync fails before. As with DeleteDuring,
+	// nothing is deleted in a directory where a file failed.
+	DeleteAfter
+)
+
+// deleteFirst deletes the files in dst that aren't in src, with
+// DeleteBefore.
+func (r *run) deleteFirst(dst, src string) {
+	sstat, err := r.sfs.Stat(src)
+	check(err)
+	dstat, err := r.dfs.Stat(dst)
+	if os.IsNotExist(err) {
+		return
+	}
+	check(err)
+	if sstat.IsDir() && dstat.IsDir() {
+		r.deleteExtras(dst, src)
+	}
+	r.removePending()
+}
+
+// deleteExtras deletes the files in the directory dst that aren't in the
+// directory src, and likewise in their subdirectories.
+func (r *run) deleteExtras(dst, src string) {
+	sfiles, err := r.sfs.ReadDir(src)
+	check(err)
+	dfiles, err := r.dfs.ReadDir(dst)
+	check(err)
+	m := make(map[string]bool, len(sfiles))
+	dirs := make(map[string]bool)
+	for _, file := range sfiles {
+		src2 := filepath.Join(src, file.Name())
+		if r.excluded(src2, file) && !r.descend(src2, file) {
+			continue
+		}
+		m[file.Name()] = true
+		dirs[file.Name()] = file.IsDir()
+	}
+	for _, file := range dfiles {
+		if !m[file.Name()] {
+			continue
+		}
+		if !file.IsDir() && !dirs[file.Name()] {
+			r.dstFiles++ // for MaxDeletePercent
+		} else if file.IsDir() && dirs[file.Name()] {
+			r.deleteExtras(filepath.Join(dst, file.Name()), filepath.Join(src, file.Name()))
+		}
+	}
+	r.removeExtras(dst, src, m)
+}
+
+// DeleteExtras deletes what's in the destination dst and not in the source
+// src, as Delete does, without copying anything, for trees copied by other
+// means. Exclude, Include, Filter, Protect, DeleteTo and backups, MaxDelete
+// and MaxDeletePercent, DryRun and OnEvent apply as they do to Delete. The
+// Stats returned count what was deleted.
+func (s *Syncer) DeleteExtras(dst, src string) (Stats, error) {
+	r, dst, src, err := s.newRun(dst, src)
+	if err != nil {
+		return Stats{}, err
+	}
+	err = r.deleteOnly(dst, src)
+	return r.result(), err
+}
+
+// deleteOnly deletes the files in dst that aren't in src, for DeleteExtras,
+// and closes the file systems of r.
+func (r *run) deleteOnly(dst, src string) (err error) {
+	defer func() {
+		if err2 := r.close(); err == nil {
+			err = err2
+		}
+	}()
+	if err := checkPatterns(r.Exclude, r.Include, r.Protect); err != nil {
+		return err
+	}
+	if _, err := r.sfs.Stat(src); err != nil {
+		return err
+	}
+	r.dstRoot = dst
+	r.start(src)
+	defer r.finish()
+	err = r.scan(func() { r.deleteFirst(dst, src) })
+	if err == nil {
+		err = r.failure()
+	}
+	return err
+}
@@ -0,0 +1,154 @@
+package fsync
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeleteMode(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	check(os.MkdirAll(filepath.Join(src, "a"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a/x"), []byte("file x"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "b"), []byte("file b"), 0644))
+	mtime := time.Unix(1e9, 0)
+	check(os.Chtimes(filepath.Join(src, "a"), mtime, mtime))
+
+	for _, c := range []struct {
+		mode DeleteMode
+		ops  string
+	}{
+		{DeleteDuring, "copy a/x, delete a/y, copy b, delete c"},
+		{DeleteBefore, "delete a/y, delete c, copy a/x, copy b"},
+		{DeleteAfter, "copy a/x, copy b, delete a/y, delete c"},
+	} {
+		dst := filepath.Join(dir, "dst")
+		check(os.RemoveAll(dst))
+		check(os.MkdirAll(filepath.Join(dst, "a"), 0755))
+		check(ioutil.WriteFile(filepath.Join(dst, "a/y"), []byte("file y"), 0644))
+		check(ioutil.WriteFile(filepath.Join(dst, "c"), []byte("file c"), 0644))
+
+		var ops []string
+		s := NewSyncer()
+		s.Delete = true
+		s.DeleteMode = c.mode
+		s.Verbosity = Verbose
+		s.OnEvent = func(e Event) {
+			ops = append(ops, e.Op.String()+" "+e.Path)
+		}
+		check(s.Sync(dst, src))
+		if got := strings.Join(ops, ", "); got != c.ops {
+			t.Errorf("mode %d: expecting %s, got %s", c.mode, c.ops, got)
+		}
+		testDirContents(filepath.Join(dst, "a"), 1, t)
+		testDirContents(dst, 2, t)
+		fi, err := os.Stat(filepath.Join(dst, "a"))
+		check(err)
+		if !fi.ModTime().Equal(mtime) {
+			t.Errorf("mode %d: a has modification time %v, expecting %v", c.mode, fi.ModTime(), mtime)
+		}
+	}
+}
+
+// slowFS takes a while to create files.
+type slowFS struct{ osFS }
+
+func (fs slowFS) Create(name string) (io.WriteCloser, error) {
+	time.Sleep(20 * time.Millisecond)
+	return fs.osFS.Create(name)
+}
+
+func TestDeleteAfterCopies(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "a"), 0755))
+	check(os.MkdirAll(filepath.Join(dst, "a"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a/x"), []byte("file x"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "a/denied"), []byte("file denied"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "b"), []byte("file b"), 0644))
+	check(ioutil.WriteFile(filepath.Join(dst, "a/y"), []byte("file y"), 0644))
+	check(ioutil.WriteFile(filepath.Join(dst, "c"), []byte("file c"), 0644))
+
+	// the workers copy b before c is deleted
+	var mu sync.Mutex
+	var ops []string
+	s := NewSyncer()
+	s.SrcFS = denyFS{}
+	s.DstFS = slowFS{}
+	s.Workers = 2
+	s.Delete = true
+	s.ContinueOnError = true
+	s.Verbosity = Verbose
+	s.OnEvent = func(e Event) {
+		mu.Lock()
+		ops = append(ops, e.Op.String()+" "+e.Path)
+		mu.Unlock()
+	}
+	if _, ok := s.Sync(dst, src).(SyncErrors); !ok {
+		t.Fatal("expecting a/denied to fail")
+	}
+	got := strings.Join(ops, ", ")
+	if i := strings.Index(got, "delete c"); i < 0 || i < strings.Index(got, "copy b") {
+		t.Errorf("expecting b to be copied before c is deleted, got %s", got)
+	}
+
+	// nothing is deleted where a file failed
+	testExistence(filepath.Join(dst, "a/y"), true, t)
+}
+
+func TestDeleteExtras(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "a"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a/x"), []byte("file x"), 0644))
+	check(os.MkdirAll(filepath.Join(dst, "a/d"), 0755))
+	for _, name := range []string{"a/y", "a/d/z", "b", "keep", "c.log"} {
+		check(ioutil.WriteFile(filepath.Join(dst, name), []byte(name), 0644))
+	}
+
+	s := NewSyncer()
+	s.Protect = []string{"/keep"}
+	s.Exclude = []string{"*.log"}
+	s.DryRun = true
+	stats, err := s.DeleteExtras(dst, src)
+	check(err)
+	if stats.Deleted != 3 {
+		t.Errorf("expecting 3 deletions in a dry run, got %+v", stats)
+	}
+	testExistence(filepath.Join(dst, "b"), true, t)
+
+	s.DryRun = false
+	s.MaxDelete = 2
+	if _, err := s.DeleteExtras(dst, src); err != ErrMaxDelete {
+		t.Errorf("expecting ErrMaxDelete, got %v", err)
+	}
+	testExistence(filepath.Join(dst, "b"), true, t)
+
+	s.MaxDelete = 0
+	stats, err = s.DeleteExtras(dst, src)
+	check(err)
+	if stats.Deleted != 3 || stats.Files != 0 {
+		t.Errorf("expecting 3 deletions and no copies, got %+v", stats)
+	}
+	for _, name := range []string{"a/y", "a/d", "b"} {
+		testExistence(filepath.Join(dst, name), false, t)
+	}
+	for _, name := range []string{"keep", "c.log"} {
+		testExistence(filepath.Join(dst, name), true, t)
+	}
+	testExistence(filepath.Join(dst, "a/x"), false, t)
+}
@@ -0,0 +1,270 @@
+package fsync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math"
+	"os"
+)
+
+// maxLiteral is how much data that's in no block of the destination is
+// buffered before it's written.
+const maxLiteral = 1 << 20
+
+// UpdateFile is a file opened for changing in place.
+type UpdateFile interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+	Truncate(size int64) error
+}
+
+// Updater is implemented by file systems that can change files in place,
+// which InPlace and DeltaMinSize need.
+type Updater interface {
+	// Update opens the existing file name for reading and writing.
+	Update(name string) (UpdateFile, error)
+}
+
+func (osFS) Update(name string) (UpdateFile, error) {
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// update updates the file dst in place to match src, if InPlace or
+// DeltaMinSize allows, and returns true if it did.
+func (r *run) update(dst, src string) bool {
+	u, ok := r.dfs.(Updater)
+	if !ok || !r.InPlace && r.DeltaMinSize <= 0 {
+		return false
+	}
+	dstat, err := r.dfs.Stat(dst)
+	if err != nil || !dstat.Mode().IsRegular() {
+		return false
+	}
+	useDelta := r.DeltaMinSize > 0 && dstat.Size() >= r.DeltaMinSize && r.deltaFits(dstat.Size())
+	if !useDelta && !r.InPlace {
+		return false
+	}
+	sf, err := r.sfs.Open(src)
+	if os.IsNotExist(err) {
+		r.vanished(src)
+		return true
+	}
+	check(err)
+	defer sf.Close()
+	df, err := u.Update(dst)
+	check(err)
+	defer df.Close()
+
+	d := &delta{f: df, bs: blockSize(dstat.Size())}
+	d.tmp = make([]byte, d.bs)
+	in, h := r.reader(src, sf)
+	var n int64
+	if useDelta {
+		d.sign(dstat.Size())
+		n = d.apply(in)
+	} else {
+		n = d.overwrite(in)
+	}
+	check(df.Truncate(n))
+	check(df.Close())
+	var sum []byte
+	if h != nil {
+		sum = h.Sum(nil)
+		r.matchSidecar(src, sum)
+		r.summed(dst, sum)
+		r.hashed(src, sum)
+	}
+	r.wrote(dst, src, n, sum)
+	r.copied(src, n)
+	r.hook(r.AfterCopy, dst, src)
+	return true
+}
+
+// blockSize returns the size of the blocks a file of the given size is
+// compared by: about its square root, as in rsync, within limits.
+func blockSize(size int64) int {
+	bs := int(math.Sqrt(float64(size))) &^ 1023
+	if bs < 4<<10 {
+		bs = 4 << 10
+	} else if bs > 1<<20 {
+		bs = 1 << 20
+	}
+	return bs
+}
+
+// delta updates a file in place. Data is only written at offsets before
+// where the source is read, so blocks are only reused from offsets after
+// it, which haven't been written yet.
+type delta struct {
+	f      UpdateFile
+	bs     int
+	weak   map[uint32][]int64          // offsets of the blocks by weak checksum
+	strong map[int64][sha256.Size]byte // strong checksums by offset
+	tmp    []byte
+}
+
+// weakSum returns the rolling checksum of b, as two sums that are rolled
+// separately.
+func weakSum(b []byte) (s1, s2 uint32) {
+	for _, c := range b {
+		s1 += uint32(c)
+		s2 += s1
+	}
+	return s1, s2
+}
+
+func weak(s1, s2 uint32) uint32 {
+	return s1&0xffff | s2<<16
+}
+
+// sign computes the checksums of the whole blocks of the file, which is
+// size bytes long.
+func (d *delta) sign(size int64) {
+	d.weak = make(map[uint32][]int64)
+	d.strong = make(map[int64][sha256.Size]byte)
+	for off := int64(0); off+int64(d.bs) <= size; off += int64(d.bs) {
+		_, err := d.f.ReadAt(d.tmp, off)
+		check(err)
+		w := weak(weakSum(d.tmp))
+		d.weak[w] = append(d.weak[w], off)
+		d.strong[off] = sha256.Sum256(d.tmp)
+	}
+}
+
+// find returns the offset of a block equal to b, with the weak checksum w,
+// that can be used at the offset p; p itself if possible.
+func (d *delta) find(w uint32, b []byte, p int64) (int64, bool) {
+	offs := d.weak[w]
+	if len(offs) == 0 {
+		return 0, false
+	}
+	sum := sha256.Sum256(b)
+	found := int64(-1)
+	for _, q := range offs {
+		if q < p || d.strong[q] != sum {
+			continue
+		}
+		if q == p {
+			return q, true
+		}
+		if found < 0 || q < found {
+			found = q
+		}
+	}
+	return found, found >= 0
+}
+
+// write writes b at off, unless the file already has it there.
+func (d *delta) write(b []byte, off int64) {
+	if len(b) <= len(d.tmp) {
+		n, _ := d.f.ReadAt(d.tmp[:len(b)], off)
+		if n == len(b) && bytes.Equal(d.tmp[:n], b) {
+			return
+		}
+	}
+	_, err := d.f.WriteAt(b, off)
+	check(err)
+}
+
+// overwrite writes the contents of in over the file, block by block, and
+// returns its size.
+func (d *delta) overwrite(in io.Reader) int64 {
+	buf := make([]byte, d.bs)
+	var off int64
+	for {
+		n, err := io.ReadFull(in, buf)
+		d.write(buf[:n], off)
+		off += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return off
+		}
+		check(err)
+	}
+}
+
+// apply writes the contents of in over the file, reusing its blocks, and
+// returns its size.
+func (d *delta) apply(in io.Reader) int64 {
+	var (
+		buf       []byte
+		base      int64 // offset of buf[0]
+		off, last int   // the window starts at off; what's before it from last isn't written yet
+		eof       bool
+		s1, s2    uint32
+		summed    bool
+		chunk     = make([]byte, 64<<10)
+		bs        = d.bs
+	)
+	// fill reads until there's a byte after the window, or the end
+	fill := func() {
+		if last > 0 {
+			buf = append(buf[:0], buf[last:]...)
+			base += int64(last)
+			off -= last
+			last = 0
+		}
+		for !eof && len(buf) < off+bs+1 {
+			n, err := in.Read(chunk)
+			buf = append(buf, chunk[:n]...)
+			if err == io.EOF {
+				eof = true
+			} else {
+				check(err)
+			}
+		}
+	}
+	// flush writes the data that's in no block up to end
+	flush := func(end int) {
+		for last < end {
+			n := end - last
+			if n > bs {
+				n = bs
+			}
+			d.write(buf[last:last+n], base+int64(last))
+			last += n
+		}
+	}
+
+	for {
+		fill()
+		if len(buf)-off < bs {
+			break // the rest is in no block
+		}
+		if !summed {
+			s1, s2 = weakSum(buf[off : off+bs])
+			summed = true
+		}
+		p := base + int64(off)
+		if q, ok := d.find(weak(s1, s2), buf[off:off+bs], p); ok {
+			flush(off)
+			if q != p {
+				_, err := d.f.ReadAt(d.tmp, q)
+				check(err)
+				_, err = d.f.WriteAt(d.tmp, p)
+				check(err)
+			}
+			off += bs
+			last = off
+			summed = false
+			continue
+		}
+		if len(buf)-off == bs {
+			break // nothing to roll in
+		}
+		x, y := uint32(buf[off]), uint32(buf[off+bs])
+		s1 += y - x
+		s2 += s1 - uint32(bs)*x
+		off++
+		if off-last >= maxLiteral {
+			flush(off)
+		}
+	}
+	flush(len(buf))
+	return base + int64(len(buf))
+}
@@ -0,0 +1,104 @@
+package fsync
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// countFS counts the bytes written to files updated in place.
+type countFS struct {
+	osFS
+	written int64
+}
+
+func (fs *countFS) Update(name string) (UpdateFile, error) {
+	f, err := fs.osFS.Update(name)
+	if err != nil {
+		return nil, err
+	}
+	return &countFile{f, fs}, nil
+}
+
+type countFile struct {
+	UpdateFile
+	fs *countFS
+}
+
+func (f *countFile) WriteAt(b []byte, off int64) (int, error) {
+	f.fs.written += int64(len(b))
+	return f.UpdateFile.WriteAt(b, off)
+}
+
+func TestDelta(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	check(ioutil.WriteFile(src, data, 0644))
+	check(ioutil.WriteFile(dst, data, 0644))
+
+	fs := &countFS{}
+	s := NewSyncer()
+	s.DstFS = fs
+	s.DeltaMinSize = 1
+	for _, c := range []struct {
+		name   string
+		change func([]byte) []byte
+		max    int64 // bytes written
+	}{
+		{"changed", func(b []byte) []byte { b[500000] ^= 1; return b }, 8 << 10},
+		{"removed", func(b []byte) []byte { return append(b[:100:100], b[108:]...) }, 1 << 20},
+		{"inserted", func(b []byte) []byte {
+			return append(b[:100:100], append([]byte("inserted"), b[100:]...)...)
+		}, 2 << 20},
+		{"appended", func(b []byte) []byte { return append(b, "appended"...) }, 8 << 10},
+		{"truncated", func(b []byte) []byte { return b[:len(b)-5000] }, 0},
+		{"shorter", func(b []byte) []byte { return b[:1000] }, 0},
+	} {
+		data = c.change(append([]byte(nil), data...))
+		check(ioutil.WriteFile(src, data, 0644))
+		fs.written = 0
+		check(s.Sync(dst, src))
+		got, err := ioutil.ReadFile(dst)
+		check(err)
+		if !bytes.Equal(got, data) {
+			t.Fatalf("%s: wrong contents", c.name)
+		}
+		if fs.written > c.max {
+			t.Errorf("%s: %d bytes written, expecting at most %d", c.name, fs.written, c.max)
+		}
+	}
+}
+
+func TestInPlace(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	link := filepath.Join(dir, "link")
+	data := make([]byte, 100<<10)
+	rand.New(rand.NewSource(1)).Read(data)
+	check(ioutil.WriteFile(dst, data, 0644))
+	check(os.Link(dst, link))
+	data[50000] ^= 1
+	data = append(data, "more"...)
+	check(ioutil.WriteFile(src, data, 0644))
+
+	fs := &countFS{}
+	s := NewSyncer()
+	s.DstFS = fs
+	s.InPlace = true
+	check(s.Sync(dst, src))
+	testFile(link, data, t)
+	if fs.written > 8<<10 {
+		t.Errorf("%d bytes written, expecting a block at most", fs.written)
+	}
+}
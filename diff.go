@@ -0,0 +1,221 @@
+package fsync
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Change is how an entry of a DiffTree changed, as a set of flags.
+type Change int
+
+const (
+	Added       Change = 1 << iota // it's only in the second tree
+	Removed                        // it's only in the first tree
+	Modified                       // its contents, or its type, changed
+	PermChanged                    // its permissions changed
+)
+
+var changeNames = []string{"added", "removed", "modified", "permissions changed"}
+
+func (c Change) String() string {
+	var names []string
+	for i, name := range changeNames {
+		if c&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "unchanged"
+	}
+	return strings.Join(names, ", ")
+}
+
+// DiffTree is a file or directory that differs between two trees, or has
+// differences in it, as Diff returns.
+type DiffTree struct {
+	Path   string // relative to the trees, slash-separated; "." is the root
+	Dir    bool   // in the second tree, or the first if it was removed
+	Change Change // zero if only what's in it changed
+	// Children are the entries in the directory that differ, in the order
+	// of their names. What's in directories added or removed is too.
+	Children []*DiffTree
+}
+
+// Diff returns how the tree b differs from the tree a.
+func Diff(a, b string) (*DiffTree, error) {
+	return NewSyncer().Diff(a, b)
+}
+
+// Diff returns how the tree b differs from the tree a: what a sync with
+// Delete would change in a if b was its source. Files are compared as
+// Comparison says, and what Exclude matches in b, and its counterpart in a,
+// are left out. Nothing is changed.
+func (s *Syncer) Diff(a, b string) (*DiffTree, error) {
+	r, a, b, err := s.newRun(a, b)
+	if err != nil {
+		return nil, err
+	}
+	defer r.close()
+	if err := checkPatterns(s.Exclude, s.Include); err != nil {
+		return nil, err
+	}
+	r.root, r.dstRoot = b, a
+	var d *DiffTree
+	err = catch(func() {
+		ainfo, err := r.dfs.Stat(a)
+		check(err)
+		binfo, err := r.sfs.Stat(b)
+		check(err)
+		d = r.diff(a, b, ainfo, binfo)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		d = &DiffTree{Path: ".", Dir: true}
+	}
+	return d, nil
+}
+
+// diff returns how the name b in the second tree differs from a in the
+// first, whose infos, nil if they're missing, are ainfo and binfo, or nil
+// if it doesn't.
+func (r *run) diff(a, b string, ainfo, binfo os.FileInfo) *DiffTree {
+	d := &DiffTree{Path: r.rel(b)}
+	switch {
+	case ainfo == nil:
+		d.Change, d.Dir = Added, binfo.IsDir()
+	case binfo == nil:
+		d.Change, d.Dir = Removed, ainfo.IsDir()
+	default:
+		d.Dir = binfo.IsDir()
+		if ainfo.IsDir() != binfo.IsDir() || !d.Dir && !r.equal(a, b) {
+			d.Change |= Modified
+		}
+		if r.permDiffers(ainfo, binfo) {
+			d.Change |= PermChanged
+		}
+	}
+	if d.Dir && d.Change&Modified == 0 {
+		d.Children = r.diffDir(a, b, ainfo, binfo)
+	}
+	if d.Change == 0 && len(d.Children) == 0 {
+		return nil
+	}
+	return d
+}
+
+// diffDir returns the entries that differ in the directories a and b.
+func (r *run) diffDir(a, b string, ainfo, binfo os.FileInfo) []*DiffTree {
+	infos := make(map[string][2]os.FileInfo)
+	if ainfo != nil {
+		files, err := r.dfs.ReadDir(a)
+		check(err)
+		for _, fi := range files {
+			infos[fi.Name()] = [2]os.FileInfo{fi, nil}
+		}
+	}
+	if binfo != nil {
+		files, err := r.sfs.ReadDir(b)
+		check(err)
+		for _, fi := range files {
+			infos[fi.Name()] = [2]os.FileInfo{infos[fi.Name()][0], fi}
+		}
+	}
+	names := make([]string, 0, len(infos))
+	for name := range infos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var children []*DiffTree
+	for _, name := range names {
+		a2, b2 := filepath.Join(a, name), filepath.Join(b, name)
+		fi := infos[name]
+		info := fi[1]
+		if info == nil {
+			info = fi[0]
+			if isTemp(name) || r.isBackup(a2) {
+				continue
+			}
+		}
+		if r.excluded(b2, info) && !r.descend(b2, info) {
+			continue
+		}
+		if d := r.diff(a2, b2, fi[0], fi[1]); d != nil {
+			children = append(children, d)
+		}
+	}
+	return children
+}
+
+// Walk calls f with d and the entries in it, depth first, skipping what's
+// in those f returns false for.
+func (d *DiffTree) Walk(f func(d *DiffTree) bool) {
+	if !f(d) {
+		return
+	}
+	for _, c := range d.Children {
+		c.Walk(f)
+	}
+}
+
+// Filter returns a copy of d with only the entries keep returns true for,
+// and the directories they're in.
+func (d *DiffTree) Filter(keep func(d *DiffTree) bool) *DiffTree {
+	if c := d.filter(keep); c != nil {
+		return c
+	}
+	return &DiffTree{Path: d.Path, Dir: d.Dir}
+}
+
+func (d *DiffTree) filter(keep func(d *DiffTree) bool) *DiffTree {
+	c := &DiffTree{Path: d.Path, Dir: d.Dir}
+	if d.Change != 0 && keep(d) {
+		c.Change = d.Change
+	}
+	for _, child := range d.Children {
+		if f := child.filter(keep); f != nil {
+			c.Children = append(c.Children, f)
+		}
+	}
+	if c.Change == 0 && len(c.Children) == 0 {
+		return nil
+	}
+	return c
+}
+
+// Empty returns true if the trees are the same.
+func (d *DiffTree) Empty() bool {
+	return d.Change == 0 && len(d.Children) == 0
+}
+
+// String lists the entries that changed, one per line, each with a sign:
+// "+" for added, "-" for removed, "M" for modified and "P" for permissions
+// changed only. Directories end with a slash.
+func (d *DiffTree) String() string {
+	var b strings.Builder
+	d.Walk(func(d *DiffTree) bool {
+		if d.Change == 0 {
+			return true
+		}
+		sign := "P"
+		switch {
+		case d.Change&Added != 0:
+			sign = "+"
+		case d.Change&Removed != 0:
+			sign = "-"
+		case d.Change&Modified != 0:
+			sign = "M"
+		}
+		name := d.Path
+		if d.Dir {
+			name = path.Clean(name) + "/"
+		}
+		b.WriteString(sign + " " + name + "\n")
+		return true
+	})
+	return b.String()
+}
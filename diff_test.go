@@ -0,0 +1,58 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	check(os.MkdirAll(filepath.Join(a, "d/e"), 0755))
+	for _, name := range []string{"d/e/x", "d/y", "z", "w"} {
+		check(ioutil.WriteFile(filepath.Join(a, name), []byte("file "+name), 0644))
+	}
+	check(Sync(b, a))
+	d, err := Diff(a, b)
+	check(err)
+	if !d.Empty() {
+		t.Errorf("expecting no differences, got\n%s", d)
+	}
+
+	check(os.RemoveAll(filepath.Join(b, "d/e")))
+	check(os.MkdirAll(filepath.Join(b, "f/g"), 0755))
+	check(ioutil.WriteFile(filepath.Join(b, "f/g/v"), []byte("file v"), 0644))
+	check(ioutil.WriteFile(filepath.Join(b, "d/y"), []byte("file d/Y"), 0644))
+	check(os.Chmod(filepath.Join(b, "z"), 0600))
+	check(ioutil.WriteFile(filepath.Join(b, "w"), []byte("file W"), 0600))
+	check(os.Chmod(filepath.Join(b, "w"), 0600))
+	d, err = Diff(a, b)
+	check(err)
+	want := "- d/e/\n- d/e/x\nM d/y\n+ f/\n+ f/g/\n+ f/g/v\nM w\nP z\n"
+	if got := d.String(); got != want {
+		t.Errorf("expecting\n%s\ngot\n%s", want, got)
+	}
+	var changes []Change
+	d.Walk(func(d *DiffTree) bool {
+		if d.Path == "w" {
+			changes = append(changes, d.Change)
+		}
+		return d.Path != "f"
+	})
+	if len(changes) != 1 || changes[0] != Modified|PermChanged {
+		t.Errorf("expecting w modified with its permissions, got %v", changes)
+	}
+
+	added := d.Filter(func(d *DiffTree) bool { return d.Change&Added != 0 })
+	if got := added.String(); got != "+ f/\n+ f/g/\n+ f/g/v\n" {
+		t.Errorf("unexpected filtered diff\n%s", got)
+	}
+	if !d.Filter(func(*DiffTree) bool { return false }).Empty() {
+		t.Error("expecting nothing to be left by the filter")
+	}
+}
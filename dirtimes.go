@@ -0,0 +1,38 @@
+package fsync
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// touch records that the contents of the destination directory dst, whose
+// source is src, changed after its stats may have been synced, as moves and
+// deletions left for the end do, for restat.
+func (r *run) touch(dst, src string) {
+	if r.touched == nil {
+		r.touched = make(map[string]string)
+	}
+	r.touched[dst] = src
+}
+
+// restat syncs the stats of the directories touched again, deepest first,
+// like rsync, so that each is done after its contents.
+func (r *run) restat() {
+	dirs := make([]string, 0, len(r.touched))
+	for dst := range r.touched {
+		dirs = append(dirs, dst)
+	}
+	sep := string(filepath.Separator)
+	sort.Slice(dirs, func(i, j int) bool {
+		if a, b := strings.Count(dirs[i], sep), strings.Count(dirs[j], sep); a != b {
+			return a > b
+		}
+		return dirs[i] < dirs[j]
+	})
+	for _, dst := range dirs {
+		src := r.touched[dst]
+		r.try(src, func() { r.syncstats(dst, src) })
+	}
+	r.touched = nil
+}
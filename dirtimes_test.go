@@ -0,0 +1,56 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDirTimes(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "a/b"), 0755))
+	check(os.MkdirAll(filepath.Join(src, "c"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a/b/x"), []byte("file x"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "a/y"), []byte("file y"), 0644))
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	chtimes := func() {
+		for _, d := range []string{"", "a", "a/b", "c"} {
+			check(os.Chtimes(filepath.Join(src, d), past, past))
+		}
+	}
+	testTimes := func() {
+		for _, d := range []string{"", "a", "a/b", "c"} {
+			fi, err := os.Stat(filepath.Join(dst, d))
+			check(err)
+			if !fi.ModTime().Equal(past) {
+				t.Errorf("expecting %q to have the modification time of its source, got %v", d, fi.ModTime())
+			}
+		}
+	}
+
+	s := NewSyncer()
+	s.StateFile = filepath.Join(dir, "state")
+	s.DetectRenames = true
+	s.Delete = true
+	s.DeleteMode = DeleteAfter
+	chtimes()
+	check(s.Sync(dst, src))
+	testTimes()
+
+	// moved from a/b, which was synced before c
+	check(os.Rename(filepath.Join(src, "a/b/x"), filepath.Join(src, "c/x")))
+	check(os.Remove(filepath.Join(src, "a/y")))
+	chtimes()
+	stats, err := s.SyncStats(dst, src)
+	check(err)
+	if stats.Moved != 1 || stats.Deleted != 1 {
+		t.Errorf("expecting a move and a deletion, got %+v", stats)
+	}
+	testTimes()
+}
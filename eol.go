@@ -0,0 +1,81 @@
+package fsync
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// LineEndings is what the line endings of text files are changed to as
+// they're copied.
+type LineEndings int
+
+const (
+	// PreserveLineEndings leaves them as they are.
+	PreserveLineEndings LineEndings = iota
+	// UnixLineEndings changes CRLF to LF.
+	UnixLineEndings
+	// WindowsLineEndings changes LF to CRLF.
+	WindowsLineEndings
+)
+
+// sniffLen is how much of a file is looked at to tell if it's binary, as
+// git does.
+const sniffLen = 8000
+
+// newlines returns in with the line endings changed to CRLF if crlf is
+// true, or to LF otherwise, unless there's a zero byte in its start, which
+// makes it binary.
+func newlines(in io.Reader, crlf bool) io.Reader {
+	br := bufio.NewReaderSize(in, sniffLen)
+	head, _ := br.Peek(sniffLen) // errors are returned by Read
+	if bytes.IndexByte(head, 0) >= 0 {
+		return br
+	}
+	return &eolReader{r: br, crlf: crlf}
+}
+
+// eolReader changes the line endings of what it reads.
+type eolReader struct {
+	r    io.Reader
+	crlf bool
+	cr   bool // the last byte read was '\r'; held back without crlf
+	in   [32 << 10]byte
+	buf  []byte // changed and not read yet
+	err  error
+}
+
+func (e *eolReader) Read(p []byte) (int, error) {
+	for len(e.buf) == 0 {
+		if e.err != nil {
+			return 0, e.err
+		}
+		var n int
+		n, e.err = e.r.Read(e.in[:])
+		e.buf = e.buf[:0]
+		for _, c := range e.in[:n] {
+			if e.crlf {
+				if c == '\n' && !e.cr {
+					e.buf = append(e.buf, '\r')
+				}
+				e.buf = append(e.buf, c)
+				e.cr = c == '\r'
+				continue
+			}
+			if e.cr && c != '\n' {
+				e.buf = append(e.buf, '\r')
+			}
+			e.cr = c == '\r'
+			if !e.cr {
+				e.buf = append(e.buf, c)
+			}
+		}
+		if e.err != nil && e.cr && !e.crlf {
+			e.buf = append(e.buf, '\r')
+			e.cr = false
+		}
+	}
+	n := copy(p, e.buf)
+	e.buf = e.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,57 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestNewlines(t *testing.T) {
+	for _, c := range []struct {
+		in       string
+		lf, crlf string
+	}{
+		{"a\nb\r\nc", "a\nb\nc", "a\r\nb\r\nc"},
+		{"a\r\n\r\n", "a\n\n", "a\r\n\r\n"},
+		{"a\rb\r", "a\rb\r", "a\rb\r"},
+		{"a\x00\r\nb\n", "a\x00\r\nb\n", "a\x00\r\nb\n"},
+	} {
+		for _, crlf := range []bool{false, true} {
+			want := c.lf
+			if crlf {
+				want = c.crlf
+			}
+			// one byte at a time, to split line endings between reads
+			got, err := ioutil.ReadAll(iotest.OneByteReader(newlines(iotest.OneByteReader(strings.NewReader(c.in)), crlf)))
+			check(err)
+			if string(got) != want {
+				t.Errorf("%q with crlf %v: expecting %q, got %q", c.in, crlf, want, got)
+			}
+		}
+	}
+}
+
+func TestLineEndings(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("line 1\nline 2\n"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "b"), []byte("\x00\n"), 0644))
+
+	s := NewSyncer()
+	s.LineEndings = WindowsLineEndings
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "a"), []byte("line 1\r\nline 2\r\n"), t)
+	testFile(filepath.Join(dst, "b"), []byte("\x00\n"), t)
+	stats, err := s.SyncStats(dst, src)
+	check(err)
+	if stats.Files != 0 {
+		t.Errorf("expecting nothing to be copied again, got %+v", stats)
+	}
+}
@@ -0,0 +1,144 @@
+package fsync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// SyncError is the failure of a file or directory, as passed to OnError.
+type SyncError struct {
+	Path string // relative to the source, slash-separated
+	Err  error
+}
+
+func (e *SyncError) Error() string {
+	return "fsync: " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *SyncError) Unwrap() error { return e.Err }
+
+// SyncErrors is what a sync with ContinueOnError fails with when files
+// failed, in the order they did.
+type SyncErrors []*SyncError
+
+func (errs SyncErrors) Error() string {
+	if len(errs) == 1 {
+		return errs[0].Error()
+	}
+	return fmt.Sprintf("%v (and %d more errors)", errs[0], len(errs)-1)
+}
+
+// ErrorAction is what OnError decides to do with a file or directory that
+// failed.
+type ErrorAction int
+
+const (
+	// AbortOnError fails the sync with the error.
+	AbortOnError ErrorAction = iota
+	// SkipOnError leaves the file or directory out, as ContinueOnError
+	// does, and goes on.
+	SkipOnError
+	// RetryOnError tries to sync it again.
+	RetryOnError
+)
+
+// failures holds the errors of a run that were skipped.
+type failures struct {
+	mu   sync.Mutex
+	errs SyncErrors
+	dirs map[string]bool // source directories of errs
+}
+
+// try calls f, which syncs the source name src, and if it panics with an
+// error, retries it as Retries allows, and then does what OnError or
+// ContinueOnError decide.
+func (r *run) try(src string, f func()) {
+	tries, delay := 0, r.RetryDelay
+	for {
+		err := catch(f)
+		if err == nil {
+			return
+		}
+		if stopCause(err) != NotStopped {
+			panic(err) // not the file's failure
+		}
+		if os.IsNotExist(err) && r.gone(src) {
+			r.vanished(src)
+			return
+		}
+		if tries < r.Retries && r.retryable(err) {
+			tries++
+			select {
+			case <-r.clock().After(delay):
+			case <-r.ctx.Done():
+			}
+			check(r.ctx.Err())
+			delay *= 2
+			continue
+		}
+		switch r.action(src, err) {
+		case AbortOnError:
+			panic(err)
+		case SkipOnError:
+			return
+		}
+	}
+}
+
+// gone returns true if the source name src no longer exists.
+func (r *run) gone(src string) bool {
+	_, err := r.sfs.Stat(src)
+	return os.IsNotExist(err)
+}
+
+// vanished reports that src was deleted from the source during the sync,
+// which isn't an error.
+func (r *run) vanished(src string) {
+	r.emit(Verbose, src, Event{Op: OpSkip, Reason: Vanished})
+}
+
+// action returns what to do with err, the failure of src, and records it
+// if it's skipped.
+func (r *run) action(src string, err error) ErrorAction {
+	e := &SyncError{Path: r.rel(src), Err: err}
+	a := AbortOnError
+	if r.OnError != nil {
+		a = r.OnError(*e)
+	} else if r.ContinueOnError {
+		a = SkipOnError
+	}
+	if a == SkipOnError {
+		r.failures.mu.Lock()
+		r.failures.errs = append(r.failures.errs, e)
+		if r.failures.dirs == nil {
+			r.failures.dirs = make(map[string]bool)
+		}
+		r.failures.dirs[filepath.Dir(src)] = true
+		r.failures.mu.Unlock()
+		r.emit(Quiet, src, Event{Op: OpError, Err: err})
+	}
+	return a
+}
+
+// failure returns the error of a run whose files failed, if any.
+func (r *run) failure() error {
+	if r.failures.count() == 0 {
+		return nil
+	}
+	return r.failures.errs
+}
+
+func (f *failures) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.errs)
+}
+
+// in returns true if something in the source directory dir failed.
+func (f *failures) in(dir string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.dirs[dir]
+}
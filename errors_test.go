@@ -0,0 +1,110 @@
+package fsync
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// denyFS fails to open the files named "denied".
+type denyFS struct{ osFS }
+
+func (fs denyFS) Open(name string) (io.ReadCloser, error) {
+	if filepath.Base(name) == "denied" {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	return fs.osFS.Open(name)
+}
+
+// flakyFS fails to open the files named "denied" until they're fixed.
+type flakyFS struct {
+	denyFS
+	fixed map[string]bool
+}
+
+func (fs *flakyFS) Open(name string) (io.ReadCloser, error) {
+	if fs.fixed[name] {
+		return fs.osFS.Open(name)
+	}
+	return fs.denyFS.Open(name)
+}
+
+func TestContinueOnError(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "a"), 0755))
+	for _, name := range []string{"a/denied", "a/x", "b", "denied"} {
+		check(ioutil.WriteFile(filepath.Join(src, name), []byte(name), 0644))
+	}
+
+	for _, workers := range []int{0, 2} {
+		check(os.RemoveAll(dst))
+		s := NewSyncer()
+		s.SrcFS = denyFS{}
+		s.Workers = workers
+		if err := s.Sync(dst, src); err == nil {
+			t.Errorf("workers %d: expecting an error", workers)
+		}
+
+		s.ContinueOnError = true
+		stats, err := s.SyncStats(dst, src)
+		errs, ok := err.(SyncErrors)
+		if !ok || len(errs) != 2 || stats.Failed != 2 {
+			t.Fatalf("workers %d: expecting 2 errors, got %v", workers, err)
+		}
+		for _, e := range errs {
+			if filepath.Base(e.Path) != "denied" || !os.IsPermission(e.Err) {
+				t.Errorf("workers %d: unexpected error %v", workers, e)
+			}
+		}
+		testFile(filepath.Join(dst, "a/x"), []byte("a/x"), t)
+		testFile(filepath.Join(dst, "b"), []byte("b"), t)
+	}
+}
+
+func TestOnError(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "a"), 0755))
+	for _, name := range []string{"a/denied", "b", "denied"} {
+		check(ioutil.WriteFile(filepath.Join(src, name), []byte(name), 0644))
+	}
+
+	// skip denied, and retry a/denied once it can be read
+	s := NewSyncer()
+	fixed := make(map[string]bool)
+	s.SrcFS = &flakyFS{fixed: fixed}
+	var failed []string
+	s.OnError = func(e SyncError) ErrorAction {
+		failed = append(failed, e.Path)
+		if e.Path == "a/denied" {
+			fixed[filepath.Join(src, e.Path)] = true
+			return RetryOnError
+		}
+		return SkipOnError
+	}
+	err = s.Sync(dst, src)
+	if errs, ok := err.(SyncErrors); !ok || len(errs) != 1 || errs[0].Path != "denied" {
+		t.Errorf("expecting denied to fail, got %v", err)
+	}
+	if len(failed) != 2 {
+		t.Errorf("expecting OnError to be called twice, got %v", failed)
+	}
+	testFile(filepath.Join(dst, "a/denied"), []byte("a/denied"), t)
+	testFile(filepath.Join(dst, "b"), []byte("b"), t)
+
+	s.OnError = func(e SyncError) ErrorAction { return AbortOnError }
+	s.SrcFS = denyFS{}
+	check(os.RemoveAll(dst))
+	if err := s.Sync(dst, src); !os.IsPermission(err) {
+		t.Errorf("expecting the sync to abort, got %v", err)
+	}
+}
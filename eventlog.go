@@ -0,0 +1,40 @@
+package fsync
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// EventRecord is an Event as WriteEvents writes it, as JSON.
+type EventRecord struct {
+	Time   time.Time `json:"time"`
+	Op     string    `json:"op"`
+	Path   string    `json:"path"`
+	Size   int64     `json:"size,omitempty"`
+	Reason string    `json:"reason,omitempty"` // for "skip"
+	Error  string    `json:"error,omitempty"`  // for "error"
+	From   string    `json:"from,omitempty"`   // for "move"
+	Item   string    `json:"item,omitempty"`
+}
+
+// WriteEvents returns a function for OnEvent that writes each event to w
+// as a line of JSON, an EventRecord, for dashboards and logs to follow.
+// Errors writing to w are ignored.
+func WriteEvents(w io.Writer) func(e Event) {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return func(e Event) {
+		rec := EventRecord{Time: e.Time, Op: e.Op.String(), Path: e.Path, Size: e.Size, From: e.From, Item: e.Item}
+		if e.Op == OpSkip {
+			rec.Reason = e.Reason.String()
+		}
+		if e.Err != nil {
+			rec.Error = e.Err.Error()
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(rec)
+	}
+}
@@ -0,0 +1,51 @@
+package fsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestWriteEvents(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("file a"), 0644))
+
+	var buf bytes.Buffer
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := NewSyncer()
+	s.Clock = &stepClock{now: now}
+	s.Verbosity = Trace
+	s.OnEvent = WriteEvents(&buf)
+	check(s.Sync(dst, src))
+	check(s.Sync(dst, src))
+
+	var got []EventRecord
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var rec EventRecord
+		check(dec.Decode(&rec))
+		got = append(got, rec)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].Op+got[i].Path < got[j].Op+got[j].Path })
+	want := []EventRecord{
+		{Time: now, Op: "chtimes", Path: ".", Item: ".d..t......"},
+		{Time: now, Op: "chtimes", Path: "a", Item: ".f..t......"},
+		{Time: now, Op: "copy", Path: "a", Size: 6, Item: ">f+++++++++"},
+		{Time: now, Op: "mkdir", Path: ".", Item: "cd+++++++++"},
+		{Time: now, Op: "skip", Path: "a", Reason: "same content"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expecting %+v, got %+v", want, got)
+	}
+}
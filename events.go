@@ -0,0 +1,115 @@
+package fsync
+
+import (
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Verbosity selects the events a Syncer passes to OnEvent.
+type Verbosity int
+
+const (
+	// Quiet only reports errors.
+	Quiet Verbosity = iota
+	// Verbose also reports files and directories copied, created and
+	// deleted.
+	Verbose
+	// Trace also reports files that are skipped, and changes of
+	// permissions and modification times.
+	Trace
+)
+
+// Op is what an Event reports.
+type Op int
+
+const (
+	OpError    Op = iota // the sync failed with Err
+	OpCopy               // a file was copied
+	OpMkdir              // a directory was created
+	OpDelete             // a file or directory was deleted
+	OpSkip               // a file or directory was skipped, for Reason
+	OpChmod              // permissions were changed
+	OpChtimes            // the modification time was changed
+	OpConflict           // a path changed on both sides of SyncBoth
+	OpMove               // a file was moved from From, with DetectRenames
+	OpInconsistent       // a file changed after it was written, found by Recheck
+	OpInterfered         // a file changed before it was written, found with Interference
+	OpMknod              // a special file was made, with RecreateSpecials
+	OpLink               // a file was hard-linked to From, with PreserveHardlinks, or with LinkDest
+	OpChown              // the owner or group was changed, by SyncMetadata
+	OpXattr              // extended attributes were changed, by SyncMetadata
+)
+
+var opNames = [...]string{"error", "copy", "mkdir", "delete", "skip", "chmod", "chtimes", "conflict", "move", "inconsistent", "interfered", "mknod", "link", "chown", "xattr"}
+
+func (op Op) String() string {
+	if op < 0 || int(op) >= len(opNames) {
+		return "unknown"
+	}
+	return opNames[op]
+}
+
+// Reason is why a file was skipped.
+type Reason int
+
+const (
+	Copied          Reason = iota // the file wasn't skipped
+	SameContent                   // it has the same contents as in the destination
+	SameChecksum                  // it has the same checksum as in the destination
+	SameSizeAndTime               // it has the same size and modification time, with CompareQuick or CompareChained
+	Excluded                      // it matched a pattern in Exclude
+	DestChanged                   // it changed in the destination since the base of Sync3
+	SameState                     // it's unchanged on both sides since the last sync, as recorded in StateFile
+	Vanished                      // it was deleted from the source during the sync
+	Special                       // it's a special file, with SkipSpecials
+)
+
+var reasonNames = [...]string{"copied", "same content", "same checksum", "same size and time", "excluded", "changed in the destination",
+	"unchanged since the last sync", "vanished", "special file"}
+
+func (why Reason) String() string {
+	if why < 0 || int(why) >= len(reasonNames) {
+		return "unknown"
+	}
+	return reasonNames[why]
+}
+
+// Event is something a sync did, or would do in a dry run.
+type Event struct {
+	Op     Op
+	Path   string // relative to the source, slash-separated; "." is the source
+	Size   int64  // of the file copied
+	Reason Reason // for OpSkip
+	Err    error  // for OpError
+	From   string // for OpMove and OpLink, relative to the source, slash-separated
+	Item   string // what changed, like rsync -i does, as in ">f.st......"
+	Time   time.Time
+}
+
+// emit passes e, with Path set to the source name src and Time to now, to
+// OnEvent if the verbosity in effect is at least v.
+func (r *run) emit(v Verbosity, src string, e Event) {
+	if r.planned != nil {
+		r.plan(v, src, e)
+		return
+	}
+	changes := r.changes != nil && v <= Verbose
+	report := r.OnEvent != nil && v <= Verbosity(atomic.LoadInt32(&r.verbosity))
+	if !changes && !report {
+		return
+	}
+	if src != "" {
+		e.Path = src
+		if rel, err := filepath.Rel(r.root, src); err == nil {
+			e.Path = filepath.ToSlash(rel)
+		}
+	}
+	e.Time = r.clock().Now()
+	if changes {
+		r.changes(e)
+	}
+	if report {
+		r.OnEvent(e)
+	}
+}
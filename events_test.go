@@ -0,0 +1,85 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "a"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a/b"), []byte("file b"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "c"), []byte("file c"), 0644))
+
+	var events []string
+	s := NewSyncer()
+	s.Verbosity = Verbose
+	s.OnEvent = func(e Event) { events = append(events, e.Op.String()+" "+e.Path) }
+	check(s.Sync(dst, src))
+	sort.Strings(events)
+	want := []string{"copy a/b", "copy c", "mkdir .", "mkdir a"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("expecting %v, got %v", want, events)
+	}
+
+	// unchanged files are only reported when tracing
+	events = nil
+	check(s.Sync(dst, src))
+	if len(events) != 0 {
+		t.Errorf("expecting no events, got %v", events)
+	}
+	s.SetVerbosity(Trace)
+	check(s.Sync(dst, src))
+	sort.Strings(events)
+	if want := []string{"skip a/b", "skip c"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("expecting %v, got %v", want, events)
+	}
+
+	events = nil
+	s.SetVerbosity(Quiet)
+	if err := s.Sync(dst, filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("expecting an error")
+	}
+	if want := []string{"error "}; !reflect.DeepEqual(events, want) {
+		t.Errorf("expecting %v, got %v", want, events)
+	}
+}
+
+func TestSkipReasons(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("file a"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "b.tmp"), []byte("file b"), 0644))
+
+	reasons := make(map[string]Reason)
+	s := NewSyncer()
+	s.Exclude = []string{"*.tmp"}
+	s.Verbosity = Trace
+	s.OnEvent = func(e Event) {
+		if e.Op == OpSkip {
+			reasons[e.Path] = e.Reason
+		}
+	}
+	check(s.Sync(dst, src))
+	check(s.Sync(dst, src))
+	if reasons["a"] != SameContent || reasons["b.tmp"] != Excluded {
+		t.Errorf("wrong reasons: %v", reasons)
+	}
+	s.Comparison = CompareQuick
+	check(s.Sync(dst, src))
+	if reasons["a"] != SameSizeAndTime {
+		t.Errorf("expecting %v, got %v", SameSizeAndTime, reasons["a"])
+	}
+}
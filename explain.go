@@ -0,0 +1,193 @@
+package fsync
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrNotRelative = errors.New("fsync: path isn't relative to the source")
+)
+
+// Explanation is what a sync would do to a path, and why; see Explain.
+type Explanation struct {
+	Path string // as passed to Explain, cleaned
+	// Pattern is the pattern in Exclude that excludes the path, if any, and
+	// Matched is what it matched: Path or a directory it's in. If Filter
+	// excludes it, or it's marked with ExcludeMarked, Matched is set and
+	// Pattern is empty.
+	Pattern, Matched string
+	Src, Dst         os.FileInfo // nil if missing
+	Comparison       Comparison  // in effect, for files in both
+	Ops              []Op        // what a sync would do to the path, in order
+	Reason           Reason      // why the file is skipped, with OpSkip
+	Steps            []string    // the decisions made, in order
+}
+
+// String returns the steps, one per line.
+func (e *Explanation) String() string {
+	return strings.Join(e.Steps, "\n")
+}
+
+func (e *Explanation) step(format string, args ...interface{}) {
+	e.Steps = append(e.Steps, fmt.Sprintf(format, args...))
+}
+
+// Explain returns what syncing dst with src would do to the path rel in
+// them, slash-separated, and why. It goes through the decisions of a sync for
+// that path alone: excluded directories it's in, how the files compare and
+// the options that apply. A directory is explained by itself, not by what's
+// in it. Nothing is changed, whatever DryRun is.
+func (s *Syncer) Explain(dst, src, rel string) (*Explanation, error) {
+	rel = path.Clean(filepath.ToSlash(rel))
+	if path.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+		return nil, ErrNotRelative
+	}
+	r, dst, src, err := s.newRun(dst, src)
+	if err != nil {
+		return nil, err
+	}
+	defer r.close()
+	if err := checkPatterns(s.Exclude, s.Include); err != nil {
+		return nil, err
+	}
+	r.root = src
+	e := &Explanation{Path: rel, Comparison: r.cmp}
+	err = catch(func() {
+		r.explain(e, filepath.Join(dst, filepath.FromSlash(rel)), filepath.Join(src, filepath.FromSlash(rel)))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// explain fills e in for the destination and source names dst and src.
+func (r *run) explain(e *Explanation, dst, src string) {
+	var err error
+	e.Src, err = r.sfs.Stat(src)
+	if err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
+	e.Dst, err = r.dfs.Stat(dst)
+	if err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
+	if e.Src == nil && e.Dst == nil {
+		panic(&os.PathError{Op: "explain", Path: e.Path, Err: os.ErrNotExist})
+	}
+
+	// the sync doesn't reach what's in excluded directories, unless Include
+	// matches it
+	info := e.Src
+	if info == nil {
+		info = e.Dst // as when deleting extra files
+	}
+	f := r.filters()
+	for p := src; p != r.root && within(p, r.root); p = filepath.Dir(p) {
+		if p != src {
+			if info, err = r.sfs.Stat(p); err != nil {
+				info = nil
+			}
+		}
+		if pat, ok := r.exclusion(p, info); ok {
+			rel, _ := filepath.Rel(r.root, p)
+			e.Pattern, e.Matched = pat, filepath.ToSlash(rel)
+			e.Ops, e.Reason = []Op{OpSkip}, Excluded
+			if pat == "" && r.marked(p) {
+				e.step("%s is marked to be skipped; skipped", e.Matched)
+			} else if pat == "" {
+				e.step("%s matches Filter; skipped", e.Matched)
+			} else {
+				e.step("%s matches %q in Exclude; skipped", e.Matched, pat)
+			}
+			return
+		}
+		if rel := r.rel(p); f.included(rel, info == nil || info.IsDir()) {
+			e.step("%s matches Include; not skipped", rel)
+			break
+		}
+	}
+	if (len(r.Exclude) > 0 || r.Filter != nil) && src != r.root {
+		e.step("neither Exclude nor Filter matches %s or a directory it's in", e.Path)
+	}
+
+	switch {
+	case e.Src == nil && !r.Delete:
+		e.step("only in the destination; kept since Delete is off")
+		return
+	case e.Src == nil:
+		e.Ops = []Op{OpDelete}
+		e.step("only in the destination; deleted since Delete is on")
+		return
+	case e.Src.IsDir():
+		if e.Dst == nil {
+			e.Ops = []Op{OpMkdir}
+			e.step("directory missing in the destination; created")
+			return
+		}
+		if !e.Dst.IsDir() {
+			e.Ops = []Op{OpDelete, OpMkdir}
+			e.step("a file in the destination; replaced with a directory")
+			return
+		}
+		e.step("directory in both; what's in it is synced path by path")
+	case e.Dst == nil:
+		e.Ops = []Op{OpCopy}
+		e.step("missing in the destination; copied")
+		return
+	case e.Dst.IsDir():
+		if src == r.root {
+			files, err := r.dfs.ReadDir(dst)
+			check(err)
+			if len(files) > 0 {
+				panic(ErrFileOverDir)
+			}
+		}
+		e.Ops = []Op{OpDelete, OpCopy}
+		e.step("a directory in the destination; replaced with the file")
+		return
+	default:
+		e.Reason = r.same(dst, src)
+		switch {
+		case e.Reason != Copied:
+			e.Ops = []Op{OpSkip}
+			e.step("skipped: %v", e.Reason)
+		case e.Src.Size() != e.Dst.Size():
+			e.Ops = []Op{OpCopy}
+			e.step("sizes differ (%d in the source, %d in the destination); copied", e.Src.Size(), e.Dst.Size())
+			return
+		case r.cmp == CompareQuick:
+			e.Ops = []Op{OpCopy}
+			e.step("modification times differ with CompareQuick; copied")
+			return
+		case r.cmp == CompareChained && !r.sameTime(e.Src.ModTime(), e.Dst.ModTime()):
+			e.Ops = []Op{OpCopy}
+			e.step("modification times differ with CompareChained; copied")
+			return
+		default:
+			e.Ops = []Op{OpCopy}
+			e.step("contents differ; copied")
+			return
+		}
+	}
+
+	// in both and not copied; only stats may change
+	if r.permDiffers(e.Dst, e.Src) {
+		e.Ops = append(e.Ops, OpChmod)
+		e.step("permissions differ (%v for the source, %v in the destination); changed",
+			r.perm(e.Src), e.Dst.Mode()&permBits)
+	}
+	switch {
+	case r.sameTime(e.Src.ModTime(), e.Dst.ModTime()):
+	case r.NoTimes:
+		e.step("modification times differ; kept since NoTimes is on")
+	default:
+		e.Ops = append(e.Ops, OpChtimes)
+		e.step("modification times differ; changed")
+	}
+}
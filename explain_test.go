@@ -0,0 +1,75 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExplain(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	tt := time.Now().Add(-time.Hour)
+	write := func(name, data string) {
+		check(os.MkdirAll(filepath.Dir(name), 0755))
+		check(ioutil.WriteFile(name, []byte(data), 0644))
+		check(os.Chtimes(name, tt, tt))
+	}
+	write(filepath.Join(src, "same"), "same")
+	write(filepath.Join(dst, "same"), "same")
+	write(filepath.Join(src, "changed"), "new")
+	write(filepath.Join(dst, "changed"), "old")
+	write(filepath.Join(src, "touched"), "touched")
+	write(filepath.Join(dst, "touched"), "touched")
+	check(os.Chtimes(filepath.Join(src, "touched"), time.Now(), time.Now()))
+	write(filepath.Join(src, "tmp/a"), "a")
+	write(filepath.Join(dst, "extra"), "extra")
+	check(os.Chtimes(src, tt, tt))
+	check(os.Chtimes(dst, tt, tt))
+
+	s := NewSyncer()
+	s.Exclude = []string{"/tmp"}
+	for _, c := range []struct {
+		path    string
+		ops     []Op
+		reason  Reason
+		pattern string
+	}{
+		{"same", []Op{OpSkip}, SameContent, ""},
+		{"changed", []Op{OpCopy}, Copied, ""},
+		{"touched", []Op{OpSkip, OpChtimes}, SameContent, ""},
+		{"tmp/a", []Op{OpSkip}, Excluded, "/tmp"},
+		{"extra", nil, Copied, ""},
+		{".", nil, Copied, ""},
+	} {
+		e, err := s.Explain(dst, src, c.path)
+		if err != nil {
+			t.Errorf("%s: %v", c.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(e.Ops, c.ops) || e.Reason != c.reason || e.Pattern != c.pattern {
+			t.Errorf("%s: expecting %v %v %q, got %v %v %q\n%s",
+				c.path, c.ops, c.reason, c.pattern, e.Ops, e.Reason, e.Pattern, e)
+		}
+	}
+
+	s.Delete = true
+	if e, err := s.Explain(dst, src, "extra"); err != nil || !reflect.DeepEqual(e.Ops, []Op{OpDelete}) {
+		t.Errorf("expecting extra to be deleted, got %v, %v", e, err)
+	}
+	if _, err := s.Explain(dst, src, "../x"); err != ErrNotRelative {
+		t.Errorf("expecting ErrNotRelative, got %v", err)
+	}
+	if _, err := s.Explain(dst, src, "missing"); !os.IsNotExist(err) {
+		t.Errorf("expecting a missing file error, got %v", err)
+	}
+	// nothing is changed
+	testFile(filepath.Join(dst, "changed"), []byte("old"), t)
+	testFile(filepath.Join(dst, "extra"), []byte("extra"), t)
+}
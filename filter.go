@@ -0,0 +1,366 @@
+package fsync
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Filters select what's synced, as set in a Syncer. A file or directory is
+// excluded if a pattern in Exclude or Filter matches it; the two are
+// combined as with Or. Patterns in Include override both. For any other
+// precedence, leave Exclude empty and express all of them as one Filter,
+// such as a First chain. Filters can be tried on their own with Match and
+// MatchTree.
+type Filters struct {
+	// Exclude lists patterns of files and directories that aren't synced;
+	// see Syncer.Exclude.
+	Exclude []string
+	// Include lists patterns of files and directories that are synced
+	// even if excluded; see Syncer.Include.
+	Include []string
+	// Filter, if set, excludes what it matches as well.
+	Filter Filter
+}
+
+// FilterMatch is a pattern and the paths it matched.
+type FilterMatch struct {
+	Pattern string   // "" for Filter
+	Paths   []string // slash-separated, relative to the root
+}
+
+// Match returns true if the file or directory at rel, a slash-separated path
+// relative to the root of a sync, is excluded, along with the first pattern
+// in Exclude matching it, or "" if it's Filter that does. info is its file
+// info; if nil, patterns for directories are matched too. Only rel itself is
+// matched, not the directories it's in. Paths matched by Include are never
+// excluded.
+func (f Filters) Match(rel string, info os.FileInfo) (pattern string, ok bool) {
+	switch i := f.match(rel, info); {
+	case i < 0:
+		return "", false
+	case i < len(f.Exclude):
+		return f.Exclude[i], true
+	}
+	return "", true
+}
+
+// MatchTree walks the tree root and returns what each pattern in Exclude
+// matches in it, in the order of Exclude, including the patterns that
+// match nothing, followed by what Filter matches if set. As in a sync,
+// what's in an excluded directory isn't matched again. If fs is nil, root
+// is opened as the paths passed to Sync are.
+func (f Filters) MatchTree(fs FS, root string) ([]FilterMatch, error) {
+	if err := checkPatterns(f.Exclude, f.Include); err != nil {
+		return nil, err
+	}
+	tfs, root, err := openFS(fs, root)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFS(tfs, fs)
+	n := len(f.Exclude)
+	if f.Filter != nil {
+		n++
+	}
+	matches := make([]FilterMatch, n)
+	for i, p := range f.Exclude {
+		matches[i].Pattern = p
+	}
+	var walk func(dir, rel string)
+	walk = func(dir, rel string) {
+		files, err := tfs.ReadDir(dir)
+		check(err)
+		for _, fi := range files {
+			p := path.Join(rel, fi.Name())
+			if i := f.match(p, fi); i >= 0 {
+				matches[i].Paths = append(matches[i].Paths, p)
+			} else if fi.IsDir() {
+				walk(filepath.Join(dir, fi.Name()), p)
+			}
+		}
+	}
+	err = catch(func() {
+		fi, err := tfs.Stat(root)
+		check(err)
+		if fi.IsDir() {
+			walk(root, "")
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// match returns the index of the first pattern in Exclude matching rel,
+// len(Exclude) if only Filter does, or -1 if nothing does or Include does.
+func (f Filters) match(rel string, info os.FileInfo) int {
+	dir := info == nil || info.IsDir()
+	if f.included(rel, dir) {
+		return -1
+	}
+	for i, p := range f.Exclude {
+		if matchPattern(p, rel, dir) {
+			return i
+		}
+	}
+	if f.Filter != nil && f.Filter(rel, info) {
+		return len(f.Exclude)
+	}
+	return -1
+}
+
+// included returns true if a pattern in Include matches rel.
+func (f Filters) included(rel string, dir bool) bool {
+	for _, p := range f.Include {
+		if matchPattern(p, rel, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// reaches returns true if a pattern in Include may match something in the
+// directory rel.
+func (f Filters) reaches(rel string) bool {
+	for _, p := range f.Include {
+		p = strings.TrimSuffix(p, "/")
+		if !strings.Contains(p, "/") {
+			return true
+		}
+		if matchPrefix(strings.Split(strings.TrimPrefix(p, "/"), "/"), strings.Split(rel, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern returns true if the pattern p, as in Exclude, matches rel.
+// As in .gitignore files, patterns ending with a slash only match
+// directories, other slashes anchor patterns to the root, and "**" matches
+// any number of directories.
+func matchPattern(p, rel string, dir bool) bool {
+	if strings.HasSuffix(p, "/") {
+		if !dir {
+			return false
+		}
+		p = strings.TrimSuffix(p, "/")
+	}
+	if !strings.Contains(p, "/") {
+		if p == "**" {
+			return true
+		}
+		ok, _ := path.Match(p, path.Base(rel))
+		return ok
+	}
+	return matchSegments(strings.Split(strings.TrimPrefix(p, "/"), "/"), strings.Split(rel, "/"))
+}
+
+// matchSegments returns true if the slash-separated segments of a pattern
+// match those of a path. A "**" segment matches any number of segments, but
+// at least one at the end of the pattern, so that "a/**" matches what's in
+// a but not a itself.
+func matchSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			if len(pat) == 1 {
+				return len(name) > 0
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pat[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// matchPrefix returns true if the segments of a path are those of a
+// directory that what the segments of a pattern match may be in.
+func matchPrefix(pat, name []string) bool {
+	for len(name) > 0 {
+		if len(pat) == 0 {
+			return false
+		}
+		if pat[0] == "**" {
+			return true
+		}
+		if ok, _ := path.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(pat) > 0
+}
+
+// Filter matches files and directories by their slash-separated path
+// relative to the root of a sync, and their file info, which is nil if it
+// isn't known. Filters are combined with And, Or, Not and First.
+type Filter func(rel string, info os.FileInfo) bool
+
+// Pattern returns a Filter matching what the pattern p matches in
+// Syncer.Exclude. Malformed patterns match nothing.
+func Pattern(p string) Filter {
+	return func(rel string, info os.FileInfo) bool {
+		return matchPattern(p, rel, info == nil || info.IsDir())
+	}
+}
+
+// And returns a Filter matching what all of filters match. It matches
+// everything if there are none.
+func And(filters ...Filter) Filter {
+	return func(rel string, info os.FileInfo) bool {
+		for _, f := range filters {
+			if !f(rel, info) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or returns a Filter matching what any of filters matches. It matches
+// nothing if there are none.
+func Or(filters ...Filter) Filter {
+	return func(rel string, info os.FileInfo) bool {
+		for _, f := range filters {
+			if f(rel, info) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not returns a Filter matching what f doesn't.
+func Not(f Filter) Filter {
+	return func(rel string, info os.FileInfo) bool {
+		return !f(rel, info)
+	}
+}
+
+// Rule is a step of a First chain.
+type Rule struct {
+	Filter  Filter
+	Exclude bool // whether what Filter matches is excluded or included
+}
+
+// Include returns a Rule including what f matches.
+func Include(f Filter) Rule { return Rule{Filter: f} }
+
+// Exclude returns a Rule excluding what f matches.
+func Exclude(f Filter) Rule { return Rule{Filter: f, Exclude: true} }
+
+// First returns a Filter matching, as excluded, what the first of rules to
+// match a path excludes. Paths no rule matches are included. For instance,
+// this excludes the files ending with ".log" except for "keep.log":
+//
+//	First(Include(Pattern("keep.log")), Exclude(Pattern("*.log")))
+func First(rules ...Rule) Filter {
+	return func(rel string, info os.FileInfo) bool {
+		for _, r := range rules {
+			if r.Filter(rel, info) {
+				return r.Exclude
+			}
+		}
+		return false
+	}
+}
+
+// checkPatterns returns an error if a pattern is malformed.
+func checkPatterns(lists ...[]string) error {
+	for _, patterns := range lists {
+		for _, p := range patterns {
+			if _, err := path.Match(strings.Trim(p, "/"), ""); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// filters returns the Filters of r.
+func (r *run) filters() Filters {
+	return Filters{Exclude: r.Exclude, Include: r.Include, Filter: r.Filter}
+}
+
+// excluded returns true if the source name src, with the file info info,
+// is excluded by Exclude or Filter. With Include, so is what's in a
+// directory gone through by descend, unless Include matches it.
+func (r *run) excluded(src string, info os.FileInfo) bool {
+	if _, ok := r.exclusion(src, info); ok {
+		return true
+	}
+	f := r.filters()
+	if len(r.Include) == 0 || f.included(r.rel(src), info == nil || info.IsDir()) {
+		return false
+	}
+	for p := filepath.Dir(src); p != r.root && within(p, r.root); p = filepath.Dir(p) {
+		rel := r.rel(p)
+		if f.included(rel, true) {
+			return false
+		}
+		if _, ok := f.Match(rel, nil); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// exclusion is like Filters.Match for the source name src, except that
+// files marked to be skipped with ExcludeMarked are excluded too, with no
+// pattern.
+func (r *run) exclusion(src string, info os.FileInfo) (pattern string, ok bool) {
+	if len(r.Exclude) == 0 && r.Filter == nil && !r.ExcludeMarked {
+		return "", false
+	}
+	rel, err := filepath.Rel(r.root, src)
+	if err != nil {
+		return "", false
+	}
+	if pattern, ok = r.filters().Match(filepath.ToSlash(rel), info); ok {
+		return pattern, ok
+	}
+	return "", r.marked(src)
+}
+
+// descend returns true if the excluded source directory src is still to be
+// gone through, as Include may match something in it. Marked directories
+// aren't.
+func (r *run) descend(src string, info os.FileInfo) bool {
+	if len(r.Include) == 0 || !info.IsDir() || !r.filters().reaches(r.rel(src)) {
+		return false
+	}
+	return !r.ExcludeMarked || !r.marked(src)
+}
+
+// excludedPath returns true if the source name src, or a directory it's in
+// under the source of r, is excluded. Names that no longer exist are
+// matched with a nil file info.
+func (r *run) excludedPath(src string) bool {
+	f := r.filters()
+	for p := src; p != r.root && within(p, r.root); p = filepath.Dir(p) {
+		fi, err := r.sfs.Stat(p)
+		if err != nil {
+			fi = nil
+		}
+		if r.excluded(p, fi) {
+			return true
+		}
+		if f.included(r.rel(p), fi == nil || fi.IsDir()) {
+			return false
+		}
+	}
+	return false
+}
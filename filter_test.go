@@ -0,0 +1,188 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExclude(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	for _, name := range []string{"a/x.tmp", "a/b", "c/d", "e"} {
+		check(os.MkdirAll(filepath.Join(src, filepath.Dir(name)), 0755))
+		check(ioutil.WriteFile(filepath.Join(src, name), []byte(name), 0644))
+	}
+	check(os.MkdirAll(filepath.Join(dst, "c"), 0755))
+	check(ioutil.WriteFile(filepath.Join(dst, "c/kept"), nil, 0644))
+
+	s := NewSyncer()
+	s.Delete = true
+	s.Exclude = []string{"*.tmp", "/c"}
+	check(s.Sync(dst, src))
+	testDirContents(filepath.Join(dst, "a"), 1, t)
+	testFile(filepath.Join(dst, "a/b"), []byte("a/b"), t)
+	testFile(filepath.Join(dst, "e"), []byte("e"), t)
+	// excluded files in the destination are left alone
+	testDirContents(filepath.Join(dst, "c"), 1, t)
+	testFile(filepath.Join(dst, "c/kept"), nil, t)
+
+	s.Exclude = []string{"["}
+	if err := s.Sync(dst, src); err == nil {
+		t.Error("expecting an error for a malformed pattern")
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	for _, name := range []string{"vendor/manifest.json", "vendor/a/b.go", "vendor/a/c.json", "lib/x.tmp", "lib/keep.tmp", "e"} {
+		check(os.MkdirAll(filepath.Join(src, filepath.Dir(name)), 0755))
+		check(ioutil.WriteFile(filepath.Join(src, name), []byte(name), 0644))
+	}
+	check(os.MkdirAll(filepath.Join(dst, "vendor"), 0755))
+	check(ioutil.WriteFile(filepath.Join(dst, "vendor/kept"), nil, 0644))
+
+	s := NewSyncer()
+	s.Delete = true
+	s.Exclude = []string{"vendor/", "*.tmp"}
+	s.Include = []string{"/vendor/manifest.json", "keep.tmp"}
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "vendor/manifest.json"), []byte("vendor/manifest.json"), t)
+	testFile(filepath.Join(dst, "vendor/kept"), nil, t)
+	// "keep.tmp" may be in vendor/a too, so it's made
+	testDirContents(filepath.Join(dst, "vendor"), 3, t)
+	testDirContents(filepath.Join(dst, "vendor/a"), 0, t)
+	testFile(filepath.Join(dst, "lib/keep.tmp"), []byte("lib/keep.tmp"), t)
+	testDirContents(filepath.Join(dst, "lib"), 1, t)
+
+	// unanchored patterns may match at any depth
+	s.Include = []string{"*.json"}
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "vendor/a/c.json"), []byte("vendor/a/c.json"), t)
+	testDirContents(filepath.Join(dst, "vendor/a"), 1, t)
+	e, err := s.Explain(dst, src, "vendor/a/c.json")
+	check(err)
+	if e.Reason == Excluded {
+		t.Errorf("vendor/a/c.json should be included\n%s", e)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a/x.tmp", "a/b", "build/c", "c/build", "c/d/y.tmp", "e"} {
+		check(os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755))
+		check(ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644))
+	}
+
+	f := Filters{Exclude: []string{"*.tmp", "build/", "/c/d", "nothing"}}
+	info := func(name string) os.FileInfo {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		check(err)
+		return fi
+	}
+	for name, want := range map[string]string{
+		"a/x.tmp": "*.tmp",
+		"a/b":     "",
+		"build":   "build/",
+		"c/build": "", // not a directory
+		"c/d":     "/c/d",
+		"e":       "",
+	} {
+		if got, ok := f.Match(name, info(name)); got != want || ok != (want != "") {
+			t.Errorf("%s: expecting %q, got %q", name, want, got)
+		}
+	}
+	if got, _ := f.Match("c/build", nil); got != "build/" {
+		t.Errorf("expecting patterns for directories to match without info, got %q", got)
+	}
+
+	matches, err := f.MatchTree(nil, dir)
+	check(err)
+	want := []FilterMatch{
+		{"*.tmp", []string{"a/x.tmp"}},
+		{"build/", []string{"build"}},
+		{"/c/d", []string{"c/d"}},
+		{"nothing", nil},
+	}
+	if !reflect.DeepEqual(matches, want) {
+		t.Errorf("expecting %v, got %v", want, matches)
+	}
+
+	// directory patterns apply to syncs too
+	dst := filepath.Join(dir, "..", filepath.Base(dir)+"_dst")
+	defer os.RemoveAll(dst)
+	s := NewSyncer()
+	s.Exclude = []string{"build/"}
+	check(s.Sync(dst, dir))
+	testFile(filepath.Join(dst, "c/build"), []byte("c/build"), t)
+	if _, err := os.Stat(filepath.Join(dst, "build")); !os.IsNotExist(err) {
+		t.Error("build should be excluded")
+	}
+}
+
+func TestFilterAlgebra(t *testing.T) {
+	logs := First(Include(Pattern("keep.log")), Exclude(Pattern("*.log")))
+	f := Filters{
+		Exclude: []string{"*.tmp"},
+		Filter:  Or(logs, And(Pattern("/cache/*"), Not(Pattern("*.idx")))),
+	}
+	for name, want := range map[string]bool{
+		"a.tmp":       true,
+		"a.log":       true,
+		"d/keep.log":  false,
+		"keep.txt":    false,
+		"cache/data":  true,
+		"cache/a.idx": false,
+		"d/cache/x":   false,
+	} {
+		if _, ok := f.Match(name, nil); ok != want {
+			t.Errorf("%s: expecting %v, got %v", name, want, ok)
+		}
+	}
+	if pat, ok := f.Match("a.log", nil); pat != "" || !ok {
+		t.Errorf("expecting a.log to be matched by Filter, got %q", pat)
+	}
+	if And()("a", nil) != true || Or()("a", nil) != false || First()("a", nil) != false {
+		t.Error("wrong results for empty compositions")
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	for _, c := range []struct {
+		pattern, rel string
+		dir, want    bool
+	}{
+		{"*.o", "a/b.o", false, true},
+		{"/build", "build", true, true},
+		{"/build", "a/build", true, false},
+		{"build", "a/build", false, true},
+		{"cache/", "a/cache", true, true},
+		{"cache/", "a/cache", false, false},
+		{"a/cache/", "a/cache", true, true},
+		{"**/cache", "cache", false, true},
+		{"**/cache", "a/b/cache", false, true},
+		{"logs/**/*.gz", "logs/x.gz", false, true},
+		{"logs/**/*.gz", "logs/a/b/x.gz", false, true},
+		{"logs/**/*.gz", "a/logs/x.gz", false, false},
+		{"logs/**", "logs/a", false, true},
+		{"logs/**", "logs", true, false},
+		{"**", "a/b", false, true},
+		{"a/*/c", "a/b/c", false, true},
+		{"a/*/c", "a/b/d/c", false, false},
+	} {
+		if got := matchPattern(c.pattern, c.rel, c.dir); got != c.want {
+			t.Errorf("%q on %q: expecting %v, got %v", c.pattern, c.rel, c.want, got)
+		}
+	}
+}
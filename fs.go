@@ -0,0 +1,144 @@
+package fsync
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FS is the interface implemented by file systems that can be used as the
+// source or destination of a sync. Names passed to its methods use the path
+// separator of the local operating system; backends for remote systems
+// translate them as needed.
+//
+// Stat, ReadDir and Open are enough for a source. A destination needs the
+// rest too. Backends that can't honor Chmod or Chtimes (e.g. object stores)
+// should return nil rather than an error.
+type FS interface {
+	Stat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	Open(name string) (io.ReadCloser, error)
+	Create(name string) (io.WriteCloser, error)
+	MkdirAll(name string, perm os.FileMode) error
+	Remove(name string) error
+	RemoveAll(name string) error
+	Rename(oldname, newname string) error
+	Chmod(name string, mode os.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// Hasher is implemented by file systems that store a checksum of each file,
+// such as object stores. When the destination is a Hasher, files of equal
+// size are compared by hashing the source file instead of reading the
+// destination file back, and likewise when the source is one.
+type Hasher interface {
+	// NewHash returns a new hash.Hash of the kind Hash returns.
+	NewHash() hash.Hash
+	// Hash returns the stored checksum of name, or nil if there is none.
+	Hash(name string) ([]byte, error)
+}
+
+// Queuer is implemented by file systems that finish writes in the
+// background, such as tiered ones uploading to a remote tier. Stats.Pending
+// is set from it for a destination that is one.
+type Queuer interface {
+	// Pending returns the number of writes that aren't done yet.
+	Pending() int
+}
+
+// OS is the local file system. It is used when a Syncer has no file system
+// set and the path isn't a URL with a registered scheme.
+var OS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) Stat(name string) (os.FileInfo, error)        { return os.Stat(name) }
+func (osFS) ReadDir(name string) ([]os.FileInfo, error)   { return ioutil.ReadDir(name) }
+func (osFS) Open(name string) (io.ReadCloser, error)      { return os.Open(name) }
+func (osFS) Create(name string) (io.WriteCloser, error)   { return os.Create(name) }
+func (osFS) MkdirAll(name string, perm os.FileMode) error { return os.MkdirAll(name, perm) }
+func (osFS) Remove(name string) error                     { return os.Remove(name) }
+func (osFS) RemoveAll(name string) error                  { return os.RemoveAll(name) }
+func (osFS) Rename(oldname, newname string) error         { return os.Rename(oldname, newname) }
+func (osFS) Chmod(name string, mode os.FileMode) error    { return os.Chmod(name, mode) }
+func (osFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+var (
+	ErrUnknownScheme = errors.New("fsync: no backend registered for URL scheme")
+)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]func(u *url.URL) (FS, error))
+)
+
+// Register makes a backend available for URLs with the given scheme, so that
+// e.g. Sync("sftp://user@host/path", ".") works once the sftpfs package is
+// imported. open is called with the parsed URL for every sync; the path of
+// the URL is then used as a name in the returned FS. If the FS implements
+// io.Closer it is closed when the sync finishes, and an error closing it
+// fails the sync.
+//
+// Register panics if it's called twice for the same scheme.
+func Register(scheme string, open func(u *url.URL) (FS, error)) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if open == nil {
+		panic("fsync: Register open function is nil")
+	}
+	if _, dup := backends[scheme]; dup {
+		panic("fsync: Register called twice for scheme " + scheme)
+	}
+	backends[scheme] = open
+}
+
+// openFS returns the file system and the name within it for name. If fs is
+// not nil it's used as is. Otherwise name is parsed as a URL when it looks
+// like one, and the local file system is used when it doesn't.
+func openFS(fs FS, name string) (FS, string, error) {
+	if fs != nil {
+		return fs, name, nil
+	}
+	i := strings.Index(name, "://")
+	if i < 0 {
+		return OS, name, nil
+	}
+	u, err := url.Parse(name)
+	if err != nil {
+		return nil, "", err
+	}
+	backendsMu.RLock()
+	open, ok := backends[u.Scheme]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, "", ErrUnknownScheme
+	}
+	fs, err = open(u)
+	if err != nil {
+		return nil, "", err
+	}
+	p := u.Path
+	if p == "" {
+		p = "/"
+	}
+	return fs, p, nil
+}
+
+// closeFS closes fs if it was opened by openFS.
+func closeFS(fs, given FS) error {
+	if fs == given {
+		return nil
+	}
+	if c, ok := fs.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// closeCounter is a local file system that counts how many times it's closed.
+type closeCounter struct {
+	FS
+	n *int
+}
+
+func (c closeCounter) Close() error {
+	*c.n++
+	return nil
+}
+
+func TestRegister(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	check(os.MkdirAll(filepath.Join(dir, "src"), 0755))
+	check(ioutil.WriteFile(filepath.Join(dir, "src/a"), []byte("file a"), 0644))
+
+	closed := 0
+	Register("fsynctest", func(u *url.URL) (FS, error) {
+		return closeCounter{OS, &closed}, nil
+	})
+
+	check(Sync("fsynctest://"+filepath.Join(dir, "dst"), filepath.Join(dir, "src")))
+	testFile(filepath.Join(dir, "dst/a"), []byte("file a"), t)
+	if closed != 1 {
+		t.Errorf("backend closed %d times, should be 1.\n", closed)
+	}
+
+	if err := Sync("nosuchscheme://host/dst", dir); err != ErrUnknownScheme {
+		t.Errorf("expecting ErrUnknownScheme, got %v.\n", err)
+	}
+}
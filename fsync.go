@@ -23,16 +23,26 @@
 // By default, sync code ignores extra files in the destination that don’t have
 // identicals in the source. Setting Delete field of a Syncer to true changes
 // this behavior and deletes these extra files.
+//
+// The source and destination don't have to be local. Any type implementing FS
+// can be set as SrcFS or DstFS of a Syncer, and backend packages such as
+// sftpfs register URL schemes so that paths like "sftp://user@host/path" can
+// be passed to Sync directly.
 package fsync
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"errors"
+	"hash"
 	"io"
-	"io/ioutil"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
+	"time"
 )
 
 var (
@@ -55,10 +65,357 @@ type Syncer struct {
 	// Set this to true to delete files in the destination that don't exist
 	// in the source.
 	Delete bool
+	// Protect lists patterns, as in Exclude, of files and directories in
+	// the destination that Delete never deletes, such as data kept there
+	// by an application. What's in a directory a pattern may match
+	// something in is deleted one by one, leaving the directory.
+	Protect []string
+	// MaxDelete, if positive, makes a sync fail with ErrMaxDelete, before
+	// deleting anything, if Delete would delete more files than that.
+	// MaxDeletePercent likewise limits them to a percentage of the files
+	// in the destination that the sync went through. They guard against
+	// syncing from an empty or unmounted source.
+	MaxDelete        int
+	MaxDeletePercent float64
+	// MaxBytes, if positive, makes a sync fail with ErrMaxBytes before it
+	// copies a file that takes the bytes it copies over it. What's copied
+	// before is kept, so that the next sync goes on from there.
+	MaxBytes int64
+	// DeleteMode decides when Delete deletes files; see the DeleteMode
+	// constants. By default, it's done directory by directory.
+	DeleteMode DeleteMode
 	// By default, modification times are synced. This can be turned off by
 	// setting this to true.
 	NoTimes bool
-	// TODO add options for not checking content for equality
+	// NoPerms likewise leaves the permissions in the destination alone,
+	// for file systems where changing them fails or means nothing, such as
+	// FAT. Otherwise, FileMode and DirMode, if set, are the permissions
+	// files and directories are given instead of those in the source, and
+	// PermMask is cleared from them, as a umask is.
+	NoPerms  bool
+	FileMode os.FileMode
+	DirMode  os.FileMode
+	PermMask os.FileMode
+	// NoSpecialBits clears the setuid, setgid and sticky bits in the
+	// destination, which are otherwise synced with the permissions.
+	NoSpecialBits bool
+	// MkdirMode is the permissions directories are made with, 0755 if
+	// zero. Those synced from the source get theirs right after, but the
+	// missing parents of the destination, and of BackupDir and DeleteTo,
+	// keep it, changed by DirMode and PermMask like the others.
+	MkdirMode os.FileMode
+	// Specials decides what's done with named pipes, sockets and devices
+	// in the source; see the Specials constants. By default, they're
+	// skipped.
+	Specials Specials
+	// RootLink decides what's synced when the source is a symbolic link;
+	// see the RootLink constants. By default, what it points to is.
+	RootLink RootLink
+	// Comparison decides how files of the same size are compared; see the
+	// Compare constants. By default their contents are compared.
+	Comparison Comparison
+	// ModifyWindow is how much modification times may differ and still be
+	// considered equal. It's useful for file systems that store times with
+	// less precision, such as FAT.
+	ModifyWindow time.Duration
+	// Suspects and SuspectAge pick the files that CompareChained compares
+	// by content: those matching a pattern in Suspects, which are matched
+	// as those in Exclude are, and those modified less than SuspectAge
+	// before they're compared, which may still be being written.
+	Suspects   []string
+	SuspectAge time.Duration
+	// Sidecars makes a sync trust the checksums of source files in their
+	// sidecars, <file>.sha256 files or the SumsFile of their directory,
+	// with the sums named after them, or just one unnamed in <file>.sha256:
+	// files are compared with them instead of reading the source, and
+	// copies are checked against them, failing with ErrSidecar. Sidecars
+	// older than their files are ignored, as they may be stale.
+	Sidecars bool
+	// WriteSums makes a sync keep a SumsFile in each directory of the
+	// destination, listing the checksums of its files as sha256sum does, so
+	// that the copy can be checked with "sha256sum -c". They're written at
+	// the end of a sync, when they changed, replacing those of the source;
+	// Delete leaves them alone.
+	WriteSums bool
+	// History is the path of a local file where the directories changed by
+	// each sync are recorded. If set, directories that changed in recent
+	// syncs are synced first, so that likely changes land early.
+	History string
+	// StateFile is the path of a local file where the files synced are
+	// recorded after each successful Sync, so that the next one can skip
+	// files unchanged on both sides without comparing them. With Delete,
+	// only files put in the destination by the last sync, and unchanged
+	// since, are deleted, which is nothing on the first sync. If nothing
+	// changed on either side since, as far as the sizes, modification times
+	// and permissions of the files tell, a sync stops early, with
+	// Stats.UpToDate set, reading only those. Each destination needs its
+	// own file; see State.
+	StateFile string
+	// DetectRenames makes a sync with StateFile move files in the
+	// destination when they were moved in the source, instead of copying
+	// them again: a new file with the size and checksum of one recorded in
+	// StateFile that's no longer in the source is renamed to its new path.
+	// Without Delete, the old file is kept and the new one is hard-linked to
+	// it if the destination is a Linker, and copied otherwise.
+	DetectRenames bool
+	// PreserveHardlinks makes files that are hard links to the same file
+	// in the source hard links in the destination too, if it's a Linker,
+	// instead of copies: the first one synced is copied, and the others are
+	// linked to it. Links to files outside the source aren't kept.
+	PreserveHardlinks bool
+	// LinkDest, if set, is a directory with an earlier copy of the
+	// destination, such as the last snapshot, whose files are hard-linked
+	// into the destination instead of copied when they're the same as in
+	// the source, permissions and modification times included, as rsync
+	// --link-dest does. If it's relative, it's relative to the
+	// destination. The destination file system must be a Linker.
+	LinkDest string
+	// DeltaMinSize, if positive, is the size from which files that changed
+	// are updated in place, writing only the blocks that differ, as rsync
+	// --inplace does, instead of being written again. Blocks that moved
+	// towards the start of the file, as when data is removed, are found
+	// with a rolling checksum and copied within the file; data inserted
+	// makes what's after it be written again. The destination must be an
+	// Updater.
+	DeltaMinSize int64
+	// InPlace makes files that changed be updated in place, block by
+	// block, writing only the blocks that differ, instead of being
+	// written again. Hard links to them are kept, and copy-on-write file
+	// systems only store the blocks that changed. It's a cheaper
+	// alternative to DeltaMinSize, which takes precedence for large files.
+	// The destination must be an Updater.
+	InPlace bool
+	// Atomic makes files be copied to a temporary file in the same
+	// directory, named ".fsync-tmp-" and a random suffix, that's then
+	// renamed over the destination file, so that it's never seen partly
+	// written. InPlace and DeltaMinSize are ignored with it. Temporary
+	// files aren't deleted with Delete, as they may be in use.
+	Atomic bool
+	// BackupDir, if set, makes files and directories in the destination
+	// be moved to it before they're overwritten or deleted, at the same
+	// path relative to it, replacing older backups. If it's relative, it's
+	// in the destination, and Delete leaves it alone. BackupSuffix is
+	// appended to the names of backups; without BackupDir, they're made
+	// next to the files, and Delete leaves what ends with it alone. Files
+	// aren't updated in place with either.
+	BackupDir    string
+	BackupSuffix string
+	// Interference decides what's done with files in the destination
+	// changed by something else between being compared and written; see
+	// the Interference constants. By default, they aren't looked for.
+	Interference Interference
+	// Recheck is the percentage of the files written by a sync that are
+	// checked again at its end, to find those changed meanwhile by
+	// something else: their size, permissions and checksum are compared
+	// with what was written. They're reported as OpInconsistent events
+	// and counted in Stats.
+	Recheck float64
+	// DeleteTo, if set, makes files and directories deleted with Delete be
+	// moved to it instead, at the same path relative to it, so that they
+	// can be recovered. Names already taken there get a number appended.
+	// If it's relative, it's in the destination, and Delete leaves it
+	// alone. If it's Trash, they're moved to the trash of the user.
+	DeleteTo string
+	// Swap makes the whole destination tree be replaced at once; see the
+	// Swap constants. By default, it's synced in place.
+	Swap Swap
+	// Workers is the number of files copied at the same time. If positive,
+	// files are copied in the background while the scan goes on, so that
+	// copying starts before the whole tree is compared. Both file systems
+	// must then be safe for concurrent use. It can be changed during a sync
+	// with SetWorkers.
+	Workers int
+	// RateLimit is the maximum number of bytes copied per second, over all
+	// files. Zero means no limit. It can be changed during a sync with
+	// SetRateLimit.
+	RateLimit int64
+	// BufferSize is the size of the buffers files are copied with, rounded
+	// up to a multiple of the memory page size; 32 KiB if zero. MaxBuffers,
+	// if positive, is how many may be in use at once, so that memory use is
+	// bounded with many Workers: copies wait for one otherwise. Buffers are
+	// reused, as counted in Progress. Files that aren't changed, hashed or
+	// rate limited on the way are copied by the destination itself when it
+	// can, as the local file system does in the kernel, without buffers.
+	BufferSize int
+	MaxBuffers int
+	// Sparse, if true, leaves holes in the destination files where the
+	// source files have them, as found with SEEK_DATA and SEEK_HOLE,
+	// instead of writing zeros, so that sparse files such as disk images
+	// take no more space than they did. It needs both to be local files on
+	// Linux, macOS or FreeBSD, and files that aren't changed on the way.
+	Sparse bool
+	// Preallocate, if true, allocates the space of each file in the
+	// destination before it's copied, with fallocate on Linux and the
+	// allocation size of the file on Windows, which keeps files from being
+	// fragmented and fails them right away if the space isn't there. It
+	// needs the destination to be local; elsewhere it does nothing.
+	Preallocate bool
+	// MemoryBudget, if positive, is about how many bytes a sync may use
+	// for its copy buffers, the files queued for Workers, the checksums of
+	// DeltaMinSize, and what it keeps of each file for StateFile,
+	// DetectRenames and MaxDelete. Rather than use more, it waits for
+	// buffers and workers, copies big files whole, keeps the states and
+	// the files left to delete in temporary files, and looks for fewer
+	// renamed files.
+	MemoryBudget int64
+	// ProgressFile is the path of a local file where the progress of each
+	// sync is written as JSON, about every second while files are copied
+	// and at its end, for other programs to follow; see ProgressReport.
+	// Failing to write it doesn't fail the sync.
+	ProgressFile string
+	// OnProgress, if set, is called after each file is copied. With Workers
+	// it's called from the workers, so it must be safe for concurrent use.
+	OnProgress func(p Progress)
+	// OnEvent, if set, is called with the events Verbosity selects. As
+	// OnProgress, it may be called from the workers. Verbosity can be
+	// changed during a sync with SetVerbosity.
+	OnEvent   func(e Event)
+	Verbosity Verbosity
+	// BeforeCopy, if set, is called before a file is copied, with its
+	// names in the destination and source and the info of the source, and
+	// AfterCopy after its contents are written, to stop a service before
+	// its binary is replaced, say. If they return an error, the file fails
+	// with it. They aren't called in a dry run, and may be called from the
+	// workers.
+	BeforeCopy func(dst, src string, fi os.FileInfo) error
+	AfterCopy  func(dst, src string, fi os.FileInfo) error
+	// Conflicts decides which version wins when SyncBoth finds a path
+	// changed on both sides, unless OnConflict is set, in which case it
+	// decides for each path.
+	Conflicts  ConflictPolicy
+	OnConflict func(c Conflict) ConflictPolicy
+	// OnRun, if set, is called by Run with the result of each sync.
+	OnRun func(stats Stats, err error)
+	// Clock, if set, is used instead of the wall clock to tell the time
+	// and wait, by Run, Watch, RateLimit, progress reports and Verify.
+	Clock Clock
+	// Seed, if not zero, seeds the random choices made by Run, Recheck and
+	// Verify, so that they're the same each time, as in tests. Otherwise,
+	// a seed is taken from the time. Stats has the seed used.
+	Seed int64
+	// WatchDelay is how long Watch waits for more changes after one before
+	// syncing them. DefaultWatchDelay is used if it's zero.
+	WatchDelay time.Duration
+	// Strict makes a sync fail with a LossError rather than lose anything
+	// a file in the source has: symbolic links, which are otherwise
+	// followed, special files, extended attributes, and permissions or
+	// modification times the destination doesn't keep as they are.
+	Strict bool
+	// ContinueOnError makes a sync go on when a file or directory fails,
+	// as when it can't be read, and fail at the end with SyncErrors
+	// listing them. Errors of the whole sync still stop it at once.
+	ContinueOnError bool
+	// Retries is how many more times a file or directory that fails with
+	// an error Retryable accepts is tried, waiting RetryDelay before the
+	// first retry and twice as long before each next one, before OnError
+	// or ContinueOnError decide. Retryable is Transient if nil.
+	Retries    int
+	RetryDelay time.Duration
+	Retryable  func(err error) bool
+	// OnError, if set, decides what's done when a file or directory
+	// fails, instead of ContinueOnError. It's called again if a retry
+	// fails too. As OnProgress, it may be called from the workers.
+	OnError func(err SyncError) ErrorAction
+	// ConfirmBytes and ConfirmDeletes, if positive, make a sync that
+	// would copy more bytes, or delete more files and directories, than
+	// that fail with a ConfirmError, before doing anything, unless it's
+	// confirmed: by Confirm, called with what it would do, or by
+	// ConfirmToken, if it's the token of the ConfirmError of the same
+	// sync, so that a larger one can be approved by another run.
+	ConfirmBytes   int64
+	ConfirmDeletes int
+	Confirm        func(plan Stats) bool
+	ConfirmToken   string
+	// DryRun makes a sync leave the destination alone, and only report what
+	// it would do in the Stats returned by SyncStats and in OnProgress.
+	DryRun bool
+	// Exclude lists patterns of files and directories in the source that
+	// aren't synced. Their counterparts in the destination aren't deleted.
+	// Patterns without a slash match names at any depth, as understood by
+	// path.Match; others match paths relative to the source, with
+	// slashes as separators. As in .gitignore files, patterns ending with
+	// a slash only match directories, and "**" matches any number of
+	// directories, as in "**/cache" or "logs/**/*.gz". See Filters to try
+	// them out.
+	Exclude []string
+	// Include lists patterns, as in Exclude, of files and directories
+	// that are synced even though Exclude or Filter matches them or a
+	// directory they're in, as "vendor/manifest.json" with "vendor/"
+	// excluded. Excluded directories are gone through if a pattern in
+	// Include may match something in them, and made in the destination
+	// even if nothing does.
+	Include []string
+	// Filter, if set, excludes what it matches as well; see Filters for
+	// how it's combined with Exclude.
+	Filter Filter
+	// Transformers change the contents of files as they're copied,
+	// compressing them, say: those of each file matching their patterns are
+	// passed through them in order. Such files are compared with the
+	// destination by their changed contents, regardless of Comparison,
+	// except that CompareQuick, and CompareChained for files that aren't
+	// suspect, only compare modification times.
+	Transformers []Transformer
+	// LineEndings, if set, changes the line endings of text files as
+	// they're copied, after Transformers; see the LineEndings constants.
+	// Files with a zero byte in their first 8000 bytes are binary, and left
+	// as they are. As with Transformers, files are compared by what they'd
+	// be changed to.
+	LineEndings LineEndings
+	// ExcludeMarked excludes the files and directories in the source that
+	// are marked to be skipped, with SkipXattr or the nodump flag, if the
+	// source is a Marker.
+	ExcludeMarked bool
+	// SrcFS and DstFS are the file systems of the source and destination. If
+	// nil, paths that look like URLs are opened with the backend registered
+	// for their scheme and other paths are on the local file system.
+	SrcFS, DstFS FS
+
+	mu     sync.Mutex
+	runs   map[*run]bool // in progress, for Boost
+	resume chan struct{} // closed by Resume; nil unless paused
+}
+
+// run holds the state of a single call to Sync.
+type run struct {
+	*Syncer
+	ctx       context.Context // SyncContext's
+	dirOnly   bool            // with CopyDir
+	dfs, sfs  FS
+	cmp       Comparison                // comparison in effect
+	window    time.Duration             // modify window in effect
+	hist      *history                  // nil without History
+	state     *stateRun                 // nil without StateFile
+	renames   map[int64][]moveCandidate // files that may have moved, by size
+	moved     map[string]bool           // destination names moved from
+	links     map[fileID]job            // first files synced, with PreserveHardlinks
+	deletions jobList                   // left by remove for removePending
+	touched   map[string]string         // directories to restat, by destination
+	boosts    boosts                    // paths passed to Boost
+	limit     limiter                   // RateLimit in effect
+	progress  tracker
+	rechecks  rechecks        // files written, with Recheck
+	expected  interference    // files to write, with Interference
+	stats     Stats           // except Files and Bytes, kept by progress
+	root      string          // source of the run
+	dstRoot   string          // destination of the run
+	dstFound  bool            // dstRoot existed when the run began
+	dryDirs   map[string]bool // directories a dry run would create
+	dstFiles  int             // files in the destination with a source
+	last      string          // source name synced last, for Stats.Last
+	rnd       *rand.Rand      // seeded with stats.Seed
+	sums      sidecars        // with Sidecars
+	dstSums   dstSums         // with WriteSums
+	failures  failures        // with ContinueOnError
+	bufs      buffers         // to copy files with
+	DryRun    bool            // Syncer.DryRun, or true for Plan
+	LinkDest  string          // Syncer.LinkDest, or the last snapshot for Snapshot
+	planned   *Plan           // with Plan
+	meta      MetadataOptions // with SyncMetadata
+	changes   func(e Event)   // with Changes
+	reported  progressFile    // with ProgressFile
+	verbosity int32           // Verbosity in effect; accessed atomically
+	noclone   int32           // set once a clone fails; accessed atomically
+	workers                   // only used with Workers
 }
 
 // NewSyncer creates a new instance of Syncer with default options.
@@ -68,18 +425,201 @@ func NewSyncer() *Syncer {
 
 // Sync copies files and directories inside src into dst.
 func (s *Syncer) Sync(dst, src string) error {
+	_, err := s.SyncStats(dst, src)
+	return err
+}
+
+// SyncStats is like Sync, but also returns what it did, or what it would
+// do in a dry run. When it fails, that's what it did before, up to
+// Stats.Last, so that it's known what state the destination was left in.
+func (s *Syncer) SyncStats(dst, src string) (Stats, error) {
+	return s.SyncContext(context.Background(), dst, src)
+}
+
+// do syncs dst with src and closes the file systems of r.
+func (r *run) do(dst, src string) (err error) {
+	defer func() { r.report(true, err) }()
+	defer func() {
+		if err2 := r.close(); err == nil {
+			err = err2
+		}
+	}()
+	defer func() { r.stats.Stopped = r.stopped(err) }()
+	if r.History != "" {
+		if r.hist, err = loadHistory(r.History); err != nil {
+			return err
+		}
+		defer func() {
+			if r.DryRun {
+				return // nothing changed
+			}
+			if err2 := r.hist.save(); err == nil {
+				err = err2
+			}
+		}()
+	}
+	if r.StateFile != "" {
+		if r.state, err = loadStateRun(r.StateFile, r.recordLimit()); err != nil {
+			return err
+		}
+		defer func() {
+			if err == nil && !r.DryRun {
+				err = r.state.save()
+			}
+		}()
+	}
+	if err := checkPatterns(r.Exclude, r.Include, r.Protect, r.Suspects, r.transformerPatterns()); err != nil {
+		return err
+	}
+
+	// sync into the tree to swap in
+	if r.Swap != NoSwap {
+		if r.StateFile != "" {
+			return ErrSwapState
+		}
+		var staged string
+		if staged, err = r.stage(dst); err != nil {
+			return err
+		}
+		live := dst
+		defer func() {
+			if err == nil && !r.DryRun {
+				err = r.swap(live, staged)
+			}
+		}()
+		dst = staged
+	}
+
+	// resolve or copy a source that's a symbolic link
+	target := ""
+	if r.RootLink != FollowRootLink {
+		if src, target, err = r.rootLink(src); err != nil {
+			return err
+		}
+	}
+	if target != "" {
+		r.dstRoot = dst
+		r.start(src)
+		defer r.finish()
+		r.report(false, nil)
+		return catch(func() { r.copyLink(dst, src, target) })
+	}
+
 	// make sure src exists
-	if _, err := os.Stat(src); err != nil {
+	if fi, err := r.sfs.Stat(src); err != nil {
 		return err
+	} else if r.dirOnly && !fi.IsDir() {
+		return ErrNotDir
 	}
 	// return error instead of replacing a non-empty directory with a file
-	if b, err := s.checkDir(dst, src); err != nil {
+	if b, err := r.checkDir(dst, src); err != nil {
 		return err
 	} else if b {
 		return ErrFileOverDir
 	}
 
-	return s.syncRecover(dst, src)
+	// with nothing changed since the last sync, there's nothing to do
+	if r.state != nil {
+		r.root = src
+		if r.idle(dst, src) {
+			r.state.idle = true
+			r.stats.UpToDate = true
+			return r.state.old.each(func(p string, e *StateEntry) error {
+				if !e.Dir {
+					r.stats.Unchanged++
+				}
+				return nil
+			})
+		}
+	}
+
+	// Boost, Status, SetWorkers and SetRateLimit may reach r from now on
+	r.dstRoot = dst
+	r.start(src)
+	defer r.finish()
+	r.report(false, nil)
+	err = r.scan(func() {
+		if r.Delete && r.DeleteMode == DeleteBefore {
+			r.deleteFirst(dst, src)
+		}
+		r.sync(dst, src)
+	})
+	if err == nil {
+		err = catch(r.removePending)
+	}
+	if err == nil && r.WriteSums && !r.DryRun {
+		err = catch(r.writeSums)
+	}
+	if err == nil && r.Recheck > 0 {
+		err = catch(r.recheck)
+	}
+	if err == nil {
+		err = r.failure()
+	}
+	return err
+}
+
+// scan calls f, which syncs files, and waits for the copies it left to the
+// workers.
+func (r *run) scan(f func()) error {
+	err := catch(f)
+	r.progress.scanned()
+	r.report(false, nil)
+	if r.jobs == nil {
+		return err
+	}
+	if err2 := r.stopWorkers(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	// directories were changed by the workers; sync their stats now
+	return catch(func() {
+		for _, d := range r.dirs {
+			r.try(d.src, func() { r.syncstats(d.dst, d.src) })
+		}
+	})
+}
+
+// newRun opens the file systems of dst and src and returns a run on them,
+// with dst and src converted to names in those file systems.
+func (s *Syncer) newRun(dst, src string) (r *run, dname, sname string, err error) {
+	sfs, src, err := openFS(s.SrcFS, src)
+	if err != nil {
+		return nil, "", "", err
+	}
+	dfs, dst, err := openFS(s.DstFS, dst)
+	if err != nil {
+		closeFS(sfs, s.SrcFS)
+		return nil, "", "", err
+	}
+	r = &run{Syncer: s, ctx: context.Background(), dfs: dfs, sfs: sfs, DryRun: s.DryRun,
+		LinkDest: s.LinkDest, dryDirs: make(map[string]bool)}
+	_, err = dfs.Stat(dst)
+	r.dstFound = err == nil
+	r.cmp, r.window = r.comparison()
+	r.stats.Seed = s.seed()
+	r.rnd = rand.New(rand.NewSource(r.stats.Seed))
+	r.deletions.limit = r.recordLimit()
+	return r, dst, src, nil
+}
+
+// seed returns Seed, or a seed taken from the time if it's zero.
+func (s *Syncer) seed() int64 {
+	if s.Seed != 0 {
+		return s.Seed
+	}
+	return s.clock().Now().UnixNano()
+}
+
+// close closes the file systems opened for the run. Backends may only store
+// changes when closed, so errors closing the destination are returned.
+func (r *run) close() error {
+	r.state.close()
+	r.deletions.close()
+	closeFS(r.sfs, r.SrcFS)
+	return closeFS(r.dfs, r.DstFS)
 }
 
 // SyncTo syncs srcs files or directories into to directory.
@@ -93,8 +633,8 @@ func (s *Syncer) SyncTo(to string, srcs ...string) error {
 	return nil
 }
 
-// syncRecover handles errors and calls sync
-func (s *Syncer) syncRecover(dst, src string) (err error) {
+// catch calls f and returns the error it panics with, if any.
+func catch(f func()) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			if _, ok := r.(runtime.Error); ok {
@@ -104,96 +644,317 @@ func (s *Syncer) syncRecover(dst, src string) (err error) {
 		}
 	}()
 
-	s.sync(dst, src)
+	f()
 	return nil
 }
 
 // sync updates dst to match with src, handling both files and directories.
-func (s *Syncer) sync(dst, src string) {
-	// sync permissions and modification times after handling content
-	defer s.syncstats(dst, src)
+func (r *run) sync(dst, src string) {
+	r.wait() // while paused
+	r.last = src
 
-	// read files info
-	dstat, err := os.Stat(dst)
-	if err != nil && !os.IsNotExist(err) {
-		panic(err)
+	// sync permissions and modification times after handling content,
+	// unless it's left to the workers
+	later := false
+	defer func() {
+		if !later {
+			r.syncstats(dst, src)
+		}
+	}()
+
+	// read files info; in a dry run, directories that would have been
+	// created are empty
+	var dstat os.FileInfo
+	var err error
+	if !r.dryDirs[filepath.Dir(dst)] {
+		dstat, err = r.dfs.Stat(dst)
+		if err != nil && !os.IsNotExist(err) {
+			panic(err)
+		}
 	}
-	sstat, err := os.Stat(src)
+	sstat, err := r.sfs.Stat(src)
 	if err != nil && os.IsNotExist(err) {
-		return // src was deleted before we could copy it
+		r.vanished(src) // deleted before we could copy it
+		return
 	}
 	check(err)
+	r.strict(src, sstat)
 
+	if isSpecial(sstat) {
+		later = !r.special(dst, src, dstat, sstat)
+		return
+	}
 	if !sstat.IsDir() {
 		// src is a file
 		// delete dst if its a directory
-		if dstat != nil && dstat.IsDir() {
-			check(os.RemoveAll(dst))
+		replace := dstat != nil && dstat.IsDir()
+		if replace {
+			r.stats.Deleted++
+			r.emit(Verbose, src, Event{Op: OpDelete, Item: itemDeleting})
+			if !r.DryRun {
+				r.discard(dst)
+			}
 		}
-		if !s.equal(dst, src) {
-			// perform copy
-			df, err := os.Create(dst)
-			check(err)
-			defer df.Close()
-			sf, err := os.Open(src)
-			if os.IsNotExist(err) {
-				return
+		if r.link(dst, src, dstat, sstat) {
+			return
+		}
+		if dstat != nil && !replace {
+			r.dstFiles++
+			why := SameState
+			if !r.unchanged(src, sstat, dstat) {
+				why = r.same(dst, src)
 			}
-			check(err)
-			defer sf.Close()
-			_, err = io.Copy(df, sf)
-			if os.IsNotExist(err) {
+			if why != Copied {
+				r.stats.Unchanged++
+				r.emit(Trace, src, Event{Op: OpSkip, Reason: why})
 				return
 			}
-			check(err)
+		}
+		r.hist.changed(filepath.Dir(src))
+		if dstat == nil && (r.move(dst, src, sstat) || r.linkDest(dst, src, sstat)) {
+			return
+		}
+		r.budget(sstat.Size())
+		r.progress.found(sstat.Size())
+		if dstat == nil || replace {
+			r.progress.changing(src, itemNew)
+		} else {
+			r.progress.changing(src, r.itemize('>', dstat, sstat))
+		}
+		if r.DryRun {
+			r.copied(src, sstat.Size())
+			return
+		}
+		r.compared(dst, dstat)
+		// files with hard links are copied right away, to be linked to
+		if r.jobs != nil && !r.linking(sstat) {
+			later = true
+			r.enqueue(dst, src)
+			return
+		}
+		if !r.copy(dst, src) {
+			later = true // left as it is
 		}
 		return
 	}
 
 	// src is a directory
 	// make dst if necessary
-	if dstat == nil {
+	if dstat == nil || !dstat.IsDir() {
+		r.hist.changed(src)
+		if dstat != nil {
+			r.stats.Deleted++
+			r.emit(Verbose, src, Event{Op: OpDelete, Item: itemDeleting})
+		}
+		r.stats.Dirs++
+		r.emit(Verbose, src, Event{Op: OpMkdir, Item: itemNewDir})
+	}
+	if r.DryRun && (dstat == nil || !dstat.IsDir()) {
+		r.dryDirs[dst] = true
+	} else if dstat == nil {
 		// dst does not exist; create directory
-		check(os.MkdirAll(dst, 0755)) // permissions will be synced later
+		r.mkdirAll(dst) // permissions will be synced later
 	} else if !dstat.IsDir() {
 		// dst is a file; remove and create directory
-		check(os.Remove(dst))
-		check(os.MkdirAll(dst, 0755)) // permissions will be synced later
+		r.discard(dst)
+		r.mkdirAll(dst) // permissions will be synced later
 	}
 
+	r.summing(dst, src)
+
 	// go through sf files and sync them
-	files, err := ioutil.ReadDir(src)
+	files, err := r.sfs.ReadDir(src)
 	if os.IsNotExist(err) {
+		r.vanished(src)
 		return
 	}
 	check(err)
+	r.hist.order(src, files)
 	// make a map of filenames for quick lookup; used in deletion
 	// deletion below
 	m := make(map[string]bool, len(files))
-	for _, file := range files {
+	gen := -1
+	for i := range files {
+		// paths may be boosted while the directory is synced
+		gen = r.boostOrder(src, files[i:], gen)
+		file := files[i]
 		dst2 := filepath.Join(dst, file.Name())
 		src2 := filepath.Join(src, file.Name())
-		s.sync(dst2, src2)
+		if r.excluded(src2, file) && !r.descend(src2, file) {
+			r.emit(Trace, src2, Event{Op: OpSkip, Reason: Excluded})
+			continue
+		}
+		r.try(src2, func() { r.sync(dst2, src2) })
 		m[file.Name()] = true
 	}
 
 	// delete files from dst that does not exist in src
-	if s.Delete {
-		files, err = ioutil.ReadDir(dst)
-		check(err)
-		for _, file := range files {
-			if !m[file.Name()] {
-				check(os.RemoveAll(filepath.Join(dst, file.Name())))
-			}
+	if r.Delete && r.DeleteMode != DeleteBefore && !r.dryDirs[dst] {
+		r.removeExtras(dst, src, m)
+	}
+
+	if r.jobs != nil {
+		later = true
+		r.dirs = append(r.dirs, job{dst, src})
+	}
+}
+
+// removeExtras deletes the files in dst that aren't in the directory src,
+// whose names are in synced.
+func (r *run) removeExtras(dst, src string, synced map[string]bool) {
+	files, err := r.dfs.ReadDir(dst)
+	check(err)
+	for _, file := range files {
+		dst2 := filepath.Join(dst, file.Name())
+		src2 := filepath.Join(src, file.Name())
+		if r.Atomic && isTemp(file.Name()) {
+			continue // being copied
 		}
+		if synced[file.Name()] || r.isBackup(dst2) || r.excluded(src2, file) || !r.deletable(src2, file) {
+			continue
+		}
+		switch r.protection(src2, file) {
+		case protected:
+			continue
+		case protectedInside:
+			r.removeExtras(dst2, src2, nil)
+			continue
+		}
+		r.hist.changed(src)
+		r.try(src2, func() { r.remove(dst2, src2) })
 	}
 }
 
+// remove deletes dst, which has no source src. With DetectRenames, it's
+// left for removePending, as files in it may have moved elsewhere, and
+// likewise with MaxDelete, until all the deletions are known, with
+// DeleteBefore and DeleteAfter, and while the workers copy files into its
+// directory.
+func (r *run) remove(dst, src string) {
+	if r.DetectRenames && r.state != nil || r.MaxDelete > 0 || r.MaxDeletePercent > 0 || r.DeleteMode != DeleteDuring ||
+		r.copying(filepath.Dir(dst)) {
+		r.deletions.add(job{dst, src})
+		return
+	}
+	r.delete(dst, src)
+}
+
+// delete deletes dst, which has no source src, unless a file in its
+// directory failed.
+func (r *run) delete(dst, src string) {
+	if r.failures.in(filepath.Dir(src)) {
+		return
+	}
+	r.stats.Deleted++
+	r.emit(Verbose, src, Event{Op: OpDelete, Item: itemDeleting})
+	if !r.DryRun && !r.quarantine(dst) {
+		r.discard(dst)
+	}
+}
+
+// copy copies the contents of the file src to dst. It returns false if dst
+// was left alone, as someone else changed it.
+func (r *run) copy(dst, src string) bool {
+	if r.interfered(dst, src) {
+		return false
+	}
+	r.hook(r.BeforeCopy, dst, src)
+	// files updated in place keep their inode, and hard links to it
+	inPlace := !r.Atomic && r.BackupDir == "" && r.BackupSuffix == ""
+	if inPlace && r.update(dst, src) {
+		return true
+	}
+	if r.clone(dst, src) {
+		return true
+	}
+	// open src first, so that dst is left alone if it's gone
+	sf, err := r.sfs.Open(src)
+	if os.IsNotExist(err) {
+		r.vanished(src)
+		return true
+	}
+	check(err)
+	defer sf.Close()
+	name, renamed := dst, false
+	if r.Atomic {
+		name = tempName(dst)
+		defer func() {
+			if !renamed {
+				r.dfs.Remove(name)
+			}
+		}()
+	} else {
+		r.backup(dst)
+	}
+	df, err := r.dfs.Create(name)
+	check(err)
+	defer df.Close()
+	in, h := r.reader(src, sf)
+	var n int64
+	sparse := false
+	if !r.transformed(src) {
+		n, sparse, err = r.sparse(df, sf, in, h)
+	}
+	if !sparse {
+		r.preallocate(df, src)
+		n, err = r.copyData(df, in, in == io.Reader(sf))
+	}
+	if os.IsNotExist(err) {
+		r.vanished(src)
+		return true
+	}
+	check(err)
+	// some backends only store the file when it's closed
+	check(df.Close())
+	var sum []byte
+	if h != nil {
+		sum = h.Sum(nil)
+		r.matchSidecar(src, sum)
+		r.summed(dst, sum)
+	}
+	if r.Atomic {
+		r.backup(dst)
+		check(r.dfs.Rename(name, dst))
+		renamed = true
+	}
+	if sum != nil {
+		r.hashed(src, sum)
+	}
+	r.wrote(dst, src, n, sum)
+	r.copied(src, n)
+	r.hook(r.AfterCopy, dst, src)
+	return true
+}
+
+// reader returns the reader to copy the source file src from, opened as
+// sf, which is changed by Transformers and obeys RateLimit, and the hash it
+// computes on the way with StateFile, Recheck, Sidecars or WriteSums.
+func (r *run) reader(src string, sf io.Reader) (io.Reader, hash.Hash) {
+	in := r.transform(src, sf)
+	if r.limit.limited() {
+		in = &limitReader{in, &r.limit}
+	}
+	var h hash.Hash
+	if r.hashing() {
+		h = sha256.New()
+		in = io.TeeReader(in, h)
+	}
+	return in, h
+}
+
+// hashing returns true if the checksums of files copied are needed.
+func (r *run) hashing() bool {
+	return r.state != nil || r.Recheck > 0 || r.Sidecars || r.WriteSums
+}
+
 // syncstats makes sure dst has the same pemissions and modification time as src
-func (s *Syncer) syncstats(dst, src string) {
+func (r *run) syncstats(dst, src string) {
+	if r.DryRun {
+		return
+	}
 	// get file infos; return if not exist and panic if error
-	dstat, err1 := os.Stat(dst)
-	sstat, err2 := os.Stat(src)
+	dstat, err1 := r.dfs.Stat(dst)
+	sstat, err2 := r.sfs.Stat(src)
 	if os.IsNotExist(err1) || os.IsNotExist(err2) {
 		return
 	}
@@ -201,58 +962,135 @@ func (s *Syncer) syncstats(dst, src string) {
 	check(err2)
 
 	// update dst's permission bits
-	if dstat.Mode().Perm() != sstat.Mode().Perm() {
-		check(os.Chmod(dst, sstat.Mode().Perm()))
+	perm := dstat.Mode() & permBits
+	if r.permDiffers(dstat, sstat) {
+		perm = r.perm(sstat)
+		check(r.dfs.Chmod(dst, perm))
+		r.emit(Trace, src, Event{Op: OpChmod, Item: itemAttr(sstat, 'p')})
+	}
+	if r.Recheck > 0 {
+		r.chmodded(dst, perm)
 	}
 
 	// update dst's modification time
-	if !s.NoTimes {
-		if !dstat.ModTime().Equal(sstat.ModTime()) {
-			err := os.Chtimes(dst, sstat.ModTime(), sstat.ModTime())
+	dtime := dstat.ModTime()
+	if !r.NoTimes {
+		if !r.sameTime(dstat.ModTime(), sstat.ModTime()) {
+			err := r.dfs.Chtimes(dst, sstat.ModTime(), sstat.ModTime())
 			check(err)
+			r.emit(Trace, src, Event{Op: OpChtimes, Item: itemAttr(sstat, 't')})
+			dtime = sstat.ModTime()
 		}
 	}
+	r.strictStats(dst, src, sstat)
+	r.record(src, sstat, dtime)
 }
 
 // equal returns true if both files are equal
-func (s *Syncer) equal(a, b string) bool {
+func (r *run) equal(a, b string) bool {
+	return r.same(a, b) != Copied
+}
+
+// same returns why the files a and b are equal, or Copied if they aren't.
+func (r *run) same(a, b string) Reason {
 	// get file infos
-	info1, err1 := os.Stat(a)
-	info2, err2 := os.Stat(b)
+	info1, err1 := r.dfs.Stat(a)
+	info2, err2 := r.sfs.Stat(b)
 	if os.IsNotExist(err1) || os.IsNotExist(err2) {
-		return false
+		return Copied
 	}
 	check(err1)
 	check(err2)
 
-	// check sizes
-	if info1.Size() != info2.Size() {
-		return false
+	// special files are the same if they're the same kind of file
+	if isSpecial(info1) || isSpecial(info2) {
+		if sameSpecial(info1, info2) {
+			return SameContent
+		}
+		return Copied
+	}
+
+	// check sizes, unless the source is changed as it's copied
+	tf := r.transformed(b)
+	if info1.Size() != info2.Size() && !tf {
+		return Copied
+	}
+
+	// both have the same size; when comparing quickly, the modification
+	// times decide, unless the file is suspect with CompareChained
+	if r.cmp == CompareQuick || r.cmp == CompareChained {
+		if !r.sameTime(info1.ModTime(), info2.ModTime()) {
+			return Copied
+		}
+		if r.cmp == CompareQuick || !r.suspect(b, info1, info2) {
+			return SameSizeAndTime
+		}
 	}
 
-	// both have the same size, check the contents
-	f1, err := os.Open(a)
+	// if the destination keeps checksums, compare
+	// with that instead of reading it
+	if h, ok := r.dfs.(Hasher); ok && !tf {
+		sum, err := h.Hash(a)
+		check(err)
+		if sum != nil {
+			if bytes.Equal(sum, hashFile(r.sfs, b, h.NewHash())) {
+				return SameChecksum
+			}
+			return Copied
+		}
+	}
+	// or the sidecar of the source
+	if sum := r.sidecar(b, info2); sum != nil && !tf {
+		if bytes.Equal(sum, hashFile(r.dfs, a, sha256.New())) {
+			return SameChecksum
+		}
+		return Copied
+	}
+	// and the same for the source
+	if h, ok := r.sfs.(Hasher); ok && !tf {
+		sum, err := h.Hash(b)
+		check(err)
+		if sum != nil {
+			if bytes.Equal(sum, hashFile(r.dfs, a, h.NewHash())) {
+				return SameChecksum
+			}
+			return Copied
+		}
+	}
+
+	// or both checksums
+	if r.cmp == CompareChecksum && !tf {
+		if bytes.Equal(hashFile(r.dfs, a, sha256.New()), hashFile(r.sfs, b, sha256.New())) {
+			return SameChecksum
+		}
+		return Copied
+	}
+
+	// check the contents
+	f1, err := r.dfs.Open(a)
 	check(err)
 	defer f1.Close()
-	f2, err := os.Open(b)
+	f2, err := r.sfs.Open(b)
 	check(err)
 	defer f2.Close()
+	in2 := r.transform(b, f2)
 	buf1 := make([]byte, 1000)
 	buf2 := make([]byte, 1000)
 	for {
-		// read from both
-		n1, err := f1.Read(buf1)
-		if err != nil && err != io.EOF {
+		// read from both; streams from remote backends may return less
+		// than asked for
+		n1, err := io.ReadFull(f1, buf1)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 			panic(err)
 		}
-		n2, err := f2.Read(buf2)
-		if err != nil && err != io.EOF {
+		n2, err := io.ReadFull(in2, buf2)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 			panic(err)
 		}
 
 		// compare read bytes
 		if !bytes.Equal(buf1[:n1], buf2[:n2]) {
-			return false
+			return Copied
 		}
 
 		// end of both files
@@ -261,19 +1099,29 @@ func (s *Syncer) equal(a, b string) bool {
 		}
 	}
 
-	return true
+	return SameContent
+}
+
+// hashFile returns the checksum of name in fs computed with h.
+func hashFile(fs FS, name string, h hash.Hash) []byte {
+	f, err := fs.Open(name)
+	check(err)
+	defer f.Close()
+	_, err = io.Copy(h, f)
+	check(err)
+	return h.Sum(nil)
 }
 
 // checkDir returns true if dst is a non-empty directory and src is a file
-func (s *Syncer) checkDir(dst, src string) (b bool, err error) {
+func (r *run) checkDir(dst, src string) (b bool, err error) {
 	// read file info
-	dstat, err := os.Stat(dst)
+	dstat, err := r.dfs.Stat(dst)
 	if os.IsNotExist(err) {
 		return false, nil
 	} else if err != nil {
 		return false, err
 	}
-	sstat, err := os.Stat(src)
+	sstat, err := r.sfs.Stat(src)
 	if err != nil {
 		return false, err
 	}
@@ -286,7 +1134,7 @@ func (s *Syncer) checkDir(dst, src string) (b bool, err error) {
 	// dst is a directory and src is a file
 	// check if dst is non-empty
 	// read dst directory
-	files, err := ioutil.ReadDir(dst)
+	files, err := r.dfs.ReadDir(dst)
 	if err != nil {
 		return false, err
 	}
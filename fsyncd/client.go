@@ -0,0 +1,324 @@
+package fsyncd
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"hash"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mostafah/fsync"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func init() {
+	fsync.Register("fsyncd", Open)
+}
+
+// FS is an fsync.FS on a directory served by fsyncd. Names are relative to
+// that directory.
+type FS struct {
+	conn  *grpc.ClientConn
+	owned bool // whether to close conn on Close
+}
+
+// New returns an FS that uses conn. The connection must send the token
+// itself (see Token) and use the "fsyncd" content subtype, as Dial's do.
+// Closing the FS does not close conn.
+func New(conn *grpc.ClientConn) *FS {
+	return &FS{conn: conn}
+}
+
+// Dial connects to the server at addr with token. creds secures the
+// connection; if it's nil, TLS is used with the system's root certificates.
+func Dial(addr, token string, creds credentials.TransportCredentials) (*FS, error) {
+	if creds == nil {
+		creds = credentials.NewTLS(&tls.Config{})
+	}
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithPerRPCCredentials(Token(token)),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(codec{}.Name())))
+	if err != nil {
+		return nil, err
+	}
+	return &FS{conn: conn, owned: true}, nil
+}
+
+// Open connects to the server in u, with the user of u as the token. The
+// insecure query parameter turns off TLS.
+func Open(u *url.URL) (fsync.FS, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), DefaultPort)
+	}
+	var token string
+	if u.User != nil {
+		token = u.User.Username()
+	}
+	var creds credentials.TransportCredentials
+	if _, ok := u.Query()["insecure"]; ok {
+		creds = insecure.NewCredentials()
+	}
+	return Dial(addr, token, creds)
+}
+
+// Close closes the connection if FS was created by Dial or Open.
+func (fs *FS) Close() error {
+	if !fs.owned {
+		return nil
+	}
+	return fs.conn.Close()
+}
+
+// Token returns credentials that send token with every call.
+func Token(token string) credentials.PerRPCCredentials {
+	return tokenCreds(token)
+}
+
+type tokenCreds string
+
+func (t tokenCreds) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity returns false so that tokens can be sent over
+// connections secured some other way, such as an SSH tunnel.
+func (t tokenCreds) RequireTransportSecurity() bool {
+	return false
+}
+
+// invoke calls a unary method, converting not found errors.
+func (fs *FS) invoke(method, name string, req, resp interface{}) error {
+	err := fs.conn.Invoke(context.Background(), "/"+serviceName+"/"+method, req, resp)
+	return fsError(method, name, err)
+}
+
+// fsError converts errors from the server to the ones the os package
+// returns, which fsync checks for.
+func fsError(op, name string, err error) error {
+	switch status.Code(err) {
+	case codes.OK:
+		return nil
+	case codes.NotFound:
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	case codes.AlreadyExists:
+		return &os.PathError{Op: op, Path: name, Err: os.ErrExist}
+	case codes.PermissionDenied:
+		return &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	}
+	return err
+}
+
+func slash(name string) string {
+	return filepath.ToSlash(name)
+}
+
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	var fi fileInfo
+	if err := fs.invoke("StatFile", name, &pathRequest{Path: slash(name)}, &fi); err != nil {
+		return nil, err
+	}
+	return info{&fi}, nil
+}
+
+func (fs *FS) ReadDir(name string) ([]os.FileInfo, error) {
+	var resp listDirResponse
+	if err := fs.invoke("ListDir", name, &pathRequest{Path: slash(name)}, &resp); err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, len(resp.Files))
+	for i, fi := range resp.Files {
+		infos[i] = info{fi}
+	}
+	return infos, nil
+}
+
+func (fs *FS) Open(name string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := fs.conn.NewStream(ctx, &serviceDesc.Streams[0], "/"+serviceName+"/ReadFile")
+	if err == nil {
+		err = stream.SendMsg(&pathRequest{Path: slash(name)})
+	}
+	if err == nil {
+		err = stream.CloseSend()
+	}
+	if err != nil {
+		cancel()
+		return nil, fsError("open", name, err)
+	}
+	return &reader{stream: stream, cancel: cancel, name: name}, nil
+}
+
+// reader reads a file from a ReadFile stream.
+type reader struct {
+	stream grpc.ClientStream
+	cancel context.CancelFunc
+	name   string
+	buf    []byte
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		var c chunk
+		if err := r.stream.RecvMsg(&c); err == io.EOF {
+			return 0, io.EOF
+		} else if err != nil {
+			return 0, fsError("read", r.name, err)
+		}
+		r.buf = c.Data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *reader) Close() error {
+	r.cancel()
+	return nil
+}
+
+// Create returns a writer that streams to the server, which replaces name
+// when the writer is closed.
+func (fs *FS) Create(name string) (io.WriteCloser, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := fs.conn.NewStream(ctx, &serviceDesc.Streams[1], "/"+serviceName+"/WriteChunk")
+	if err != nil {
+		cancel()
+		return nil, fsError("create", name, err)
+	}
+	return &writer{stream: stream, cancel: cancel, name: name}, nil
+}
+
+// writer writes a file to a WriteChunk stream.
+type writer struct {
+	stream grpc.ClientStream
+	cancel context.CancelFunc
+	name   string
+	sent   bool // whether the first chunk, with the path, was sent
+	closed bool
+	err    error
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	n := 0
+	for w.err == nil && (len(p) > 0 || !w.sent) {
+		c := &chunk{Data: p}
+		if len(c.Data) > chunkSize {
+			c.Data = c.Data[:chunkSize]
+		}
+		if !w.sent {
+			c.Path = slash(w.name)
+			w.sent = true
+		}
+		if err := w.stream.SendMsg(c); err != nil {
+			w.err = w.result(err)
+			break
+		}
+		n += len(c.Data)
+		p = p[len(c.Data):]
+	}
+	return n, w.err
+}
+
+// Close finishes the file. Closing it again does nothing.
+func (w *writer) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	defer w.cancel()
+	if !w.sent {
+		w.Write(nil) // empty file
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.stream.CloseSend(); err != nil {
+		w.err = w.result(err)
+		return w.err
+	}
+	w.err = fsError("create", w.name, w.stream.RecvMsg(&empty{}))
+	return w.err
+}
+
+// result returns the error the server ended the stream with, which explains
+// err from sending better.
+func (w *writer) result(err error) error {
+	if err == io.EOF {
+		err = w.stream.RecvMsg(&empty{})
+	}
+	return fsError("create", w.name, err)
+}
+
+func (fs *FS) MkdirAll(name string, perm os.FileMode) error {
+	return fs.invoke("Mkdir", name, &pathRequest{Path: slash(name), Mode: uint32(perm.Perm())}, &empty{})
+}
+
+func (fs *FS) Remove(name string) error {
+	return fs.invoke("Delete", name, &deleteRequest{Path: slash(name)}, &empty{})
+}
+
+func (fs *FS) RemoveAll(name string) error {
+	err := fs.invoke("Delete", name, &deleteRequest{Path: slash(name), Recursive: true}, &empty{})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (fs *FS) Rename(oldname, newname string) error {
+	return fs.invoke("Rename", oldname, &renameRequest{OldPath: slash(oldname), NewPath: slash(newname)}, &empty{})
+}
+
+func (fs *FS) Chmod(name string, mode os.FileMode) error {
+	// 0 means unchanged on the wire; no permissions at all is rare enough
+	// not to matter
+	return fs.invoke("SetAttr", name, &setAttrRequest{Path: slash(name), Mode: uint32(mode.Perm())}, &empty{})
+}
+
+func (fs *FS) Chtimes(name string, atime, mtime time.Time) error {
+	return fs.invoke("SetAttr", name, &setAttrRequest{Path: slash(name), Mtime: mtime.UnixNano()}, &empty{})
+}
+
+// NewHash returns a SHA-256 hash; see fsync.Hasher.
+func (fs *FS) NewHash() hash.Hash {
+	return sha256.New()
+}
+
+// Hash returns the SHA-256 checksum of name, computed by the server.
+func (fs *FS) Hash(name string) ([]byte, error) {
+	var resp hashFileResponse
+	if err := fs.invoke("HashFile", name, &pathRequest{Path: slash(name)}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Sha256, nil
+}
+
+// info is the os.FileInfo of a fileInfo from the server.
+type info struct {
+	fi *fileInfo
+}
+
+func (i info) Name() string       { return i.fi.Name }
+func (i info) Size() int64        { return i.fi.Size }
+func (i info) ModTime() time.Time { return time.Unix(0, i.fi.Mtime) }
+func (i info) IsDir() bool        { return i.fi.Dir }
+func (i info) Sys() interface{}   { return nil }
+
+func (i info) Mode() os.FileMode {
+	m := os.FileMode(i.fi.Mode).Perm()
+	if i.fi.Dir {
+		m |= os.ModeDir
+	}
+	return m
+}
@@ -0,0 +1,236 @@
+// Package fsyncd provides a gRPC service that serves a directory, and an
+// fsync backend that uses it, so that two machines running this package can
+// sync trees without SSH.
+//
+// The server is run with NewServer, or the service is added to an existing
+// gRPC server with Register; cmd/fsyncd is a ready-made daemon. Clients
+// authenticate with a shared token, sent with every call, and should use TLS
+// so the token and files are encrypted:
+//
+//	srv := fsyncd.NewServer("/srv/backup", token, grpc.Creds(creds))
+//	srv.Serve(ln)
+//
+// Importing the package registers the "fsyncd" URL scheme. The token is the
+// user of the URL, and the path is relative to the served directory. TLS is
+// used unless the insecure query parameter is set:
+//
+//	import _ "github.com/mostafah/fsync/fsyncd"
+//
+//	err := fsync.Sync("fsyncd://"+token+"@backup.example.com/site", "build")
+//
+// Files are written to a temporary file on the server and renamed into place
+// when complete, and the server computes checksums, so files are compared by
+// SHA-256 without reading them back (see fsync.Hasher).
+//
+// The messages use the protobuf wire format described in fsyncd.proto, with
+// the gRPC content subtype "fsyncd" so they don't depend on generated code.
+package fsyncd
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// DefaultPort is the port used for URLs without one.
+const DefaultPort = "8730"
+
+// serviceName is the name of the service in fsyncd.proto.
+const serviceName = "fsyncd.FS"
+
+// chunkSize is the most file data sent in a single message.
+const chunkSize = 256 << 10
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+// messages; see fsyncd.proto
+
+type pathRequest struct {
+	Path string `pb:"1"`
+	Mode uint32 `pb:"2"`
+}
+
+type fileInfo struct {
+	Name  string `pb:"1"`
+	Size  int64  `pb:"2"`
+	Mode  uint32 `pb:"3"` // permission bits
+	Mtime int64  `pb:"4"` // Unix time in nanoseconds
+	Dir   bool   `pb:"5"`
+}
+
+type listDirResponse struct {
+	Files []*fileInfo `pb:"1"`
+}
+
+type hashFileResponse struct {
+	Sha256 []byte `pb:"1"`
+}
+
+type chunk struct {
+	Path string `pb:"1"` // only in the first chunk of a write
+	Data []byte `pb:"2"`
+}
+
+type deleteRequest struct {
+	Path      string `pb:"1"`
+	Recursive bool   `pb:"2"`
+}
+
+type renameRequest struct {
+	OldPath string `pb:"1"`
+	NewPath string `pb:"2"`
+}
+
+type setAttrRequest struct {
+	Path  string `pb:"1"`
+	Mode  uint32 `pb:"2"` // unchanged if 0
+	Mtime int64  `pb:"3"` // unchanged if 0
+}
+
+type empty struct{}
+
+// codec encodes the messages above in the protobuf wire format, using the
+// field numbers in their pb tags.
+type codec struct{}
+
+func (codec) Name() string { return "fsyncd" }
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("fsyncd: can't marshal %T", v)
+	}
+	return marshal(nil, rv.Elem()), nil
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("fsyncd: can't unmarshal into %T", v)
+	}
+	return unmarshal(data, rv.Elem())
+}
+
+// fieldNum returns the field number of field i of the struct type t.
+func fieldNum(t reflect.Type, i int) protowire.Number {
+	n, _ := strconv.Atoi(t.Field(i).Tag.Get("pb"))
+	return protowire.Number(n)
+}
+
+func marshal(b []byte, v reflect.Value) []byte {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		n, f := fieldNum(t, i), v.Field(i)
+		switch f.Kind() {
+		case reflect.String:
+			if f.Len() > 0 {
+				b = protowire.AppendTag(b, n, protowire.BytesType)
+				b = protowire.AppendString(b, f.String())
+			}
+		case reflect.Bool:
+			if f.Bool() {
+				b = protowire.AppendTag(b, n, protowire.VarintType)
+				b = protowire.AppendVarint(b, 1)
+			}
+		case reflect.Uint32:
+			if f.Uint() != 0 {
+				b = protowire.AppendTag(b, n, protowire.VarintType)
+				b = protowire.AppendVarint(b, f.Uint())
+			}
+		case reflect.Int64:
+			if f.Int() != 0 {
+				b = protowire.AppendTag(b, n, protowire.VarintType)
+				b = protowire.AppendVarint(b, uint64(f.Int()))
+			}
+		case reflect.Slice:
+			if f.Type().Elem().Kind() == reflect.Uint8 {
+				if f.Len() > 0 {
+					b = protowire.AppendTag(b, n, protowire.BytesType)
+					b = protowire.AppendBytes(b, f.Bytes())
+				}
+				break
+			}
+			// repeated messages
+			for j := 0; j < f.Len(); j++ {
+				b = protowire.AppendTag(b, n, protowire.BytesType)
+				b = protowire.AppendBytes(b, marshal(nil, f.Index(j).Elem()))
+			}
+		}
+	}
+	return b
+}
+
+var errWireType = errors.New("fsyncd: wrong wire type")
+
+func unmarshal(b []byte, v reflect.Value) error {
+	t := v.Type()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var f reflect.Value
+		for i := 0; i < t.NumField(); i++ {
+			if fieldNum(t, i) == num {
+				f = v.Field(i)
+				break
+			}
+		}
+		if !f.IsValid() {
+			// unknown field
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		switch typ {
+		case protowire.VarintType:
+			x, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch f.Kind() {
+			case reflect.Bool:
+				f.SetBool(x != 0)
+			case reflect.Uint32:
+				f.SetUint(uint64(uint32(x)))
+			case reflect.Int64:
+				f.SetInt(int64(x))
+			default:
+				return errWireType
+			}
+		case protowire.BytesType:
+			x, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch {
+			case f.Kind() == reflect.String:
+				f.SetString(string(x))
+			case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.Uint8:
+				f.SetBytes(append([]byte(nil), x...))
+			case f.Kind() == reflect.Slice:
+				e := reflect.New(f.Type().Elem().Elem())
+				if err := unmarshal(x, e.Elem()); err != nil {
+					return err
+				}
+				f.Set(reflect.Append(f, e))
+			default:
+				return errWireType
+			}
+		default:
+			return errWireType
+		}
+	}
+	return nil
+}
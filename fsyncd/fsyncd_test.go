@@ -0,0 +1,94 @@
+package fsyncd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mostafah/fsync"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestSync(t *testing.T) {
+	src, err := ioutil.TempDir(os.TempDir(), "fsyncd_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	root, err := ioutil.TempDir(os.TempDir(), "fsyncd_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := os.MkdirAll(filepath.Join(src, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "a/b"), []byte("file b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "c"), make([]byte, 3*chunkSize+1), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(root, "secret")
+	go srv.Serve(ln)
+	defer srv.Stop()
+
+	// a wrong token is refused
+	fs, err := Dial(ln.Addr().String(), "wrong", insecure.NewCredentials())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expecting Unauthenticated, got %v", err)
+	}
+	fs.Close()
+
+	s := fsync.NewSyncer()
+	s.Delete = true
+	dst := "fsyncd://secret@" + ln.Addr().String() + "/site?insecure"
+	if err := s.Sync(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	for name, size := range map[string]int{"a/b": 6, "c": 3*chunkSize + 1} {
+		fi, err := os.Stat(filepath.Join(root, "site", name))
+		if err != nil {
+			t.Error(err)
+		} else if fi.Size() != int64(size) {
+			t.Errorf("%s has %d bytes, should have %d", name, fi.Size(), size)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(src, "a/b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Sync(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "site/a/b")); !os.IsNotExist(err) {
+		t.Errorf("a/b should be deleted, got %v", err)
+	}
+
+	// files are compared by checksum and can be read back
+	v, err := s.Verify(dst, src, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Mismatches) > 0 {
+		t.Errorf("mismatches: %v", v.Mismatches)
+	}
+	if err := fsync.Sync(filepath.Join(src, "copy"), dst); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(filepath.Join(src, "copy/c")); err != nil || fi.Size() != 3*chunkSize+1 {
+		t.Errorf("c wasn't read back: %v", err)
+	}
+}
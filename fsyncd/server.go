@@ -0,0 +1,292 @@
+package fsyncd
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// NewServer returns a gRPC server that serves the directory root to clients
+// that send token. opts are passed to grpc.NewServer; they should include
+// grpc.Creds for TLS.
+func NewServer(root, token string, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := authorize(ctx, token); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := authorize(ss.Context(), token); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}))
+	s := grpc.NewServer(opts...)
+	Register(s, root)
+	return s
+}
+
+// Register adds the service serving the directory root to s, which is then
+// responsible for authenticating clients.
+func Register(s *grpc.Server, root string) {
+	s.RegisterService(&serviceDesc, &service{root: root})
+}
+
+// authorize checks the token sent with a call.
+func authorize(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if subtle.ConstantTimeCompare([]byte(v), []byte("Bearer "+token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "fsyncd: invalid token")
+}
+
+// service implements the calls on the local directory root.
+type service struct {
+	root string
+}
+
+// path converts a path from a client to a local one. Paths can't leave the
+// root.
+func (s *service) path(p string) string {
+	return filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+p)))
+}
+
+// statusError converts err to an error with a gRPC status code.
+func statusError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err // nil or already converted
+	}
+	switch {
+	case os.IsNotExist(err):
+		return status.Error(codes.NotFound, err.Error())
+	case os.IsExist(err):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case os.IsPermission(err):
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+func newFileInfo(fi os.FileInfo) *fileInfo {
+	return &fileInfo{
+		Name:  fi.Name(),
+		Size:  fi.Size(),
+		Mode:  uint32(fi.Mode().Perm()),
+		Mtime: fi.ModTime().UnixNano(),
+		Dir:   fi.IsDir(),
+	}
+}
+
+func (s *service) statFile(req *pathRequest) (*fileInfo, error) {
+	fi, err := os.Stat(s.path(req.Path))
+	if err != nil {
+		return nil, err
+	}
+	return newFileInfo(fi), nil
+}
+
+func (s *service) listDir(req *pathRequest) (*listDirResponse, error) {
+	infos, err := ioutil.ReadDir(s.path(req.Path))
+	if err != nil {
+		return nil, err
+	}
+	resp := &listDirResponse{}
+	for _, fi := range infos {
+		resp.Files = append(resp.Files, newFileInfo(fi))
+	}
+	return resp, nil
+}
+
+func (s *service) hashFile(req *pathRequest) (*hashFileResponse, error) {
+	f, err := os.Open(s.path(req.Path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return &hashFileResponse{Sha256: h.Sum(nil)}, nil
+}
+
+func (s *service) readFile(req *pathRequest, stream grpc.ServerStream) error {
+	f, err := os.Open(s.path(req.Path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if err := stream.SendMsg(&chunk{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// writeChunk writes the chunks to a temporary file next to the target, and
+// renames it when the stream ends.
+func (s *service) writeChunk(stream grpc.ServerStream) error {
+	var c chunk
+	if err := stream.RecvMsg(&c); err != nil {
+		return err
+	}
+	if c.Path == "" {
+		return status.Error(codes.InvalidArgument, "fsyncd: no path in first chunk")
+	}
+	name := s.path(c.Path)
+	f, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // fails after the rename
+	defer f.Close()
+	for {
+		if _, err := f.Write(c.Data); err != nil {
+			return err
+		}
+		c = chunk{}
+		if err := stream.RecvMsg(&c); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	if err := f.Chmod(0644); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		return err
+	}
+	return stream.SendMsg(&empty{})
+}
+
+func (s *service) mkdir(req *pathRequest) (*empty, error) {
+	return &empty{}, os.MkdirAll(s.path(req.Path), os.FileMode(req.Mode).Perm())
+}
+
+func (s *service) delete(req *deleteRequest) (*empty, error) {
+	if strings.Trim(path.Clean("/"+req.Path), "/") == "" {
+		return nil, status.Error(codes.InvalidArgument, "fsyncd: can't delete the root")
+	}
+	if req.Recursive {
+		return &empty{}, os.RemoveAll(s.path(req.Path))
+	}
+	return &empty{}, os.Remove(s.path(req.Path))
+}
+
+func (s *service) rename(req *renameRequest) (*empty, error) {
+	return &empty{}, os.Rename(s.path(req.OldPath), s.path(req.NewPath))
+}
+
+func (s *service) setAttr(req *setAttrRequest) (*empty, error) {
+	name := s.path(req.Path)
+	if req.Mode != 0 {
+		if err := os.Chmod(name, os.FileMode(req.Mode).Perm()); err != nil {
+			return nil, err
+		}
+	}
+	if req.Mtime != 0 {
+		t := time.Unix(0, req.Mtime)
+		if err := os.Chtimes(name, t, t); err != nil {
+			return nil, err
+		}
+	}
+	return &empty{}, nil
+}
+
+// unary returns the description of a unary method that decodes a request
+// with newReq and calls call with it.
+func unary(name string, newReq func() interface{}, call func(s *service, req interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := newReq()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				resp, err := call(srv.(*service), req)
+				if err != nil {
+					return nil, statusError(err)
+				}
+				return resp, nil
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/" + name}
+			return interceptor(ctx, req, info, handler)
+		},
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		unary("StatFile", func() interface{} { return &pathRequest{} },
+			func(s *service, req interface{}) (interface{}, error) { return s.statFile(req.(*pathRequest)) }),
+		unary("ListDir", func() interface{} { return &pathRequest{} },
+			func(s *service, req interface{}) (interface{}, error) { return s.listDir(req.(*pathRequest)) }),
+		unary("HashFile", func() interface{} { return &pathRequest{} },
+			func(s *service, req interface{}) (interface{}, error) { return s.hashFile(req.(*pathRequest)) }),
+		unary("Mkdir", func() interface{} { return &pathRequest{} },
+			func(s *service, req interface{}) (interface{}, error) { return s.mkdir(req.(*pathRequest)) }),
+		unary("Delete", func() interface{} { return &deleteRequest{} },
+			func(s *service, req interface{}) (interface{}, error) { return s.delete(req.(*deleteRequest)) }),
+		unary("Rename", func() interface{} { return &renameRequest{} },
+			func(s *service, req interface{}) (interface{}, error) { return s.rename(req.(*renameRequest)) }),
+		unary("SetAttr", func() interface{} { return &setAttrRequest{} },
+			func(s *service, req interface{}) (interface{}, error) { return s.setAttr(req.(*setAttrRequest)) }),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "ReadFile",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				var req pathRequest
+				if err := stream.RecvMsg(&req); err != nil {
+					return err
+				}
+				return statusError(srv.(*service).readFile(&req, stream))
+			},
+			ServerStreams: true,
+		},
+		{
+			StreamName: "WriteChunk",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return statusError(srv.(*service).writeChunk(stream))
+			},
+			ClientStreams: true,
+		},
+	},
+	Metadata: "fsyncd.proto",
+}
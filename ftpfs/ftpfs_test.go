@@ -0,0 +1,314 @@
+package ftpfs
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mostafah/fsync"
+)
+
+// fakeFTP serves the files in root to one client at a time, with the few
+// commands FS uses and passive data connections.
+type fakeFTP struct {
+	root string
+	l    net.Listener
+}
+
+func newFakeFTP(t *testing.T, root string) *fakeFTP {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeFTP{root: root, l: l}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *fakeFTP) Close() error {
+	return s.l.Close()
+}
+
+// session is the state of a control connection.
+type session struct {
+	s    *fakeFTP
+	c    *textproto.Conn
+	cwd  string
+	data net.Listener // for the next data command
+	from string       // RNFR argument
+}
+
+func (s *fakeFTP) serve(c net.Conn) {
+	ss := &session{s: s, c: textproto.NewConn(c), cwd: "/"}
+	defer ss.c.Close()
+	ss.c.PrintfLine("220 fake FTP ready")
+	for {
+		line, err := ss.c.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			cmd, arg = line[:i], line[i+1:]
+		}
+		if cmd == "QUIT" {
+			ss.c.PrintfLine("221 bye")
+			return
+		}
+		ss.handle(strings.ToUpper(cmd), arg)
+	}
+}
+
+// local returns the file on disk for the FTP path p.
+func (ss *session) local(p string) string {
+	if !path.IsAbs(p) {
+		p = path.Join(ss.cwd, p)
+	}
+	return filepath.Join(ss.s.root, filepath.FromSlash(path.Clean(p)))
+}
+
+func (ss *session) handle(cmd, arg string) {
+	reply := func(code int, err error, format string, args ...interface{}) {
+		if err != nil {
+			ss.c.PrintfLine("550 %v", err)
+			return
+		}
+		ss.c.PrintfLine("%d "+format, append([]interface{}{code}, args...)...)
+	}
+	switch cmd {
+	case "USER":
+		reply(331, nil, "password please")
+	case "PASS":
+		reply(230, nil, "logged in")
+	case "FEAT":
+		ss.c.PrintfLine("211-Features:\r\n MLST type*;size*;modify*;\r\n MFMT\r\n211 End")
+	case "TYPE", "OPTS":
+		reply(200, nil, "ok")
+	case "EPSV":
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			reply(0, err, "")
+			return
+		}
+		ss.data = l
+		reply(229, nil, "Entering Extended Passive Mode (|||%d|)", l.Addr().(*net.TCPAddr).Port)
+	case "MLSD", "RETR", "STOR":
+		ss.transfer(cmd, arg)
+	case "MLST":
+		fi, err := os.Stat(ss.local(arg))
+		if err != nil {
+			reply(0, err, "")
+			return
+		}
+		ss.c.PrintfLine("250-Listing %s\r\n %s\r\n250 End", arg, fact(fi, arg))
+	case "MKD":
+		reply(257, os.Mkdir(ss.local(arg), 0755), "%q created", arg)
+	case "RMD":
+		reply(250, os.Remove(ss.local(arg)), "removed")
+	case "DELE":
+		reply(250, os.Remove(ss.local(arg)), "deleted")
+	case "RNFR":
+		_, err := os.Stat(ss.local(arg))
+		ss.from = arg
+		reply(350, err, "ready for RNTO")
+	case "RNTO":
+		reply(250, os.Rename(ss.local(ss.from), ss.local(arg)), "renamed")
+	case "MFMT":
+		f := strings.SplitN(arg, " ", 2)
+		t, err := time.Parse("20060102150405", f[0])
+		if err == nil {
+			err = os.Chtimes(ss.local(f[1]), t, t)
+		}
+		reply(213, err, "Modify=%s; %s", f[0], f[1])
+	case "CWD":
+		fi, err := os.Stat(ss.local(arg))
+		if err == nil && !fi.IsDir() {
+			err = fmt.Errorf("%s isn't a directory", arg)
+		}
+		if err == nil {
+			ss.cwd = path.Join(ss.cwd, arg)
+			if path.IsAbs(arg) {
+				ss.cwd = path.Clean(arg)
+			}
+		}
+		reply(250, err, "ok")
+	case "CDUP":
+		ss.cwd = path.Dir(ss.cwd)
+		reply(250, nil, "ok")
+	case "PWD":
+		reply(257, nil, "%q", ss.cwd)
+	default:
+		reply(502, nil, "%s not implemented", cmd)
+	}
+}
+
+// transfer runs a command that uses the data connection.
+func (ss *session) transfer(cmd, arg string) {
+	if ss.data == nil {
+		ss.c.PrintfLine("425 no data connection")
+		return
+	}
+	defer func() {
+		ss.data.Close()
+		ss.data = nil
+	}()
+	name := ss.local(arg)
+	var f *os.File
+	var err error
+	switch cmd {
+	case "RETR":
+		f, err = os.Open(name)
+	case "STOR":
+		f, err = os.Create(name)
+	case "MLSD":
+		f, err = os.Open(name)
+	}
+	if err != nil {
+		ss.c.PrintfLine("550 %v", err)
+		return
+	}
+	defer f.Close()
+	conn, err := ss.data.Accept()
+	if err != nil {
+		ss.c.PrintfLine("425 %v", err)
+		return
+	}
+	ss.c.PrintfLine("150 opening data connection")
+	switch cmd {
+	case "RETR":
+		_, err = io.Copy(conn, f)
+	case "STOR":
+		_, err = io.Copy(f, conn)
+	case "MLSD":
+		var fis []os.FileInfo
+		if fis, err = f.Readdir(-1); err == nil {
+			for _, fi := range fis {
+				fmt.Fprintf(conn, "%s\r\n", fact(fi, fi.Name()))
+			}
+		}
+	}
+	conn.Close()
+	if err != nil {
+		ss.c.PrintfLine("451 %v", err)
+		return
+	}
+	ss.c.PrintfLine("226 transfer complete")
+}
+
+// fact returns the MLST facts line of fi.
+func fact(fi os.FileInfo, name string) string {
+	typ := "file"
+	if fi.IsDir() {
+		typ = "dir"
+	}
+	return fmt.Sprintf("Type=%s;Size=%d;Modify=%s; %s", typ, fi.Size(),
+		fi.ModTime().UTC().Format("20060102150405"), name)
+}
+
+func TestSync(t *testing.T) {
+	src, err := ioutil.TempDir(os.TempDir(), "ftpfs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	root, err := ioutil.TempDir(os.TempDir(), "ftpfs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := os.MkdirAll(filepath.Join(src, "a/b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "a/b/c"), []byte("file c"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "d"), []byte("file d"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(filepath.Join(src, "d"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newFakeFTP(t, root)
+	defer srv.Close()
+	u := &url.URL{Scheme: "ftp", User: url.UserPassword("user", "pass"),
+		Host: srv.l.Addr().String()}
+	fs, err := Open(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.(*FS).Close()
+
+	s := fsync.NewSyncer()
+	s.DstFS = fs
+	s.Delete = true
+	if err := s.Sync("/site", src); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"site/a/b/c": "file c", "site/d": "file d"} {
+		b, err := ioutil.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != content {
+			t.Errorf("%s is %q, should be %q", name, b, content)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(root, "site/d")); err != nil {
+		t.Fatal(err)
+	} else if !fi.ModTime().Equal(mtime) {
+		t.Errorf("site/d was modified at %v, should be %v", fi.ModTime(), mtime)
+	}
+
+	// files are read back, and stat'ed with MLST
+	r, err := fs.Open("/site/d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "file d" {
+		t.Errorf("read %q from site/d, should be %q", b, "file d")
+	}
+	if fi, err := fs.Stat("/site/a"); err != nil || !fi.IsDir() {
+		t.Errorf("expecting site/a to be a directory, got %v, %v", fi, err)
+	}
+	if _, err := fs.Stat("/site/e"); !os.IsNotExist(err) {
+		t.Errorf("expecting site/e not to exist, got %v", err)
+	}
+
+	// a second sync leaves unchanged files alone and deletes whole trees
+	if err := os.RemoveAll(filepath.Join(src, "a")); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := s.SyncStats("/site", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "site/a")); !os.IsNotExist(err) {
+		t.Errorf("site/a should be deleted, got %v", err)
+	}
+	if stats.Files != 0 || stats.Unchanged != 1 {
+		t.Errorf("expecting site/d to be left unchanged, got %+v", stats)
+	}
+}
@@ -0,0 +1,14 @@
+// Package fusefs mounts synced trees read-only with FUSE, so that snapshots
+// and other destinations can be browsed, and single files restored, with
+// standard tools:
+//
+//	fsys, err := fsync.SnapshotFS("/backup", "2024-06-01T12:00:00")
+//	...
+//	err = fusefs.Mount(ctx, "/mnt/backup", fsys)
+//
+// Any io/fs.FS can be mounted, including fsync.IOFS views of remote
+// backends. The package needs the FUSE libraries of the system, so it's only
+// built with the fuse build tag:
+//
+//	go build -tags fuse
+package fusefs
@@ -0,0 +1,202 @@
+//go:build fuse && (linux || darwin)
+// +build fuse
+// +build linux darwin
+
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"path"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Mount mounts fsys read-only at dir and serves it until ctx is done or the
+// file system is unmounted by other means.
+func Mount(ctx context.Context, dir string, fsys iofs.FS) error {
+	root := &node{fsys: fsys, path: "."}
+	server, err := fs.Mount(dir, root, &fs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName:  "fsync",
+			Name:    "fsync",
+			Options: []string{"ro"},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		server.Wait()
+		close(done)
+	}()
+	select {
+	case <-ctx.Done():
+		if err := server.Unmount(); err != nil {
+			return err
+		}
+		<-done
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
+
+// node is a file or directory in the mounted file system.
+type node struct {
+	fs.Inode
+	fsys iofs.FS
+	path string
+}
+
+var (
+	_ fs.NodeLookuper  = (*node)(nil)
+	_ fs.NodeGetattrer = (*node)(nil)
+	_ fs.NodeReaddirer = (*node)(nil)
+	_ fs.NodeOpener    = (*node)(nil)
+	_ fs.FileReader    = (*handle)(nil)
+	_ fs.FileReleaser  = (*handle)(nil)
+)
+
+func (n *node) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	p := path.Join(n.path, name)
+	info, err := iofs.Stat(n.fsys, p)
+	if err != nil {
+		return nil, errno(err)
+	}
+	setAttr(&out.Attr, info)
+	child := &node{fsys: n.fsys, path: p}
+	return n.NewInode(ctx, child, fs.StableAttr{Mode: mode(info)}), 0
+}
+
+func (n *node) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	info, err := iofs.Stat(n.fsys, n.path)
+	if err != nil {
+		return errno(err)
+	}
+	setAttr(&out.Attr, info)
+	return 0
+}
+
+func (n *node) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	entries, err := iofs.ReadDir(n.fsys, n.path)
+	if err != nil {
+		return nil, errno(err)
+	}
+	list := make([]fuse.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		m := uint32(syscall.S_IFREG)
+		if e.IsDir() {
+			m = syscall.S_IFDIR
+		}
+		list = append(list, fuse.DirEntry{Name: e.Name(), Mode: m})
+	}
+	return fs.NewListDirStream(list), 0
+}
+
+func (n *node) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	f, err := n.fsys.Open(n.path)
+	if err != nil {
+		return nil, 0, errno(err)
+	}
+	return &handle{fsys: n.fsys, path: n.path, f: f}, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+// handle is an open file. Reads at arbitrary offsets use ReadAt or Seek when
+// the file supports them; otherwise the file is read sequentially and
+// reopened when a read goes backwards.
+type handle struct {
+	fsys iofs.FS
+	path string
+
+	mu  sync.Mutex
+	f   iofs.File
+	pos int64 // offset of f for sequential reads
+}
+
+func (h *handle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if ra, ok := h.f.(io.ReaderAt); ok {
+		n, err := ra.ReadAt(dest, off)
+		if err != nil && err != io.EOF {
+			return nil, errno(err)
+		}
+		return fuse.ReadResultData(dest[:n]), 0
+	}
+
+	if s, ok := h.f.(io.Seeker); ok {
+		if _, err := s.Seek(off, io.SeekStart); err != nil {
+			return nil, errno(err)
+		}
+		h.pos = off
+	} else if off < h.pos {
+		h.f.Close()
+		f, err := h.fsys.Open(h.path)
+		if err != nil {
+			return nil, errno(err)
+		}
+		h.f, h.pos = f, 0
+	}
+	if off > h.pos {
+		n, err := io.CopyN(io.Discard, h.f, off-h.pos)
+		h.pos += n
+		if err == io.EOF {
+			return fuse.ReadResultData(nil), 0
+		} else if err != nil {
+			return nil, errno(err)
+		}
+	}
+	n, err := io.ReadFull(h.f, dest)
+	h.pos += int64(n)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, errno(err)
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+func (h *handle) Release(ctx context.Context) syscall.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return errno(h.f.Close())
+}
+
+// mode returns the file type bits of info for FUSE.
+func mode(info iofs.FileInfo) uint32 {
+	if info.IsDir() {
+		return syscall.S_IFDIR
+	}
+	return syscall.S_IFREG
+}
+
+// setAttr fills out with the attributes in info.
+func setAttr(out *fuse.Attr, info iofs.FileInfo) {
+	out.Mode = mode(info) | uint32(info.Mode().Perm())
+	out.Size = uint64(info.Size())
+	out.Blocks = (out.Size + 511) / 512
+	t := info.ModTime()
+	out.SetTimes(&t, &t, &t)
+}
+
+// errno converts err to an error number for FUSE.
+func errno(err error) syscall.Errno {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, iofs.ErrNotExist):
+		return syscall.ENOENT
+	case errors.Is(err, iofs.ErrPermission):
+		return syscall.EACCES
+	}
+	return fs.ToErrno(err)
+}
@@ -0,0 +1,130 @@
+//go:build fuse && (linux || darwin)
+// +build fuse
+// +build linux darwin
+
+package fusefs
+
+import (
+	"context"
+	iofs "io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// seqFS hides ReadAt and Seek of the files of a MapFS and counts opens.
+type seqFS struct {
+	fstest.MapFS
+	opens int
+}
+
+func (s *seqFS) Open(name string) (iofs.File, error) {
+	f, err := s.MapFS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	s.opens++
+	return struct{ iofs.File }{f}, nil
+}
+
+func TestReadSequential(t *testing.T) {
+	fsys := &seqFS{MapFS: fstest.MapFS{"a": {Data: []byte("0123456789")}}}
+	f, err := fsys.Open("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &handle{fsys: fsys, path: "a", f: f}
+	read := func(off int64, want string) {
+		res, errno := h.Read(context.Background(), make([]byte, 3), off)
+		if errno != 0 {
+			t.Fatalf("reading at %d: %v", off, errno)
+		}
+		b, _ := res.Bytes(nil)
+		if string(b) != want {
+			t.Errorf("read %q at %d, should be %q", b, off, want)
+		}
+	}
+	read(0, "012")
+	read(5, "567") // skips forward
+	if fsys.opens != 1 {
+		t.Errorf("file opened %d times reading forward, should be 1", fsys.opens)
+	}
+	read(1, "123") // reopens
+	if fsys.opens != 2 {
+		t.Errorf("file opened %d times reading backwards, should be 2", fsys.opens)
+	}
+	read(9, "9")
+	read(20, "")
+	if errno := h.Release(context.Background()); errno != 0 {
+		t.Errorf("release: %v", errno)
+	}
+}
+
+func TestMount(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fusefs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fsys := fstest.MapFS{
+		"a/b": {Data: []byte("file b"), Mode: 0644},
+		"c":   {Data: []byte("file c"), Mode: 0600},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- Mount(ctx, dir, fsys) }()
+
+	// wait for the mount, or skip if FUSE isn't usable here
+	for i := 0; ; i++ {
+		select {
+		case err := <-errc:
+			t.Skipf("can't mount: %v", err)
+		default:
+		}
+		if _, err := os.Stat(filepath.Join(dir, "c")); err == nil {
+			break
+		} else if i == 100 {
+			t.Fatalf("mount didn't come up: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "a/b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "file b" {
+		t.Errorf("read %q from a/b, should be %q", b, "file b")
+	}
+	fi, err := os.Stat(filepath.Join(dir, "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 || fi.Size() != 6 {
+		t.Errorf("c has mode %v and size %d, should be %v and 6",
+			fi.Mode().Perm(), fi.Size(), os.FileMode(0600))
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name() != "a" || !entries[0].IsDir() ||
+		entries[1].Name() != "c" {
+		t.Errorf("unexpected entries in the root: %v", entries)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "c"), []byte("x"), 0600); err == nil {
+		t.Errorf("expecting writes to fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "d")); !os.IsNotExist(err) {
+		t.Errorf("expecting d not to exist, got %v", err)
+	}
+
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("expecting Mount to return context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+// Package gcsfs provides a backend for fsync that stores files in a Google
+// Cloud Storage bucket.
+//
+// Importing the package registers the "gs" URL scheme. The host of the URL is
+// the bucket and its path is the prefix to sync to:
+//
+//	import _ "github.com/mostafah/fsync/gcsfs"
+//
+//	err := fsync.Sync("gs://my-bucket/site", "build")
+//
+// Clients opened from URLs use Application Default Credentials.
+//
+// Files are compared by size and CRC32C, so unchanged files are never
+// downloaded. Writes and deletes are conditional on the generation of the
+// object seen while comparing: if another process changes an object in the
+// meantime, the sync fails with ErrConflict instead of clobbering its work.
+package gcsfs
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/mostafah/fsync"
+	"github.com/mostafah/fsync/internal/objfs"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+var (
+	ErrConflict = errors.New(
+		"gcsfs: object was changed by someone else during the sync")
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+func init() {
+	fsync.Register("gs", Open)
+}
+
+// New returns an FS that stores files in bucket using c. Names are used as
+// object names, without the leading slash. Closing the FS does not close c.
+func New(c *storage.Client, bucket string) fsync.FS {
+	return objfs.New(newStore(c, bucket, false))
+}
+
+// Open returns an FS for the bucket in u. The path of u is not used. The FS
+// owns the client and closes it when closed.
+func Open(u *url.URL) (fsync.FS, error) {
+	c, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return objfs.New(newStore(c, u.Host, true)), nil
+}
+
+// store implements objfs.Store for a bucket.
+type store struct {
+	c      *storage.Client
+	b      *storage.BucketHandle
+	closer bool // whether to close c on Close
+
+	mu   sync.Mutex
+	gens map[string]int64 // last seen generations; 0 for missing objects
+}
+
+func newStore(c *storage.Client, bucket string, closer bool) *store {
+	return &store{
+		c:      c,
+		b:      c.Bucket(bucket),
+		closer: closer,
+		gens:   make(map[string]int64),
+	}
+}
+
+func (s *store) Close() error {
+	if s.closer {
+		return s.c.Close()
+	}
+	return nil
+}
+
+// seen records gen as the last known generation of key.
+func (s *store) seen(key string, gen int64) {
+	s.mu.Lock()
+	s.gens[key] = gen
+	s.mu.Unlock()
+}
+
+// object returns a handle for key that only succeeds if the object is still
+// at the generation last seen, if any.
+func (s *store) object(key string) *storage.ObjectHandle {
+	o := s.b.Object(key)
+	s.mu.Lock()
+	gen, ok := s.gens[key]
+	s.mu.Unlock()
+	switch {
+	case !ok:
+		return o
+	case gen == 0:
+		return o.If(storage.Conditions{DoesNotExist: true})
+	default:
+		return o.If(storage.Conditions{GenerationMatch: gen})
+	}
+}
+
+func (s *store) Stat(key string) (objfs.Object, error) {
+	attrs, err := s.b.Object(key).Attrs(context.Background())
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		s.seen(key, 0)
+		return objfs.Object{}, os.ErrNotExist
+	} else if err != nil {
+		return objfs.Object{}, err
+	}
+	s.seen(key, attrs.Generation)
+	return object(attrs), nil
+}
+
+func (s *store) List(prefix string, recursive bool) ([]objfs.Object, error) {
+	q := &storage.Query{Prefix: prefix}
+	if !recursive {
+		q.Delimiter = "/"
+	}
+	var objs []objfs.Object
+	it := s.b.Objects(context.Background(), q)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if attrs.Prefix != "" {
+			objs = append(objs, objfs.Object{Key: attrs.Prefix})
+			continue
+		}
+		s.seen(attrs.Name, attrs.Generation)
+		objs = append(objs, object(attrs))
+	}
+	return objs, nil
+}
+
+func (s *store) Get(key string) (io.ReadCloser, error) {
+	r, err := s.b.Object(key).NewReader(context.Background())
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, os.ErrNotExist
+	}
+	return r, err
+}
+
+func (s *store) Put(key string, r io.Reader, size int64, sum []byte) error {
+	w := s.object(key).NewWriter(context.Background())
+	w.CRC32C = binary.BigEndian.Uint32(sum)
+	w.SendCRC32C = true
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return conflict(err)
+	}
+	if err := w.Close(); err != nil {
+		return conflict(err)
+	}
+	s.seen(key, w.Attrs().Generation)
+	return nil
+}
+
+func (s *store) Delete(key string) error {
+	err := s.object(key).Delete(context.Background())
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		err = nil
+	}
+	if err == nil {
+		s.seen(key, 0)
+	}
+	return conflict(err)
+}
+
+func (s *store) Copy(dst, src string) error {
+	attrs, err := s.object(dst).CopierFrom(s.b.Object(src)).
+		Run(context.Background())
+	if err != nil {
+		return conflict(err)
+	}
+	s.seen(dst, attrs.Generation)
+	return nil
+}
+
+func (s *store) NewHash() hash.Hash {
+	return crc32.New(crc32c)
+}
+
+// object converts object attributes from GCS.
+func object(attrs *storage.ObjectAttrs) objfs.Object {
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, attrs.CRC32C)
+	return objfs.Object{
+		Key:     attrs.Name,
+		Size:    attrs.Size,
+		ModTime: attrs.Updated,
+		Sum:     sum,
+	}
+}
+
+// conflict converts failed preconditions to ErrConflict.
+func conflict(err error) error {
+	var e *googleapi.Error
+	if errors.As(err, &e) && e.Code == http.StatusPreconditionFailed {
+		return ErrConflict
+	}
+	return err
+}
@@ -0,0 +1,312 @@
+package gcsfs
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/mostafah/fsync"
+	"google.golang.org/api/option"
+)
+
+// fakeObject is an object of fakeGCS.
+type fakeObject struct {
+	data []byte
+	gen  int64
+	time time.Time
+}
+
+// fakeGCS serves the few JSON and XML API requests store makes, for a single
+// bucket and with no authentication.
+type fakeGCS struct {
+	mu      sync.Mutex
+	bucket  string
+	objects map[string]*fakeObject
+	gen     int64 // last generation given out
+}
+
+func (s *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var parts []string
+	for _, p := range strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/") {
+		p, _ = url.PathUnescape(p)
+		parts = append(parts, p)
+	}
+	switch {
+	case len(parts) == 6 && parts[0] == "upload" && r.Method == "POST":
+		s.insert(w, r)
+	case len(parts) == 5 && parts[0] == "storage" && r.Method == "GET":
+		s.list(w, r)
+	case len(parts) == 6 && parts[0] == "storage":
+		obj := s.objects[parts[5]]
+		if obj == nil {
+			s.error(w, http.StatusNotFound)
+		} else if !s.match(r, parts[5]) {
+			s.error(w, http.StatusPreconditionFailed)
+		} else if r.Method == "DELETE" {
+			delete(s.objects, parts[5])
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			json.NewEncoder(w).Encode(s.attrs(parts[5], obj))
+		}
+	case len(parts) == 11 && parts[6] == "rewriteTo":
+		src, dst := s.objects[parts[5]], parts[10]
+		if src == nil {
+			s.error(w, http.StatusNotFound)
+			return
+		} else if !s.match(r, dst) {
+			s.error(w, http.StatusPreconditionFailed)
+			return
+		}
+		obj := s.put(dst, src.data)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"kind":                "storage#rewriteResponse",
+			"done":                true,
+			"objectSize":          fmt.Sprint(len(obj.data)),
+			"totalBytesRewritten": fmt.Sprint(len(obj.data)),
+			"resource":            s.attrs(dst, obj),
+		})
+	case len(parts) == 2 && r.Method == "GET":
+		obj := s.objects[parts[1]]
+		if obj == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(obj.data)))
+		w.Header().Set("X-Goog-Generation", fmt.Sprint(obj.gen))
+		w.Write(obj.data)
+	default:
+		s.error(w, http.StatusNotImplemented)
+	}
+}
+
+// match reports whether the generation preconditions of r hold for name.
+func (s *fakeGCS) match(r *http.Request, name string) bool {
+	v := r.URL.Query().Get("ifGenerationMatch")
+	if v == "" {
+		return true
+	}
+	want, _ := strconv.ParseInt(v, 10, 64)
+	var gen int64
+	if obj := s.objects[name]; obj != nil {
+		gen = obj.gen
+	}
+	return gen == want
+}
+
+func (s *fakeGCS) put(name string, data []byte) *fakeObject {
+	s.gen++
+	obj := &fakeObject{data: data, gen: s.gen, time: time.Now()}
+	s.objects[name] = obj
+	return obj
+}
+
+// insert answers multipart uploads.
+func (s *fakeGCS) insert(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		s.error(w, http.StatusBadRequest)
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	var attrs struct{ Name string }
+	p, err := mr.NextPart()
+	if err == nil {
+		err = json.NewDecoder(p).Decode(&attrs)
+	}
+	if err == nil {
+		p, err = mr.NextPart()
+	}
+	var data []byte
+	if err == nil {
+		data, err = ioutil.ReadAll(p)
+	}
+	if err != nil {
+		s.error(w, http.StatusBadRequest)
+		return
+	}
+	if !s.match(r, attrs.Name) {
+		s.error(w, http.StatusPreconditionFailed)
+		return
+	}
+	json.NewEncoder(w).Encode(s.attrs(attrs.Name, s.put(attrs.Name, data)))
+}
+
+// list answers object listings.
+func (s *fakeGCS) list(w http.ResponseWriter, r *http.Request) {
+	pre, delim := r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter")
+	var names []string
+	for name := range s.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	res := struct {
+		Items    []map[string]interface{} `json:"items"`
+		Prefixes []string                 `json:"prefixes"`
+	}{}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if !strings.HasPrefix(name, pre) {
+			continue
+		}
+		if i := strings.Index(name[len(pre):], delim); delim != "" && i >= 0 {
+			p := name[:len(pre)+i+1]
+			if !seen[p] {
+				seen[p] = true
+				res.Prefixes = append(res.Prefixes, p)
+			}
+			continue
+		}
+		res.Items = append(res.Items, s.attrs(name, s.objects[name]))
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// attrs returns the JSON resource of obj.
+func (s *fakeGCS) attrs(name string, obj *fakeObject) map[string]interface{} {
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.Checksum(obj.data, crc32c))
+	return map[string]interface{}{
+		"kind":       "storage#object",
+		"bucket":     s.bucket,
+		"name":       name,
+		"size":       fmt.Sprint(len(obj.data)),
+		"generation": fmt.Sprint(obj.gen),
+		"crc32c":     base64.StdEncoding.EncodeToString(sum),
+		"updated":    obj.time.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+func (s *fakeGCS) error(w http.ResponseWriter, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, `{"error":{"code":%d,"message":%q}}`, code, http.StatusText(code))
+}
+
+func TestSync(t *testing.T) {
+	src, err := ioutil.TempDir(os.TempDir(), "gcsfs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	if err := os.MkdirAll(filepath.Join(src, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "a/b"), []byte("file b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "c"), []byte("file c"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &fakeGCS{bucket: "bucket", objects: make(map[string]*fakeObject)}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	c, err := storage.NewClient(context.Background(),
+		option.WithEndpoint(ts.URL+"/storage/v1/"),
+		option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	fs := New(c, "bucket")
+
+	s := fsync.NewSyncer()
+	s.DstFS = fs
+	s.Delete = true
+	if err := s.Sync("/site", src); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"site/a/b": "file b", "site/c": "file c"} {
+		if obj := srv.objects[name]; obj == nil {
+			t.Errorf("%s wasn't uploaded", name)
+		} else if string(obj.data) != content {
+			t.Errorf("%s is %q, should be %q", name, obj.data, content)
+		}
+	}
+
+	// files are read back and hashed with CRC32C
+	r, err := fs.Open("/site/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "file c" {
+		t.Errorf("read %q from site/c, should be %q", b, "file c")
+	}
+	sum, err := fs.(fsync.Hasher).Hash("/site/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := binary.BigEndian.Uint32(sum), crc32.Checksum(b, crc32c); got != want {
+		t.Errorf("hash of site/c is %08x, should be %08x", got, want)
+	}
+
+	// writes fail if the object changed since it was seen
+	if _, err := fs.Stat("/site/c"); err != nil {
+		t.Fatal(err)
+	}
+	srv.put("site/c", []byte("theirs"))
+	w, err := fs.Create("/site/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "ours")
+	if err := w.Close(); err != ErrConflict {
+		t.Errorf("expecting ErrConflict, got %v", err)
+	}
+	if string(srv.objects["site/c"].data) != "theirs" {
+		t.Errorf("site/c was overwritten with %q", srv.objects["site/c"].data)
+	}
+
+	// renames copy on the server
+	if err := fs.Rename("/site/c", "/site/d"); err != nil {
+		t.Fatal(err)
+	}
+	if obj := srv.objects["site/d"]; obj == nil || string(obj.data) != "theirs" {
+		t.Errorf("site/c wasn't renamed to site/d")
+	}
+	if _, ok := srv.objects["site/c"]; ok {
+		t.Errorf("site/c should be removed after the rename")
+	}
+
+	// a second sync deletes extra files
+	if err := os.Remove(filepath.Join(src, "a/b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Sync("/site", src); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"site/a/b", "site/d"} {
+		if _, ok := srv.objects[name]; ok {
+			t.Errorf("%s should be deleted", name)
+		}
+	}
+	if obj := srv.objects["site/c"]; obj == nil || string(obj.data) != "file c" {
+		t.Errorf("site/c should be copied again")
+	}
+}
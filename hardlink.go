@@ -0,0 +1,57 @@
+package fsync
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// linking returns true if the source file with the file info sstat has
+// other hard links, which PreserveHardlinks makes again in the destination.
+func (r *run) linking(sstat os.FileInfo) bool {
+	if !r.PreserveHardlinks {
+		return false
+	}
+	_, nlink, ok := inode(sstat)
+	return ok && nlink > 1
+}
+
+// link makes the destination name dst a hard link to the destination of
+// the first source file synced that src, with the file info sstat, is a
+// hard link to, and returns true, unless there's none or the destination
+// file system isn't a Linker. dstat is the file info of dst, if it exists.
+func (r *run) link(dst, src string, dstat, sstat os.FileInfo) bool {
+	if !r.linking(sstat) {
+		return false
+	}
+	id, _, _ := inode(sstat)
+	first, ok := r.links[id]
+	if !ok {
+		if r.links == nil {
+			r.links = make(map[fileID]job)
+		}
+		r.links[id] = job{dst, src}
+		return false
+	}
+	linker, ok := r.dfs.(Linker)
+	if !ok {
+		return false
+	}
+	exists := dstat != nil && !dstat.IsDir() // a directory was discarded
+	if exists {
+		if fi, err := r.dfs.Stat(first.dst); err == nil && os.SameFile(fi, dstat) {
+			r.stats.Unchanged++
+			r.emit(Trace, src, Event{Op: OpSkip, Reason: SameContent})
+			return true
+		}
+	}
+	r.hist.changed(filepath.Dir(src))
+	r.stats.Linked++
+	r.emit(Verbose, src, Event{Op: OpLink, From: r.rel(first.src), Item: itemLinked})
+	if !r.DryRun {
+		if exists {
+			r.discard(dst)
+		}
+		check(linker.Link(first.dst, dst))
+	}
+	return true
+}
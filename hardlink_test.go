@@ -0,0 +1,48 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPreserveHardlinks(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "b"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("file a"), 0644))
+	check(os.Link(filepath.Join(src, "a"), filepath.Join(src, "b/a")))
+	check(os.Link(filepath.Join(src, "a"), filepath.Join(src, "c")))
+	linked := func(a, b string) bool {
+		fa, err := os.Stat(filepath.Join(dst, a))
+		check(err)
+		fb, err := os.Stat(filepath.Join(dst, b))
+		check(err)
+		return os.SameFile(fa, fb)
+	}
+
+	// without it, they're copies
+	s := NewSyncer()
+	check(s.Sync(dst, src))
+	if linked("a", "c") {
+		t.Errorf("expecting a and c to be copies")
+	}
+
+	s.PreserveHardlinks = true
+	s.Workers = 2
+	stats, err := s.SyncStats(dst, src)
+	check(err)
+	testFile(filepath.Join(dst, "b/a"), []byte("file a"), t)
+	if !linked("a", "b/a") || !linked("a", "c") || stats.Linked != 2 || stats.Files != 0 {
+		t.Errorf("expecting b/a and c to be linked to a, got %+v", stats)
+	}
+	stats, err = s.SyncStats(dst, src)
+	check(err)
+	if stats.Linked != 0 || stats.Unchanged != 3 {
+		t.Errorf("expecting the links to be left alone, got %+v", stats)
+	}
+}
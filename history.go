@@ -0,0 +1,102 @@
+package fsync
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// historyDecay is how much the changes of each earlier run count compared to
+// the run after it.
+const historyDecay = 0.5
+
+// history keeps track of how often directories in the source change, so that
+// the likeliest to have changed can be synced first. A nil *history does
+// nothing.
+type history struct {
+	path    string
+	scores  map[string]float64 // source directory -> decayed change count
+	changes map[string]int     // changes in this run
+}
+
+// loadHistory reads the history in path. A missing file is an empty history.
+func loadHistory(path string) (*history, error) {
+	h := &history{
+		path:    path,
+		scores:  make(map[string]float64),
+		changes: make(map[string]int),
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &h.scores); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// changed records a change in the source directory dir, which also counts as
+// a change in its ancestors.
+func (h *history) changed(dir string) {
+	if h == nil {
+		return
+	}
+	for {
+		h.changes[dir]++
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return
+		}
+		dir = parent
+	}
+}
+
+// order sorts the entries of the source directory dir so that subdirectories
+// that changed more in earlier runs come first.
+func (h *history) order(dir string, files []os.FileInfo) {
+	if h == nil {
+		return
+	}
+	score := func(fi os.FileInfo) float64 {
+		if !fi.IsDir() {
+			return 0
+		}
+		return h.scores[filepath.Join(dir, fi.Name())]
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return score(files[i]) > score(files[j])
+	})
+}
+
+// save decays the earlier scores, adds the changes of this run and writes the
+// history back to its file.
+func (h *history) save() error {
+	if h == nil {
+		return nil
+	}
+	for dir, s := range h.scores {
+		if s *= historyDecay; s < 0.01 {
+			delete(h.scores, dir)
+		} else {
+			h.scores[dir] = s
+		}
+	}
+	for dir, n := range h.changes {
+		h.scores[dir] += float64(n)
+	}
+	h.changes = make(map[string]int)
+	data, err := json.Marshal(h.scores)
+	if err != nil {
+		return err
+	}
+	tmp := h.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.path)
+}
@@ -0,0 +1,40 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	for _, name := range []string{"a", "b", "c"} {
+		check(os.MkdirAll(filepath.Join(src, name), 0755))
+		check(ioutil.WriteFile(filepath.Join(src, name, "f"), []byte(name), 0644))
+	}
+
+	s := NewSyncer()
+	s.History = filepath.Join(dir, "history")
+	check(s.Sync(dst, src))
+	check(ioutil.WriteFile(filepath.Join(src, "c", "f"), []byte("changed"), 0644))
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "c", "f"), []byte("changed"), t)
+
+	// c changed in both syncs, a and b only in the first
+	h, err := loadHistory(s.History)
+	check(err)
+	files, err := ioutil.ReadDir(src)
+	check(err)
+	h.order(src, files)
+	if files[0].Name() != "c" {
+		t.Errorf("expecting c to be synced first, got %s.\n", files[0].Name())
+	}
+	if a, c := h.scores[filepath.Join(src, "a")], h.scores[filepath.Join(src, "c")]; a != 1 || c != 2 {
+		t.Errorf("expecting scores 1 and 2, got %v and %v.\n", a, c)
+	}
+}
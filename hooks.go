@@ -0,0 +1,15 @@
+package fsync
+
+import "os"
+
+// hook calls f, BeforeCopy or AfterCopy, if set, with the destination and
+// source names and the info of the source file src, and panics with the
+// error it returns.
+func (r *run) hook(f func(dst, src string, fi os.FileInfo) error, dst, src string) {
+	if f == nil {
+		return
+	}
+	fi, err := r.sfs.Stat(src)
+	check(err)
+	check(f(dst, src, fi))
+}
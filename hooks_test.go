@@ -0,0 +1,50 @@
+package fsync
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCopyHooks(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("file a"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "b"), []byte("file b"), 0644))
+
+	var calls []string
+	stop := errors.New("can't stop")
+	s := NewSyncer()
+	s.ContinueOnError = true
+	s.BeforeCopy = func(dst2, src2 string, fi os.FileInfo) error {
+		if fi.Name() == "b" {
+			return stop
+		}
+		testExistence(dst2, false, t)
+		calls = append(calls, "before "+fi.Name())
+		return nil
+	}
+	s.AfterCopy = func(dst2, src2 string, fi os.FileInfo) error {
+		testFile(dst2, []byte("file a"), t)
+		if src2 != filepath.Join(src, "a") || fi.Size() != 6 {
+			t.Errorf("wrong source %s of size %d", src2, fi.Size())
+		}
+		calls = append(calls, "after "+fi.Name())
+		return nil
+	}
+	errs, ok := s.Sync(dst, src).(SyncErrors)
+	if !ok || len(errs) != 1 || errs[0].Path != "b" || errs[0].Err != stop {
+		t.Errorf("expecting b to fail, got %v", errs)
+	}
+	if want := []string{"before a", "after a"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("expecting %v, got %v", want, calls)
+	}
+	testExistence(filepath.Join(dst, "b"), false, t)
+}
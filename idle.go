@@ -0,0 +1,51 @@
+package fsync
+
+import "path/filepath"
+
+// idle returns true if nothing changed in the destination dst and the
+// source src since the last sync, as recorded in StateFile: the same files
+// and directories are in both, with the sizes, modification times and
+// permissions they had then. Only their file infos are read. Files in the
+// destination that aren't recorded aren't looked for, as Delete leaves them
+// alone, and errors are left for the sync to find.
+func (r *run) idle(dst, src string) bool {
+	seen := 0
+	var walk func(dst, src string) bool
+	walk = func(dst, src string) bool {
+		sstat, err := r.sfs.Stat(src)
+		if err != nil {
+			return false
+		}
+		dstat, err := r.dfs.Stat(dst)
+		if err != nil {
+			return false
+		}
+		e := r.state.entry(r.rel(src))
+		if e == nil || e.Dir != sstat.IsDir() || e.Dir != dstat.IsDir() ||
+			e.Mode != sstat.Mode()&permBits || r.permDiffers(dstat, sstat) ||
+			!r.sameTime(e.SrcTime, sstat.ModTime()) || !r.sameTime(e.DstTime, dstat.ModTime()) {
+			return false
+		}
+		seen++
+		if !e.Dir {
+			return e.Size == sstat.Size() && (e.Size == dstat.Size() || r.transformed(src))
+		}
+		files, err := r.sfs.ReadDir(src)
+		if err != nil {
+			return false
+		}
+		for _, fi := range files {
+			src2 := filepath.Join(src, fi.Name())
+			if r.excluded(src2, fi) && !r.descend(src2, fi) {
+				continue
+			}
+			if !walk(filepath.Join(dst, fi.Name()), src2) {
+				return false
+			}
+		}
+		return true
+	}
+	ok := false
+	catch(func() { ok = walk(dst, src) })
+	return ok && seen == r.state.old.len()
+}
@@ -0,0 +1,58 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpToDate(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "a"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a/x"), []byte("file x"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "b"), []byte("file b"), 0644))
+
+	s := NewSyncer()
+	s.StateFile = filepath.Join(dir, "state")
+	s.Delete = true
+	stats, err := s.SyncStats(dst, src)
+	check(err)
+	if stats.UpToDate {
+		t.Error("the first sync can't be up to date")
+	}
+	stats, err = s.SyncStats(dst, src)
+	check(err)
+	if want := (Stats{Unchanged: 2, Seed: stats.Seed, UpToDate: true}); stats != want {
+		t.Errorf("expecting %+v, got %+v", want, stats)
+	}
+
+	// changes on either side are synced
+	for _, change := range []func(){
+		func() { check(os.Chmod(filepath.Join(src, "a/x"), 0600)) },
+		func() { check(os.Remove(filepath.Join(dst, "b"))) },
+		func() { check(os.Remove(filepath.Join(src, "b"))) },
+	} {
+		change()
+		stats, err = s.SyncStats(dst, src)
+		check(err)
+		if stats.UpToDate {
+			t.Errorf("expecting a change to be synced, got %+v", stats)
+		}
+		stats, err = s.SyncStats(dst, src)
+		check(err)
+		if !stats.UpToDate {
+			t.Errorf("expecting nothing to sync, got %+v", stats)
+		}
+	}
+	fi, err := os.Stat(filepath.Join(dst, "a/x"))
+	check(err)
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("expecting a/x to be chmodded, got %v", fi.Mode())
+	}
+	testExistence(filepath.Join(dst, "b"), false, t)
+}
@@ -0,0 +1,17 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package fsync
+
+import "os"
+
+// fileID identifies a file on a device; hard links share the same fileID.
+type fileID struct {
+	dev, ino uint64
+}
+
+// inode returns the identity and link count of the file described by fi. ok
+// is false if the platform doesn't expose them.
+func inode(fi os.FileInfo) (id fileID, nlink uint64, ok bool) {
+	return fileID{}, 0, false
+}
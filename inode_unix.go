@@ -0,0 +1,24 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package fsync
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID identifies a file on a device; hard links share the same fileID.
+type fileID struct {
+	dev, ino uint64
+}
+
+// inode returns the identity and link count of the file described by fi. ok
+// is false if the platform doesn't expose them.
+func inode(fi os.FileInfo) (id fileID, nlink uint64, ok bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, 0, false
+	}
+	return fileID{uint64(st.Dev), uint64(st.Ino)}, uint64(st.Nlink), true
+}
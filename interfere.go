@@ -0,0 +1,92 @@
+package fsync
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+var (
+	ErrInterference = errors.New("fsync: changed by someone else during the sync")
+)
+
+// Interference is what a sync does with a file in the destination that
+// changed after it was compared with its source and before it was written,
+// because something else wrote it meanwhile.
+type Interference int
+
+const (
+	// IgnoreInterference doesn't look for changes.
+	IgnoreInterference Interference = iota
+	// OverwriteInterference writes over the file anyway.
+	OverwriteInterference
+	// SkipInterference leaves the file as it is, to be synced next time.
+	SkipInterference
+	// FailOnInterference fails the sync with ErrInterference.
+	FailOnInterference
+)
+
+// interference holds the destination files a run is to write, as they were
+// when compared with their sources, with Interference.
+type interference struct {
+	mu    sync.Mutex
+	files map[string]os.FileInfo // nil for missing files
+	count int
+}
+
+// compared records dstat, the file info of the destination file dst, nil
+// if it's missing, before dst is written.
+func (r *run) compared(dst string, dstat os.FileInfo) {
+	if r.Interference == IgnoreInterference {
+		return
+	}
+	r.expected.mu.Lock()
+	defer r.expected.mu.Unlock()
+	if r.expected.files == nil {
+		r.expected.files = make(map[string]os.FileInfo)
+	}
+	r.expected.files[dst] = dstat
+}
+
+// interfered returns true if the destination file dst, about to be written
+// from src, is to be left alone because it changed since compared. Changes
+// are reported as OpInterfered events.
+func (r *run) interfered(dst, src string) bool {
+	if r.Interference == IgnoreInterference {
+		return false
+	}
+	r.expected.mu.Lock()
+	then, ok := r.expected.files[dst]
+	delete(r.expected.files, dst)
+	r.expected.mu.Unlock()
+	if !ok {
+		return false
+	}
+	now, err := r.dfs.Stat(dst)
+	if err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
+	if sameInfo(then, now) {
+		return false
+	}
+	r.expected.mu.Lock()
+	r.expected.count++
+	r.expected.mu.Unlock()
+	r.emit(Quiet, src, Event{Op: OpInterfered})
+	switch r.Interference {
+	case SkipInterference:
+		return true
+	case FailOnInterference:
+		panic(&os.PathError{Op: "sync", Path: dst, Err: ErrInterference})
+	}
+	return false
+}
+
+// sameInfo returns true if a and b, which may be nil, describe a file of
+// the same size, mode and modification time.
+func sameInfo(a, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Size() == b.Size() && a.Mode() == b.Mode() && a.ModTime().Equal(b.ModTime())
+}
@@ -0,0 +1,67 @@
+package fsync
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// interfereFS writes to a file once it's opened, as if someone else did
+// while it's compared.
+type interfereFS struct {
+	osFS
+	name string
+}
+
+func (fs interfereFS) Open(name string) (io.ReadCloser, error) {
+	f, err := fs.osFS.Open(name)
+	if name == fs.name {
+		check(ioutil.WriteFile(name, []byte("someone else's a"), 0644))
+	}
+	return f, err
+}
+
+func TestInterference(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(os.MkdirAll(dst, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("new a"), 0644))
+
+	s := NewSyncer()
+	s.DstFS = interfereFS{name: filepath.Join(dst, "a")}
+	for _, c := range []struct {
+		policy Interference
+		want   string
+	}{
+		{OverwriteInterference, "new a"},
+		{SkipInterference, "someone else's a"},
+		{FailOnInterference, "someone else's a"},
+	} {
+		check(ioutil.WriteFile(filepath.Join(dst, "a"), []byte("old a"), 0644))
+		s.Interference = c.policy
+		var interfered []string
+		s.OnEvent = func(e Event) {
+			if e.Op == OpInterfered {
+				interfered = append(interfered, e.Path)
+			}
+		}
+		stats, err := s.SyncStats(dst, src)
+		if c.policy == FailOnInterference {
+			if pe, ok := err.(*os.PathError); !ok || pe.Err != ErrInterference {
+				t.Errorf("expecting ErrInterference, got %v", err)
+			}
+		} else {
+			check(err)
+		}
+		testFile(filepath.Join(dst, "a"), []byte(c.want), t)
+		if stats.Interfered != 1 || len(interfered) != 1 || interfered[0] != "a" {
+			t.Errorf("%d: expecting a to be reported, got %+v, %v", c.policy, stats, interfered)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+// Package objfs implements fsync.FS on top of object stores.
+//
+// Object stores have a flat key space. Directories are emulated the usual
+// way: keys are slash-separated paths, a directory exists if any key starts
+// with its path followed by a slash, and MkdirAll stores an empty marker
+// object named after the directory with a trailing slash so that empty
+// directories survive. Permissions and modification times can't be set;
+// Chmod and Chtimes do nothing.
+package objfs
+
+import (
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Object describes an object in a Store.
+type Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+	Sum     []byte // checksum made by the store's NewHash, nil if unknown
+}
+
+// Store is the interface an object store provides to FS.
+type Store interface {
+	// Stat returns the object at key. If there is none, the error must
+	// satisfy os.IsNotExist.
+	Stat(key string) (Object, error)
+	// List returns the objects with keys starting with prefix. Unless
+	// recursive is set, keys with a slash after prefix are rolled up into
+	// a single entry for the common prefix, including the trailing slash.
+	List(prefix string, recursive bool) ([]Object, error)
+	// Get opens the object at key for reading.
+	Get(key string) (io.ReadCloser, error)
+	// Put stores size bytes read from r at key. sum is their checksum
+	// computed with NewHash.
+	Put(key string, r io.Reader, size int64, sum []byte) error
+	// Delete removes the object at key. Deleting a missing key is not an
+	// error.
+	Delete(key string) error
+	// Copy copies the object at src to dst.
+	Copy(dst, src string) error
+	// NewHash returns a hash of the kind the store keeps for objects.
+	NewHash() hash.Hash
+}
+
+// FS is an fsync.FS backed by a Store. It implements fsync.Hasher.
+type FS struct {
+	store Store
+}
+
+// New returns an FS that stores files in s.
+func New(s Store) *FS {
+	return &FS{store: s}
+}
+
+// Store returns the store of fs.
+func (fs *FS) Store() Store {
+	return fs.store
+}
+
+// Close closes the store if it implements io.Closer.
+func (fs *FS) Close() error {
+	if c, ok := fs.store.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// key converts a file name to an object key.
+func key(name string) string {
+	k := path.Clean("/" + filepath.ToSlash(name))
+	return strings.TrimPrefix(k, "/")
+}
+
+// dirPrefix returns the prefix of keys inside the directory with key k.
+func dirPrefix(k string) string {
+	if k == "" {
+		return ""
+	}
+	return k + "/"
+}
+
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	k := key(name)
+	if k == "" {
+		return &fileInfo{name: "/", dir: true}, nil
+	}
+	obj, err := fs.store.Stat(k)
+	if err == nil {
+		return &fileInfo{name: path.Base(k), size: obj.Size,
+			mod: obj.ModTime, obj: obj}, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	objs, err := fs.store.List(dirPrefix(k), false)
+	if err != nil {
+		return nil, err
+	}
+	if len(objs) == 0 {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	var mod time.Time
+	for _, obj := range objs {
+		if obj.Key == dirPrefix(k) {
+			mod = obj.ModTime // the marker
+		}
+	}
+	return &fileInfo{name: path.Base(k), mod: mod, dir: true}, nil
+}
+
+func (fs *FS) ReadDir(name string) ([]os.FileInfo, error) {
+	prefix := dirPrefix(key(name))
+	objs, err := fs.store.List(prefix, false)
+	if err != nil {
+		return nil, err
+	}
+	var files []os.FileInfo
+	for _, obj := range objs {
+		if obj.Key == prefix {
+			continue // the marker of the directory itself
+		}
+		n := strings.TrimPrefix(obj.Key, prefix)
+		if strings.HasSuffix(n, "/") {
+			files = append(files, &fileInfo{name: strings.TrimSuffix(n, "/"),
+				mod: obj.ModTime, dir: true})
+		} else {
+			files = append(files, &fileInfo{name: n, size: obj.Size,
+				mod: obj.ModTime, obj: obj})
+		}
+	}
+	if len(files) == 0 && prefix != "" && len(objs) == 0 {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrNotExist}
+	}
+	return files, nil
+}
+
+func (fs *FS) Open(name string) (io.ReadCloser, error) {
+	return fs.store.Get(key(name))
+}
+
+// Create returns a writer that spools the content to a temporary file and
+// uploads it when closed, so that the store gets the size and checksum up
+// front.
+func (fs *FS) Create(name string) (io.WriteCloser, error) {
+	f, err := ioutil.TempFile("", "fsync-objfs")
+	if err != nil {
+		return nil, err
+	}
+	return &writer{fs: fs, key: key(name), f: f, h: fs.store.NewHash()}, nil
+}
+
+// MkdirAll stores a marker for the directory name. perm is ignored.
+func (fs *FS) MkdirAll(name string, perm os.FileMode) error {
+	k := key(name)
+	if k == "" {
+		return nil
+	}
+	h := fs.store.NewHash()
+	return fs.store.Put(dirPrefix(k), strings.NewReader(""), 0, h.Sum(nil))
+}
+
+func (fs *FS) Remove(name string) error {
+	k := key(name)
+	if _, err := fs.store.Stat(k); err == nil {
+		return fs.store.Delete(k)
+	}
+	return fs.store.Delete(dirPrefix(k))
+}
+
+func (fs *FS) RemoveAll(name string) error {
+	k := key(name)
+	if k != "" {
+		if err := fs.store.Delete(k); err != nil {
+			return err
+		}
+	}
+	objs, err := fs.store.List(dirPrefix(k), true)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objs {
+		if err := fs.store.Delete(obj.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rename copies the objects of oldname to newname and deletes the originals.
+// It is not atomic.
+func (fs *FS) Rename(oldname, newname string) error {
+	oldk, newk := key(oldname), key(newname)
+	if _, err := fs.store.Stat(oldk); err == nil {
+		if err := fs.store.Copy(newk, oldk); err != nil {
+			return err
+		}
+		return fs.store.Delete(oldk)
+	}
+	objs, err := fs.store.List(dirPrefix(oldk), true)
+	if err != nil {
+		return err
+	}
+	if len(objs) == 0 {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname,
+			Err: os.ErrNotExist}
+	}
+	for _, obj := range objs {
+		k := dirPrefix(newk) + strings.TrimPrefix(obj.Key, dirPrefix(oldk))
+		if err := fs.store.Copy(k, obj.Key); err != nil {
+			return err
+		}
+		if err := fs.store.Delete(obj.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Chmod does nothing; objects have no permissions.
+func (fs *FS) Chmod(name string, mode os.FileMode) error {
+	return nil
+}
+
+// Chtimes does nothing; stores set modification times themselves.
+func (fs *FS) Chtimes(name string, atime, mtime time.Time) error {
+	return nil
+}
+
+// NewHash returns a hash of the kind the store keeps.
+func (fs *FS) NewHash() hash.Hash {
+	return fs.store.NewHash()
+}
+
+// Hash returns the checksum the store keeps for name.
+func (fs *FS) Hash(name string) ([]byte, error) {
+	obj, err := fs.store.Stat(key(name))
+	if err != nil {
+		return nil, err
+	}
+	return obj.Sum, nil
+}
+
+// writer spools a file to be uploaded to a temporary file.
+type writer struct {
+	fs     *FS
+	key    string
+	f      *os.File
+	h      hash.Hash
+	n      int64
+	err    error // result of the first Close
+	closed bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.h.Write(p[:n])
+	w.n += int64(n)
+	return n, err
+}
+
+// Close uploads the content and removes the temporary file. Calling it again
+// returns the result of the first call.
+func (w *writer) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	defer os.Remove(w.f.Name())
+	defer w.f.Close()
+	if _, w.err = w.f.Seek(0, io.SeekStart); w.err != nil {
+		return w.err
+	}
+	w.err = w.fs.store.Put(w.key, w.f, w.n, w.h.Sum(nil))
+	return w.err
+}
+
+// fileInfo describes a file or directory in an FS.
+type fileInfo struct {
+	name string
+	size int64
+	mod  time.Time
+	dir  bool
+	obj  Object
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.mod }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+
+// Sys returns the Object of a file, and nil for directories.
+func (fi *fileInfo) Sys() interface{} {
+	if fi.dir {
+		return nil
+	}
+	return fi.obj
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
@@ -0,0 +1,141 @@
+package objfs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mostafah/fsync"
+)
+
+// memStore is a Store in memory.
+type memStore struct {
+	objs map[string][]byte
+	gets int
+}
+
+func (m *memStore) Stat(key string) (Object, error) {
+	b, ok := m.objs[key]
+	if !ok {
+		return Object{}, os.ErrNotExist
+	}
+	sum := md5.Sum(b)
+	return Object{Key: key, Size: int64(len(b)), Sum: sum[:]}, nil
+}
+
+func (m *memStore) List(prefix string, recursive bool) ([]Object, error) {
+	seen := make(map[string]bool)
+	var objs []Object
+	for k := range m.objs {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		if i := strings.Index(k[len(prefix):], "/"); !recursive && i >= 0 {
+			k = k[:len(prefix)+i+1]
+		}
+		if !seen[k] {
+			seen[k] = true
+			objs = append(objs, Object{Key: k, Size: int64(len(m.objs[k]))})
+		}
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Key < objs[j].Key })
+	return objs, nil
+}
+
+func (m *memStore) Get(key string) (io.ReadCloser, error) {
+	b, ok := m.objs[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	m.gets++
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (m *memStore) Put(key string, r io.Reader, size int64, sum []byte) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.objs[key] = b
+	return nil
+}
+
+func (m *memStore) Delete(key string) error {
+	delete(m.objs, key)
+	return nil
+}
+
+func (m *memStore) Copy(dst, src string) error {
+	m.objs[dst] = m.objs[src]
+	return nil
+}
+
+func (m *memStore) NewHash() hash.Hash {
+	return md5.New()
+}
+
+func TestSync(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "objfs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		tt := time.Now().Add(-time.Hour)
+		os.Chtimes(name, tt, tt)
+	}
+	write("a/b", "file b")
+	write("c", "file c")
+	store := &memStore{objs: make(map[string][]byte)}
+	s := fsync.NewSyncer()
+	s.DstFS = New(store)
+	s.Delete = true
+	if err := s.Sync("/site", dir); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"site/": "", "site/a/": "",
+		"site/a/b": "file b", "site/c": "file c"}
+	for k, v := range want {
+		if b, ok := store.objs[k]; !ok || string(b) != v {
+			t.Errorf("object %q is %q, should be %q", k, b, v)
+		}
+	}
+	if len(store.objs) != len(want) {
+		t.Errorf("store has %d objects, should have %d",
+			len(store.objs), len(want))
+	}
+
+	// unchanged files are compared with checksums, not downloaded
+	store.gets = 0
+	write("c", "file C")
+	if err := os.Remove(filepath.Join(dir, "a/b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Sync("/site", dir); err != nil {
+		t.Fatal(err)
+	}
+	if store.gets != 0 {
+		t.Errorf("downloaded %d objects, should be 0", store.gets)
+	}
+	if string(store.objs["site/c"]) != "file C" {
+		t.Errorf("site/c is %q, should be updated", store.objs["site/c"])
+	}
+	if _, ok := store.objs["site/a/b"]; ok {
+		t.Errorf("site/a/b should be deleted")
+	}
+}
@@ -0,0 +1,159 @@
+package fsync
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// IOFS returns a read-only io/fs.FS view of the tree at root in fsys. It can
+// be used to serve or inspect a synced tree, including one on a remote
+// backend, with packages that work on io/fs, e.g. with http.FS. The returned
+// FS implements fs.StatFS and fs.ReadDirFS.
+func IOFS(fsys FS, root string) fs.FS {
+	return &ioFS{fsys: fsys, root: root}
+}
+
+// SnapshotFS returns a read-only io/fs.FS view of the snapshot called name in
+// root, or of the newest snapshot if name is empty. See SnapshotFormat for
+// the layout of snapshots.
+func SnapshotFS(root, name string) (fs.FS, error) {
+	snap, err := findSnapshot(root, name)
+	if err != nil {
+		return nil, err
+	}
+	return IOFS(OS, snap.Path), nil
+}
+
+type ioFS struct {
+	fsys FS
+	root string
+}
+
+// path returns the name in fsys of name, which is a path in io/fs form.
+func (f *ioFS) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(f.root, filepath.FromSlash(name)), nil
+}
+
+func (f *ioFS) Open(name string) (fs.File, error) {
+	p, err := f.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.fsys.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if name == "." {
+		info = rootInfo{info}
+	}
+	if info.IsDir() {
+		return &ioDir{fsys: f.fsys, path: p, info: info}, nil
+	}
+	rc, err := f.fsys.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	return &ioFile{ReadCloser: rc, info: info}, nil
+}
+
+func (f *ioFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := f.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.fsys.Stat(p)
+	if err == nil && name == "." {
+		info = rootInfo{info}
+	}
+	return info, err
+}
+
+func (f *ioFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := f.path("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	files, err := f.fsys.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]fs.DirEntry, len(files))
+	for i, file := range files {
+		entries[i] = fs.FileInfoToDirEntry(file)
+	}
+	return entries, nil
+}
+
+// rootInfo renames the root directory to ".", as io/fs expects.
+type rootInfo struct {
+	os.FileInfo
+}
+
+func (rootInfo) Name() string { return "." }
+
+// ioFile is a file opened through an ioFS. Seek and ReadAt work if the
+// backend's files support them.
+type ioFile struct {
+	io.ReadCloser
+	info os.FileInfo
+}
+
+func (f *ioFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *ioFile) Seek(offset int64, whence int) (int64, error) {
+	if s, ok := f.ReadCloser.(io.Seeker); ok {
+		return s.Seek(offset, whence)
+	}
+	return 0, errors.New("fsync: file does not support seeking")
+}
+
+func (f *ioFile) ReadAt(p []byte, off int64) (int, error) {
+	if r, ok := f.ReadCloser.(io.ReaderAt); ok {
+		return r.ReadAt(p, off)
+	}
+	return 0, errors.New("fsync: file does not support ReadAt")
+}
+
+// ioDir is a directory opened through an ioFS.
+type ioDir struct {
+	fsys  FS
+	path  string
+	info  os.FileInfo
+	files []os.FileInfo // entries not returned by ReadDir yet
+	read  bool          // whether files has been read
+}
+
+func (d *ioDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *ioDir) Close() error               { return nil }
+
+func (d *ioDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: errors.New("is a directory")}
+}
+
+func (d *ioDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		files, err := d.fsys.ReadDir(d.path)
+		if err != nil {
+			return nil, err
+		}
+		d.files, d.read = files, true
+	}
+	if n > 0 && len(d.files) == 0 {
+		return nil, io.EOF
+	}
+	if n <= 0 || n > len(d.files) {
+		n = len(d.files)
+	}
+	entries := make([]fs.DirEntry, n)
+	for i := range entries {
+		entries[i] = fs.FileInfoToDirEntry(d.files[i])
+	}
+	d.files = d.files[n:]
+	return entries, nil
+}
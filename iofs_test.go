@@ -0,0 +1,37 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSnapshotFS(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(root)
+	old := filepath.Join(root, "2020-01-01T00:00:00")
+	snap := filepath.Join(root, "2020-01-02T00:00:00")
+	check(os.MkdirAll(filepath.Join(old, "a"), 0755))
+	check(os.MkdirAll(filepath.Join(snap, "a"), 0755))
+	check(ioutil.WriteFile(filepath.Join(snap, "a/b"), []byte("file b"), 0644))
+	check(ioutil.WriteFile(filepath.Join(snap, "c"), []byte("file c"), 0644))
+
+	fsys, err := SnapshotFS(root, "")
+	check(err)
+	if err := fstest.TestFS(fsys, "a/b", "c"); err != nil {
+		t.Error(err)
+	}
+
+	fsys, err = SnapshotFS(root, "2020-01-01T00:00:00")
+	check(err)
+	if err := fstest.TestFS(fsys, "a"); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := SnapshotFS(root, "2020-01-03T00:00:00"); err != ErrNoSnapshot {
+		t.Errorf("expecting ErrNoSnapshot, got %v.\n", err)
+	}
+}
@@ -0,0 +1,68 @@
+package fsync
+
+import (
+	"os"
+	"strings"
+)
+
+// The Item of an Event tells what changed like rsync's --itemize-changes,
+// so that scripts can parse it: eleven characters YXcstpoguax, where Y is
+// '>' for a file copied, 'c' for one created otherwise, '.' for a change of
+// attributes only and '*' for a message; X is 'f' for a file, 'd' for a
+// directory, 'L' for a symbolic link and 'D' for a special file; and c, s, t and p are shown where
+// the contents, size, modification time or permissions differ, with '+'
+// for all of them when it's new. Owners, groups and extended attributes are
+// only synced by SyncMetadata, which shows o, g and x where they differ;
+// ACLs never are, so u and a stay '.'.
+const (
+	itemNew      = ">f+++++++++"
+	itemNewDir   = "cd+++++++++"
+	itemMoved    = "cf+++++++++"
+	itemSpecial  = "cD+++++++++"
+	itemLinked   = "hf+++++++++"
+	itemDeleting = "*deleting"
+)
+
+// itemize returns the Item of a change of dstat to sstat, with the update
+// character y.
+func (r *run) itemize(y byte, dstat, sstat os.FileInfo) string {
+	item := []byte(".f.........")
+	item[0], item[1] = y, itemType(sstat)
+	if sstat.Size() != dstat.Size() {
+		item[3] = 's'
+	} else if r.cmp == CompareContent || r.cmp == CompareChecksum || r.sameTime(dstat.ModTime(), sstat.ModTime()) {
+		item[2] = 'c'
+	}
+	if !r.NoTimes && !r.sameTime(dstat.ModTime(), sstat.ModTime()) {
+		item[4] = 't'
+	}
+	if r.permDiffers(dstat, sstat) {
+		item[5] = 'p'
+	}
+	return string(item)
+}
+
+// itemAttr returns the Item of a change of the attributes cs of sstat
+// alone.
+func itemAttr(sstat os.FileInfo, cs ...byte) string {
+	item := []byte("...........")
+	item[1] = itemType(sstat)
+	for _, c := range cs {
+		if i := strings.IndexByte("..cstpoguax", c); i >= 2 {
+			item[i] = c
+		}
+	}
+	return string(item)
+}
+
+func itemType(fi os.FileInfo) byte {
+	switch {
+	case fi.IsDir():
+		return 'd'
+	case fi.Mode()&os.ModeSymlink != 0:
+		return 'L'
+	case isSpecial(fi):
+		return 'D'
+	}
+	return 'f'
+}
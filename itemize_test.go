@@ -0,0 +1,49 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestItemize(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "a"), 0755))
+	check(os.MkdirAll(dst, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a/b"), []byte("file b"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "c"), []byte("file c"), 0644))
+	check(ioutil.WriteFile(filepath.Join(dst, "c"), []byte("old file c"), 0644))
+	old := time.Now().Add(-time.Hour)
+	check(os.Chtimes(filepath.Join(dst, "c"), old, old))
+	check(ioutil.WriteFile(filepath.Join(dst, "d"), []byte("file d"), 0644))
+
+	var items []string
+	s := NewSyncer()
+	s.Delete = true
+	s.Verbosity = Verbose
+	s.OnEvent = func(e Event) { items = append(items, e.Item+" "+e.Path) }
+	check(s.Sync(dst, src))
+	sort.Strings(items)
+	want := []string{"*deleting d", ">f+++++++++ a/b", ">f.st...... c", "cd+++++++++ a"}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("expecting %q, got %q", want, items)
+	}
+
+	// changes of attributes alone are traced
+	items = nil
+	check(os.Chmod(filepath.Join(src, "c"), 0600))
+	s.SetVerbosity(Trace)
+	check(s.Sync(dst, src))
+	want = []string{" a/b", " c", ".f...p..... c"}
+	if sort.Strings(items); !reflect.DeepEqual(items, want) {
+		t.Errorf("expecting %q, got %q", want, items)
+	}
+}
@@ -0,0 +1,67 @@
+//go:build go1.23
+// +build go1.23
+
+package fsync
+
+import (
+	"context"
+	"iter"
+	"slices"
+)
+
+// All returns an iterator over the Events of p, in order.
+func (p *Plan) All() iter.Seq[Event] {
+	return slices.Values(p.Events)
+}
+
+// Changes syncs dst with src, as SyncContext does, and returns an iterator
+// over the changes it makes, as it makes them: the events it reports at
+// Verbose, whatever Verbosity is, with errors. If the sync fails, the last
+// event is an OpError one with its error. Breaking out of the loop stops the
+// sync, as canceling ctx does, and waits for it to return. OnEvent is still
+// called as usual.
+func (s *Syncer) Changes(ctx context.Context, dst, src string) iter.Seq[Event] {
+	return func(yield func(Event) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		// the sync waits for each event to be handled, so that it stops
+		// right after the last one
+		events := make(chan Event)
+		handled := make(chan struct{}, 1)
+		done := make(chan error, 1)
+		go func() {
+			_, err := s.syncContext(ctx, dst, src, func(r *run) {
+				r.changes = func(e Event) {
+					select {
+					case events <- e:
+						select {
+						case <-handled:
+						case <-ctx.Done():
+						}
+					case <-ctx.Done():
+					}
+				}
+			})
+			done <- err
+		}()
+		for {
+			select {
+			case e := <-events:
+				more := yield(e)
+				if !more {
+					cancel()
+				}
+				handled <- struct{}{}
+				if !more {
+					<-done
+					return
+				}
+			case err := <-done:
+				if err != nil {
+					yield(Event{Op: OpError, Err: err, Time: s.clock().Now()})
+				}
+				return
+			}
+		}
+	}
+}
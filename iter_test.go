@@ -0,0 +1,75 @@
+//go:build go1.23
+// +build go1.23
+
+package fsync
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChanges(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	for i := 0; i < 5; i++ {
+		check(ioutil.WriteFile(filepath.Join(src, fmt.Sprint(i)), []byte("file"), 0644))
+	}
+
+	s := NewSyncer()
+	p, err := s.Plan(dst, src)
+	check(err)
+	n := 0
+	for e := range p.All() {
+		if e != p.Events[n] {
+			t.Errorf("expecting event %d to be %+v, got %+v", n, p.Events[n], e)
+		}
+		n++
+	}
+	if n != len(p.Events) {
+		t.Errorf("expecting %d events, got %d", len(p.Events), n)
+	}
+
+	// breaking out stops the sync
+	copies := 0
+	for e := range s.Changes(context.Background(), dst, src) {
+		if e.Op == OpCopy {
+			copies++
+			if copies == 2 {
+				break
+			}
+		}
+	}
+	testDirContents(dst, 2, t)
+
+	// the rest is copied, and nothing else reported
+	copies = 0
+	for e := range s.Changes(context.Background(), dst, src) {
+		switch e.Op {
+		case OpCopy:
+			copies++
+		case OpError:
+			t.Errorf("unexpected error %v", e.Err)
+		}
+	}
+	if copies != 3 {
+		t.Errorf("expecting 3 files copied, got %d", copies)
+	}
+	testDirContents(dst, 5, t)
+
+	// failures end the iteration
+	var last Event
+	for e := range s.Changes(context.Background(), dst, filepath.Join(dir, "none")) {
+		last = e
+	}
+	if last.Op != OpError || !os.IsNotExist(last.Err) {
+		t.Errorf("expecting the sync to fail for a missing source, got %+v", last)
+	}
+}
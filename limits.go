@@ -0,0 +1,153 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Limits are the resources a process may use, as SystemLimits finds them.
+type Limits struct {
+	CPUs   int   // at least 1
+	Memory int64 // bytes; zero if unlimited or unknown
+}
+
+// cgroupRoot is where the cgroup file system is mounted, and procCgroup
+// lists the cgroups of the process in it.
+var (
+	cgroupRoot = "/sys/fs/cgroup"
+	procCgroup = "/proc/self/cgroup"
+)
+
+// SystemLimits returns the CPUs and memory the process may use: those of
+// its cgroups, as in a container or a systemd service, on Linux, or else the number of CPUs of the
+// host and no memory limit.
+func SystemLimits() Limits {
+	l := cgroupLimits(cgroupRoot, procCgroup)
+	if n := runtime.NumCPU(); l.CPUs <= 0 || l.CPUs > n {
+		l.CPUs = n
+	}
+	return l
+}
+
+// cgroupLimits reads the limits of the cgroups listed in the file self, as
+// in /proc/self/cgroup, from the cgroup file system mounted at root, with
+// version 2 or version 1 controllers, or returns zeros for those that
+// aren't set. The tightest limit of a cgroup and those it's in is taken.
+// Without self, or if the cgroups aren't found under root, as in containers
+// with their own cgroup namespace, the limits at root are.
+func cgroupLimits(root, self string) Limits {
+	var l Limits
+	// cgroups by version 1 controller, and "" for version 2
+	groups := map[string]string{"": "/", "cpu": "/", "memory": "/"}
+	if b, err := ioutil.ReadFile(self); err == nil {
+		// "0::/path" with version 2, "4:cpu,cpuacct:/path" with version 1
+		for _, line := range strings.Split(string(b), "\n") {
+			f := strings.SplitN(line, ":", 3)
+			if len(f) != 3 {
+				continue
+			}
+			for _, c := range strings.Split(f[1], ",") {
+				if _, ok := groups[c]; ok {
+					groups[c] = f[2]
+				}
+			}
+		}
+	}
+	// up calls f with the cgroup of controller and those it's in
+	up := func(controller string, f func(dir string)) {
+		for p := path.Clean("/" + groups[controller]); ; p = path.Dir(p) {
+			f(filepath.Join(root, controller, filepath.FromSlash(p)))
+			if p == "/" {
+				return
+			}
+		}
+	}
+	read := func(dir, name string) []string {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil
+		}
+		return strings.Fields(string(b))
+	}
+	num := func(s string) int64 {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			return 0
+		}
+		return n
+	}
+	cpus := func(quota, period int64) {
+		if quota > 0 && period > 0 {
+			// a fraction of a CPU is worth a worker
+			if n := int((quota + period - 1) / period); l.CPUs == 0 || n < l.CPUs {
+				l.CPUs = n
+			}
+		}
+	}
+	memory := func(n int64) {
+		if n > 0 && (l.Memory == 0 || n < l.Memory) {
+			l.Memory = n
+		}
+	}
+
+	// version 2: "max 100000" or "50000 100000", and "max" or bytes; the
+	// version 1 controllers are only read if there are none
+	v2cpu, v2memory := false, false
+	up("", func(dir string) {
+		if f := read(dir, "cpu.max"); len(f) == 2 {
+			cpus(num(f[0]), num(f[1]))
+			v2cpu = true
+		}
+		if f := read(dir, "memory.max"); len(f) == 1 {
+			memory(num(f[0]))
+			v2memory = true
+		}
+	})
+	if !v2cpu {
+		up("cpu", func(dir string) {
+			if q, p := read(dir, "cpu.cfs_quota_us"), read(dir, "cpu.cfs_period_us"); len(q) == 1 && len(p) == 1 {
+				cpus(num(q[0]), num(p[0]))
+			}
+		})
+	}
+	if !v2memory {
+		up("memory", func(dir string) {
+			// no limit is shown as a huge number, rounded down to a page
+			if f := read(dir, "memory.limit_in_bytes"); len(f) == 1 {
+				if n := num(f[0]); n < 1<<60 {
+					memory(n)
+				}
+			}
+		})
+	}
+	return l
+}
+
+// AutoTune sets Workers, BufferSize and MemoryBudget, those that are zero,
+// to suit the limits of SystemLimits: two workers per CPU, up to 32, and an
+// eighth of the memory for the sync when it's limited, with bigger buffers
+// when it's plenty. Fields set before are left alone, so that explicit
+// choices win. As with Workers, both file systems must then be safe for
+// concurrent use.
+func (s *Syncer) AutoTune() {
+	s.tune(SystemLimits())
+}
+
+func (s *Syncer) tune(l Limits) {
+	if s.Workers == 0 {
+		s.Workers = 2 * l.CPUs
+		if s.Workers > 32 {
+			s.Workers = 32
+		}
+	}
+	if s.MemoryBudget == 0 && l.Memory > 0 {
+		s.MemoryBudget = l.Memory / 8
+	}
+	if s.BufferSize == 0 && (l.Memory == 0 || l.Memory >= 1<<30) {
+		s.BufferSize = 256 << 10
+	}
+}
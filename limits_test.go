@@ -0,0 +1,81 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCgroupLimits(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	write := func(name, s string) {
+		check(os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		check(ioutil.WriteFile(filepath.Join(dir, name), []byte(s), 0644))
+	}
+
+	if l := cgroupLimits(dir, ""); l != (Limits{}) {
+		t.Errorf("expecting no limits, got %+v", l)
+	}
+	write("cpu/cpu.cfs_quota_us", "-1\n")
+	write("cpu/cpu.cfs_period_us", "100000\n")
+	write("memory/memory.limit_in_bytes", "9223372036854771712\n")
+	if l := cgroupLimits(dir, ""); l != (Limits{}) {
+		t.Errorf("expecting no limits with version 1, got %+v", l)
+	}
+	write("cpu/cpu.cfs_quota_us", "250000\n")
+	write("memory/memory.limit_in_bytes", "536870912\n")
+	if l := cgroupLimits(dir, ""); l != (Limits{CPUs: 3, Memory: 512 << 20}) {
+		t.Errorf("expecting 3 CPUs and 512MiB with version 1, got %+v", l)
+	}
+	write("cpu.max", "50000 100000\n")
+	write("memory.max", "max\n")
+	if l := cgroupLimits(dir, ""); l != (Limits{CPUs: 1}) {
+		t.Errorf("expecting 1 CPU and no memory limit with version 2, got %+v", l)
+	}
+
+	// the cgroups of the process and those they're in, with the tightest
+	// limits taken, as with systemd
+	check(os.RemoveAll(dir))
+	write("self", "0::/system.slice/a.service\n")
+	write("system.slice/cpu.max", "200000 100000\n")
+	write("system.slice/memory.max", "1073741824\n")
+	write("system.slice/a.service/cpu.max", "max 100000\n")
+	write("system.slice/a.service/memory.max", "536870912\n")
+	self := filepath.Join(dir, "self")
+	if l := cgroupLimits(dir, self); l != (Limits{CPUs: 2, Memory: 512 << 20}) {
+		t.Errorf("expecting 2 CPUs and 512MiB from nested cgroups, got %+v", l)
+	}
+	check(os.RemoveAll(dir))
+	write("self", "12:memory:/docker/x\n4:cpu,cpuacct:/docker/x\n1:name=systemd:/docker/x\n")
+	write("cpu/docker/x/cpu.cfs_quota_us", "150000\n")
+	write("cpu/docker/x/cpu.cfs_period_us", "100000\n")
+	write("memory/docker/memory.limit_in_bytes", "268435456\n")
+	write("memory/docker/x/memory.limit_in_bytes", "9223372036854771712\n")
+	if l := cgroupLimits(dir, self); l != (Limits{CPUs: 2, Memory: 256 << 20}) {
+		t.Errorf("expecting 2 CPUs and 256MiB from nested cgroups with version 1, got %+v", l)
+	}
+	// a container without its own cgroup namespace sees its cgroup at root
+	check(os.RemoveAll(dir))
+	write("self", "0::/docker/x\n")
+	write("cpu.max", "100000 100000\n")
+	if l := cgroupLimits(dir, self); l != (Limits{CPUs: 1}) {
+		t.Errorf("expecting 1 CPU from the root, got %+v", l)
+	}
+
+	s := NewSyncer()
+	s.Workers = 1
+	s.tune(Limits{CPUs: 4, Memory: 512 << 20})
+	if s.Workers != 1 || s.MemoryBudget != 64<<20 || s.BufferSize != 0 {
+		t.Errorf("expecting 1 worker, a budget of 64MiB and small buffers, got %d, %d and %d",
+			s.Workers, s.MemoryBudget, s.BufferSize)
+	}
+	s = NewSyncer()
+	s.tune(Limits{CPUs: 4})
+	if s.Workers != 8 || s.MemoryBudget != 0 || s.BufferSize != 256<<10 {
+		t.Errorf("expecting 8 workers, no budget and big buffers, got %d, %d and %d",
+			s.Workers, s.MemoryBudget, s.BufferSize)
+	}
+}
@@ -0,0 +1,37 @@
+package fsync
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// linkDest makes the destination name dst, which doesn't exist, a hard link
+// to its counterpart in LinkDest, and returns true, if that's the same as the
+// source file src, with the file info sstat, permissions and modification
+// time included, so that syncing dst leaves the counterpart alone.
+func (r *run) linkDest(dst, src string, sstat os.FileInfo) bool {
+	if r.LinkDest == "" {
+		return false
+	}
+	linker, ok := r.dfs.(Linker)
+	if !ok {
+		return false
+	}
+	rel, err := filepath.Rel(r.dstRoot, dst)
+	check(err)
+	old := filepath.Join(r.LinkDest, rel)
+	if !filepath.IsAbs(r.LinkDest) {
+		old = filepath.Join(r.dstRoot, r.LinkDest, rel)
+	}
+	ostat, err := r.dfs.Stat(old)
+	if err != nil || !ostat.Mode().IsRegular() || r.permDiffers(ostat, sstat) ||
+		!r.NoTimes && !r.sameTime(ostat.ModTime(), sstat.ModTime()) || r.same(old, src) == Copied {
+		return false
+	}
+	r.stats.Linked++
+	r.emit(Verbose, src, Event{Op: OpLink, Item: itemLinked})
+	if !r.DryRun {
+		check(linker.Link(old, dst))
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLinkDest(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	check(os.MkdirAll(filepath.Join(src, "d"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("file a"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "d/b"), []byte("file b"), 0644))
+	check(Sync(filepath.Join(dir, "1"), src))
+	same := func(a, b string) bool {
+		fa, err := os.Stat(filepath.Join(dir, a))
+		check(err)
+		fb, err := os.Stat(filepath.Join(dir, b))
+		check(err)
+		return os.SameFile(fa, fb)
+	}
+
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("new file a"), 0644))
+	s := NewSyncer()
+	s.LinkDest = "../1"
+	stats, err := s.SyncStats(filepath.Join(dir, "2"), src)
+	check(err)
+	if stats.Linked != 1 || stats.Files != 1 || !same("1/d/b", "2/d/b") || same("1/a", "2/a") {
+		t.Errorf("expecting d/b to be linked and a copied, got %+v", stats)
+	}
+	testFile(filepath.Join(dir, "1/a"), []byte("file a"), t)
+	testFile(filepath.Join(dir, "2/a"), []byte("new file a"), t)
+
+	// not linked if the permissions changed
+	check(os.Chmod(filepath.Join(src, "d/b"), 0600))
+	s.LinkDest = filepath.Join(dir, "2")
+	stats, err = s.SyncStats(filepath.Join(dir, "3"), src)
+	check(err)
+	if stats.Linked != 1 || !same("2/a", "3/a") || same("2/d/b", "3/d/b") {
+		t.Errorf("expecting a to be linked and d/b copied, got %+v", stats)
+	}
+	testPerms(filepath.Join(dir, "2/d/b"), 0644, t)
+}
@@ -0,0 +1,31 @@
+package fsync
+
+import "os"
+
+// SkipXattr is the extended attribute that marks files and directories to be
+// skipped with ExcludeMarked, e.g. with
+//
+//	setfattr -n user.fsync.skip -v 1 cache
+const SkipXattr = "user.fsync.skip"
+
+// Marker is implemented by file systems that can tell if files are marked to
+// be skipped, which ExcludeMarked uses. The local file system does on Linux,
+// macOS, FreeBSD and NetBSD, where files are marked with SkipXattr or the
+// nodump flag.
+type Marker interface {
+	Marked(name string) (bool, error)
+}
+
+// marked returns true if the source name src is marked to be skipped.
+func (r *run) marked(src string) bool {
+	m, ok := r.sfs.(Marker)
+	if !r.ExcludeMarked || !ok {
+		return false
+	}
+	b, err := m.Marked(src)
+	if os.IsNotExist(err) {
+		return false
+	}
+	check(err)
+	return b
+}
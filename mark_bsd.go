@@ -0,0 +1,31 @@
+//go:build darwin || freebsd || netbsd
+// +build darwin freebsd netbsd
+
+package fsync
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ufNodump is UF_NODUMP of sys/stat.h, set by chflags nodump.
+const ufNodump = 0x1
+
+func (osFS) Marked(name string) (bool, error) {
+	_, err := unix.Getxattr(name, SkipXattr, nil)
+	switch err {
+	case nil:
+		return true, nil
+	case unix.ENOATTR, unix.ENOTSUP:
+	default:
+		return false, &os.PathError{Op: "getxattr", Path: name, Err: err}
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return false, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && st.Flags&ufNodump != 0, nil
+}
@@ -0,0 +1,31 @@
+package fsync
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// fsNodumpFl is FS_NODUMP_FL of linux/fs.h, set by chattr +d.
+const fsNodumpFl = 0x40
+
+func (osFS) Marked(name string) (bool, error) {
+	_, err := unix.Getxattr(name, SkipXattr, nil)
+	switch err {
+	case nil:
+		return true, nil
+	case unix.ENODATA, unix.ENOTSUP:
+	default:
+		return false, &os.PathError{Op: "getxattr", Path: name, Err: err}
+	}
+	fd, err := unix.Open(name, unix.O_RDONLY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return false, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	defer unix.Close(fd)
+	flags, err := unix.IoctlGetUint32(fd, unix.FS_IOC_GETFLAGS)
+	if err != nil {
+		return false, nil // not supported by the file system or file type
+	}
+	return flags&fsNodumpFl != 0, nil
+}
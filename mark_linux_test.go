@@ -0,0 +1,40 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestExcludeMarked(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "cache"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "cache/a"), nil, 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "b"), nil, 0644))
+	err = unix.Setxattr(filepath.Join(src, "cache"), SkipXattr, []byte("1"), 0)
+	if err == unix.ENOTSUP || err == unix.EPERM {
+		t.Skip("extended attributes aren't supported:", err)
+	}
+	check(err)
+
+	s := NewSyncer()
+	check(s.Sync(dst, src))
+	testDirContents(dst, 2, t)
+	check(os.RemoveAll(dst))
+
+	s.ExcludeMarked = true
+	check(s.Sync(dst, src))
+	testDirContents(dst, 1, t)
+	e, err := s.Explain(dst, src, "cache/a")
+	check(err)
+	if e.Matched != "cache" || e.Reason != Excluded {
+		t.Errorf("expecting cache to be excluded, got\n%s", e)
+	}
+}
@@ -0,0 +1,50 @@
+package fsync
+
+import (
+	"errors"
+	"path/filepath"
+)
+
+var (
+	ErrMaxDelete = errors.New("fsync: more files to delete than MaxDelete allows")
+)
+
+// limitDeletions panics with ErrMaxDelete if deleting what's left in
+// r.deletions deletes more files than MaxDelete or MaxDeletePercent allow.
+func (r *run) limitDeletions() {
+	if r.MaxDelete <= 0 && r.MaxDeletePercent <= 0 {
+		return
+	}
+	n := 0
+	r.deletions.each(func(j job) {
+		if !r.moved[j.dst] {
+			n += r.countFiles(j.dst)
+		}
+	})
+	total := r.dstFiles + n
+	if r.MaxDelete > 0 && n > r.MaxDelete ||
+		r.MaxDeletePercent > 0 && float64(n) > float64(total)*r.MaxDeletePercent/100 {
+		panic(ErrMaxDelete)
+	}
+}
+
+// countFiles returns the number of files in the destination name dst,
+// which is 1 if it's a file.
+func (r *run) countFiles(dst string) int {
+	fi, err := r.dfs.Stat(dst)
+	check(err)
+	if !fi.IsDir() {
+		return 1
+	}
+	files, err := r.dfs.ReadDir(dst)
+	check(err)
+	n := 0
+	for _, fi := range files {
+		if fi.IsDir() {
+			n += r.countFiles(filepath.Join(dst, fi.Name()))
+		} else {
+			n++
+		}
+	}
+	return n
+}
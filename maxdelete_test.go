@@ -0,0 +1,41 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMaxDelete(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(os.MkdirAll(filepath.Join(dst, "a"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "b"), []byte("file b"), 0644))
+	for _, name := range []string{"a/x", "a/y", "b", "c"} {
+		check(ioutil.WriteFile(filepath.Join(dst, name), []byte("file"), 0644))
+	}
+
+	s := NewSyncer()
+	s.Delete = true
+	s.MaxDelete = 2
+	if err := s.Sync(dst, src); err != ErrMaxDelete {
+		t.Errorf("expecting ErrMaxDelete, got %v", err)
+	}
+	testDirContents(dst, 3, t)
+	testFile(filepath.Join(dst, "b"), []byte("file b"), t)
+
+	// 3 of 4 files
+	s.MaxDelete = 0
+	s.MaxDeletePercent = 50
+	if err := s.Sync(dst, src); err != ErrMaxDelete {
+		t.Errorf("expecting ErrMaxDelete, got %v", err)
+	}
+	s.MaxDeletePercent = 75
+	check(s.Sync(dst, src))
+	testDirContents(dst, 1, t)
+}
@@ -0,0 +1,217 @@
+package fsync
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+)
+
+// MetadataOptions selects what SyncMetadata syncs besides permissions and
+// modification times, which NoPerms and NoTimes leave out.
+type MetadataOptions struct {
+	// Owners syncs the owner and group of files, by their IDs, if the
+	// destination is a Chowner. Usually only root may change them.
+	Owners bool
+	// Xattrs syncs the extended attributes of files, removing those the
+	// source doesn't have, if both file systems are Xattrers.
+	Xattrs bool
+}
+
+// Chowner is implemented by file systems that can change the owner and
+// group of files, which SyncMetadata uses with Owners. The local file system
+// does on Unix, where the owners of files are read from their file info.
+type Chowner interface {
+	Chown(name string, uid, gid int) error
+}
+
+// Xattrer is implemented by file systems that can read and write the
+// extended attributes of files, which SyncMetadata uses with Xattrs. The
+// local file system does on Linux, macOS, FreeBSD and NetBSD, leaving out
+// SELinux labels, which the destination's own policy sets.
+type Xattrer interface {
+	ListXattrs(name string) ([]string, error)
+	GetXattr(name, attr string) ([]byte, error)
+	SetXattr(name, attr string, value []byte) error
+	RemoveXattr(name, attr string) error
+}
+
+// SyncMetadata syncs the permissions and modification times of the files
+// and directories that are in both dst and src, as a sync does after
+// copying them, and their owners and extended attributes as opts selects,
+// without copying, making or deleting anything, to fix up a tree restored
+// by other means. Exclude, Include, Filter, NoPerms, PermMask,
+// NoSpecialBits, NoTimes, ModifyWindow, DryRun and OnEvent apply as they do
+// to a sync. Names that are a directory on one side only are left alone.
+// The Stats returned count what failed, with ContinueOnError or OnError.
+func (s *Syncer) SyncMetadata(dst, src string, opts MetadataOptions) (Stats, error) {
+	r, dst, src, err := s.newRun(dst, src)
+	if err != nil {
+		return Stats{}, err
+	}
+	r.meta = opts
+	err = r.metadataOnly(dst, src)
+	return r.result(), err
+}
+
+// metadataOnly syncs the stats of dst and src and what they have in common,
+// for SyncMetadata, and closes the file systems of r.
+func (r *run) metadataOnly(dst, src string) (err error) {
+	defer func() {
+		if err2 := r.close(); err == nil {
+			err = err2
+		}
+	}()
+	if err := checkPatterns(r.Exclude, r.Include, r.Protect); err != nil {
+		return err
+	}
+	if _, err := r.sfs.Stat(src); err != nil {
+		return err
+	}
+	if _, err := r.dfs.Stat(dst); err != nil {
+		return err
+	}
+	r.dstRoot = dst
+	r.start(src)
+	defer r.finish()
+	err = r.scan(func() { r.metadata(dst, src, true) })
+	if err == nil {
+		err = r.failure()
+	}
+	return err
+}
+
+// metadata syncs the stats of the directory dst with those of the directory
+// src, if sync is true, after those of what's in both of them, so that
+// their modification times stay.
+func (r *run) metadata(dst, src string, sync bool) {
+	r.try(src, func() {
+		files, err := r.sfs.ReadDir(src)
+		check(err)
+		for _, file := range files {
+			src2 := filepath.Join(src, file.Name())
+			dst2 := filepath.Join(dst, file.Name())
+			excluded := r.excluded(src2, file)
+			if excluded && !r.descend(src2, file) {
+				continue
+			}
+			dstat, err := r.dfs.Stat(dst2)
+			if os.IsNotExist(err) {
+				continue
+			}
+			check(err)
+			sstat, err := r.sfs.Stat(src2)
+			if os.IsNotExist(err) {
+				continue
+			}
+			check(err)
+			if dstat.IsDir() != sstat.IsDir() {
+				continue
+			}
+			if sstat.IsDir() {
+				r.metadata(dst2, src2, !excluded)
+			} else if !excluded {
+				r.try(src2, func() { r.fixStats(dst2, src2) })
+			}
+		}
+		if sync {
+			r.fixStats(dst, src)
+		}
+	})
+}
+
+// fixStats syncs the stats of dst with those of src, with its owner and
+// extended attributes as r.meta selects, or only reports what it would
+// change in a dry run. The owner goes first, as changing it may clear the
+// setuid and setgid bits.
+func (r *run) fixStats(dst, src string) {
+	r.fixOwner(dst, src)
+	r.fixXattrs(dst, src)
+	if !r.DryRun {
+		r.syncstats(dst, src)
+		return
+	}
+	dstat, err := r.dfs.Stat(dst)
+	check(err)
+	sstat, err := r.sfs.Stat(src)
+	check(err)
+	if r.permDiffers(dstat, sstat) {
+		r.emit(Trace, src, Event{Op: OpChmod, Item: itemAttr(sstat, 'p')})
+	}
+	if !r.NoTimes && !r.sameTime(dstat.ModTime(), sstat.ModTime()) {
+		r.emit(Trace, src, Event{Op: OpChtimes, Item: itemAttr(sstat, 't')})
+	}
+}
+
+// fixOwner syncs the owner and group of dst with those of src, with Owners.
+func (r *run) fixOwner(dst, src string) {
+	c, ok := r.dfs.(Chowner)
+	if !r.meta.Owners || !ok {
+		return
+	}
+	dstat, err := r.dfs.Stat(dst)
+	check(err)
+	sstat, err := r.sfs.Stat(src)
+	check(err)
+	suid, sgid, ok := owner(sstat)
+	duid, dgid, ok2 := owner(dstat)
+	if !ok || !ok2 || suid == duid && sgid == dgid {
+		return
+	}
+	if !r.DryRun {
+		check(c.Chown(dst, suid, sgid))
+	}
+	var cs []byte
+	if suid != duid {
+		cs = append(cs, 'o')
+	}
+	if sgid != dgid {
+		cs = append(cs, 'g')
+	}
+	r.emit(Trace, src, Event{Op: OpChown, Item: itemAttr(sstat, cs...)})
+}
+
+// fixXattrs syncs the extended attributes of dst with those of src, with
+// Xattrs.
+func (r *run) fixXattrs(dst, src string) {
+	sx, ok := r.sfs.(Xattrer)
+	dx, ok2 := r.dfs.(Xattrer)
+	if !r.meta.Xattrs || !ok || !ok2 {
+		return
+	}
+	snames, err := sx.ListXattrs(src)
+	check(err)
+	dnames, err := dx.ListXattrs(dst)
+	check(err)
+	has := make(map[string]bool, len(dnames))
+	for _, a := range dnames {
+		has[a] = true
+	}
+	changed := false
+	for _, a := range snames {
+		sv, err := sx.GetXattr(src, a)
+		check(err)
+		if has[a] {
+			delete(has, a)
+			dv, err := dx.GetXattr(dst, a)
+			check(err)
+			if bytes.Equal(sv, dv) {
+				continue
+			}
+		}
+		changed = true
+		if !r.DryRun {
+			check(dx.SetXattr(dst, a, sv))
+		}
+	}
+	for a := range has {
+		changed = true
+		if !r.DryRun {
+			check(dx.RemoveXattr(dst, a))
+		}
+	}
+	if changed {
+		sstat, err := r.sfs.Stat(src)
+		check(err)
+		r.emit(Trace, src, Event{Op: OpXattr, Item: itemAttr(sstat, 'x')})
+	}
+}
@@ -0,0 +1,96 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSyncMetadataOwners(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("only root can change owners")
+	}
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	for _, root := range []string{src, dst} {
+		check(os.MkdirAll(root, 0755))
+		check(ioutil.WriteFile(filepath.Join(root, "a"), []byte("file a"), 0644))
+	}
+	check(os.Chown(filepath.Join(src, "a"), 1234, 5678))
+
+	s := NewSyncer()
+	s.DryRun = true
+	_, err = s.SyncMetadata(dst, src, MetadataOptions{Owners: true})
+	check(err)
+	testOwner(filepath.Join(dst, "a"), 0, 0, t)
+	s.DryRun = false
+	_, err = s.SyncMetadata(dst, src, MetadataOptions{})
+	check(err)
+	testOwner(filepath.Join(dst, "a"), 0, 0, t)
+	_, err = s.SyncMetadata(dst, src, MetadataOptions{Owners: true})
+	check(err)
+	testOwner(filepath.Join(dst, "a"), 1234, 5678, t)
+}
+
+func TestSyncMetadataXattrs(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	for _, root := range []string{src, dst} {
+		check(os.MkdirAll(root, 0755))
+		check(ioutil.WriteFile(filepath.Join(root, "a"), []byte("file a"), 0644))
+	}
+	sa, da := filepath.Join(src, "a"), filepath.Join(dst, "a")
+	if err := unix.Setxattr(sa, "user.kept", []byte("new"), 0); err == unix.ENOTSUP {
+		t.Skip("the file system doesn't support extended attributes")
+	}
+	check(unix.Setxattr(sa, "user.added", []byte("added"), 0))
+	check(unix.Setxattr(da, "user.kept", []byte("old"), 0))
+	check(unix.Setxattr(da, "user.removed", []byte("removed"), 0))
+
+	var events []Event
+	s := NewSyncer()
+	s.Verbosity = Trace
+	s.OnEvent = func(e Event) {
+		if e.Op == OpXattr {
+			events = append(events, e)
+		}
+	}
+	_, err = s.SyncMetadata(dst, src, MetadataOptions{Xattrs: true})
+	check(err)
+	names, err := OS.(Xattrer).ListXattrs(da)
+	check(err)
+	if len(names) != 2 {
+		t.Errorf("expecting 2 extended attributes, got %v", names)
+	}
+	for _, a := range []string{"user.kept", "user.added"} {
+		want, err := OS.(Xattrer).GetXattr(sa, a)
+		check(err)
+		got, err := OS.(Xattrer).GetXattr(da, a)
+		check(err)
+		if string(got) != string(want) {
+			t.Errorf("expecting %s to be %q, got %q", a, want, got)
+		}
+	}
+	if len(events) != 1 || events[0].Path != "a" || events[0].Item != ".f........x" {
+		t.Errorf("expecting a single xattr event, got %+v", events)
+	}
+}
+
+func testOwner(name string, uid, gid int, t *testing.T) {
+	fi, err := os.Stat(name)
+	check(err)
+	st := fi.Sys().(*syscall.Stat_t)
+	if int(st.Uid) != uid || int(st.Gid) != gid {
+		t.Errorf("%s is owned by %d:%d, should be %d:%d", name, st.Uid, st.Gid, uid, gid)
+	}
+}
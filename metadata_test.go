@@ -0,0 +1,51 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSyncMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	for _, root := range []string{src, dst} {
+		check(os.MkdirAll(filepath.Join(root, "sub"), 0755))
+		check(ioutil.WriteFile(filepath.Join(root, "sub/a"), []byte("file a"), 0644))
+		check(ioutil.WriteFile(filepath.Join(root, "b"), []byte("file b"), 0644))
+	}
+	check(ioutil.WriteFile(filepath.Join(src, "c"), []byte("file c"), 0644))
+	check(ioutil.WriteFile(filepath.Join(dst, "b"), []byte("other b"), 0644))
+	check(ioutil.WriteFile(filepath.Join(dst, "d"), []byte("file d"), 0644))
+	check(os.Chmod(filepath.Join(src, "sub/a"), 0600))
+	check(os.Chmod(filepath.Join(src, "sub"), 0700))
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, name := range []string{"sub/a", "b", "sub", ""} {
+		check(os.Chtimes(filepath.Join(src, name), old, old))
+	}
+
+	// a dry run changes nothing
+	s := NewSyncer()
+	s.DryRun = true
+	_, err = s.SyncMetadata(dst, src, MetadataOptions{})
+	check(err)
+	testPerms(filepath.Join(dst, "sub/a"), 0644, t)
+
+	s.DryRun = false
+	_, err = s.SyncMetadata(dst, src, MetadataOptions{})
+	check(err)
+	testPerms(filepath.Join(dst, "sub/a"), 0600, t)
+	testPerms(filepath.Join(dst, "sub"), 0700, t)
+	for _, name := range []string{"sub/a", "b", "sub", ""} {
+		testModTime(filepath.Join(dst, name), old, t)
+	}
+	// contents are left alone, and nothing is copied or deleted
+	testFile(filepath.Join(dst, "b"), []byte("other b"), t)
+	testExistence(filepath.Join(dst, "c"), false, t)
+	testExistence(filepath.Join(dst, "d"), true, t)
+}
@@ -0,0 +1,269 @@
+package mirror
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mostafah/fsync"
+)
+
+func init() {
+	fsync.Register("mirror", Open)
+	fsync.Register("mirrors", Open)
+}
+
+// FS is a read-only fsync.FS on a mirror.
+type FS struct {
+	base   *url.URL // names are appended to its path
+	root   string   // name of the directory of the manifest
+	key    ed25519.PublicKey
+	client *http.Client
+
+	once     sync.Once
+	err      error
+	entries  map[string]*entry   // by path relative to root
+	children map[string][]*entry // by parent
+}
+
+// New returns an FS for the mirror at rawurl, an http or https URL of the
+// directory of the manifest, which must be signed with key. Names are
+// relative to that directory. If client is nil, http.DefaultClient is used.
+func New(rawurl string, key ed25519.PublicKey, client *http.Client) (*FS, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return &FS{base: u, root: "/", key: key, client: client}, nil
+}
+
+// Open returns an FS for the mirror in u, with the key in its query. Names
+// passed to the FS are paths on the server, as the path of u is.
+func Open(u *url.URL) (fsync.FS, error) {
+	k := u.Query().Get("key")
+	if k == "" {
+		return nil, ErrNoKey
+	}
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k, "="))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("mirror: invalid public key %q", k)
+	}
+	base := *u
+	base.Scheme = "http"
+	if u.Scheme == "mirrors" {
+		base.Scheme = "https"
+	}
+	base.Path, base.RawQuery = "", ""
+	fs, err := New(base.String(), key, nil)
+	if err != nil {
+		return nil, err
+	}
+	fs.root = path.Clean("/" + u.Path)
+	return fs, nil
+}
+
+// url returns the URL of the path p.
+func (fs *FS) url(p string) string {
+	u := *fs.base
+	u.Path += p
+	return u.String()
+}
+
+// rel returns the path of name relative to the root, and false if it's
+// outside it.
+func (fs *FS) rel(name string) (string, bool) {
+	p := path.Clean("/" + filepath.ToSlash(name))
+	if p == fs.root {
+		return ".", true
+	}
+	prefix := strings.TrimSuffix(fs.root, "/") + "/"
+	if !strings.HasPrefix(p, prefix) {
+		return "", false
+	}
+	return p[len(prefix):], true
+}
+
+// load downloads and checks the manifest the first time it's called.
+func (fs *FS) load() error {
+	fs.once.Do(func() {
+		fs.err = fs.fetchManifest()
+	})
+	return fs.err
+}
+
+func (fs *FS) fetchManifest() error {
+	resp, err := fs.client.Get(fs.url(path.Join(fs.root, ManifestName)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("mirror: getting the manifest: %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	m, err := parseManifest(data, fs.key)
+	if err != nil {
+		return err
+	}
+	fs.entries = make(map[string]*entry, len(m.Files))
+	fs.children = make(map[string][]*entry)
+	for _, e := range m.Files {
+		e.Path = path.Clean(e.Path)
+		if e.Path == ".." || strings.HasPrefix(e.Path, "../") || path.IsAbs(e.Path) {
+			return fmt.Errorf("mirror: invalid path %q in the manifest", e.Path)
+		}
+		fs.entries[e.Path] = e
+		if e.Path != "." {
+			dir := path.Dir(e.Path)
+			fs.children[dir] = append(fs.children[dir], e)
+		}
+	}
+	for _, c := range fs.children {
+		sort.Slice(c, func(i, j int) bool { return c[i].Path < c[j].Path })
+	}
+	return nil
+}
+
+// lookup returns the entry of name.
+func (fs *FS) lookup(op, name string) (*entry, error) {
+	if err := fs.load(); err != nil {
+		return nil, err
+	}
+	rel, ok := fs.rel(name)
+	if e := fs.entries[rel]; ok && e != nil {
+		return e, nil
+	}
+	return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	e, err := fs.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info{e}, nil
+}
+
+func (fs *FS) ReadDir(name string) ([]os.FileInfo, error) {
+	e, err := fs.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.Dir {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: fmt.Errorf("not a directory")}
+	}
+	var infos []os.FileInfo
+	for _, c := range fs.children[e.Path] {
+		infos = append(infos, info{c})
+	}
+	return infos, nil
+}
+
+// Open downloads name. Reading it fails with ErrChecksum at the end if the
+// contents don't match the manifest.
+func (fs *FS) Open(name string) (io.ReadCloser, error) {
+	e, err := fs.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if e.Dir {
+		return nil, &os.PathError{Op: "open", Path: name, Err: fmt.Errorf("is a directory")}
+	}
+	resp, err := fs.client.Get(fs.url(path.Join(fs.root, e.Path)))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		return nil, fmt.Errorf("mirror: GET %s: %s", name, resp.Status)
+	}
+	return &reader{ReadCloser: resp.Body, e: e, h: sha256.New()}, nil
+}
+
+// reader checks a download against its entry.
+type reader struct {
+	io.ReadCloser
+	e *entry
+	h hash.Hash
+	n int64
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.h.Write(p[:n])
+	r.n += int64(n)
+	if r.n > r.e.Size {
+		return n, ErrChecksum
+	}
+	if err == io.EOF && (r.n != r.e.Size || hex.EncodeToString(r.h.Sum(nil)) != r.e.Sha256) {
+		return n, ErrChecksum
+	}
+	return n, err
+}
+
+// NewHash returns a SHA-256 hash; see fsync.Hasher.
+func (fs *FS) NewHash() hash.Hash {
+	return sha256.New()
+}
+
+// Hash returns the checksum of name in the manifest.
+func (fs *FS) Hash(name string) ([]byte, error) {
+	e, err := fs.lookup("hash", name)
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(e.Sha256)
+}
+
+func (fs *FS) Create(name string) (io.WriteCloser, error)   { return nil, ErrReadOnly }
+func (fs *FS) MkdirAll(name string, perm os.FileMode) error { return ErrReadOnly }
+func (fs *FS) Remove(name string) error                     { return ErrReadOnly }
+func (fs *FS) RemoveAll(name string) error                  { return ErrReadOnly }
+func (fs *FS) Rename(oldname, newname string) error         { return ErrReadOnly }
+func (fs *FS) Chmod(name string, mode os.FileMode) error    { return ErrReadOnly }
+func (fs *FS) Chtimes(name string, atime, mtime time.Time) error {
+	return ErrReadOnly
+}
+
+// info is the os.FileInfo of an entry.
+type info struct {
+	e *entry
+}
+
+func (i info) Name() string       { return path.Base(i.e.Path) }
+func (i info) Size() int64        { return i.e.Size }
+func (i info) ModTime() time.Time { return i.e.Mtime }
+func (i info) IsDir() bool        { return i.e.Dir }
+func (i info) Sys() interface{}   { return nil }
+
+func (i info) Mode() os.FileMode {
+	m := os.FileMode(i.e.Mode).Perm()
+	if i.e.Dir {
+		m |= os.ModeDir
+	}
+	return m
+}
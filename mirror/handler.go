@@ -0,0 +1,96 @@
+package mirror
+
+import (
+	"crypto/ed25519"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Handler serves a directory as a mirror. The manifest is built for every
+// request of it, so it's always up to date, but the checksums of files that
+// didn't change since the last one are reused. As in the manifest, symbolic
+// links are left out.
+type Handler struct {
+	root string
+	key  ed25519.PrivateKey
+
+	mu    sync.Mutex // serializes builds
+	cache hashCache
+}
+
+// NewHandler returns a Handler serving the directory root, with manifests
+// signed with key. Its paths are relative to root; use http.StripPrefix to
+// serve it under a prefix.
+func NewHandler(root string, key ed25519.PrivateKey) *Handler {
+	return &Handler{root: root, key: key}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p := path.Clean("/" + r.URL.Path)
+	if p == "/"+ManifestName {
+		h.mu.Lock()
+		m, cache, err := build(h.root, h.cache)
+		if err == nil {
+			h.cache = cache
+		}
+		h.mu.Unlock()
+		var data []byte
+		if err == nil {
+			data, err = m.sign(h.key)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(data)
+		return
+	}
+
+	f, err := h.open(p)
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !fi.Mode().IsRegular() {
+		http.NotFound(w, r) // directories aren't listed
+		return
+	}
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+// open opens the file at the slash-separated path p under root. Symbolic
+// links aren't mirrored, so that files outside root can't be reached
+// through them, and are reported missing, as is what's under them.
+func (h *Handler) open(p string) (*os.File, error) {
+	name := h.root
+	for _, elem := range strings.Split(strings.TrimPrefix(p, "/"), "/") {
+		name = filepath.Join(name, elem)
+		fi, err := os.Lstat(name)
+		if err != nil {
+			return nil, err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+	}
+	return os.Open(name)
+}
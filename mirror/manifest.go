@@ -0,0 +1,175 @@
+// Package mirror serves directories over HTTP for clients to pull from, as
+// apt-mirror and zsync do, and provides the backend for those clients.
+//
+// A mirror publishes a manifest listing every file with its size,
+// modification time and SHA-256 checksum, signed with an Ed25519 key. It can
+// be served by Handler, or written with WriteManifest and served along with
+// the files by any web server. Clients check the signature with the public
+// key, and each file they download against its checksum, so mirrors can be
+// hosted on untrusted servers and CDNs.
+//
+// Importing the package registers the "mirror" and "mirrors" URL schemes,
+// which use HTTP and HTTPS respectively. The path is the directory of the
+// manifest and the key query parameter is the public key, encoded with
+// base64.RawURLEncoding:
+//
+//	import _ "github.com/mostafah/fsync/mirror"
+//
+//	err := fsync.Sync("site", "mirrors://mirror.example.com/site?key="+key)
+//
+// Files are compared with the checksums in the manifest (see fsync.Hasher),
+// so only files that changed are downloaded.
+package mirror
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ManifestName is the name of the manifest in the directory it lists.
+const ManifestName = ".fsync-manifest"
+
+var (
+	ErrNoKey     = errors.New("mirror: no public key in the URL")
+	ErrSignature = errors.New("mirror: manifest signature is invalid")
+	ErrChecksum  = errors.New("mirror: file doesn't match the manifest")
+	ErrReadOnly  = errors.New("mirror: mirrors are read-only")
+)
+
+// manifest lists the files of a mirror. It is encoded as JSON, preceded by a
+// line with the base64 encoded signature of the JSON.
+type manifest struct {
+	Files []*entry `json:"files"`
+}
+
+// entry is a file or directory in a manifest.
+type entry struct {
+	Path   string    `json:"path"` // slash-separated; "." is the root
+	Dir    bool      `json:"dir,omitempty"`
+	Size   int64     `json:"size"`
+	Mode   uint32    `json:"mode"` // permission bits
+	Mtime  time.Time `json:"mtime"`
+	Sha256 string    `json:"sha256,omitempty"` // hex
+}
+
+// hashCache keeps the checksums of files, so that unchanged files don't
+// have to be read again.
+type hashCache map[string]cachedHash
+
+type cachedHash struct {
+	size  int64
+	mtime time.Time
+	sum   string
+}
+
+// build lists the tree root, reusing checksums in old for files whose size
+// and modification time didn't change. It returns the checksums of the
+// files in the tree to be passed to the next build.
+func build(root string, old hashCache) (*manifest, hashCache, error) {
+	m := &manifest{}
+	cache := make(hashCache)
+	err := filepath.Walk(root, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == ManifestName || rel == ManifestName+".tmp" {
+			return nil
+		}
+		e := &entry{
+			Path:  rel,
+			Dir:   fi.IsDir(),
+			Mode:  uint32(fi.Mode().Perm()),
+			Mtime: fi.ModTime(),
+		}
+		if !fi.Mode().IsRegular() {
+			if e.Dir {
+				m.Files = append(m.Files, e)
+			}
+			return nil // other files aren't mirrored
+		}
+		e.Size = fi.Size()
+		if c, ok := old[rel]; ok && c.size == e.Size && c.mtime.Equal(e.Mtime) {
+			e.Sha256 = c.sum
+		} else {
+			f, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			h := sha256.New()
+			_, err = io.Copy(h, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			e.Sha256 = hex.EncodeToString(h.Sum(nil))
+		}
+		cache[rel] = cachedHash{e.Size, e.Mtime, e.Sha256}
+		m.Files = append(m.Files, e)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, cache, nil
+}
+
+// sign encodes m with its signature.
+func (m *manifest) sign(key ed25519.PrivateKey) ([]byte, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+	return append([]byte(sig+"\n"), data...), nil
+}
+
+// parseManifest checks the signature of data, made by sign, and decodes it.
+func parseManifest(data []byte, key ed25519.PublicKey) (*manifest, error) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return nil, ErrSignature
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(data[:i]))
+	if err != nil || !ed25519.Verify(key, data[i+1:], sig) {
+		return nil, ErrSignature
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(data[i+1:], m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WriteManifest writes the signed manifest of the tree root into it, so that
+// it can be served as a mirror by any web server. It must be written again
+// after the tree changes.
+func WriteManifest(root string, key ed25519.PrivateKey) error {
+	m, _, err := build(root, nil)
+	if err != nil {
+		return err
+	}
+	data, err := m.sign(key)
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(root, ManifestName)
+	if err := ioutil.WriteFile(name+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
@@ -0,0 +1,129 @@
+package mirror
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mostafah/fsync"
+)
+
+func TestSync(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "mirror_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	src := filepath.Join(root, "src")
+	if err := os.MkdirAll(filepath.Join(src, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "a/b"), []byte("file b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "c"), []byte("file c"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := base64.RawURLEncoding.EncodeToString(pub)
+
+	ts := httptest.NewServer(NewHandler(src, priv))
+	defer ts.Close()
+	u := strings.Replace(ts.URL, "http", "mirror", 1) + "/?key=" + key
+	dst := filepath.Join(root, "dst")
+	if err := fsync.Sync(dst, u); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"a/b": "file b", "c": "file c"} {
+		b, err := ioutil.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != content {
+			t.Errorf("%s is %q, should be %q", name, b, content)
+		}
+	}
+
+	// a static mirror
+	if err := WriteManifest(src, priv); err != nil {
+		t.Fatal(err)
+	}
+	static := httptest.NewServer(http.StripPrefix("/pub", http.FileServer(http.Dir(src))))
+	defer static.Close()
+	u = strings.Replace(static.URL, "http", "mirror", 1) + "/pub?key=" + key
+	if err := fsync.Sync(filepath.Join(root, "static"), u); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "static", ManifestName)); !os.IsNotExist(err) {
+		t.Errorf("the manifest shouldn't be synced, got %v", err)
+	}
+
+	// files that don't match the manifest are refused
+	if err := ioutil.WriteFile(filepath.Join(src, "c"), []byte("file C"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsync.Sync(filepath.Join(root, "bad"), u); err != ErrChecksum {
+		t.Errorf("expecting ErrChecksum, got %v", err)
+	}
+
+	// and so are manifests signed with another key
+	other, _, _ := ed25519.GenerateKey(nil)
+	u = strings.Replace(ts.URL, "http", "mirror", 1) + "/?key=" + base64.RawURLEncoding.EncodeToString(other)
+	if err := fsync.Sync(filepath.Join(root, "bad"), u); err != ErrSignature {
+		t.Errorf("expecting ErrSignature, got %v", err)
+	}
+}
+
+func TestHandlerSymlinks(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "mirror_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	src := filepath.Join(root, "src")
+	out := filepath.Join(root, "out")
+	if err := os.MkdirAll(filepath.Join(src, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "a/b"), []byte("file b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(out, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(out, "secret"), filepath.Join(src, "x")); err != nil {
+		t.Skip(err)
+	}
+	if err := os.Symlink(out, filepath.Join(src, "d")); err != nil {
+		t.Fatal(err)
+	}
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(NewHandler(src, priv))
+	defer ts.Close()
+
+	// what's behind symbolic links isn't served
+	for p, code := range map[string]int{"/a/b": 200, "/x": 404, "/d/secret": 404} {
+		resp, err := http.Get(ts.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Errorf("GET %s returned %d, should return %d", p, resp.StatusCode, code)
+		}
+	}
+}
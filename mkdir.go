@@ -0,0 +1,37 @@
+package fsync
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// mkdirAll makes the destination directory dst and those missing above it
+// with MkdirMode. Unless NoPerms is set, they're then given it, as DirMode
+// and PermMask change it, whatever the umask, since those with no source
+// counterpart aren't synced.
+func (r *run) mkdirAll(dst string) {
+	perm := r.MkdirMode.Perm()
+	if perm == 0 {
+		perm = 0755
+	}
+	var made []string
+	for d := dst; ; d = filepath.Dir(d) {
+		if _, err := r.dfs.Stat(d); !os.IsNotExist(err) {
+			break
+		}
+		made = append(made, d)
+		if d == filepath.Dir(d) {
+			break
+		}
+	}
+	check(r.dfs.MkdirAll(dst, perm))
+	if r.NoPerms {
+		return
+	}
+	if r.DirMode != 0 {
+		perm = r.DirMode.Perm()
+	}
+	for _, d := range made {
+		check(r.dfs.Chmod(d, perm&^r.PermMask))
+	}
+}
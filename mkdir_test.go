@@ -0,0 +1,32 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMkdirMode(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	check(os.MkdirAll(filepath.Join(src, "a"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a/b"), []byte("file b"), 0644))
+	check(os.Chmod(filepath.Join(src, "a"), 0750))
+
+	s := NewSyncer()
+	s.MkdirMode = 0711
+	check(s.Sync(filepath.Join(dir, "x/y/dst"), src))
+	testPerms(filepath.Join(dir, "x"), 0711, t)
+	testPerms(filepath.Join(dir, "x/y"), 0711, t)
+	testPerms(filepath.Join(dir, "x/y/dst/a"), 0750, t)
+
+	// parents are given the permissions whatever the umask
+	s.MkdirMode = 0777
+	s.PermMask = 0002
+	check(s.Sync(filepath.Join(dir, "z/dst"), src))
+	testPerms(filepath.Join(dir, "z"), 0775, t)
+	testPerms(filepath.Join(dir, "z/dst/a"), 0750, t)
+}
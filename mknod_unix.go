@@ -0,0 +1,10 @@
+//go:build linux || darwin || netbsd || openbsd
+// +build linux darwin netbsd openbsd
+
+package fsync
+
+import "golang.org/x/sys/unix"
+
+func mknod(name string, mode uint32, dev uint64) error {
+	return unix.Mknod(name, mode, int(dev))
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package fsync
+
+import "os"
+
+// owner returns false, as files have no Unix owners on this platform.
+func owner(fi os.FileInfo) (uid, gid int, ok bool) { return 0, 0, false }
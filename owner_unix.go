@@ -0,0 +1,21 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package fsync
+
+import (
+	"os"
+	"syscall"
+)
+
+func (osFS) Chown(name string, uid, gid int) error { return os.Chown(name, uid, gid) }
+
+// owner returns the user and group IDs of the file described by fi, if the
+// platform exposes them.
+func owner(fi os.FileInfo) (uid, gid int, ok bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
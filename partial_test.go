@@ -0,0 +1,31 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPartialStats(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "a"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a/b"), []byte("file b"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "a/denied"), []byte("file denied"), 0644))
+
+	s := NewSyncer()
+	s.SrcFS = denyFS{}
+	stats, err := s.SyncStats(dst, src)
+	if err == nil {
+		t.Fatal("expecting a/denied to fail")
+	}
+	want := Stats{Files: 1, Bytes: 6, Dirs: 2, Created: 1, Seed: stats.Seed, Last: "a/denied"}
+	if stats != want {
+		t.Errorf("expecting %+v, got %+v", want, stats)
+	}
+	testFile(filepath.Join(dst, "a/b"), []byte("file b"), t)
+}
@@ -0,0 +1,45 @@
+package fsync
+
+// Pause stops the syncs in progress, and those started later, from starting
+// on more files until Resume is called. Files being copied are finished, so
+// nothing is lost; the syncs just wait. It's meant to relieve I/O pressure
+// for a while, and is safe to call from any goroutine.
+func (s *Syncer) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.resume == nil {
+		s.resume = make(chan struct{})
+	}
+}
+
+// Resume lets paused syncs go on.
+func (s *Syncer) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.resume != nil {
+		close(s.resume)
+		s.resume = nil
+	}
+}
+
+// Paused returns true if Pause was called without a Resume after it.
+func (s *Syncer) Paused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resume != nil
+}
+
+// wait blocks while the Syncer is paused, and panics with the error of the
+// context of the sync once it's done.
+func (r *run) wait() {
+	r.mu.Lock()
+	resume := r.resume
+	r.mu.Unlock()
+	if resume != nil {
+		select {
+		case <-resume:
+		case <-r.ctx.Done():
+		}
+	}
+	check(r.ctx.Err())
+}
@@ -0,0 +1,60 @@
+package fsync
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pauseFS pauses a Syncer when the first file is created in it.
+type pauseFS struct {
+	FS
+	s      *Syncer
+	once   sync.Once
+	paused chan bool
+}
+
+func (fs *pauseFS) Create(name string) (io.WriteCloser, error) {
+	fs.once.Do(func() {
+		fs.s.Pause()
+		fs.paused <- true
+	})
+	return fs.FS.Create(name)
+}
+
+func TestPause(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	for i := 0; i < 5; i++ {
+		check(ioutil.WriteFile(filepath.Join(src, fmt.Sprint(i)), []byte("file"), 0644))
+	}
+
+	s := NewSyncer()
+	s.Workers = 1
+	s.DstFS = &pauseFS{FS: OS, s: s, paused: make(chan bool, 1)}
+	done := make(chan error)
+	go func() { done <- s.Sync(dst, src) }()
+	<-s.DstFS.(*pauseFS).paused
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("sync finished while paused: %v", err)
+	default:
+	}
+	// only the file being copied when paused is finished
+	if files, _ := ioutil.ReadDir(dst); len(files) != 1 {
+		t.Errorf("expecting 1 file while paused, got %d.\n", len(files))
+	}
+	s.Resume()
+	check(<-done)
+	testDirContents(dst, 5, t)
+}
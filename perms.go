@@ -0,0 +1,34 @@
+package fsync
+
+import "os"
+
+// permBits are the bits of a file mode that are synced as permissions: the
+// setuid, setgid and sticky bits too, unless NoSpecialBits clears them.
+const (
+	specialBits = os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+	permBits    = os.ModePerm | specialBits
+)
+
+// perm returns the permissions the destination counterpart of the source
+// file or directory with the file info sstat is given, as FileMode, DirMode
+// and PermMask decide.
+func (r *run) perm(sstat os.FileInfo) os.FileMode {
+	perm := sstat.Mode()
+	if sstat.IsDir() && r.DirMode != 0 {
+		perm = r.DirMode
+	} else if !sstat.IsDir() && r.FileMode != 0 {
+		perm = r.FileMode
+	}
+	perm &= permBits &^ r.PermMask
+	if r.NoSpecialBits {
+		perm &^= specialBits
+	}
+	return perm
+}
+
+// permDiffers returns true if the destination file or directory with the
+// file info dstat doesn't have the permissions its source, with sstat,
+// gives it, unless NoPerms leaves them alone.
+func (r *run) permDiffers(dstat, sstat os.FileInfo) bool {
+	return !r.NoPerms && dstat.Mode()&permBits != r.perm(sstat)
+}
@@ -0,0 +1,78 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPermPolicies(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "a"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a/b"), []byte("file b"), 0644))
+	check(os.Chmod(filepath.Join(src, "a"), 0777))
+	check(os.Chmod(filepath.Join(src, "a/b"), 0666))
+
+	s := NewSyncer()
+	s.PermMask = 0022
+	check(s.Sync(dst, src))
+	testPerms(filepath.Join(dst, "a"), 0755, t)
+	testPerms(filepath.Join(dst, "a/b"), 0644, t)
+
+	s.FileMode = 0640
+	s.DirMode = 0750
+	check(s.Sync(dst, src))
+	testPerms(filepath.Join(dst, "a"), 0750, t)
+	testPerms(filepath.Join(dst, "a/b"), 0640, t)
+	if d, err := s.VerifyAll(dst, src); err != nil || len(d.Mismatches) != 0 {
+		t.Errorf("expecting no mismatches, got %v, %v", d, err)
+	}
+
+	// without syncing them, permissions are left alone
+	s = NewSyncer()
+	s.NoPerms = true
+	check(os.Chmod(filepath.Join(src, "a/b"), 0600))
+	check(s.Sync(dst, src))
+	testPerms(filepath.Join(dst, "a/b"), 0640, t)
+}
+
+func TestSpecialBits(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "a"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a/b"), []byte("file b"), 0755))
+	check(os.Chmod(filepath.Join(src, "a"), 0777|os.ModeSticky))
+	check(os.Chmod(filepath.Join(src, "a/b"), 0755|os.ModeSetuid))
+	special := func(name string) os.FileMode {
+		fi, err := os.Stat(filepath.Join(dst, name))
+		check(err)
+		return fi.Mode() & specialBits
+	}
+
+	s := NewSyncer()
+	check(s.Sync(dst, src))
+	if m := special("a"); m != os.ModeSticky {
+		t.Errorf("expecting a to be sticky, got %v", m)
+	}
+	if m := special("a/b"); m != os.ModeSetuid {
+		t.Errorf("expecting a/b to be setuid, got %v", m)
+	}
+	if d, err := s.VerifyAll(dst, src); err != nil || len(d.Mismatches) != 0 {
+		t.Errorf("expecting no mismatches, got %v, %v", d, err)
+	}
+
+	s.NoSpecialBits = true
+	check(s.Sync(dst, src))
+	if special("a") != 0 || special("a/b") != 0 {
+		t.Errorf("expecting the special bits to be cleared")
+	}
+	testPerms(filepath.Join(dst, "a/b"), 0755, t)
+}
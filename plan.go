@@ -0,0 +1,136 @@
+package fsync
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// planLargest is how many of the largest files WriteSummary lists.
+const planLargest = 5
+
+// Plan is what a sync would do, as Syncer.Plan returns.
+type Plan struct {
+	Stats Stats
+	// Events are those a dry run reports at Verbose, in order, with
+	// Path set.
+	Events []Event
+}
+
+// Plan returns what syncing dst with src would do, going through it in a
+// dry run, whatever DryRun is. OnEvent, OnProgress and ProgressFile aren't
+// used.
+func (s *Syncer) Plan(dst, src string) (*Plan, error) {
+	r, dst, src, err := s.newRun(dst, src)
+	if err != nil {
+		return nil, err
+	}
+	r.DryRun = true
+	r.planned = &Plan{}
+	if err := r.do(dst, src); err != nil {
+		return nil, err
+	}
+	r.planned.Stats = r.result()
+	return r.planned, nil
+}
+
+// plan adds e, the event of the source name src, to the plan of r.
+func (r *run) plan(v Verbosity, src string, e Event) {
+	if v > Verbose {
+		return
+	}
+	if src != "" {
+		e.Path = r.rel(src)
+	}
+	r.planned.Events = append(r.planned.Events, e)
+}
+
+// WriteSummary writes a summary of p to w: the totals, what's copied and
+// deleted under each directory at the top of the tree, and the largest
+// files copied.
+func (p *Plan) WriteSummary(w io.Writer) error {
+	type group struct {
+		copies, deletions, dirs int
+		bytes                   int64
+	}
+	groups := make(map[string]*group)
+	var copies []Event
+	for _, e := range p.Events {
+		// files at the top are grouped together, as "."
+		top := "."
+		if i := strings.IndexByte(e.Path, '/'); i >= 0 {
+			top = e.Path[:i]
+		} else if e.Op == OpMkdir {
+			top = e.Path
+		}
+		g := groups[top]
+		if g == nil {
+			g = &group{}
+			groups[top] = g
+		}
+		switch e.Op {
+		case OpCopy:
+			g.copies++
+			g.bytes += e.Size
+			copies = append(copies, e)
+		case OpDelete:
+			g.deletions++
+		case OpMkdir:
+			g.dirs++
+		}
+	}
+
+	st := p.Stats
+	fmt.Fprintf(w, "copy %d files (%s), create %d directories, delete %d, move %d, %d unchanged\n",
+		st.Files, sizeString(st.Bytes), st.Dirs, st.Deleted, st.Moved, st.Unchanged)
+	tops := make([]string, 0, len(groups))
+	for top := range groups {
+		tops = append(tops, top)
+	}
+	sort.Strings(tops)
+	for _, top := range tops {
+		g := groups[top]
+		var parts []string
+		if g.copies > 0 {
+			parts = append(parts, fmt.Sprintf("%d copied (%s)", g.copies, sizeString(g.bytes)))
+		}
+		if g.dirs > 0 {
+			parts = append(parts, fmt.Sprintf("%d directories created", g.dirs))
+		}
+		if g.deletions > 0 {
+			parts = append(parts, fmt.Sprintf("%d deleted", g.deletions))
+		}
+		if len(parts) > 0 {
+			fmt.Fprintf(w, "  %s: %s\n", path.Clean(top+"/"), strings.Join(parts, ", "))
+		}
+	}
+
+	sort.SliceStable(copies, func(i, j int) bool { return copies[i].Size > copies[j].Size })
+	if len(copies) > planLargest {
+		copies = copies[:planLargest]
+	}
+	if len(copies) > 0 {
+		fmt.Fprintf(w, "largest:\n")
+	}
+	var err error
+	for _, e := range copies {
+		_, err = fmt.Fprintf(w, "  %10s  %s\n", sizeString(e.Size), e.Path)
+	}
+	return err
+}
+
+// sizeString returns n bytes in binary units.
+func sizeString(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,45 @@
+package fsync
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlanSummary(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "a/b"), 0755))
+	check(os.MkdirAll(dst, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a/b/x"), make([]byte, 3000), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "a/y"), []byte("file y"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "z"), []byte("file z"), 0644))
+	check(ioutil.WriteFile(filepath.Join(dst, "w"), []byte("file w"), 0644))
+
+	s := NewSyncer()
+	s.Delete = true
+	p, err := s.Plan(dst, src)
+	check(err)
+	testDirContents(dst, 1, t)
+	if p.Stats.Files != 3 || p.Stats.Deleted != 1 || len(p.Events) != 6 {
+		t.Errorf("unexpected plan %+v", p)
+	}
+	var b bytes.Buffer
+	check(p.WriteSummary(&b))
+	want := `copy 3 files (2.9 KiB), create 2 directories, delete 1, move 0, 0 unchanged
+  .: 1 copied (6 B), 1 deleted
+  a: 2 copied (2.9 KiB), 2 directories created
+largest:
+     2.9 KiB  a/b/x
+         6 B  a/y
+         6 B  z
+`
+	if b.String() != want {
+		t.Errorf("expecting\n%s\ngot\n%s", want, b.String())
+	}
+}
@@ -0,0 +1,24 @@
+package fsync
+
+import (
+	"io"
+	"os"
+)
+
+// preallocate allocates the space of the source file src for the
+// destination file df, with Preallocate, before it's copied, if df is a
+// local file. It panics with the error of the file system, such as ENOSPC
+// if there isn't enough space, unless it can't preallocate.
+func (r *run) preallocate(df io.Writer, src string) {
+	f, ok := df.(*os.File)
+	if !r.Preallocate || !ok {
+		return
+	}
+	sstat, err := r.sfs.Stat(src)
+	if err != nil || sstat.Size() == 0 {
+		return // the copy finds out
+	}
+	if err := preallocate(f, sstat.Size()); err != nil {
+		panic(&os.PathError{Op: "preallocate", Path: f.Name(), Err: err})
+	}
+}
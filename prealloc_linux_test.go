@@ -0,0 +1,44 @@
+package fsync
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestPreallocate(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	data := bytes.Repeat([]byte("data"), 1<<18)
+	check(ioutil.WriteFile(filepath.Join(src, "a"), data, 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "empty"), nil, 0644))
+
+	s := NewSyncer()
+	s.Preallocate = true
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "a"), data, t)
+	testFile(filepath.Join(dst, "empty"), nil, t)
+
+	// the space is allocated without changing the size
+	f, err := os.Create(filepath.Join(dir, "f"))
+	check(err)
+	defer f.Close()
+	r, _, _, err := s.newRun(dst, src)
+	check(err)
+	r.preallocate(f, filepath.Join(src, "a"))
+	fi, err := f.Stat()
+	check(err)
+	if fi.Size() != 0 {
+		t.Errorf("expecting the size to stay 0, got %d", fi.Size())
+	}
+	if b := fi.Sys().(*syscall.Stat_t).Blocks; b != 0 && b < int64(len(data))/512 {
+		t.Errorf("expecting %d bytes allocated, got %d blocks", len(data), b)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package fsync
+
+import "os"
+
+// preallocate does nothing, as there's no way to on this platform.
+func preallocate(f *os.File, size int64) error { return nil }
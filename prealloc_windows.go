@@ -0,0 +1,19 @@
+package fsync
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// preallocate sets the allocation size of f, as SetEndOfFile would without
+// moving the end of the file.
+func preallocate(f *os.File, size int64) error {
+	err := windows.SetFileInformationByHandle(windows.Handle(f.Fd()), windows.FileAllocationInfo,
+		(*byte)(unsafe.Pointer(&size)), uint32(unsafe.Sizeof(size)))
+	if err == windows.ERROR_DISK_FULL {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package fsync
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// rateWindow is about how far back Progress.Rate looks.
+const rateWindow = 10 * time.Second
+
+// Progress describes how far a sync is.
+type Progress struct {
+	Src  string // source of the sync
+	File string // the file copied last, in the source
+	// Files and Bytes are the number and total size of the files copied so
+	// far.
+	Files int
+	Bytes int64
+	// TotalFiles and TotalBytes count the files found to need copying. They
+	// grow while Scanning; with Workers the scan gets ahead of the copies,
+	// so they are known long before the copies finish.
+	TotalFiles int
+	TotalBytes int64
+	// Scanning is true until the whole source has been compared.
+	Scanning bool
+	// Elapsed is the time since the sync started.
+	Elapsed time.Duration
+	// Rate is the number of bytes copied per second, smoothed over about
+	// the last ten seconds so that it doesn't jump with each file.
+	Rate float64
+	// ETA is the estimated time until the copies found so far are done,
+	// from the bytes left and Rate. It's negative until there is a Rate.
+	ETA time.Duration
+	// Buffers is the number of copy buffers allocated so far, and
+	// BufferReuses the number of times one was reused instead; see
+	// Syncer.BufferSize.
+	Buffers      int
+	BufferReuses int
+}
+
+// Status returns the progress of the syncs in progress.
+func (s *Syncer) Status() []Progress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ps []Progress
+	for r := range s.runs {
+		ps = append(ps, r.progress.get(r.clock().Now()))
+	}
+	return ps
+}
+
+// tracker keeps the Progress of a run.
+type tracker struct {
+	mu      sync.Mutex
+	p       Progress
+	start   time.Time
+	sampled time.Time // time of the last rate sample
+	bytes   int64     // Bytes at the last rate sample
+
+	items   map[string]string // itemized changes of the files found to need copying
+	created int               // files copied that were new
+}
+
+func (t *tracker) begin(src string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p = Progress{Src: src, Scanning: true}
+	t.start, t.sampled = now, now
+}
+
+// found counts a file of size bytes that needs copying.
+func (t *tracker) found(size int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.TotalFiles++
+	t.p.TotalBytes += size
+}
+
+// changing records the itemized change of the file src, found to need
+// copying.
+func (t *tracker) changing(src, item string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.items == nil {
+		t.items = make(map[string]string)
+	}
+	t.items[src] = item
+}
+
+// item returns the itemized change of the file src, if it's to be copied.
+func (t *tracker) item(src string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.items[src]
+}
+
+// buffered counts a copy buffer allocated, or reused.
+func (t *tracker) buffered(reused bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if reused {
+		t.p.BufferReuses++
+	} else {
+		t.p.Buffers++
+	}
+}
+
+// scanned records the end of the scan.
+func (t *tracker) scanned() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.Scanning = false
+}
+
+// copied counts the file src of n bytes that was copied, and returns the
+// progress.
+func (t *tracker) copied(src string, n int64, now time.Time) Progress {
+	t.mu.Lock()
+	t.p.File = src
+	t.p.Files++
+	t.p.Bytes += n
+	if item, ok := t.items[src]; ok {
+		delete(t.items, src)
+		if item == itemNew {
+			t.created++
+		}
+	}
+	if dt := now.Sub(t.sampled); dt >= time.Second/2 {
+		rate := float64(t.p.Bytes-t.bytes) / dt.Seconds()
+		if t.p.Rate == 0 {
+			t.p.Rate = rate
+		} else {
+			t.p.Rate += (1 - math.Exp(-float64(dt)/float64(rateWindow))) * (rate - t.p.Rate)
+		}
+		t.sampled, t.bytes = now, t.p.Bytes
+	}
+	t.mu.Unlock()
+	return t.get(now)
+}
+
+// news returns the number of files copied that were new.
+func (t *tracker) news() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.created
+}
+
+// get returns the progress at now.
+func (t *tracker) get(now time.Time) Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := t.p
+	p.Elapsed = now.Sub(t.start)
+	p.ETA = -1
+	if left := p.TotalBytes - p.Bytes; left <= 0 && p.Files >= p.TotalFiles {
+		p.ETA = 0
+	} else if p.Rate > 0 {
+		p.ETA = time.Duration(float64(left) / p.Rate * float64(time.Second))
+	}
+	return p
+}
+
+// copied records a copy of n bytes of src and reports it to OnEvent and
+// OnProgress.
+func (r *run) copied(src string, n int64) {
+	r.emit(Verbose, src, Event{Op: OpCopy, Size: n, Item: r.progress.item(src)})
+	p := r.progress.copied(src, n, r.clock().Now())
+	if r.OnProgress != nil && r.planned == nil {
+		r.OnProgress(p)
+	}
+	r.report(false, nil)
+}
@@ -0,0 +1,66 @@
+package fsync
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	for i := 0; i < 3; i++ {
+		check(ioutil.WriteFile(filepath.Join(src, fmt.Sprint(i)), make([]byte, 100), 0644))
+	}
+
+	var mu sync.Mutex
+	var last Progress
+	s := NewSyncer()
+	s.Workers = 2
+	s.OnProgress = func(p Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		if p.Files > last.Files {
+			last = p
+		}
+	}
+	check(s.Sync(dst, src))
+	if last.Files != 3 || last.Bytes != 300 || last.TotalFiles != 3 || last.TotalBytes != 300 {
+		t.Errorf("wrong final progress: %+v", last)
+	}
+	if last.ETA != 0 {
+		t.Errorf("expecting no time left, got %v", last.ETA)
+	}
+	if len(s.Status()) != 0 {
+		t.Error("expecting no syncs in progress")
+	}
+}
+
+func TestProgressRate(t *testing.T) {
+	var tr tracker
+	t0 := time.Now()
+	tr.begin("src", t0)
+	tr.found(1000)
+	tr.found(100000)
+	tr.found(1000)
+	if p := tr.get(t0); p.ETA >= 0 {
+		t.Errorf("expecting no ETA before the first copy, got %v", p.ETA)
+	}
+	p := tr.copied("a", 1000, t0.Add(time.Second))
+	if p.Rate != 1000 || p.ETA != 101*time.Second {
+		t.Errorf("expecting 1000 bytes/s and 101s left, got %v and %v", p.Rate, p.ETA)
+	}
+	// a burst only moves the rate a bit
+	p = tr.copied("b", 100000, t0.Add(2*time.Second))
+	if p.Rate < 1000 || p.Rate > 20000 {
+		t.Errorf("expecting a smoothed rate, got %v", p.Rate)
+	}
+}
@@ -0,0 +1,98 @@
+package fsync
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// progressFileInterval is how often ProgressFile is written at most while
+// files are copied.
+const progressFileInterval = time.Second
+
+// ProgressReport is what ProgressFile holds, as JSON.
+type ProgressReport struct {
+	PID        int       `json:"pid"`
+	State      string    `json:"state"` // "running", "done" or "failed"
+	Error      string    `json:"error,omitempty"`
+	Src        string    `json:"src"`
+	Dst        string    `json:"dst"`
+	File       string    `json:"file,omitempty"` // the file copied last
+	Files      int       `json:"files"`
+	Bytes      int64     `json:"bytes"`
+	TotalFiles int       `json:"total_files"`
+	TotalBytes int64     `json:"total_bytes"`
+	Scanning   bool      `json:"scanning"`
+	Elapsed    float64   `json:"elapsed"` // seconds
+	Rate       float64   `json:"rate"`    // bytes per second
+	ETA        float64   `json:"eta"`     // seconds; negative until known
+	Updated    time.Time `json:"updated"`
+}
+
+// ReadProgressFile reads the ProgressFile of a sync.
+func ReadProgressFile(path string) (*ProgressReport, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p ProgressReport
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// progressFile throttles the writes of ProgressFile.
+type progressFile struct {
+	mu      sync.Mutex
+	written time.Time
+}
+
+// report writes ProgressFile, if set, unless it was written less than
+// progressFileInterval ago. When final, it's written anyway, with the
+// outcome of the sync, err.
+func (r *run) report(final bool, err error) {
+	if r.ProgressFile == "" || r.planned != nil {
+		return
+	}
+	now := r.clock().Now()
+	r.reported.mu.Lock()
+	defer r.reported.mu.Unlock()
+	if !final && !r.reported.written.IsZero() && now.Sub(r.reported.written) < progressFileInterval {
+		return
+	}
+	r.reported.written = now
+	p := r.progress.get(now)
+	rep := ProgressReport{
+		PID:        os.Getpid(),
+		State:      "running",
+		Src:        r.root,
+		Dst:        r.dstRoot,
+		File:       p.File,
+		Files:      p.Files,
+		Bytes:      p.Bytes,
+		TotalFiles: p.TotalFiles,
+		TotalBytes: p.TotalBytes,
+		Scanning:   p.Scanning,
+		Elapsed:    p.Elapsed.Seconds(),
+		Rate:       p.Rate,
+		ETA:        p.ETA.Seconds(),
+		Updated:    now,
+	}
+	if final && err == nil {
+		rep.State = "done"
+	} else if final {
+		rep.State = "failed"
+		rep.Error = err.Error()
+	}
+	data, err := json.Marshal(rep)
+	if err != nil {
+		return
+	}
+	tmp := r.ProgressFile + ".tmp"
+	if ioutil.WriteFile(tmp, data, 0644) == nil {
+		os.Rename(tmp, r.ProgressFile)
+	}
+}
@@ -0,0 +1,48 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProgressFile(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("file a"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "b"), []byte("file b"), 0644))
+
+	s := NewSyncer()
+	s.ProgressFile = filepath.Join(dir, "progress")
+	var during *ProgressReport
+	s.OnProgress = func(Progress) {
+		if during == nil {
+			during, err = ReadProgressFile(s.ProgressFile)
+			check(err)
+		}
+	}
+	check(s.Sync(dst, src))
+	if during == nil || during.State != "running" || during.PID != os.Getpid() || during.Src != src {
+		t.Errorf("unexpected progress while syncing: %+v", during)
+	}
+	p, err := ReadProgressFile(s.ProgressFile)
+	check(err)
+	if p.State != "done" || p.Files != 2 || p.Bytes != 12 || p.Scanning {
+		t.Errorf("unexpected progress after syncing: %+v", p)
+	}
+
+	s.OnProgress = nil
+	if err := s.Sync(dst, filepath.Join(src, "a")); err != ErrFileOverDir {
+		t.Fatalf("expecting ErrFileOverDir, got %v", err)
+	}
+	p, err = ReadProgressFile(s.ProgressFile)
+	check(err)
+	if p.State != "failed" || p.Error != ErrFileOverDir.Error() {
+		t.Errorf("unexpected progress after failing: %+v", p)
+	}
+}
@@ -0,0 +1,29 @@
+package fsync
+
+import "os"
+
+// protection is how Protect keeps a file or directory from being deleted.
+type protection int
+
+const (
+	unprotected     protection = iota
+	protected                  // it's kept
+	protectedInside            // it's a directory with something kept in it
+)
+
+// protection returns how the destination counterpart of the source name
+// src, with the file info info, is protected.
+func (r *run) protection(src string, info os.FileInfo) protection {
+	if len(r.Protect) == 0 {
+		return unprotected
+	}
+	f := Filters{Include: r.Protect}
+	rel := r.rel(src)
+	switch {
+	case f.included(rel, info.IsDir()):
+		return protected
+	case info.IsDir() && f.reaches(rel):
+		return protectedInside
+	}
+	return unprotected
+}
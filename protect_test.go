@@ -0,0 +1,31 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProtect(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	for _, name := range []string{"app.log", "old", "uploads/a/b", "cache/x", "cache/keep.log"} {
+		check(os.MkdirAll(filepath.Join(dst, filepath.Dir(name)), 0755))
+		check(ioutil.WriteFile(filepath.Join(dst, name), []byte(name), 0644))
+	}
+
+	s := NewSyncer()
+	s.Delete = true
+	s.Protect = []string{"*.log", "uploads/**"}
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "app.log"), []byte("app.log"), t)
+	testFile(filepath.Join(dst, "uploads/a/b"), []byte("uploads/a/b"), t)
+	testFile(filepath.Join(dst, "cache/keep.log"), []byte("cache/keep.log"), t)
+	testDirContents(filepath.Join(dst, "cache"), 1, t)
+	testDirContents(dst, 3, t)
+}
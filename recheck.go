@@ -0,0 +1,87 @@
+package fsync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math"
+	"os"
+	"sort"
+	"sync"
+)
+
+// written is what a file in the destination was written as, for Recheck.
+type written struct {
+	src  string
+	size int64
+	perm os.FileMode
+	sum  []byte
+}
+
+// rechecks holds the files a run wrote, by destination name.
+type rechecks struct {
+	mu    sync.Mutex
+	files map[string]*written
+}
+
+// wrote records that the destination file dst was written with n bytes of
+// src, with the checksum sum, if Recheck is set.
+func (r *run) wrote(dst, src string, n int64, sum []byte) {
+	if r.Recheck <= 0 {
+		return
+	}
+	r.rechecks.mu.Lock()
+	defer r.rechecks.mu.Unlock()
+	if r.rechecks.files == nil {
+		r.rechecks.files = make(map[string]*written)
+	}
+	r.rechecks.files[dst] = &written{src: src, size: n, sum: sum}
+}
+
+// chmodded records the permissions of dst, if written.
+func (r *run) chmodded(dst string, perm os.FileMode) {
+	r.rechecks.mu.Lock()
+	defer r.rechecks.mu.Unlock()
+	if w := r.rechecks.files[dst]; w != nil {
+		w.perm = perm
+	}
+}
+
+// recheck compares Recheck% of the files written by r with what they were
+// written as, and reports those that changed since.
+func (r *run) recheck() {
+	files := r.rechecks.files
+	if len(files) == 0 {
+		return
+	}
+	names := make([]string, 0, len(files))
+	for dst := range files {
+		names = append(names, dst)
+	}
+	sort.Strings(names)
+	n := int(math.Ceil(float64(len(names)) * r.Recheck / 100))
+	if n < len(names) {
+		r.rnd.Shuffle(len(names), func(i, j int) { names[i], names[j] = names[j], names[i] })
+		names = names[:n]
+	}
+	for _, dst := range names {
+		w := files[dst]
+		r.stats.Rechecked++
+		if !r.consistent(dst, w) {
+			r.stats.Inconsistent++
+			r.emit(Quiet, w.src, Event{Op: OpInconsistent})
+		}
+	}
+}
+
+// consistent returns true if dst is still as it was written.
+func (r *run) consistent(dst string, w *written) bool {
+	fi, err := r.dfs.Stat(dst)
+	if os.IsNotExist(err) {
+		return false
+	}
+	check(err)
+	if fi.Size() != w.size || w.perm != 0 && fi.Mode()&permBits != w.perm {
+		return false
+	}
+	return w.sum == nil || bytes.Equal(hashFile(r.dfs, dst, sha256.New()), w.sum)
+}
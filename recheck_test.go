@@ -0,0 +1,39 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecheck(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	for _, name := range []string{"a", "b", "c"} {
+		check(ioutil.WriteFile(filepath.Join(src, name), []byte("file "+name), 0644))
+	}
+
+	s := NewSyncer()
+	s.Recheck = 100
+	s.Verbosity = Verbose
+	var inconsistent []string
+	s.OnEvent = func(e Event) {
+		if e.Op == OpInconsistent {
+			inconsistent = append(inconsistent, e.Path)
+		}
+		// another writer changes b once it's copied
+		if e.Op == OpCopy && e.Path == "b" {
+			check(ioutil.WriteFile(filepath.Join(dst, "b"), []byte("file B"), 0644))
+		}
+	}
+	stats, err := s.SyncStats(dst, src)
+	check(err)
+	if stats.Rechecked != 3 || stats.Inconsistent != 1 || len(inconsistent) != 1 || inconsistent[0] != "b" {
+		t.Errorf("expecting b to be inconsistent, got %+v, %v", stats, inconsistent)
+	}
+}
@@ -0,0 +1,109 @@
+package fsync
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+)
+
+// Linker is implemented by file systems that can make hard links, which
+// DetectRenames uses without Delete.
+type Linker interface {
+	Link(oldname, newname string) error
+}
+
+func (osFS) Link(oldname, newname string) error { return os.Link(oldname, newname) }
+
+// moveCandidate is a file recorded in StateFile that may have moved.
+type moveCandidate struct {
+	path string
+	hash string
+}
+
+// movable indexes the files recorded in StateFile by size, for
+// DetectRenames, up to limit of them if it's positive.
+func (st *stateRun) movable(limit int) map[int64][]moveCandidate {
+	bySize := make(map[int64][]moveCandidate)
+	n := 0
+	check(st.old.each(func(p string, e *StateEntry) error {
+		if !e.Dir && e.Hash != "" && (limit <= 0 || n < limit) {
+			bySize[e.Size] = append(bySize[e.Size], moveCandidate{p, e.Hash})
+			n++
+		}
+		return nil
+	}))
+	return bySize
+}
+
+// move looks for a file in the destination that the source file src, new in
+// the destination dst, was moved from since the last sync: one recorded in
+// StateFile with the same size and checksum, that's no longer in the source
+// and is unchanged in the destination. If there's one, it's renamed to dst,
+// or hard-linked without Delete, and move returns true. Within MemoryBudget,
+// files past the first few recorded aren't looked for.
+func (r *run) move(dst, src string, sstat os.FileInfo) bool {
+	if !r.DetectRenames || r.state == nil {
+		return false
+	}
+	if r.renames == nil {
+		r.renames = r.state.movable(r.recordLimit())
+	}
+	candidates := r.renames[sstat.Size()]
+	if len(candidates) == 0 {
+		return false
+	}
+	link, canLink := r.dfs.(Linker)
+	if !r.Delete && !canLink {
+		return false
+	}
+	sum := r.srcSum(src, sstat)
+	hash := hex.EncodeToString(sum)
+	for i, c := range candidates {
+		if c.hash != hash {
+			continue
+		}
+		p := c.path
+		from := filepath.Join(r.root, filepath.FromSlash(p))
+		if _, err := r.sfs.Stat(from); !os.IsNotExist(err) {
+			continue // still there; a copy, not a move
+		}
+		old := filepath.Join(r.dstRoot, filepath.FromSlash(p))
+		fi, err := r.dfs.Stat(old)
+		if err != nil || !r.deletable(from, fi) {
+			continue
+		}
+		r.renames[sstat.Size()] = append(candidates[:i:i], candidates[i+1:]...)
+		if r.moved == nil {
+			r.moved = make(map[string]bool)
+		}
+		r.moved[old] = true
+		r.touch(filepath.Dir(old), filepath.Dir(from))
+		if !r.DryRun {
+			if r.Delete {
+				check(r.dfs.Rename(old, dst))
+			} else {
+				check(link.Link(old, dst))
+			}
+		}
+		r.hashed(src, sum)
+		r.stats.Moved++
+		r.emit(Verbose, src, Event{Op: OpMove, From: p, Item: itemMoved})
+		return true
+	}
+	return false
+}
+
+// removePending deletes what remove left, except for the files moved, and
+// syncs the modification times of the directories they were in, and moved
+// from, again.
+func (r *run) removePending() {
+	r.limitDeletions()
+	r.deletions.each(func(j job) {
+		if !r.moved[j.dst] {
+			r.try(j.src, func() { r.delete(j.dst, j.src) })
+			r.touch(filepath.Dir(j.dst), filepath.Dir(j.src))
+		}
+	})
+	r.deletions.close()
+	r.restat()
+}
@@ -0,0 +1,63 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectRenames(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "a"), 0755))
+	check(os.MkdirAll(filepath.Join(src, "b"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a/x"), []byte("file x"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "a/y"), []byte("file y"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "a/z"), []byte("file z"), 0644))
+
+	s := NewSyncer()
+	s.StateFile = filepath.Join(dir, "state")
+	s.DetectRenames = true
+	s.Delete = true
+	check(s.Sync(dst, src))
+	before, err := os.Stat(filepath.Join(dst, "a/x"))
+	check(err)
+
+	check(os.Rename(filepath.Join(src, "a/x"), filepath.Join(src, "b/x")))
+	check(os.Remove(filepath.Join(src, "a/z")))
+	var from string
+	s.Verbosity = Verbose
+	s.OnEvent = func(e Event) {
+		if e.Op == OpMove && e.Path == "b/x" {
+			from = e.From
+		}
+	}
+	stats, err := s.SyncStats(dst, src)
+	check(err)
+	if stats.Moved != 1 || stats.Files != 0 || stats.Deleted != 1 || from != "a/x" {
+		t.Errorf("expecting a/x to be moved, got %+v from %q", stats, from)
+	}
+	after, err := os.Stat(filepath.Join(dst, "b/x"))
+	check(err)
+	if !os.SameFile(before, after) {
+		t.Error("b/x should be a/x renamed")
+	}
+	testDirContents(filepath.Join(dst, "a"), 1, t)
+
+	// without Delete, the old file is kept and linked
+	s.Delete = false
+	check(os.Rename(filepath.Join(src, "a/y"), filepath.Join(src, "b/y")))
+	stats, err = s.SyncStats(dst, src)
+	check(err)
+	old, err := os.Stat(filepath.Join(dst, "a/y"))
+	check(err)
+	linked, err := os.Stat(filepath.Join(dst, "b/y"))
+	check(err)
+	if stats.Moved != 1 || !os.SameFile(old, linked) {
+		t.Errorf("expecting b/y to be linked to a/y, got %+v", stats)
+	}
+}
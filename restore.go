@@ -0,0 +1,83 @@
+package fsync
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A Selector chooses one of the snapshots that contain a file. snaps is
+// sorted oldest first. ok is false if none fits.
+type Selector func(snaps []Snapshot) (snap Snapshot, ok bool)
+
+// Latest selects the newest snapshot.
+func Latest(snaps []Snapshot) (Snapshot, bool) {
+	if len(snaps) == 0 {
+		return Snapshot{}, false
+	}
+	return snaps[len(snaps)-1], true
+}
+
+// At returns a Selector for the newest snapshot taken at or before t.
+func At(t time.Time) Selector {
+	return func(snaps []Snapshot) (Snapshot, bool) {
+		for i := len(snaps) - 1; i >= 0; i-- {
+			if !snaps[i].Time.After(t) {
+				return snaps[i], true
+			}
+		}
+		return Snapshot{}, false
+	}
+}
+
+// Named returns a Selector for the snapshot called name.
+func Named(name string) Selector {
+	return func(snaps []Snapshot) (Snapshot, bool) {
+		for _, snap := range snaps {
+			if snap.Name == name {
+				return snap, true
+			}
+		}
+		return Snapshot{}, false
+	}
+}
+
+// Versions lists the snapshots in root that contain relPath, oldest first.
+func Versions(root, relPath string) ([]Snapshot, error) {
+	snaps, err := listSnapshots(root)
+	if err != nil {
+		return nil, err
+	}
+	var found []Snapshot
+	for _, snap := range snaps {
+		_, err := os.Stat(filepath.Join(snap.Path, relPath))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		found = append(found, snap)
+	}
+	return found, nil
+}
+
+// RestoreFile copies relPath from the snapshot in root chosen by version to
+// to, without syncing anything else.
+func RestoreFile(to, root, relPath string, version Selector) error {
+	return NewSyncer().RestoreFile(to, root, relPath, version)
+}
+
+// RestoreFile copies relPath from the snapshot in root chosen by version to
+// to, without syncing anything else. Only snapshots that contain relPath are
+// offered to version. relPath may also be a directory.
+func (s *Syncer) RestoreFile(to, root, relPath string, version Selector) error {
+	snaps, err := Versions(root, relPath)
+	if err != nil {
+		return err
+	}
+	snap, ok := version(snaps)
+	if !ok {
+		return ErrNoSnapshot
+	}
+	return s.Sync(to, filepath.Join(snap.Path, relPath))
+}
@@ -0,0 +1,40 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRestoreFile(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(root)
+	for i, content := range []string{"one", "two", ""} {
+		snap := filepath.Join(root, time.Date(2020, 1, i+1, 0, 0, 0, 0,
+			time.Local).Format(SnapshotFormat))
+		check(os.MkdirAll(filepath.Join(snap, "a"), 0755))
+		if content != "" {
+			check(ioutil.WriteFile(filepath.Join(snap, "a/b"), []byte(content), 0644))
+		}
+	}
+	to := filepath.Join(root, "restored")
+
+	// the newest snapshot doesn't have the file
+	check(RestoreFile(to, root, "a/b", Latest))
+	testFile(to, []byte("two"), t)
+
+	check(RestoreFile(to, root, "a/b", Named("2020-01-01T00:00:00")))
+	testFile(to, []byte("one"), t)
+
+	check(RestoreFile(to, root, "a/b",
+		At(time.Date(2020, 1, 2, 12, 0, 0, 0, time.Local))))
+	testFile(to, []byte("two"), t)
+
+	err = RestoreFile(to, root, "a/b", At(time.Date(2019, 1, 1, 0, 0, 0, 0, time.Local)))
+	if err != ErrNoSnapshot {
+		t.Errorf("expecting ErrNoSnapshot, got %v.\n", err)
+	}
+}
@@ -0,0 +1,26 @@
+package fsync
+
+import (
+	"errors"
+	"net"
+)
+
+// Transient returns true if err is likely to go away if what failed is
+// tried again: timeouts, interrupted calls and files busy or locked by
+// others, as with sharing violations on Windows. It's the default of
+// Retryable.
+func Transient(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return transient(err)
+}
+
+// retryable returns true if what failed with err may be retried.
+func (r *run) retryable(err error) bool {
+	if r.Retryable != nil {
+		return r.Retryable(err)
+	}
+	return Transient(err)
+}
@@ -0,0 +1,3 @@
+package fsync
+
+func transient(err error) bool { return false }
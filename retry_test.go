@@ -0,0 +1,96 @@
+package fsync
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// busyFS fails to open files while busy is positive, counting down.
+type busyFS struct {
+	osFS
+	busy int
+}
+
+func (fs *busyFS) Open(name string) (io.ReadCloser, error) {
+	if fs.busy > 0 {
+		fs.busy--
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EBUSY}
+	}
+	return fs.osFS.Open(name)
+}
+
+func TestRetries(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("file a"), 0644))
+
+	start := time.Unix(1e9, 0)
+	clock := &stepClock{now: start}
+	fs := &busyFS{busy: 3}
+	s := NewSyncer()
+	s.SrcFS = fs
+	s.Clock = clock
+	s.Retries = 2
+	s.RetryDelay = time.Second
+	if err := s.Sync(dst, src); !Transient(err) {
+		t.Errorf("expecting a transient error, got %v", err)
+	}
+	if d := clock.Now().Sub(start); d != 3*time.Second {
+		t.Errorf("expecting retries after 1s and 2s, waited %v", d)
+	}
+
+	fs.busy = 3
+	s.Retries = 3
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "a"), []byte("file a"), t)
+
+	fs.busy = 1
+	s.Retryable = func(err error) bool { return false }
+	check(os.Remove(filepath.Join(dst, "a")))
+	if err := s.Sync(dst, src); err == nil {
+		t.Error("expecting the error not to be retried")
+	}
+}
+
+// cancelClock cancels a context when waited on, and never lets the time
+// pass.
+type cancelClock struct {
+	stepClock
+	cancel context.CancelFunc
+}
+
+func (c *cancelClock) After(d time.Duration) <-chan time.Time {
+	c.cancel()
+	return nil
+}
+
+func TestRetryCanceled(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("file a"), 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewSyncer()
+	s.SrcFS = &busyFS{busy: 1}
+	s.Clock = &cancelClock{cancel: cancel}
+	s.Retries = 1
+	s.RetryDelay = time.Hour
+	if _, err := s.SyncContext(ctx, dst, src); err != context.Canceled {
+		t.Errorf("expecting the wait for a retry to be canceled, got %v", err)
+	}
+}
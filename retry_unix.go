@@ -0,0 +1,17 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package fsync
+
+import (
+	"errors"
+	"syscall"
+)
+
+func transient(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	return errno.Temporary() || errno == syscall.EBUSY || errno == syscall.ETXTBSY
+}
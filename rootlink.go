@@ -0,0 +1,114 @@
+package fsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrNoSymlinks = errors.New("fsync: the file system has no symbolic links")
+	ErrLinkLoop   = errors.New("fsync: too many levels of symbolic links")
+)
+
+// RootLink is what a sync does when its source is a symbolic link, such as
+// a link to the current release. Links in the source tree aren't affected.
+type RootLink int
+
+const (
+	// FollowRootLink syncs what the link points to, looking it up by the
+	// name of the link each time.
+	FollowRootLink RootLink = iota
+	// ResolveRootLink resolves the link once, before syncing, and syncs
+	// the tree it points to, so that the sync isn't split between two
+	// trees if the link changes meanwhile. Events, history and state are
+	// kept under the real name.
+	ResolveRootLink
+	// CopyRootLink makes the destination a symbolic link with the same
+	// target, rather than syncing what it points to.
+	CopyRootLink
+)
+
+// Symlinker is implemented by file systems with symbolic links, which
+// RootLink needs.
+type Symlinker interface {
+	Lstat(name string) (os.FileInfo, error)
+	Readlink(name string) (string, error)
+	Symlink(oldname, newname string) error
+}
+
+func (osFS) Lstat(name string) (os.FileInfo, error) { return os.Lstat(name) }
+func (osFS) Readlink(name string) (string, error)   { return os.Readlink(name) }
+func (osFS) Symlink(oldname, newname string) error  { return os.Symlink(oldname, newname) }
+
+// rootLink applies RootLink to the source src. It returns the name to sync
+// from, and with CopyRootLink, the target of src if it's a link.
+func (r *run) rootLink(src string) (name, target string, err error) {
+	sl, ok := r.sfs.(Symlinker)
+	if !ok {
+		return "", "", ErrNoSymlinks
+	}
+	fi, err := sl.Lstat(src)
+	if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		return src, "", err
+	}
+	if r.RootLink == ResolveRootLink {
+		name, err = resolveLink(sl, src)
+		return name, "", err
+	}
+	target, err = sl.Readlink(src)
+	return src, target, err
+}
+
+// copyLink makes dst a symbolic link to target, as the source src is.
+func (r *run) copyLink(dst, src, target string) {
+	dl, ok := r.dfs.(Symlinker)
+	if !ok {
+		panic(ErrNoSymlinks)
+	}
+	dstat, err := dl.Lstat(dst)
+	if err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
+	if dstat != nil && dstat.Mode()&os.ModeSymlink != 0 {
+		if old, err := dl.Readlink(dst); err == nil && old == target {
+			r.stats.Unchanged++
+			r.emit(Trace, src, Event{Op: OpSkip, Reason: SameContent})
+			return
+		}
+	} else if dstat != nil && dstat.IsDir() {
+		files, err := r.dfs.ReadDir(dst)
+		check(err)
+		if len(files) > 0 {
+			panic(ErrFileOverDir)
+		}
+	}
+	r.progress.found(0)
+	if !r.DryRun {
+		if dstat != nil {
+			check(r.dfs.Remove(dst))
+		}
+		check(dl.Symlink(target, dst))
+	}
+	r.copied(src, 0)
+}
+
+// resolveLink returns what the symbolic link name points to, following
+// links until one that isn't.
+func resolveLink(fs Symlinker, name string) (string, error) {
+	for i := 0; i < 255; i++ {
+		target, err := fs.Readlink(name)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(name), target)
+		}
+		name = target
+		fi, err := fs.Lstat(name)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			return name, err
+		}
+	}
+	return "", ErrLinkLoop
+}
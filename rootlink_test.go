@@ -0,0 +1,45 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRootLink(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	check(os.MkdirAll(filepath.Join(dir, "releases/v1"), 0755))
+	check(ioutil.WriteFile(filepath.Join(dir, "releases/v1/a"), []byte("file a"), 0644))
+	src := filepath.Join(dir, "current")
+	check(os.Symlink("releases/v1", src))
+
+	s := NewSyncer()
+	s.RootLink = ResolveRootLink
+	var root string
+	s.OnProgress = func(p Progress) { root = p.Src }
+	dst := filepath.Join(dir, "resolved")
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "a"), []byte("file a"), t)
+	if root != filepath.Join(dir, "releases/v1") {
+		t.Errorf("expecting the link to be resolved, got %q", root)
+	}
+
+	s.RootLink = CopyRootLink
+	s.OnProgress = nil
+	dst = filepath.Join(dir, "copied")
+	check(s.Sync(dst, src))
+	if target, err := os.Readlink(dst); err != nil || target != "releases/v1" {
+		t.Errorf("expecting a link to releases/v1, got %q, %v", target, err)
+	}
+	stats, err := s.SyncStats(dst, src)
+	check(err)
+	if stats.Unchanged != 1 || stats.Files != 0 {
+		t.Errorf("expecting the link to be unchanged, got %+v", stats)
+	}
+	if err := s.Sync(filepath.Join(dir, "releases"), src); err != ErrFileOverDir {
+		t.Errorf("expecting ErrFileOverDir, got %v", err)
+	}
+}
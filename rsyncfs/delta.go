@@ -0,0 +1,152 @@
+package rsyncfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"golang.org/x/crypto/md4"
+)
+
+// maxLiteral is the largest run of literal data sent in one token.
+const maxLiteral = 32 << 10
+
+// weakSum returns the rolling checksum of b. Bytes are signed, as in rsync.
+func weakSum(b []byte) (s1, s2 uint32) {
+	for _, c := range b {
+		s1 += uint32(int8(c))
+		s2 += s1
+	}
+	return s1, s2
+}
+
+// strongSum returns the checksum of a block.
+func strongSum(b []byte, seed int32) []byte {
+	h := md4.New()
+	h.Write(b)
+	if seed != 0 {
+		binary.Write(h, binary.LittleEndian, seed)
+	}
+	return h.Sum(nil)
+}
+
+// sendDelta sends the contents of f, which is size bytes long, as tokens
+// against the blocks described by sh. Data that's in a block of the server's
+// copy is sent as a reference to it; the rest is sent literally.
+func (s *session) sendDelta(f io.ReaderAt, size int64, sh sumHead) {
+	blocks := make(map[uint32][]int, len(sh.sums))
+	for i, b := range sh.sums {
+		blocks[b.weak] = append(blocks[b.weak], i)
+	}
+	blockLen := func(i int) int {
+		if i == len(sh.sums)-1 && sh.remainder != 0 {
+			return int(sh.remainder)
+		}
+		return int(sh.blockLen)
+	}
+
+	// buf holds the file from base; the window is buf[off:off+k] and
+	// literal data not yet sent starts at last
+	var (
+		buf         []byte
+		base        int64
+		off, last   int
+		k           int
+		s1, s2      uint32
+		haveSums    bool
+		matchTarget = len(sh.sums) > 0 && sh.blockLen > 0
+	)
+	fill := func(n int) {
+		// make sure buf has n bytes from off, or as many as there are
+		if off+n <= len(buf) || base+int64(len(buf)) >= size {
+			return
+		}
+		if last > 0 {
+			buf = append(buf[:0], buf[last:]...)
+			base += int64(last)
+			off -= last
+			last = 0
+		}
+		want := off + n + 4*maxLiteral
+		if rest := size - base; int64(want) > rest {
+			want = int(rest)
+		}
+		old := len(buf)
+		if cap(buf) < want {
+			buf = append(buf[:cap(buf)], make([]byte, want-cap(buf))...)
+		}
+		buf = buf[:want]
+		if _, err := f.ReadAt(buf[old:], base+int64(old)); err != nil && err != io.EOF {
+			s.fail(err)
+		}
+	}
+	sendLiteral := func(end int) {
+		for last < end {
+			n := end - last
+			if n > maxLiteral {
+				n = maxLiteral
+			}
+			s.writeInt32(int32(n))
+			s.write(buf[last : last+n])
+			last += n
+		}
+	}
+
+	for matchTarget && s.e == nil {
+		fill(int(sh.blockLen) + 1)
+		if k = len(buf) - off; k > int(sh.blockLen) {
+			k = int(sh.blockLen)
+		}
+		if k == 0 {
+			break
+		}
+		if !haveSums {
+			s1, s2 = weakSum(buf[off : off+k])
+			haveSums = true
+		}
+		matched := -1
+		for _, i := range blocks[s1&0xffff|s2<<16] {
+			if blockLen(i) != k {
+				continue
+			}
+			if bytes.Equal(strongSum(buf[off:off+k], s.seed)[:sh.sumLen], sh.sums[i].strong) {
+				matched = i
+				break
+			}
+		}
+		if matched >= 0 {
+			sendLiteral(off)
+			s.writeInt32(-int32(matched + 1))
+			off += k
+			last = off
+			haveSums = false
+			continue
+		}
+		// roll the window one byte
+		x := uint32(int8(buf[off]))
+		s1 -= x
+		s2 -= uint32(k) * x
+		off++
+		if off+k <= len(buf) {
+			s1 += uint32(int8(buf[off+k-1]))
+			s2 += s1
+		} else {
+			// the window shrinks at the end of the file
+			haveSums = false
+		}
+		if off-last >= maxLiteral {
+			sendLiteral(off)
+		}
+	}
+
+	// the rest is literal
+	for s.e == nil {
+		off = len(buf)
+		sendLiteral(off)
+		if base+int64(len(buf)) >= size {
+			break
+		}
+		fill(maxLiteral)
+	}
+	s.writeInt32(0)
+}
@@ -0,0 +1,563 @@
+// Package rsyncfs provides a backend for fsync that pushes to rsync daemons,
+// so existing rsync modules can be synced to without shell access.
+//
+// Importing the package registers the "rsync" URL scheme. The first element
+// of the path is the module; the rest is the directory in it. The password
+// can be given in the URL or in the RSYNC_PASSWORD environment variable, as
+// with rsync:
+//
+//	import _ "github.com/mostafah/fsync/rsyncfs"
+//
+//	err := fsync.Sync("rsync://backup@mirror.example.com/www/site", "build")
+//
+// The daemon is not written to during the sync. The directory is listed once
+// and changes are made to that listing, with created files kept in temporary
+// files. When the FS is closed the whole tree is sent in a single rsync
+// transfer: the daemon asks for the files that changed in size or
+// modification time and receives only the parts of them it doesn't already
+// have, with rsync's delta transfer. Removed files are deleted with
+// --delete. An error sending the tree is returned by Close, and so fails the
+// sync.
+//
+// Files are compared by size and modification time (see fsync.CompareQuick),
+// since reading them back means transferring them. Only regular files and
+// directories are synced; other files in the directory are ignored, and are
+// deleted by a sync that deletes anything.
+package rsyncfs
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mostafah/fsync"
+)
+
+// Timeout is the timeout for connecting to and reading from daemons.
+var Timeout = 30 * time.Second
+
+var (
+	ErrNoModule = errors.New("rsyncfs: no module in the URL")
+	ErrOutside  = errors.New("rsyncfs: name is outside the synced directory")
+)
+
+func init() {
+	fsync.Register("rsync", Open)
+}
+
+// FS is an fsync.FS on a directory of an rsync module. It is safe for
+// concurrent use.
+type FS struct {
+	addr, module   string
+	user, password string
+	dir            string // in the module, slash-separated
+	prefix         string // removed from names; the URL path for URLs
+
+	mu       sync.Mutex
+	entries  map[string]*entry            // by path relative to dir; "." is dir
+	children map[string]map[string]*entry // by parent and base name
+	spool    string                       // temporary directory for data
+	dirty    bool                         // whether anything changed
+	deleted  bool                         // whether anything was removed
+}
+
+// entry is a file or directory in the listing.
+type entry struct {
+	name  string // relative to dir, slash-separated
+	size  int64
+	mtime time.Time
+	mode  uint32 // Unix mode, with type bits
+	data  string // temporary file with new contents, if any
+}
+
+// New returns an FS on the directory dir of module on the daemon at addr
+// (host:port). user may be empty for modules that don't need
+// authentication. Names are relative to dir.
+func New(addr, module, dir, user, password string) *FS {
+	return &FS{
+		addr:     addr,
+		module:   module,
+		dir:      strings.Trim(path.Clean("/"+dir), "/"),
+		user:     user,
+		password: password,
+	}
+}
+
+// Open returns an FS on the directory in u. Names passed to the FS are paths
+// starting with the module, as the path of u does.
+func Open(u *url.URL) (fsync.FS, error) {
+	p := path.Clean("/" + u.Path)
+	parts := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 2)
+	if parts[0] == "" {
+		return nil, ErrNoModule
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "873")
+	}
+	var user, password string
+	if u.User != nil {
+		user = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	if password == "" {
+		password = os.Getenv("RSYNC_PASSWORD")
+	}
+	var dir string
+	if len(parts) > 1 {
+		dir = parts[1]
+	}
+	fs := New(addr, parts[0], dir, user, password)
+	fs.prefix = p
+	return fs, nil
+}
+
+// Close sends the changes to the daemon, if there are any, and removes the
+// temporary files.
+func (fs *FS) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	var err error
+	if fs.dirty {
+		files := make([]*entry, 0, len(fs.entries))
+		for _, e := range fs.entries {
+			files = append(files, e)
+		}
+		// the daemon sorts the list the same way, and asks for files by
+		// their index in it
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].name < files[j].name
+		})
+		if err = fs.put(fs.remote(""), files, fs.deleted); err == nil {
+			fs.dirty, fs.deleted = false, false
+		}
+	}
+	if fs.spool != "" {
+		if err2 := os.RemoveAll(fs.spool); err == nil {
+			err = err2
+		}
+		fs.spool = ""
+		for _, e := range fs.entries {
+			e.data = ""
+		}
+	}
+	return err
+}
+
+// Comparison returns fsync.CompareQuick; see fsync.Comparer.
+func (fs *FS) Comparison() fsync.Comparison {
+	return fsync.CompareQuick
+}
+
+// ModifyWindow returns a second, the precision of rsync modification times.
+func (fs *FS) ModifyWindow() time.Duration {
+	return time.Second
+}
+
+// rel converts a name to a path relative to the synced directory.
+func (fs *FS) rel(name string) (string, error) {
+	p := path.Clean("/" + filepath.ToSlash(name))
+	if p == fs.prefix || p == "/" && fs.prefix == "" {
+		return ".", nil
+	}
+	if !strings.HasPrefix(p, fs.prefix+"/") {
+		return "", ErrOutside
+	}
+	return p[len(fs.prefix)+1:], nil
+}
+
+// remote returns the path of rel as the daemon expects it, starting with
+// the module.
+func (fs *FS) remote(rel string) string {
+	return path.Join(fs.module, fs.dir, rel)
+}
+
+// lookup loads the listing if needed and returns the entry for name, which
+// is nil if there is none. It must be called with mu held.
+func (fs *FS) lookup(name string) (rel string, e *entry, err error) {
+	if rel, err = fs.rel(name); err != nil {
+		return "", nil, err
+	}
+	if fs.entries == nil {
+		files, err := fs.list(fs.remote(""))
+		if err != nil {
+			return "", nil, err
+		}
+		fs.entries = make(map[string]*entry)
+		fs.children = make(map[string]map[string]*entry)
+		for _, e := range files {
+			switch e.mode & modeType {
+			case modeDir, modeReg:
+				fs.add(e)
+			}
+		}
+	}
+	return rel, fs.entries[rel], nil
+}
+
+// add adds e to the listing, replacing any entry with its name.
+func (fs *FS) add(e *entry) {
+	fs.entries[e.name] = e
+	if e.name == "." {
+		return
+	}
+	dir := path.Dir(e.name)
+	if fs.children[dir] == nil {
+		fs.children[dir] = make(map[string]*entry)
+	}
+	fs.children[dir][path.Base(e.name)] = e
+}
+
+// remove removes e and everything in it from the listing.
+func (fs *FS) remove(e *entry) {
+	for _, c := range fs.children[e.name] {
+		fs.remove(c)
+	}
+	delete(fs.children, e.name)
+	delete(fs.entries, e.name)
+	delete(fs.children[path.Dir(e.name)], path.Base(e.name))
+	if e.data != "" {
+		os.Remove(e.data)
+	}
+	fs.dirty, fs.deleted = true, true
+}
+
+// parent returns an error unless the parent of rel is a directory.
+func (fs *FS) parent(op, name, rel string) error {
+	if rel == "." {
+		return nil
+	}
+	if p := fs.entries[path.Dir(rel)]; p == nil || p.mode&modeType != modeDir {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return nil
+}
+
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	_, e, err := fs.lookup(name)
+	if err != nil {
+		return nil, err
+	} else if e == nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return e.info(), nil
+}
+
+func (fs *FS) ReadDir(name string) ([]os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	rel, e, err := fs.lookup(name)
+	if err != nil {
+		return nil, err
+	} else if e == nil {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrNotExist}
+	} else if e.mode&modeType != modeDir {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	var infos []os.FileInfo
+	for _, c := range fs.children[rel] {
+		infos = append(infos, c.info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+	return infos, nil
+}
+
+// Open opens a file created during the sync from its temporary file, and
+// other files by transferring them from the daemon.
+func (fs *FS) Open(name string) (io.ReadCloser, error) {
+	fs.mu.Lock()
+	_, e, err := fs.lookup(name)
+	fs.mu.Unlock()
+	if err != nil {
+		return nil, err
+	} else if e == nil || e.mode&modeType != modeReg {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	if e.data != "" {
+		return os.Open(e.data)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(fs.get(fs.remote(e.name), pw))
+	}()
+	return pr, nil
+}
+
+// Create creates a temporary file for name, which is added to the listing
+// when it's closed.
+func (fs *FS) Create(name string) (io.WriteCloser, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	rel, e, err := fs.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if e != nil && e.mode&modeType == modeDir {
+		return nil, &os.PathError{Op: "create", Path: name, Err: errors.New("is a directory")}
+	}
+	if err := fs.parent("create", name, rel); err != nil {
+		return nil, err
+	}
+	f, err := fs.tempFile()
+	if err != nil {
+		return nil, err
+	}
+	mode := uint32(modeReg | 0644)
+	if e != nil {
+		mode = e.mode
+	}
+	return &writer{File: f, fs: fs, name: rel, mode: mode}, nil
+}
+
+// tempFile creates a file in the spool directory. It must be called with mu
+// held.
+func (fs *FS) tempFile() (*os.File, error) {
+	if fs.spool == "" {
+		dir, err := ioutil.TempDir("", "rsyncfs")
+		if err != nil {
+			return nil, err
+		}
+		fs.spool = dir
+	}
+	return ioutil.TempFile(fs.spool, "")
+}
+
+// writer is a file being created.
+type writer struct {
+	*os.File
+	fs   *FS
+	name string
+	mode uint32
+	done bool
+}
+
+// Close adds the file to the listing. Closing it again does nothing.
+func (w *writer) Close() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	fi, err := w.File.Stat()
+	if err2 := w.File.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(w.File.Name())
+		return err
+	}
+	fs := w.fs
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if old := fs.entries[w.name]; old != nil && old.data != "" {
+		os.Remove(old.data)
+	}
+	fs.add(&entry{
+		name:  w.name,
+		size:  fi.Size(),
+		mtime: time.Now(),
+		mode:  w.mode,
+		data:  w.File.Name(),
+	})
+	fs.dirty = true
+	return nil
+}
+
+func (fs *FS) MkdirAll(name string, perm os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	rel, _, err := fs.lookup(name)
+	if err != nil {
+		return err
+	}
+	return fs.mkdirAll(name, rel, perm)
+}
+
+func (fs *FS) mkdirAll(name, rel string, perm os.FileMode) error {
+	if e := fs.entries[rel]; e != nil {
+		if e.mode&modeType != modeDir {
+			return &os.PathError{Op: "mkdir", Path: name, Err: errors.New("not a directory")}
+		}
+		return nil
+	}
+	if rel != "." {
+		if err := fs.mkdirAll(name, path.Dir(rel), perm); err != nil {
+			return err
+		}
+	}
+	fs.add(&entry{name: rel, mtime: time.Now(), mode: modeDir | uint32(perm.Perm())})
+	fs.dirty = true
+	return nil
+}
+
+func (fs *FS) Remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	rel, e, err := fs.lookup(name)
+	if err != nil {
+		return err
+	} else if e == nil {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	} else if len(fs.children[rel]) > 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+	}
+	fs.remove(e)
+	return nil
+}
+
+func (fs *FS) RemoveAll(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	_, e, err := fs.lookup(name)
+	if err != nil || e == nil {
+		return err
+	}
+	fs.remove(e)
+	return nil
+}
+
+// Rename renames oldname to newname, replacing newname if it's a file.
+// Files that weren't created during the sync are transferred from the daemon
+// first, since the daemon sees the rename as a new file and a deleted one.
+func (fs *FS) Rename(oldname, newname string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	oldrel, e, err := fs.lookup(oldname)
+	if err != nil {
+		return err
+	} else if e == nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrNotExist}
+	}
+	newrel, err := fs.rel(newname)
+	if err != nil {
+		return err
+	}
+	if t := fs.entries[newrel]; t != nil && t.mode&modeType == modeDir {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: errors.New("file exists")}
+	}
+	if err := fs.parent("rename", newname, newrel); err != nil {
+		return err
+	}
+	var moved []entry
+	for name, e := range fs.entries {
+		if name == oldrel || strings.HasPrefix(name, oldrel+"/") {
+			if err := fs.fetch(e); err != nil {
+				return err
+			}
+			moved = append(moved, *e)
+		}
+	}
+	for _, e := range moved {
+		fs.entries[e.name].data = "" // keep it from being removed
+	}
+	fs.remove(fs.entries[oldrel])
+	if t := fs.entries[newrel]; t != nil {
+		fs.remove(t)
+	}
+	for i := range moved {
+		e := &moved[i]
+		e.name = newrel + strings.TrimPrefix(e.name, oldrel)
+		fs.add(e)
+	}
+	return nil
+}
+
+// fetch transfers the contents of the file e from the daemon to a temporary
+// file, unless it already has one. It must be called with mu held.
+func (fs *FS) fetch(e *entry) error {
+	if e.data != "" || e.mode&modeType != modeReg {
+		return nil
+	}
+	f, err := fs.tempFile()
+	if err != nil {
+		return err
+	}
+	err = fs.get(fs.remote(e.name), f)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	e.data = f.Name()
+	return nil
+}
+
+func (fs *FS) Chmod(name string, mode os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	_, e, err := fs.lookup(name)
+	if err != nil {
+		return err
+	} else if e == nil {
+		return &os.PathError{Op: "chmod", Path: name, Err: os.ErrNotExist}
+	}
+	if m := e.mode&modeType | uint32(mode.Perm()); m != e.mode {
+		e.mode = m
+		fs.dirty = true
+	}
+	return nil
+}
+
+// Chtimes sets the modification time of name. The daemon asks for files
+// whose modification time changed, so the contents of files that weren't
+// created during the sync are transferred from it first.
+func (fs *FS) Chtimes(name string, atime, mtime time.Time) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	_, e, err := fs.lookup(name)
+	if err != nil {
+		return err
+	} else if e == nil {
+		return &os.PathError{Op: "chtimes", Path: name, Err: os.ErrNotExist}
+	}
+	mtime = mtime.Truncate(time.Second)
+	if mtime.Equal(e.mtime) {
+		return nil
+	}
+	if err := fs.fetch(e); err != nil {
+		return err
+	}
+	e.mtime = mtime
+	fs.dirty = true
+	return nil
+}
+
+func (e *entry) info() os.FileInfo {
+	return &fileInfo{name: path.Base(e.name), size: e.size, mtime: e.mtime, mode: e.mode}
+}
+
+// fileInfo is a copy of an entry, since entries change.
+type fileInfo struct {
+	name  string
+	size  int64
+	mtime time.Time
+	mode  uint32
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.mtime }
+func (fi *fileInfo) IsDir() bool        { return fi.mode&modeType == modeDir }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	m := os.FileMode(fi.mode & 0777)
+	if fi.IsDir() {
+		m |= os.ModeDir
+	}
+	return m
+}
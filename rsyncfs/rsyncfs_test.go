@@ -0,0 +1,100 @@
+package rsyncfs
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gokrazy/rsync/rsyncd"
+	"github.com/mostafah/fsync"
+)
+
+func TestSync(t *testing.T) {
+	src, err := ioutil.TempDir(os.TempDir(), "rsyncfs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	srv, err := ioutil.TempDir(os.TempDir(), "rsyncfs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srv)
+	big := make([]byte, 200<<10)
+	rand.New(rand.NewSource(1)).Read(big)
+	if err := os.MkdirAll(filepath.Join(src, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "a/b"), []byte("file b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "c"), big, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := rsyncd.NewServer([]rsyncd.Module{{Name: "site", Path: srv, Writable: true}},
+		rsyncd.WithLogger(log.New(ioutil.Discard, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Serve(ctx, ln)
+	dst := "rsync://" + ln.Addr().String() + "/site"
+
+	s := fsync.NewSyncer()
+	s.Delete = true
+	sync := func() {
+		if err := s.Sync(dst, src); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testFile := func(name string, content []byte) {
+		b, err := ioutil.ReadFile(filepath.Join(srv, name))
+		if err != nil {
+			t.Error(err)
+		} else if !bytes.Equal(b, content) {
+			t.Errorf("%s has wrong content", name)
+		}
+	}
+	sync()
+	testFile("a/b", []byte("file b"))
+	testFile("c", big)
+
+	// the changed file is sent as a delta
+	copy(big[100<<10:], "changed")
+	big = append(big, "appended"...)
+	if err := ioutil.WriteFile(filepath.Join(src, "c"), big, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sync()
+	testFile("c", big)
+
+	// files are read back from the daemon
+	v, err := s.Verify(dst, src, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Mismatches) > 0 {
+		t.Errorf("mismatches: %v", v.Mismatches)
+	}
+
+	if err := os.Remove(filepath.Join(src, "a/b")); err != nil {
+		t.Fatal(err)
+	}
+	sync()
+	if _, err := os.Stat(filepath.Join(srv, "a/b")); !os.IsNotExist(err) {
+		t.Errorf("a/b should be deleted, got %v", err)
+	}
+	testFile("c", big)
+}
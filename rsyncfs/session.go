@@ -0,0 +1,531 @@
+package rsyncfs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/md4"
+)
+
+// protocol is the rsync protocol version spoken. Version 27 is understood by
+// every rsync since 2.6.0 and needs no capability negotiation.
+const protocol = 27
+
+const (
+	mplexBase = 7 // added to message tags in multiplexed headers
+	msgData   = 0
+
+	// file mode bits as sent in file lists
+	modeType = 0170000
+	modeDir  = 0040000
+	modeReg  = 0100000
+
+	// file list flags
+	xmitTopDir   = 1 << 0
+	xmitSameMode = 1 << 1
+	xmitSameUID  = 1 << 3
+	xmitSameGID  = 1 << 4
+	xmitSameName = 1 << 5
+	xmitLongName = 1 << 6
+	xmitSameTime = 1 << 7
+)
+
+// session is a connection to an rsync daemon for a single transfer. Errors
+// are sticky: after the first one, reads return zero values and writes do
+// nothing.
+type session struct {
+	conn net.Conn
+	r    *bufio.Reader // from the daemon; multiplexed after start
+	w    *bufio.Writer
+	seed int32 // checksum seed
+	e    error
+}
+
+// dial connects to the daemon and starts the server side of rsync with args.
+func (fs *FS) dial(args ...string) (*session, error) {
+	conn, err := net.DialTimeout("tcp", fs.addr, Timeout)
+	if err != nil {
+		return nil, err
+	}
+	s := &session{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	if err := s.start(fs.module, fs.user, fs.password, args); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// start does the daemon handshake, selecting module and sending args.
+func (s *session) start(module, user, password string, args []string) error {
+	fmt.Fprintf(s.w, "@RSYNCD: %d\n", protocol)
+	s.flush()
+	line := s.line()
+	var version int
+	if !strings.HasPrefix(line, "@RSYNCD: ") {
+		return s.fail(fmt.Errorf("rsyncfs: unexpected greeting %q", line))
+	} else if fmt.Sscanf(line[len("@RSYNCD: "):], "%d", &version); version < protocol {
+		return s.fail(fmt.Errorf("rsyncfs: server protocol %d is too old", version))
+	}
+
+	fmt.Fprintf(s.w, "%s\n", module)
+	s.flush()
+	for s.e == nil {
+		line := s.line()
+		switch {
+		case line == "@RSYNCD: OK":
+			for _, arg := range args {
+				fmt.Fprintf(s.w, "%s\n", arg)
+			}
+			fmt.Fprintf(s.w, "\n")
+			s.flush()
+			s.seed = s.readInt32()
+			// everything from the server is multiplexed from now on
+			s.r = bufio.NewReaderSize(&demux{r: s.r}, 64<<10)
+			return s.e
+		case strings.HasPrefix(line, "@RSYNCD: AUTHREQD "):
+			challenge := line[len("@RSYNCD: AUTHREQD "):]
+			fmt.Fprintf(s.w, "%s %s\n", user, authHash(password, challenge))
+			s.flush()
+		case strings.HasPrefix(line, "@ERROR"):
+			return s.fail(fmt.Errorf("rsyncfs: %s", line))
+		case line == "@RSYNCD: EXIT":
+			return s.fail(fmt.Errorf("rsyncfs: server closed the connection"))
+		}
+		// anything else is the message of the day
+	}
+	return s.e
+}
+
+// authHash returns the response to an authentication challenge.
+func authHash(password, challenge string) string {
+	h := md4.New()
+	h.Write([]byte{0, 0, 0, 0})
+	io.WriteString(h, password)
+	io.WriteString(h, challenge)
+	return base64.RawStdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func (s *session) close() error {
+	return s.conn.Close()
+}
+
+// fail records err if it's the first error and returns the first error.
+func (s *session) fail(err error) error {
+	if s.e == nil {
+		s.e = err
+	}
+	return s.e
+}
+
+func (s *session) line() string {
+	if s.e != nil {
+		return ""
+	}
+	s.conn.SetReadDeadline(time.Now().Add(Timeout))
+	line, err := s.r.ReadString('\n')
+	s.fail(err)
+	return strings.TrimRight(line, "\r\n")
+}
+
+func (s *session) read(p []byte) {
+	if s.e == nil {
+		s.conn.SetReadDeadline(time.Now().Add(Timeout))
+		_, err := io.ReadFull(s.r, p)
+		s.fail(err)
+	}
+}
+
+func (s *session) readInt32() int32 {
+	var b [4]byte
+	s.read(b[:])
+	return int32(binary.LittleEndian.Uint32(b[:]))
+}
+
+func (s *session) readInt64() int64 {
+	if n := s.readInt32(); n != -1 {
+		return int64(n)
+	}
+	var b [8]byte
+	s.read(b[:])
+	return int64(binary.LittleEndian.Uint64(b[:]))
+}
+
+func (s *session) readByte() byte {
+	var b [1]byte
+	s.read(b[:])
+	return b[0]
+}
+
+func (s *session) write(p []byte) {
+	if s.e == nil {
+		_, err := s.w.Write(p)
+		s.fail(err)
+	}
+}
+
+func (s *session) writeInt32(n int32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(n))
+	s.write(b[:])
+}
+
+func (s *session) writeInt64(n int64) {
+	if n >= 0 && n <= 0x7fffffff {
+		s.writeInt32(int32(n))
+		return
+	}
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(n))
+	s.writeInt32(-1)
+	s.write(b[:])
+}
+
+func (s *session) flush() {
+	if s.e == nil {
+		s.fail(s.w.Flush())
+	}
+}
+
+// demux reads the data of multiplexed messages from the server. Error
+// messages become errors; other messages are dropped.
+type demux struct {
+	r   io.Reader
+	buf []byte // rest of the current data message
+}
+
+func (d *demux) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		var h [4]byte
+		if _, err := io.ReadFull(d.r, h[:]); err != nil {
+			return 0, err
+		}
+		header := binary.LittleEndian.Uint32(h[:])
+		msg := make([]byte, header&0xffffff)
+		if _, err := io.ReadFull(d.r, msg); err != nil {
+			return 0, err
+		}
+		switch tag := int(header>>24) - mplexBase; tag {
+		case msgData:
+			d.buf = msg
+		case 1, 3: // transfer error and error
+			return 0, &remoteError{strings.TrimSpace(string(msg))}
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// remoteError is an error reported by the server.
+type remoteError struct {
+	msg string
+}
+
+func (e *remoteError) Error() string {
+	return "rsyncfs: " + e.msg
+}
+
+// isNotExist returns true if err is the server failing to find a file.
+func isNotExist(err error) bool {
+	var e *remoteError
+	return errors.As(err, &e) &&
+		strings.Contains(strings.ToLower(e.msg), "no such file or directory")
+}
+
+// readFileList reads a file list sent by the server. ids tells whether it
+// has user and group ids, as requested with -o and -g.
+func (s *session) readFileList(ids bool) []*entry {
+	var files []*entry
+	last := &entry{}
+	for s.e == nil {
+		flags := s.readByte()
+		if flags == 0 {
+			break
+		}
+		var prefix int
+		if flags&xmitSameName != 0 {
+			prefix = int(s.readByte())
+		}
+		var n int
+		if flags&xmitLongName != 0 {
+			n = int(s.readInt32())
+		} else {
+			n = int(s.readByte())
+		}
+		if prefix > len(last.name) || n < 0 || n > 4096 {
+			s.fail(errors.New("rsyncfs: corrupt file list"))
+			break
+		}
+		name := make([]byte, prefix+n)
+		copy(name, last.name[:prefix])
+		s.read(name[prefix:])
+
+		e := &entry{name: string(name), size: s.readInt64()}
+		e.mtime = last.mtime
+		if flags&xmitSameTime == 0 {
+			e.mtime = time.Unix(int64(uint32(s.readInt32())), 0)
+		}
+		e.mode = last.mode
+		if flags&xmitSameMode == 0 {
+			e.mode = uint32(s.readInt32())
+		}
+		if ids && flags&xmitSameUID == 0 {
+			s.readInt32()
+		}
+		if ids && flags&xmitSameGID == 0 {
+			s.readInt32()
+		}
+		files = append(files, e)
+		last = e
+	}
+	if ids {
+		// user and group names
+		for i := 0; i < 2; i++ {
+			for s.e == nil && s.readInt32() != 0 {
+				s.read(make([]byte, s.readByte()))
+			}
+		}
+	}
+	s.readInt32() // I/O error flag
+	return files
+}
+
+// writeFileList sends files to the server.
+func (s *session) writeFileList(files []*entry) {
+	for _, e := range files {
+		flags := byte(xmitLongName)
+		if e.name == "." {
+			flags |= xmitTopDir
+		}
+		s.write([]byte{flags})
+		s.writeInt32(int32(len(e.name)))
+		s.write([]byte(e.name))
+		s.writeInt64(e.size)
+		s.writeInt32(int32(e.mtime.Unix()))
+		s.writeInt32(int32(e.mode))
+	}
+	s.write([]byte{0})
+	s.writeInt32(0) // I/O error flag
+}
+
+// finish reads the end of the file phases, from a sender if fromSender is
+// true, and says goodbye.
+func (s *session) finish(fromSender bool) error {
+	if fromSender {
+		// statistics: bytes read, bytes written and total size
+		s.readInt64()
+		s.readInt64()
+		s.readInt64()
+		s.writeInt32(-1)
+		s.flush()
+	} else if n := s.readInt32(); s.e == nil && n != -1 {
+		s.fail(fmt.Errorf("rsyncfs: protocol error: expecting goodbye, got %d", n))
+	}
+	return s.e
+}
+
+// list returns the tree at path on the server, with names relative to it.
+// A missing path is an empty tree.
+func (fs *FS) list(path string) ([]*entry, error) {
+	s, err := fs.dial("--server", "--sender", "-rtog", ".", path+"/")
+	if isNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer s.close()
+	s.writeInt32(0) // no filters
+	s.flush()
+	files := s.readFileList(true)
+	// request nothing in both phases
+	s.writeInt32(-1)
+	s.writeInt32(-1)
+	s.flush()
+	for i := 0; i < 2 && s.e == nil; i++ {
+		if n := s.readInt32(); n != -1 {
+			s.fail(fmt.Errorf("rsyncfs: protocol error: unexpected file %d", n))
+		}
+	}
+	err = s.finish(true)
+	if isNotExist(err) {
+		return nil, nil
+	}
+	return files, err
+}
+
+// get copies the file at path on the server to w.
+func (fs *FS) get(path string, w io.Writer) error {
+	s, err := fs.dial("--server", "--sender", "-tog", ".", path)
+	if err != nil {
+		if isNotExist(err) {
+			err = os.ErrNotExist
+		}
+		return err
+	}
+	defer s.close()
+	s.writeInt32(0) // no filters
+	s.flush()
+	files := s.readFileList(true)
+	if s.e == nil && (len(files) != 1 || files[0].mode&modeType != modeReg) {
+		return fmt.Errorf("rsyncfs: %s is not a regular file", path)
+	}
+	// request the file without a basis
+	s.writeInt32(0)
+	s.writeSumHead(sumHead{})
+	s.writeInt32(-1)
+	s.writeInt32(-1)
+	s.flush()
+	if n := s.readInt32(); s.e == nil && n != 0 {
+		return fmt.Errorf("rsyncfs: protocol error: got file %d", n)
+	}
+	for i := 0; i < 4; i++ {
+		s.readInt32() // the sender's checksum header, without checksums
+	}
+	h := md4.New()
+	binary.Write(h, binary.LittleEndian, s.seed)
+	w = io.MultiWriter(w, h)
+	for s.e == nil {
+		n := s.readInt32()
+		if n == 0 {
+			break
+		} else if n < 0 {
+			s.fail(errors.New("rsyncfs: protocol error: block without basis"))
+			break
+		}
+		if _, err := io.CopyN(w, s.r, int64(n)); err != nil {
+			s.fail(err)
+		}
+	}
+	sum := make([]byte, md4.Size)
+	s.read(sum)
+	if s.e == nil && !bytes.Equal(sum, h.Sum(nil)) {
+		s.fail(fmt.Errorf("rsyncfs: checksum mismatch receiving %s", path))
+	}
+	for i := 0; i < 2 && s.e == nil; i++ {
+		if n := s.readInt32(); n != -1 {
+			s.fail(fmt.Errorf("rsyncfs: protocol error: unexpected file %d", n))
+		}
+	}
+	return s.finish(true)
+}
+
+// put sends the tree files, sorted by name, to path on the server. The
+// server asks for the files that differ from its copies in size or
+// modification time, and those are sent as deltas from its copies. If del is
+// true, files on the server that aren't in files are deleted.
+func (fs *FS) put(path string, files []*entry, del bool) error {
+	args := []string{"--server", "-rtp"}
+	if del {
+		args = append(args, "--delete")
+	}
+	s, err := fs.dial(append(args, ".", path+"/")...)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	if del {
+		s.writeInt32(0) // no filters
+	}
+	s.writeFileList(files)
+	s.flush()
+	for phase := 0; s.e == nil; {
+		i := s.readInt32()
+		if i == -1 {
+			// the end of a phase is acknowledged; there is nothing to
+			// resend in the second
+			s.writeInt32(-1)
+			s.flush()
+			if phase++; phase == 2 {
+				break
+			}
+			continue
+		}
+		sh := s.readSumHead()
+		if s.e == nil && (i < 0 || int(i) >= len(files)) {
+			s.fail(fmt.Errorf("rsyncfs: protocol error: got file %d", i))
+		}
+		if s.e != nil {
+			break
+		}
+		if e := files[i]; e.data != "" {
+			s.sendFile(i, e.data, sh)
+		}
+		// the server changed a file it was told was unchanged; it keeps
+		// its copy
+	}
+	return s.finish(false)
+}
+
+// sumHead describes the blocks of a file that a delta is made against.
+type sumHead struct {
+	count, blockLen, sumLen, remainder int32
+	sums                               []blockSum
+}
+
+// blockSum holds the checksums of a block.
+type blockSum struct {
+	weak   uint32
+	strong []byte
+}
+
+func (s *session) readSumHead() sumHead {
+	sh := sumHead{
+		count:     s.readInt32(),
+		blockLen:  s.readInt32(),
+		sumLen:    s.readInt32(),
+		remainder: s.readInt32(),
+	}
+	if sh.count < 0 || sh.blockLen < 0 || sh.sumLen < 0 || sh.sumLen > md4.Size ||
+		sh.remainder < 0 || sh.remainder > sh.blockLen {
+		s.fail(errors.New("rsyncfs: protocol error: bad checksum header"))
+		return sumHead{}
+	}
+	for i := int32(0); i < sh.count && s.e == nil; i++ {
+		b := blockSum{weak: uint32(s.readInt32()), strong: make([]byte, sh.sumLen)}
+		s.read(b.strong)
+		sh.sums = append(sh.sums, b)
+	}
+	return sh
+}
+
+func (s *session) writeSumHead(sh sumHead) {
+	s.writeInt32(sh.count)
+	s.writeInt32(sh.blockLen)
+	s.writeInt32(sh.sumLen)
+	s.writeInt32(sh.remainder)
+}
+
+// sendFile sends file number i with the contents of the local file name.
+func (s *session) sendFile(i int32, name string, sh sumHead) {
+	f, err := os.Open(name)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	s.writeInt32(i)
+	s.writeSumHead(sh)
+	s.sendDelta(f, fi.Size(), sh)
+
+	h := md4.New()
+	binary.Write(h, binary.LittleEndian, s.seed)
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, fi.Size())); err != nil {
+		s.fail(err)
+	}
+	s.write(h.Sum(nil))
+	s.flush()
+}
@@ -0,0 +1,190 @@
+// Package s3fs provides a backend for fsync that stores files in an Amazon S3
+// or S3-compatible bucket.
+//
+// Importing the package registers the "s3" URL scheme. The host of the URL is
+// the bucket and its path is the key prefix to sync to:
+//
+//	import _ "github.com/mostafah/fsync/s3fs"
+//
+//	s := fsync.NewSyncer()
+//	s.Delete = true // delete orphaned keys
+//	err := s.Sync("s3://my-bucket/site", "build")
+//
+// Credentials are read from the usual AWS environment variables, shared
+// credentials file and instance metadata. The endpoint defaults to AWS and
+// can be changed with the endpoint query parameter, e.g.
+// "s3://bucket/path?endpoint=minio.local:9000&insecure=1" for a MinIO server
+// without TLS. The region can be given with the region parameter.
+//
+// Directories map to key prefixes. Files are compared by size and MD5,
+// which is stored in the object metadata on upload, so unchanged files are
+// never downloaded. Large files are uploaded in parts.
+package s3fs
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/mostafah/fsync"
+	"github.com/mostafah/fsync/internal/objfs"
+)
+
+// PartSize is the size of the parts of multipart uploads. Files larger than
+// this are uploaded in parts.
+var PartSize uint64 = 16 << 20
+
+// md5Key is the metadata key the MD5 of uploaded files is stored under; the
+// ETag of objects uploaded in parts is not their MD5.
+const md5Key = "Fsync-Md5"
+
+func init() {
+	fsync.Register("s3", Open)
+}
+
+// New returns an FS that stores files in bucket using c. Names are used as
+// keys, without the leading slash.
+func New(c *minio.Client, bucket string) fsync.FS {
+	return objfs.New(&store{c: c, bucket: bucket})
+}
+
+// Open returns an FS for the bucket in u. The path of u is not used.
+func Open(u *url.URL) (fsync.FS, error) {
+	q := u.Query()
+	endpoint := q.Get("endpoint")
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{Client: http.DefaultClient},
+	})
+	c, err := minio.New(endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: q.Get("insecure") == "",
+		Region: q.Get("region"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return New(c, u.Host), nil
+}
+
+// store implements objfs.Store for a bucket.
+type store struct {
+	c      *minio.Client
+	bucket string
+}
+
+func (s *store) Stat(key string) (objfs.Object, error) {
+	info, err := s.c.StatObject(context.Background(), s.bucket, key,
+		minio.StatObjectOptions{})
+	if err != nil {
+		return objfs.Object{}, notExist(err)
+	}
+	return object(info), nil
+}
+
+func (s *store) List(prefix string, recursive bool) ([]objfs.Object, error) {
+	var objs []objfs.Object
+	opts := minio.ListObjectsOptions{Prefix: prefix, Recursive: recursive}
+	for info := range s.c.ListObjects(context.Background(), s.bucket, opts) {
+		if info.Err != nil {
+			return nil, info.Err
+		}
+		objs = append(objs, object(info))
+	}
+	return objs, nil
+}
+
+func (s *store) Get(key string) (io.ReadCloser, error) {
+	obj, err := s.c.GetObject(context.Background(), s.bucket, key,
+		minio.GetObjectOptions{})
+	if err != nil {
+		return nil, notExist(err)
+	}
+	// GetObject is lazy; make sure the object exists
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, notExist(err)
+	}
+	return obj, nil
+}
+
+func (s *store) Put(key string, r io.Reader, size int64, sum []byte) error {
+	_, err := s.c.PutObject(context.Background(), s.bucket, key, r, size,
+		minio.PutObjectOptions{
+			PartSize:     PartSize,
+			UserMetadata: map[string]string{md5Key: hex.EncodeToString(sum)},
+		})
+	return err
+}
+
+func (s *store) Delete(key string) error {
+	err := s.c.RemoveObject(context.Background(), s.bucket, key,
+		minio.RemoveObjectOptions{})
+	if os.IsNotExist(notExist(err)) {
+		return nil
+	}
+	return err
+}
+
+// Copy copies objects on the server. ComposeObject is used since CopyObject
+// can't copy objects larger than 5 GiB. The MD5 of the source is stored
+// with the copy, which would otherwise lose it when copied in parts.
+func (s *store) Copy(dst, src string) error {
+	obj, err := s.Stat(src)
+	if err != nil {
+		return err
+	}
+	opts := minio.CopyDestOptions{Bucket: s.bucket, Object: dst}
+	if obj.Sum != nil {
+		opts.UserMetadata = map[string]string{md5Key: hex.EncodeToString(obj.Sum)}
+		opts.ReplaceMetadata = true
+	}
+	_, err = s.c.ComposeObject(context.Background(), opts,
+		minio.CopySrcOptions{Bucket: s.bucket, Object: src})
+	return err
+}
+
+func (s *store) NewHash() hash.Hash {
+	return md5.New()
+}
+
+// object converts an object info from S3.
+func object(info minio.ObjectInfo) objfs.Object {
+	obj := objfs.Object{
+		Key:     info.Key,
+		Size:    info.Size,
+		ModTime: info.LastModified,
+	}
+	if v := info.UserMetadata[md5Key]; v != "" {
+		obj.Sum, _ = hex.DecodeString(v)
+	} else if etag := strings.Trim(info.ETag, `"`); !strings.Contains(etag, "-") {
+		// the ETag of objects uploaded in one part is their MD5
+		obj.Sum, _ = hex.DecodeString(etag)
+	}
+	return obj
+}
+
+// notExist converts S3 "not found" errors to os.ErrNotExist.
+func notExist(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NotFound":
+		return os.ErrNotExist
+	}
+	return err
+}
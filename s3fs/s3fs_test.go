@@ -0,0 +1,274 @@
+package s3fs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/mostafah/fsync"
+)
+
+// fakeObject is an object of fakeS3.
+type fakeObject struct {
+	data []byte
+	meta http.Header // X-Amz-Meta- headers
+	time time.Time
+	tag  string // ETag, if not the MD5 of data
+}
+
+func (o *fakeObject) etag() string {
+	if o.tag != "" {
+		return o.tag
+	}
+	return etag(o.data)
+}
+
+// fakeS3 serves the few S3 requests store makes, for a single bucket, with
+// path-style URLs and no authentication.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]*fakeObject
+	uploads map[string]*fakeObject // multipart uploads by ID
+	copies  int
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(parts) == 1 || parts[1] == "" {
+		s.list(w, r)
+		return
+	}
+	key := parts[1]
+	obj := s.objects[key]
+	q := r.URL.Query()
+	switch {
+	case r.Method == "POST" && q.Has("uploads"):
+		id := fmt.Sprint(len(s.uploads))
+		s.uploads[id] = &fakeObject{meta: metadata(r.Header)}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", key, id)
+		return
+	case r.Method == "PUT" && q.Has("uploadId"):
+		// only parts copied from other objects
+		up := s.uploads[q.Get("uploadId")]
+		up.data = append(up.data, s.source(r).data...)
+		fmt.Fprintf(w, "<CopyPartResult><ETag>%s</ETag><LastModified>%s</LastModified></CopyPartResult>",
+			etag(up.data), time.Now().UTC().Format(time.RFC3339))
+		return
+	case r.Method == "POST" && q.Has("uploadId"):
+		up := s.uploads[q.Get("uploadId")]
+		up.time = time.Now()
+		up.tag = partsETag(up.data)
+		s.objects[key] = up
+		s.copies++
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>%s</ETag></CompleteMultipartUploadResult>",
+			parts[0], key, up.tag)
+		return
+	}
+	switch r.Method {
+	case "HEAD", "GET":
+		if obj == nil {
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == "GET" {
+				fmt.Fprintf(w, "<Error><Code>NoSuchKey</Code><Key>%s</Key></Error>", key)
+			}
+			return
+		}
+		for k, v := range obj.meta {
+			w.Header()[k] = v
+		}
+		w.Header().Set("ETag", obj.etag())
+		w.Header().Set("Last-Modified", obj.time.Format(http.TimeFormat))
+		w.Header().Set("Content-Length", fmt.Sprint(len(obj.data)))
+		if r.Method == "GET" {
+			w.Write(obj.data)
+		}
+	case "PUT":
+		if from := s.source(r); from != nil {
+			to := &fakeObject{data: from.data, meta: from.meta, time: time.Now()}
+			if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+				to.meta = metadata(r.Header)
+			}
+			s.objects[key] = to
+			s.copies++
+			fmt.Fprintf(w, "<CopyObjectResult><ETag>%s</ETag><LastModified>%s</LastModified></CopyObjectResult>",
+				etag(to.data), to.time.UTC().Format(time.RFC3339))
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		s.objects[key] = &fakeObject{data: data, meta: metadata(r.Header), time: time.Now()}
+		w.Header().Set("ETag", etag(data))
+	case "DELETE":
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// source returns the object r copies, if any.
+func (s *fakeS3) source(r *http.Request) *fakeObject {
+	src, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if src == "" {
+		return nil
+	}
+	return s.objects[strings.SplitN(strings.TrimPrefix(src, "/"), "/", 2)[1]]
+}
+
+// list answers ListObjectsV2.
+func (s *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	type content struct {
+		Key          string
+		Size         int64
+		ETag         string
+		LastModified string
+	}
+	type prefix struct{ Prefix string }
+	var res struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		Contents       []content
+		CommonPrefixes []prefix
+	}
+	pre, delim := r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter")
+	var keys []string
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		if !strings.HasPrefix(k, pre) {
+			continue
+		}
+		if i := strings.Index(k[len(pre):], delim); delim != "" && i >= 0 {
+			p := k[:len(pre)+i+1]
+			if !seen[p] {
+				seen[p] = true
+				res.CommonPrefixes = append(res.CommonPrefixes, prefix{p})
+			}
+			continue
+		}
+		obj := s.objects[k]
+		res.Contents = append(res.Contents, content{k, int64(len(obj.data)), obj.etag(),
+			obj.time.UTC().Format(time.RFC3339)})
+	}
+	xml.NewEncoder(w).Encode(res)
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// partsETag returns the ETag of an object uploaded in one part, which isn't
+// its MD5.
+func partsETag(data []byte) string {
+	sum := md5.Sum([]byte(etag(data)))
+	return `"` + hex.EncodeToString(sum[:]) + `-1"`
+}
+
+func metadata(h http.Header) http.Header {
+	meta := make(http.Header)
+	for k, v := range h {
+		if strings.HasPrefix(k, "X-Amz-Meta-") {
+			meta[k] = v
+		}
+	}
+	return meta
+}
+
+func TestSync(t *testing.T) {
+	src, err := ioutil.TempDir(os.TempDir(), "s3fs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	if err := os.MkdirAll(filepath.Join(src, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "a/b"), []byte("file b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "c"), []byte("file c"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &fakeS3{objects: make(map[string]*fakeObject), uploads: make(map[string]*fakeObject)}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	c, err := minio.New(strings.TrimPrefix(ts.URL, "http://"), &minio.Options{
+		Creds:  credentials.NewStaticV4("", "", ""),
+		Region: "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := New(c, "bucket")
+
+	s := fsync.NewSyncer()
+	s.DstFS = fs
+	s.Delete = true
+	if err := s.Sync("/site", src); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"site/a/b": "file b", "site/c": "file c"} {
+		if obj := srv.objects[name]; obj == nil {
+			t.Errorf("%s wasn't uploaded", name)
+		} else if string(obj.data) != content {
+			t.Errorf("%s is %q, should be %q", name, obj.data, content)
+		}
+	}
+
+	// copies on the server keep the MD5 of their source, even when their
+	// ETag isn't one and the source was uploaded by others
+	srv.objects["site/e"] = &fakeObject{data: []byte("file c"), meta: make(http.Header), time: time.Now()}
+	if err := fs.Rename("/site/e", "/site/d"); err != nil {
+		t.Fatal(err)
+	}
+	if srv.copies != 1 {
+		t.Errorf("expecting a copy on the server, got %d", srv.copies)
+	}
+	h := fs.(fsync.Hasher)
+	sum, err := h.Hash("/site/d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := md5.Sum([]byte("file c"))
+	if !bytes.Equal(sum, want[:]) {
+		t.Errorf("expecting the MD5 of c to be kept, got %x", sum)
+	}
+	if v := srv.objects["site/d"].meta.Get("X-Amz-Meta-" + md5Key); v != hex.EncodeToString(want[:]) {
+		t.Errorf("expecting the copy to carry %s, got %q", md5Key, v)
+	}
+
+	// a second sync deletes extra files and leaves unchanged ones alone
+	if err := os.Remove(filepath.Join(src, "a/b")); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := s.SyncStats("/site", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"site/a/b", "site/d"} {
+		if _, ok := srv.objects[name]; ok {
+			t.Errorf("%s should be deleted", name)
+		}
+	}
+	if _, ok := srv.objects["site/c"]; !ok || stats.Files != 0 || stats.Unchanged != 1 {
+		t.Errorf("expecting c to be left unchanged, got %+v", stats)
+	}
+}
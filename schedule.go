@@ -0,0 +1,42 @@
+package fsync
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Run syncs dst with src right away and then every interval, which must be
+// positive, until ctx is done, when it returns ctx.Err(). Each sync starts
+// up to a tenth of interval late, at random, so that many Syncers started
+// together don't hit the same servers at once. A sync that takes longer
+// than interval makes Run skip the syncs it overlaps instead of starting
+// them late. The result of each sync is passed to OnRun; errors don't stop
+// Run. With Seed, the delays are the same each time.
+func (s *Syncer) Run(ctx context.Context, dst, src string, interval time.Duration) error {
+	clock := s.clock()
+	start := clock.Now()
+	rnd := rand.New(rand.NewSource(s.seed()))
+	wait := clock.After(0)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wait:
+		}
+		stats, err := s.SyncStats(dst, src)
+		if s.OnRun != nil {
+			s.OnRun(stats, err)
+		}
+		now := clock.Now()
+		jitter := time.Duration(rnd.Int63n(int64(interval)/10 + 1))
+		wait = clock.After(nextTick(start, interval, now).Add(jitter).Sub(now))
+	}
+}
+
+// nextTick returns the first time after now in the schedule of every
+// interval from start.
+func nextTick(start time.Time, interval time.Duration, now time.Time) time.Time {
+	n := now.Sub(start)/interval + 1
+	return start.Add(n * interval)
+}
@@ -0,0 +1,51 @@
+package fsync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("file a"), 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var runs []Stats
+	s := NewSyncer()
+	s.OnRun = func(stats Stats, err error) {
+		check(err)
+		runs = append(runs, stats)
+		if len(runs) == 3 {
+			cancel()
+		}
+	}
+	if err := s.Run(ctx, dst, src, 10*time.Millisecond); err != context.Canceled {
+		t.Errorf("expecting context.Canceled, got %v", err)
+	}
+	if len(runs) != 3 || runs[0].Files != 1 || runs[2].Unchanged != 1 {
+		t.Errorf("wrong runs: %+v", runs)
+	}
+}
+
+func TestNextTick(t *testing.T) {
+	start := time.Now()
+	for _, c := range []struct{ now, next time.Duration }{
+		{0, time.Minute},
+		{time.Second, time.Minute},
+		{time.Minute, 2 * time.Minute},
+		{3*time.Minute + time.Second, 4 * time.Minute}, // skipped two
+	} {
+		if next := nextTick(start, time.Minute, start.Add(c.now)); !next.Equal(start.Add(c.next)) {
+			t.Errorf("at %v expecting %v, got %v", c.now, c.next, next.Sub(start))
+		}
+	}
+}
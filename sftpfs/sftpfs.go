@@ -0,0 +1,213 @@
+// Package sftpfs provides an SFTP backend for fsync.
+//
+// Importing the package registers the "sftp" URL scheme, so a local tree can
+// be mirrored to a remote server with
+//
+//	import _ "github.com/mostafah/fsync/sftpfs"
+//
+//	err := fsync.Sync("sftp://user@host/var/www", "public")
+//
+// Connections opened from URLs authenticate with the password in the URL, if
+// any, then with the keys of a running ssh-agent, then with the default keys
+// in ~/.ssh. Host keys are checked against ~/.ssh/known_hosts.
+//
+// To use an existing connection instead, wrap the client with New:
+//
+//	s := fsync.NewSyncer()
+//	s.DstFS = sftpfs.New(client)
+//	err := s.Sync("/var/www", "public")
+package sftpfs
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/mostafah/fsync"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func init() {
+	fsync.Register("sftp", Open)
+}
+
+// FS is an fsync.FS on a remote server accessed over SFTP.
+type FS struct {
+	c     *sftp.Client
+	conn  *ssh.Client // only set when FS owns the connection
+	agent net.Conn    // to ssh-agent, if Open used it
+}
+
+// New returns an FS that uses c. Closing the FS does not close c.
+func New(c *sftp.Client) *FS {
+	return &FS{c: c}
+}
+
+// Open connects to the server in u and returns an FS for it. The path of u is
+// ignored. The FS owns the connection and closes it when closed.
+func Open(u *url.URL) (fsync.FS, error) {
+	config, ag, err := clientConfig(u)
+	if err != nil {
+		return nil, err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	conn, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		if ag != nil {
+			ag.Close()
+		}
+		return nil, err
+	}
+	c, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		if ag != nil {
+			ag.Close()
+		}
+		return nil, err
+	}
+	return &FS{c: c, conn: conn, agent: ag}, nil
+}
+
+// clientConfig builds the SSH configuration for connecting to u, and
+// returns the connection to ssh-agent it uses, if any, which the caller
+// must close.
+func clientConfig(u *url.URL) (*ssh.ClientConfig, net.Conn, error) {
+	home := os.Getenv("HOME")
+	usr, err := user.Current()
+	if err == nil {
+		home = usr.HomeDir
+	}
+	name := u.User.Username()
+	if name == "" && usr != nil {
+		name = usr.Username
+	}
+
+	hostKeys, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var auth []ssh.AuthMethod
+	if pass, ok := u.User.Password(); ok {
+		auth = append(auth, ssh.Password(pass))
+	}
+	var ag net.Conn
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if ag, err = net.Dial("unix", sock); err == nil {
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(ag).Signers))
+		} else {
+			ag = nil
+		}
+	}
+	var signers []ssh.Signer
+	for _, key := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		b, err := ioutil.ReadFile(filepath.Join(home, ".ssh", key))
+		if err != nil {
+			continue
+		}
+		if signer, err := ssh.ParsePrivateKey(b); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+
+	return &ssh.ClientConfig{
+		User:            name,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+	}, ag, nil
+}
+
+// Close closes the connection, and the one to ssh-agent, if FS was created
+// by Open.
+func (fs *FS) Close() error {
+	if fs.conn == nil {
+		return nil
+	}
+	err := fs.c.Close()
+	if err2 := fs.conn.Close(); err == nil {
+		err = err2
+	}
+	if fs.agent != nil {
+		if err2 := fs.agent.Close(); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+func (fs *FS) Stat(name string) (os.FileInfo, error) {
+	return fs.c.Stat(filepath.ToSlash(name))
+}
+
+func (fs *FS) ReadDir(name string) ([]os.FileInfo, error) {
+	return fs.c.ReadDir(filepath.ToSlash(name))
+}
+
+func (fs *FS) Open(name string) (io.ReadCloser, error) {
+	return fs.c.Open(filepath.ToSlash(name))
+}
+
+func (fs *FS) Create(name string) (io.WriteCloser, error) {
+	return fs.c.Create(filepath.ToSlash(name))
+}
+
+// MkdirAll creates name and any missing parents. perm is ignored; fsync
+// syncs permissions afterwards.
+func (fs *FS) MkdirAll(name string, perm os.FileMode) error {
+	return fs.c.MkdirAll(filepath.ToSlash(name))
+}
+
+func (fs *FS) Remove(name string) error {
+	return fs.c.Remove(filepath.ToSlash(name))
+}
+
+func (fs *FS) RemoveAll(name string) error {
+	return fs.c.RemoveAll(filepath.ToSlash(name))
+}
+
+// Rename uses the posix-rename extension when the server supports it, so an
+// existing newname is replaced like it would be locally.
+func (fs *FS) Rename(oldname, newname string) error {
+	oldname, newname = filepath.ToSlash(oldname), filepath.ToSlash(newname)
+	if _, ok := fs.c.HasExtension("posix-rename@openssh.com"); ok {
+		return fs.c.PosixRename(oldname, newname)
+	}
+	return fs.c.Rename(oldname, newname)
+}
+
+// Chmod changes the permissions of name. Servers that don't support setting
+// permissions are silently ignored.
+func (fs *FS) Chmod(name string, mode os.FileMode) error {
+	return unsupported(fs.c.Chmod(filepath.ToSlash(name), mode))
+}
+
+// Chtimes changes the times of name. Servers that don't support setting
+// times are silently ignored.
+func (fs *FS) Chtimes(name string, atime, mtime time.Time) error {
+	return unsupported(fs.c.Chtimes(filepath.ToSlash(name), atime, mtime))
+}
+
+// unsupported returns nil if err says the operation is not supported by the
+// server.
+func unsupported(err error) error {
+	if e, ok := err.(*sftp.StatusError); ok &&
+		e.FxCode() == sftp.ErrSSHFxOpUnsupported {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package sftpfs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mostafah/fsync"
+	"github.com/pkg/sftp"
+)
+
+// pipe joins the ends of two pipes into a connection.
+type pipe struct {
+	io.Reader
+	io.WriteCloser
+}
+
+func TestSync(t *testing.T) {
+	src, err := ioutil.TempDir(os.TempDir(), "sftpfs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	srv, err := ioutil.TempDir(os.TempDir(), "sftpfs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srv)
+	if err := os.MkdirAll(filepath.Join(src, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "a/b"), []byte("file b"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "c"), []byte("file c"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// an in-process server on the local file system
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	server, err := sftp.NewServer(pipe{sr, sw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		server.Serve()
+		server.Close() // so that the client sees the end
+	}()
+	c, err := sftp.NewClientPipe(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s := fsync.NewSyncer()
+	s.DstFS = New(c)
+	s.Delete = true
+	dst := filepath.Join(srv, "deploy")
+	if err := s.Sync(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"a/b": "file b", "c": "file c"} {
+		b, err := ioutil.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != content {
+			t.Errorf("%s is %q, should be %q", name, b, content)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(dst, "a/b")); err != nil {
+		t.Error(err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Errorf("a/b has permissions %v, should have 0600", fi.Mode().Perm())
+	}
+
+	// reading back through the FS, a second sync deletes extra files
+	s.SrcFS, s.DstFS = New(c), nil
+	back := filepath.Join(src, "back")
+	if err := s.Sync(back, dst); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(back, "c")); err != nil || string(b) != "file c" {
+		t.Errorf("c read back as %q, %v", b, err)
+	}
+	if err := os.Remove(filepath.Join(src, "c")); err != nil {
+		t.Fatal(err)
+	}
+	s.SrcFS, s.DstFS = nil, New(c)
+	s.Exclude = []string{"back"}
+	if err := s.Sync(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "c")); !os.IsNotExist(err) {
+		t.Errorf("c should be deleted, got %v", err)
+	}
+}
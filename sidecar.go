@@ -0,0 +1,132 @@
+package fsync
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSidecar = errors.New("fsync: the source doesn't match the checksum of its sidecar")
+)
+
+// SumsFile is the name of the files listing the checksums of the files in
+// their directory, as sha256sum writes them, that Sidecars uses.
+const SumsFile = "SHA256SUMS"
+
+// sidecars caches the SumsFile of the source directories, with Sidecars.
+type sidecars struct {
+	mu   sync.Mutex
+	dirs map[string]*sums
+}
+
+// sums are the checksums of a sidecar, by file name, as of its time.
+type sums struct {
+	time time.Time
+	sums map[string][]byte
+}
+
+// sidecar returns the checksum of the source file src, with the info
+// sstat, from its sidecar, or nil if there's none that's newer than it, or
+// if Transformers change it.
+func (r *run) sidecar(src string, sstat os.FileInfo) []byte {
+	if !r.Sidecars || r.transformed(src) {
+		return nil
+	}
+	name := filepath.Base(src)
+	if s := readSums(r.sfs, src+".sha256"); s != nil && !s.time.Before(sstat.ModTime()) {
+		if sum := s.sums[name]; sum != nil {
+			return sum
+		}
+		// a single sum with no name, or named by where it was computed
+		if len(s.sums) == 1 {
+			for n, sum := range s.sums {
+				if n == "" || path.Base(n) == name {
+					return sum
+				}
+			}
+		}
+	}
+	dir := filepath.Dir(src)
+	r.sums.mu.Lock()
+	s, ok := r.sums.dirs[dir]
+	if !ok {
+		s = readSums(r.sfs, filepath.Join(dir, SumsFile))
+		if r.sums.dirs == nil {
+			r.sums.dirs = make(map[string]*sums)
+		}
+		r.sums.dirs[dir] = s
+	}
+	r.sums.mu.Unlock()
+	if s == nil || s.time.Before(sstat.ModTime()) {
+		return nil
+	}
+	return s.sums[name]
+}
+
+// srcSum returns the SHA-256 checksum of the source file src, with the
+// info sstat, from its sidecar if it has one, or computed otherwise.
+func (r *run) srcSum(src string, sstat os.FileInfo) []byte {
+	if sum := r.sidecar(src, sstat); sum != nil {
+		return sum
+	}
+	return hashFile(r.sfs, src, sha256.New())
+}
+
+// matchSidecar panics with ErrSidecar if the source file src, copied with
+// the checksum sum, has a sidecar with another.
+func (r *run) matchSidecar(src string, sum []byte) {
+	if !r.Sidecars {
+		return
+	}
+	sstat, err := r.sfs.Stat(src)
+	check(err)
+	if want := r.sidecar(src, sstat); want != nil && !bytes.Equal(want, sum) {
+		panic(&os.PathError{Op: "sync", Path: src, Err: ErrSidecar})
+	}
+}
+
+// readSums reads the sidecar name in fs, or returns nil if there's none.
+func readSums(fs FS, name string) *sums {
+	fi, err := fs.Stat(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	check(err)
+	f, err := fs.Open(name)
+	check(err)
+	defer f.Close()
+	return &sums{time: fi.ModTime(), sums: parseSums(f)}
+}
+
+// parseSums parses the lines of sha256sum, a checksum in hex followed by
+// the file name, which is marked with '*' in binary mode. Lines it can't
+// parse are skipped.
+func parseSums(in io.Reader) map[string][]byte {
+	sums := make(map[string][]byte)
+	sc := bufio.NewScanner(in)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		hexSum, name := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			hexSum = line[:i]
+			name = strings.TrimPrefix(strings.TrimLeft(line[i:], " \t"), "*")
+		}
+		sum, err := hex.DecodeString(hexSum)
+		if err != nil || len(sum) != sha256.Size {
+			continue
+		}
+		sums[strings.TrimPrefix(name, "./")] = sum
+	}
+	check(sc.Err())
+	return sums
+}
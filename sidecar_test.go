@@ -0,0 +1,76 @@
+package fsync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSidecars(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(os.MkdirAll(dst, 0755))
+	sum := sha256.Sum256([]byte("file a"))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("file x"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "a.sha256"), []byte(hex.EncodeToString(sum[:])+"  a\n"), 0644))
+	check(ioutil.WriteFile(filepath.Join(dst, "a"), []byte("file a"), 0644))
+
+	// the sidecar is trusted over the contents
+	s := NewSyncer()
+	s.Sidecars = true
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "a"), []byte("file a"), t)
+
+	// unless it's older than the file
+	old := time.Now().Add(-time.Hour)
+	check(os.Chtimes(filepath.Join(src, "a.sha256"), old, old))
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "a"), []byte("file x"), t)
+
+	// copies are checked against the sums of the directory
+	check(ioutil.WriteFile(filepath.Join(src, "b"), []byte("file b"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, SumsFile), []byte(hex.EncodeToString(sum[:])+" *b\n"), 0644))
+	err = s.Sync(dst, src)
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != ErrSidecar {
+		t.Errorf("expecting ErrSidecar, got %v", err)
+	}
+	sum = sha256.Sum256([]byte("file b"))
+	check(ioutil.WriteFile(filepath.Join(src, SumsFile), []byte(hex.EncodeToString(sum[:])+" *b\n"), 0644))
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "b"), []byte("file b"), t)
+}
+
+func TestSidecarNames(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "a")
+	check(ioutil.WriteFile(name, []byte("file a"), 0644))
+	fi, err := os.Stat(name)
+	check(err)
+	sum := sha256.Sum256([]byte("file a"))
+	s := NewSyncer()
+	s.Sidecars = true
+	r := &run{Syncer: s, sfs: osFS{}}
+	for line, ok := range map[string]bool{
+		"":           true,
+		"  a":        true,
+		" *dir/a":    true,
+		"  b":        false,
+		"  a.sha256": false,
+		"  a\n0  b":  true, // the bad line is skipped
+	} {
+		check(ioutil.WriteFile(name+".sha256", []byte(hex.EncodeToString(sum[:])+line+"\n"), 0644))
+		if got := r.sidecar(name, fi); (got != nil) != ok {
+			t.Errorf("with %q, expecting a sum %v, got %x", line, ok, got)
+		}
+	}
+}
@@ -0,0 +1,576 @@
+package smbfs
+
+import (
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/mostafah/fsync"
+)
+
+var le = binary.LittleEndian
+
+// SMB2 commands and NT status codes used by fakeSMB
+const (
+	cmdNegotiate      = 0x00
+	cmdSessionSetup   = 0x01
+	cmdLogoff         = 0x02
+	cmdTreeConnect    = 0x03
+	cmdTreeDisconnect = 0x04
+	cmdCreate         = 0x05
+	cmdClose          = 0x06
+	cmdRead           = 0x08
+	cmdWrite          = 0x09
+	cmdQueryDirectory = 0x0e
+	cmdQueryInfo      = 0x10
+	cmdSetInfo        = 0x11
+
+	statusSuccess                = 0x00000000
+	statusNoMoreFiles            = 0x80000006
+	statusUnsuccessful           = 0xc0000001
+	statusEndOfFile              = 0xc0000011
+	statusMoreProcessingRequired = 0xc0000016
+	statusAccessDenied           = 0xc0000022
+	statusNameNotFound           = 0xc0000034
+	statusNameCollision          = 0xc0000035
+	statusNotSupported           = 0xc00000bb
+	statusBadNetworkName         = 0xc00000cc
+	statusDirectoryNotEmpty      = 0xc0000101
+)
+
+// fakeSMB serves the files in root as the share "share" to one client at a
+// time. It speaks just enough SMB 2.1 for the requests FS makes, with a guest
+// session so that nothing is signed.
+type fakeSMB struct {
+	root string
+	l    net.Listener
+}
+
+func newFakeSMB(t *testing.T, root string) *fakeSMB {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMB{root: root, l: l}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMB) Close() error {
+	return s.l.Close()
+}
+
+// smbConn is the state of a client connection.
+type smbConn struct {
+	s      *fakeSMB
+	authed bool                // the NTLM challenge was sent
+	files  map[uint64]*smbFile // open files by ID
+	lastID uint64
+}
+
+// smbFile is an open file or directory.
+type smbFile struct {
+	name   string // on disk
+	listed bool   // the directory was listed already
+}
+
+func (s *fakeSMB) serve(c net.Conn) {
+	defer c.Close()
+	sc := &smbConn{s: s, files: make(map[uint64]*smbFile)}
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(c, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(c, req); err != nil {
+			return
+		}
+		status, body := sc.handle(le.Uint16(req[12:14]), req)
+		if body == nil {
+			body = []byte{9, 0, 0, 0, 0, 0, 0, 0, 0} // error response
+		}
+		res := make([]byte, 64+len(body))
+		copy(res, req[:64])
+		le.PutUint32(res[8:12], status)
+		if credits := le.Uint16(req[14:16]); credits == 0 {
+			le.PutUint16(res[14:16], 1)
+		}
+		le.PutUint32(res[16:20], 1) // a response, not signed
+		le.PutUint32(res[20:24], 0)
+		le.PutUint32(res[36:40], 1) // tree ID
+		le.PutUint64(res[40:48], 1) // session ID
+		copy(res[48:64], make([]byte, 16))
+		copy(res[64:], body)
+		binary.BigEndian.PutUint32(size[:], uint32(len(res)))
+		if _, err := c.Write(append(size[:], res...)); err != nil {
+			return
+		}
+	}
+}
+
+// handle answers the request req with command cmd. A nil body is sent as an
+// error response.
+func (sc *smbConn) handle(cmd uint16, req []byte) (uint32, []byte) {
+	b := req[64:]
+	switch cmd {
+	case cmdNegotiate:
+		res := make([]byte, 65)
+		le.PutUint16(res[0:2], 65)
+		le.PutUint16(res[2:4], 1)      // signing enabled, not required
+		le.PutUint16(res[4:6], 0x0210) // SMB 2.1
+		for i := 28; i < 40; i += 4 {
+			le.PutUint32(res[i:], 65536) // max transact, read and write
+		}
+		return statusSuccess, res
+	case cmdSessionSetup:
+		res := make([]byte, 8)
+		le.PutUint16(res[0:2], 9)
+		le.PutUint16(res[2:4], 1) // guest
+		if sc.authed {
+			return statusSuccess, res
+		}
+		sc.authed = true
+		tok, err := challenge()
+		if err != nil {
+			return statusUnsuccessful, nil
+		}
+		le.PutUint16(res[4:6], 64+8)
+		le.PutUint16(res[6:8], uint16(len(tok)))
+		return statusMoreProcessingRequired, append(res, tok...)
+	case cmdLogoff, cmdTreeDisconnect:
+		return statusSuccess, []byte{4, 0, 0, 0}
+	case cmdTreeConnect:
+		off, n := le.Uint16(b[4:6]), le.Uint16(b[6:8])
+		if !strings.HasSuffix(decodeName(req[off:off+n]), `\share`) {
+			return statusBadNetworkName, nil
+		}
+		res := make([]byte, 16)
+		le.PutUint16(res[0:2], 16)
+		res[2] = 1 // disk
+		return statusSuccess, res
+	case cmdCreate:
+		return sc.create(req)
+	case cmdClose:
+		delete(sc.files, le.Uint64(b[8:16]))
+		res := make([]byte, 60)
+		le.PutUint16(res[0:2], 60)
+		return statusSuccess, res
+	}
+
+	var f *smbFile
+	switch cmd {
+	case cmdRead, cmdWrite, cmdSetInfo:
+		f = sc.files[le.Uint64(b[16:24])]
+	case cmdQueryDirectory:
+		f = sc.files[le.Uint64(b[8:16])]
+	case cmdQueryInfo:
+		f = sc.files[le.Uint64(b[24:32])]
+	default:
+		return statusNotSupported, nil
+	}
+	if f == nil {
+		return statusUnsuccessful, nil
+	}
+	switch cmd {
+	case cmdRead:
+		return f.read(b)
+	case cmdWrite:
+		return f.write(req)
+	case cmdQueryDirectory:
+		return f.list()
+	case cmdQueryInfo:
+		if b[3] != 4 { // FileBasicInformation
+			return statusNotSupported, nil
+		}
+		fi, err := os.Stat(f.name)
+		if err != nil {
+			return ntStatus(err), nil
+		}
+		res := make([]byte, 8+40)
+		le.PutUint16(res[0:2], 9)
+		le.PutUint16(res[2:4], 64+8)
+		le.PutUint32(res[4:8], 40)
+		putTimes(res[8:40], fi.ModTime())
+		le.PutUint32(res[40:44], attributes(fi))
+		return statusSuccess, res
+	default:
+		return sc.setInfo(f, req)
+	}
+}
+
+// challenge returns the SPNEGO response with the NTLM challenge.
+func challenge() ([]byte, error) {
+	name := encodeName("FAKE")
+	info := make([]byte, 4) // just MsvAvEOL
+	msg := make([]byte, 56, 56+len(name)+len(info))
+	copy(msg[0:8], "NTLMSSP\x00")
+	le.PutUint32(msg[8:12], 2) // challenge
+	le.PutUint16(msg[12:14], uint16(len(name)))
+	le.PutUint16(msg[14:16], uint16(len(name)))
+	le.PutUint32(msg[16:20], 56)
+	// unicode, request target, NTLM, extended session security, target
+	// info and 128-bit
+	le.PutUint32(msg[20:24], 0x20880205)
+	copy(msg[24:32], "12345678")
+	le.PutUint16(msg[40:42], uint16(len(info)))
+	le.PutUint16(msg[42:44], uint16(len(info)))
+	le.PutUint32(msg[44:48], uint32(56+len(name)))
+	msg = append(append(msg, name...), info...)
+
+	var resp struct {
+		NegState      asn1.Enumerated       `asn1:"explicit,tag:0"`
+		SupportedMech asn1.ObjectIdentifier `asn1:"explicit,tag:1"`
+		ResponseToken []byte                `asn1:"explicit,tag:2"`
+	}
+	resp.NegState = 1 // accept-incomplete
+	resp.SupportedMech = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 2, 10}
+	resp.ResponseToken = msg
+	return asn1.MarshalWithParams(resp, "explicit,tag:1")
+}
+
+// create opens or creates the file named in req.
+func (sc *smbConn) create(req []byte) (uint32, []byte) {
+	const (
+		fileOpen        = 1
+		fileCreate      = 2
+		fileOverwrite   = 4
+		fileOverwriteIf = 5
+		fileDirectory   = 1 // create option
+	)
+	b := req[64:]
+	disp, opts := le.Uint32(b[36:40]), le.Uint32(b[40:44])
+	off, n := le.Uint16(b[44:46]), le.Uint16(b[46:48])
+	name := sc.s.local(decodeName(req[off : off+n]))
+
+	_, err := os.Stat(name)
+	switch {
+	case err != nil && !os.IsNotExist(err):
+	case err == nil && disp == fileCreate:
+		err = os.ErrExist
+	case err != nil && (disp == fileOpen || disp == fileOverwrite):
+	case err != nil && opts&fileDirectory != 0:
+		err = os.Mkdir(name, 0755)
+	case err != nil || disp == fileOverwrite || disp == fileOverwriteIf:
+		err = ioutil.WriteFile(name, nil, 0644)
+	}
+	if err != nil {
+		return ntStatus(err), nil
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return ntStatus(err), nil
+	}
+	sc.lastID++
+	sc.files[sc.lastID] = &smbFile{name: name}
+
+	res := make([]byte, 88)
+	le.PutUint16(res[0:2], 89)
+	le.PutUint32(res[4:8], 1) // opened
+	putTimes(res[8:40], fi.ModTime())
+	le.PutUint64(res[40:48], uint64(fi.Size()))
+	le.PutUint64(res[48:56], uint64(fi.Size()))
+	le.PutUint32(res[56:60], attributes(fi))
+	le.PutUint64(res[64:72], sc.lastID)
+	le.PutUint64(res[72:80], sc.lastID)
+	return statusSuccess, res
+}
+
+func (f *smbFile) read(b []byte) (uint32, []byte) {
+	n, off := le.Uint32(b[4:8]), int64(le.Uint64(b[8:16]))
+	file, err := os.Open(f.name)
+	if err != nil {
+		return ntStatus(err), nil
+	}
+	defer file.Close()
+	data := make([]byte, n)
+	m, err := file.ReadAt(data, off)
+	if m == 0 && err == io.EOF {
+		return statusEndOfFile, nil
+	} else if err != nil && err != io.EOF {
+		return ntStatus(err), nil
+	}
+	res := make([]byte, 16+m)
+	le.PutUint16(res[0:2], 17)
+	res[2] = 64 + 16
+	le.PutUint32(res[4:8], uint32(m))
+	copy(res[16:], data[:m])
+	return statusSuccess, res
+}
+
+func (f *smbFile) write(req []byte) (uint32, []byte) {
+	b := req[64:]
+	dataOff, n, off := le.Uint16(b[2:4]), le.Uint32(b[4:8]), int64(le.Uint64(b[8:16]))
+	file, err := os.OpenFile(f.name, os.O_WRONLY, 0)
+	if err != nil {
+		return ntStatus(err), nil
+	}
+	defer file.Close()
+	m, err := file.WriteAt(req[dataOff:uint32(dataOff)+n], off)
+	if err != nil {
+		return ntStatus(err), nil
+	}
+	res := make([]byte, 16)
+	le.PutUint16(res[0:2], 17)
+	le.PutUint32(res[4:8], uint32(m))
+	return statusSuccess, res
+}
+
+// list answers the first query of a directory with all its entries as
+// FileDirectoryInformation, and the next ones with no more files.
+func (f *smbFile) list() (uint32, []byte) {
+	if f.listed {
+		return statusNoMoreFiles, nil
+	}
+	f.listed = true
+	fis, err := ioutil.ReadDir(f.name)
+	if err != nil {
+		return ntStatus(err), nil
+	}
+	if len(fis) == 0 {
+		return statusNoMoreFiles, nil
+	}
+	var buf []byte
+	last := 0
+	for _, fi := range fis {
+		name := encodeName(fi.Name())
+		e := make([]byte, (64+len(name)+7)&^7)
+		putTimes(e[8:40], fi.ModTime())
+		le.PutUint64(e[40:48], uint64(fi.Size()))
+		le.PutUint64(e[48:56], uint64(fi.Size()))
+		le.PutUint32(e[56:60], attributes(fi))
+		le.PutUint32(e[60:64], uint32(len(name)))
+		copy(e[64:], name)
+		if len(buf) > 0 {
+			le.PutUint32(buf[last:], uint32(len(buf)-last))
+		}
+		last = len(buf)
+		buf = append(buf, e...)
+	}
+	res := make([]byte, 8, 8+len(buf))
+	le.PutUint16(res[0:2], 9)
+	le.PutUint16(res[2:4], 64+8)
+	le.PutUint32(res[4:8], uint32(len(buf)))
+	return statusSuccess, append(res, buf...)
+}
+
+// setInfo renames, deletes, or sets the times or attributes of f.
+func (sc *smbConn) setInfo(f *smbFile, req []byte) (uint32, []byte) {
+	b := req[64:]
+	n, off := le.Uint32(b[4:8]), le.Uint16(b[8:10])
+	buf := req[off : uint32(off)+n]
+	var err error
+	switch b[3] {
+	case 4: // FileBasicInformation
+		if mtime := le.Uint64(buf[16:24]); mtime != 0 {
+			t := fromFiletime(mtime)
+			err = os.Chtimes(f.name, t, t)
+		}
+		if attrs := le.Uint32(buf[32:36]); err == nil && attrs != 0 {
+			mode := os.FileMode(0644)
+			if attrs&0x1 != 0 {
+				mode = 0444
+			}
+			err = os.Chmod(f.name, mode)
+		}
+	case 10: // FileRenameInformation
+		l := le.Uint32(buf[16:20])
+		name := sc.s.local(decodeName(buf[20 : 20+l]))
+		if _, err = os.Stat(name); err == nil && buf[0] == 0 {
+			err = os.ErrExist
+		} else if err = os.Rename(f.name, name); err == nil {
+			f.name = name
+		}
+	case 13: // FileDispositionInformation
+		if buf[0] != 0 {
+			err = os.Remove(f.name)
+		}
+	default:
+		return statusNotSupported, nil
+	}
+	if err != nil {
+		return ntStatus(err), nil
+	}
+	return statusSuccess, []byte{2, 0}
+}
+
+// local returns the file on disk for the share path p.
+func (s *fakeSMB) local(p string) string {
+	return filepath.Join(s.root, filepath.FromSlash(strings.Replace(p, `\`, "/", -1)))
+}
+
+func ntStatus(err error) uint32 {
+	switch {
+	case errors.Is(err, syscall.ENOTEMPTY):
+		return statusDirectoryNotEmpty
+	case os.IsNotExist(err):
+		return statusNameNotFound
+	case os.IsExist(err):
+		return statusNameCollision
+	case os.IsPermission(err):
+		return statusAccessDenied
+	}
+	return statusUnsuccessful
+}
+
+func attributes(fi os.FileInfo) uint32 {
+	switch {
+	case fi.IsDir():
+		return 0x10
+	case fi.Mode()&0200 == 0:
+		return 0x1
+	}
+	return 0x80
+}
+
+// putTimes puts t as the creation, access, write and change times in b.
+func putTimes(b []byte, t time.Time) {
+	ft := uint64(t.UnixNano()/100 + 116444736000000000)
+	for i := 0; i < 32; i += 8 {
+		le.PutUint64(b[i:], ft)
+	}
+}
+
+func fromFiletime(ft uint64) time.Time {
+	return time.Unix(0, (int64(ft)-116444736000000000)*100)
+}
+
+func decodeName(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = le.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+func encodeName(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(u))
+	for i, c := range u {
+		le.PutUint16(b[2*i:], c)
+	}
+	return b
+}
+
+func TestSync(t *testing.T) {
+	src, err := ioutil.TempDir(os.TempDir(), "smbfs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	root, err := ioutil.TempDir(os.TempDir(), "smbfs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := os.MkdirAll(filepath.Join(src, "a/b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "a/b/c"), []byte("file c"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "d"), []byte("file d"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(filepath.Join(src, "d"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newFakeSMB(t, root)
+	defer srv.Close()
+	u := &url.URL{Scheme: "smb", User: url.UserPassword("user", "pass"),
+		Host: srv.l.Addr().String(), Path: "/share"}
+	fs, err := Open(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.(*FS).Close()
+
+	s := fsync.NewSyncer()
+	s.DstFS = fs
+	s.Delete = true
+	if err := s.Sync("/share/site", src); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"site/a/b/c": "file c", "site/d": "file d"} {
+		b, err := ioutil.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != content {
+			t.Errorf("%s is %q, should be %q", name, b, content)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(root, "site/d")); err != nil {
+		t.Fatal(err)
+	} else if !fi.ModTime().Equal(mtime) {
+		t.Errorf("site/d was modified at %v, should be %v", fi.ModTime(), mtime)
+	}
+
+	// files are read back and stat'ed
+	r, err := fs.Open("/share/site/d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "file d" {
+		t.Errorf("read %q from site/d, should be %q", b, "file d")
+	}
+	if fi, err := fs.Stat("/share/site/a"); err != nil || !fi.IsDir() {
+		t.Errorf("expecting site/a to be a directory, got %v, %v", fi, err)
+	}
+	if _, err := fs.Stat("/share/site/e"); !os.IsNotExist(err) {
+		t.Errorf("expecting site/e not to exist, got %v", err)
+	}
+
+	// renames replace existing files
+	if err := ioutil.WriteFile(filepath.Join(root, "site/e"), []byte("file e"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename("/share/site/d", "/share/site/e"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(root, "site/e")); err != nil || string(b) != "file d" {
+		t.Errorf("expecting site/e to be replaced by site/d, got %q, %v", b, err)
+	}
+
+	// a second sync deletes whole trees and extra files
+	if err := os.RemoveAll(filepath.Join(src, "a")); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := s.SyncStats("/share/site", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"site/a", "site/e"} {
+		if _, err := os.Stat(filepath.Join(root, name)); !os.IsNotExist(err) {
+			t.Errorf("%s should be deleted, got %v", name, err)
+		}
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(root, "site/d")); err != nil || string(b) != "file d" || stats.Files != 1 {
+		t.Errorf("expecting site/d to be copied again, got %q, %v, %+v", b, err, stats)
+	}
+}
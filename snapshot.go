@@ -0,0 +1,254 @@
+package fsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// SnapshotFormat is the layout, as understood by time.Format, of the names of
+// snapshot directories. Snapshots live side by side in a root directory and
+// unchanged files are usually hard links to the same file in an older
+// snapshot, so most of their data is shared.
+const SnapshotFormat = "2006-01-02T15:04:05"
+
+var (
+	ErrNoSnapshot     = errors.New("fsync: snapshot not found")
+	ErrSnapshotExists = errors.New("fsync: snapshot already exists")
+)
+
+// Snapshot describes a snapshot directory.
+type Snapshot struct {
+	Name string    // name of the directory
+	Path string    // path of the directory
+	Time time.Time // time the snapshot was taken, parsed from Name
+
+	// Size is the total size of regular files in the snapshot. Unique is
+	// the part of it that is not hard-linked from anywhere else, which is
+	// the space that removing the snapshot frees. Shared is the rest.
+	Size, Unique, Shared int64
+}
+
+// Snapshots lists the snapshots in root, oldest first. Entries of root whose
+// names are not in SnapshotFormat are ignored.
+func Snapshots(root string) ([]Snapshot, error) {
+	snaps, usage, err := readSnapshots(root)
+	if err != nil {
+		return nil, err
+	}
+	for i := range snaps {
+		u := usage.usage(i)
+		snaps[i].Size, snaps[i].Unique, snaps[i].Shared = u.Size, u.Unique, u.Shared
+	}
+	return snaps, nil
+}
+
+// Snapshot syncs src into a new snapshot in root, named after the current
+// time in SnapshotFormat, and returns its Name, Path and Time. Files that are
+// unchanged since the newest snapshot are hard-linked to it, as with
+// LinkDest, so only changes take space. The snapshot is synced under a name
+// that Snapshots ignores and renamed when it's complete, so a failed one is
+// never listed; it's removed instead. root is a local directory, made if
+// needed. It fails with ErrSnapshotExists if a snapshot of the same second
+// exists already. Use Prune to remove old snapshots.
+func (s *Syncer) Snapshot(root, src string) (Snapshot, Stats, error) {
+	snaps, err := listSnapshots(root)
+	if os.IsNotExist(err) {
+		err = os.MkdirAll(root, 0755)
+	}
+	if err != nil {
+		return Snapshot{}, Stats{}, err
+	}
+	name := s.clock().Now().Local().Format(SnapshotFormat)
+	t, err := time.ParseInLocation(SnapshotFormat, name, time.Local)
+	if err != nil {
+		return Snapshot{}, Stats{}, err
+	}
+	snap := Snapshot{Name: name, Path: filepath.Join(root, name), Time: t}
+	if _, err := os.Lstat(snap.Path); err == nil {
+		return Snapshot{}, Stats{}, ErrSnapshotExists
+	}
+
+	partial := filepath.Join(root, "."+name+".partial")
+	stats, err := s.syncContext(context.Background(), partial, src, func(r *run) {
+		r.dirOnly = true
+		if len(snaps) > 0 {
+			r.LinkDest = snaps[len(snaps)-1].Path
+		}
+	})
+	if err == nil && !s.DryRun {
+		err = os.Rename(partial, snap.Path)
+	}
+	if err != nil {
+		os.RemoveAll(partial)
+		return Snapshot{}, stats, err
+	}
+	return snap, stats, nil
+}
+
+// Retention is a policy for which snapshots to keep. For each period it keeps
+// the newest snapshot of that many of the most recent days, weeks or months
+// that have snapshots, and Within keeps all of those taken within that long
+// before the newest one. A snapshot kept by any rule is kept.
+type Retention struct {
+	Last    int           // number of most recent snapshots
+	Daily   int           // number of days
+	Weekly  int           // number of ISO weeks
+	Monthly int           // number of months
+	Within  time.Duration // age, relative to the newest snapshot
+}
+
+// Keep splits snaps into the snapshots p keeps and the ones it doesn't. The
+// newest snapshot is always kept. Both results are sorted oldest first.
+func (p Retention) Keep(snaps []Snapshot) (keep, remove []Snapshot) {
+	sorted := make([]Snapshot, len(snaps))
+	copy(sorted, snaps)
+	sort.Sort(byTime(sorted))
+
+	kept := make(map[int]bool)
+	rule := func(n int, period func(t time.Time) string) {
+		last := ""
+		for i := len(sorted) - 1; i >= 0 && n > 0; i-- {
+			if k := period(sorted[i].Time); k != last {
+				kept[i] = true
+				last = k
+				n--
+			}
+		}
+	}
+	rule(p.Last, func(t time.Time) string { return t.String() })
+	rule(p.Daily, func(t time.Time) string { return t.Format("2006-01-02") })
+	rule(p.Weekly, func(t time.Time) string {
+		y, w := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", y, w)
+	})
+	rule(p.Monthly, func(t time.Time) string { return t.Format("2006-01") })
+	if len(sorted) > 0 {
+		newest := sorted[len(sorted)-1].Time
+		for i := range sorted {
+			if p.Within > 0 && newest.Sub(sorted[i].Time) < p.Within {
+				kept[i] = true
+			}
+		}
+		kept[len(sorted)-1] = true
+	}
+
+	for i, snap := range sorted {
+		if kept[i] {
+			keep = append(keep, snap)
+		} else {
+			remove = append(remove, snap)
+		}
+	}
+	return keep, remove
+}
+
+// Reclaimable returns the number of bytes that pruning root with p would
+// free.
+func Reclaimable(root string, p Retention) (int64, error) {
+	snaps, usage, err := readSnapshots(root)
+	if err != nil {
+		return 0, err
+	}
+	return usage.reclaimable(removeSet(snaps, p)), nil
+}
+
+// Prune removes the snapshots in root that p doesn't keep and returns them.
+func Prune(root string, p Retention) ([]Snapshot, error) {
+	snaps, err := Snapshots(root)
+	if err != nil {
+		return nil, err
+	}
+	_, remove := p.Keep(snaps)
+	for i, snap := range remove {
+		if err := os.RemoveAll(snap.Path); err != nil {
+			return remove[:i], err
+		}
+	}
+	return remove, nil
+}
+
+// removeSet returns the indexes of snaps that p doesn't keep.
+func removeSet(snaps []Snapshot, p Retention) map[int]bool {
+	_, remove := p.Keep(snaps)
+	m := make(map[int]bool, len(remove))
+	for _, r := range remove {
+		for i := range snaps {
+			if snaps[i].Name == r.Name {
+				m[i] = true
+			}
+		}
+	}
+	return m
+}
+
+// readSnapshots lists the snapshots in root, oldest first, and collects the
+// files each of them uses.
+func readSnapshots(root string) ([]Snapshot, *spaceUsage, error) {
+	snaps, err := listSnapshots(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	usage := newSpaceUsage()
+	for i, snap := range snaps {
+		if err := usage.add(i, snap.Path); err != nil {
+			return nil, nil, err
+		}
+	}
+	return snaps, usage, nil
+}
+
+// listSnapshots lists the snapshots in root, oldest first, without reading
+// their contents.
+func listSnapshots(root string) ([]Snapshot, error) {
+	files, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	var snaps []Snapshot
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		t, err := time.ParseInLocation(SnapshotFormat, file.Name(), time.Local)
+		if err != nil {
+			continue
+		}
+		snaps = append(snaps, Snapshot{
+			Name: file.Name(),
+			Path: filepath.Join(root, file.Name()),
+			Time: t,
+		})
+	}
+	sort.Sort(byTime(snaps))
+	return snaps, nil
+}
+
+// findSnapshot returns the snapshot called name in root, or the newest one if
+// name is empty.
+func findSnapshot(root, name string) (Snapshot, error) {
+	snaps, err := listSnapshots(root)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if name == "" && len(snaps) > 0 {
+		return snaps[len(snaps)-1], nil
+	}
+	for _, snap := range snaps {
+		if snap.Name == name {
+			return snap, nil
+		}
+	}
+	return Snapshot{}, ErrNoSnapshot
+}
+
+type byTime []Snapshot
+
+func (s byTime) Len() int           { return len(s) }
+func (s byTime) Less(i, j int) bool { return s[i].Time.Before(s[j].Time) }
+func (s byTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,158 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshots(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(root)
+
+	// three snapshots; the second shares a file with the first
+	a := filepath.Join(root, "2020-01-01T00:00:00")
+	b := filepath.Join(root, "2020-01-02T00:00:00")
+	c := filepath.Join(root, "2020-01-03T00:00:00")
+	for _, dir := range []string{a, b, c} {
+		check(os.MkdirAll(dir, 0755))
+	}
+	check(os.MkdirAll(filepath.Join(root, "not-a-snapshot"), 0755))
+	check(ioutil.WriteFile(filepath.Join(a, "shared"), make([]byte, 100), 0644))
+	check(os.Link(filepath.Join(a, "shared"), filepath.Join(b, "shared")))
+	check(ioutil.WriteFile(filepath.Join(b, "own"), make([]byte, 10), 0644))
+	check(ioutil.WriteFile(filepath.Join(c, "own"), make([]byte, 1), 0644))
+
+	snaps, err := Snapshots(root)
+	check(err)
+	if len(snaps) != 3 {
+		t.Fatalf("found %d snapshots, should be 3.\n", len(snaps))
+	}
+	testSnapshot(snaps[0], 100, 0, t)
+	testSnapshot(snaps[1], 110, 10, t)
+	testSnapshot(snaps[2], 1, 1, t)
+
+	// keeping only the newest frees the first two completely
+	n, err := Reclaimable(root, Retention{})
+	check(err)
+	if n != 110 {
+		t.Errorf("reclaimable space is %d, should be 110.\n", n)
+	}
+	removed, err := Prune(root, Retention{Last: 2})
+	check(err)
+	if len(removed) != 1 || removed[0].Name != "2020-01-01T00:00:00" {
+		t.Errorf("pruned %v, should be the oldest snapshot.\n", removed)
+	}
+	testExistence(a, false, t)
+	testExistence(b, true, t)
+}
+
+func TestRetention(t *testing.T) {
+	// one snapshot a day for a year
+	var snaps []Snapshot
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 366; i++ {
+		tt := start.AddDate(0, 0, i)
+		snaps = append(snaps, Snapshot{Name: tt.Format(SnapshotFormat), Time: tt})
+	}
+	keep, remove := Retention{Daily: 7, Weekly: 4, Monthly: 12}.Keep(snaps)
+	if len(keep)+len(remove) != len(snaps) {
+		t.Fatalf("kept %d and removed %d of %d snapshots.\n",
+			len(keep), len(remove), len(snaps))
+	}
+	// 7 days, the Sundays of 2 more weeks (the week of the last day is
+	// already covered), and the last day of 11 more months
+	if len(keep) != 20 {
+		t.Errorf("kept %d snapshots, should be 20.\n", len(keep))
+	}
+	if last := keep[len(keep)-1]; !last.Time.Equal(snaps[len(snaps)-1].Time) {
+		t.Errorf("newest kept snapshot is %v, should be the newest.\n", last.Time)
+	}
+}
+
+func testSnapshot(snap Snapshot, size, unique int64, t *testing.T) {
+	if snap.Size != size || snap.Unique != unique || snap.Shared != size-unique {
+		t.Errorf("snapshot %s has size %d (%d unique, %d shared), should "+
+			"have %d (%d unique).\n", snap.Name, snap.Size, snap.Unique,
+			snap.Shared, size, unique)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	root := filepath.Join(dir, "snaps")
+	check(os.MkdirAll(src, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("file a"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "b"), []byte("file b"), 0644))
+
+	clock := &stepClock{now: time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)}
+	s := NewSyncer()
+	s.Clock = clock
+	first, _, err := s.Snapshot(root, src)
+	check(err)
+	if first.Name != "2024-06-01T12:00:00" {
+		t.Errorf("expecting the snapshot to be named after the time, got %s", first.Name)
+	}
+	testFile(filepath.Join(first.Path, "a"), []byte("file a"), t)
+
+	// the same second again
+	if _, _, err := s.Snapshot(root, src); err != ErrSnapshotExists {
+		t.Errorf("expecting ErrSnapshotExists, got %v", err)
+	}
+
+	// unchanged files are linked to the last snapshot
+	clock.now = clock.now.Add(time.Hour)
+	check(ioutil.WriteFile(filepath.Join(src, "b"), []byte("new file b"), 0644))
+	second, stats, err := s.Snapshot(root, src)
+	check(err)
+	if stats.Files != 1 || stats.Linked != 1 {
+		t.Errorf("expecting 1 file copied and 1 linked, got %+v", stats)
+	}
+	testFile(filepath.Join(first.Path, "b"), []byte("file b"), t)
+	testFile(filepath.Join(second.Path, "b"), []byte("new file b"), t)
+	fi1, err := os.Stat(filepath.Join(first.Path, "a"))
+	check(err)
+	fi2, err := os.Stat(filepath.Join(second.Path, "a"))
+	check(err)
+	if !os.SameFile(fi1, fi2) {
+		t.Errorf("expecting a to be a hard link to the first snapshot")
+	}
+
+	// a failed snapshot leaves nothing behind
+	clock.now = clock.now.Add(time.Hour)
+	if _, _, err := s.Snapshot(root, filepath.Join(dir, "none")); err == nil {
+		t.Errorf("expecting a snapshot of a missing source to fail")
+	}
+	files, err := ioutil.ReadDir(root)
+	check(err)
+	if len(files) != 2 {
+		t.Errorf("expecting only the 2 snapshots in the root, got %d entries", len(files))
+	}
+	snaps, err := Snapshots(root)
+	check(err)
+	if len(snaps) != 2 || snaps[1].Name != second.Name {
+		t.Errorf("expecting 2 snapshots, the newest %s, got %+v", second.Name, snaps)
+	}
+}
+
+func TestRetentionWithin(t *testing.T) {
+	var snaps []Snapshot
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 48; i++ {
+		tt := start.Add(time.Duration(i) * time.Hour)
+		snaps = append(snaps, Snapshot{Name: tt.Format(SnapshotFormat), Time: tt})
+	}
+	keep, remove := Retention{Within: 12 * time.Hour}.Keep(snaps)
+	if len(keep) != 12 || len(remove) != 36 {
+		t.Errorf("kept %d and removed %d snapshots, should be 12 and 36", len(keep), len(remove))
+	}
+	if !keep[0].Time.Equal(snaps[36].Time) {
+		t.Errorf("oldest kept snapshot is %v, should be %v", keep[0].Time, snaps[36].Time)
+	}
+}
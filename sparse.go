@@ -0,0 +1,97 @@
+package fsync
+
+import (
+	"hash"
+	"io"
+	"os"
+)
+
+// sparseFile is a destination file that holes can be left in, by seeking
+// past them.
+type sparseFile interface {
+	io.Writer
+	io.Seeker
+	Truncate(size int64) error
+}
+
+// sparse copies the source file sf to df with Sparse, leaving holes where
+// sf has them, as found with SEEK_DATA and SEEK_HOLE, instead of writing
+// zeros, and returns its size. The data is read from in, the reader of sf,
+// which mustn't change it, and the holes are added to h, if not nil. It
+// returns false, having done nothing, if sf has no holes or the platform or
+// either file can't tell or keep them. If sf gets shorter while it's copied,
+// the error is as if it vanished.
+func (r *run) sparse(df io.Writer, sf, in io.Reader, h hash.Hash) (int64, bool, error) {
+	ss, ok := sf.(io.Seeker)
+	ds, ok2 := df.(sparseFile)
+	if !r.Sparse || !ok || !ok2 || seekData < 0 {
+		return 0, false, nil
+	}
+	size, err := ss.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, false, nil
+	}
+	// the end of the file is a hole too; any other means it's sparse
+	hole, err := ss.Seek(0, seekHole)
+	if _, err2 := ss.Seek(0, io.SeekStart); err2 != nil {
+		return 0, true, err2
+	}
+	if err != nil || hole >= size {
+		return 0, false, nil
+	}
+	buf := r.buffer()
+	defer r.release(buf)
+	for off := int64(0); off < size; {
+		data, err := ss.Seek(off, seekData)
+		if noData(err) {
+			data = size
+		} else if err != nil {
+			return 0, true, err
+		}
+		if data > size {
+			data = size
+		}
+		if h != nil {
+			zeros(h, data-off, buf)
+		}
+		if data == size {
+			break
+		}
+		hole, err := ss.Seek(data, seekHole)
+		if err != nil {
+			return 0, true, err
+		}
+		if hole > size {
+			hole = size
+		}
+		if _, err := ss.Seek(data, io.SeekStart); err != nil {
+			return 0, true, err
+		}
+		if _, err := ds.Seek(data, io.SeekStart); err != nil {
+			return 0, true, err
+		}
+		n, err := io.CopyBuffer(writerOnly{ds}, io.LimitReader(in, hole-data), buf)
+		if err != nil {
+			return 0, true, err
+		} else if n < hole-data {
+			return 0, true, os.ErrNotExist
+		}
+		off = hole
+	}
+	return size, true, ds.Truncate(size)
+}
+
+// zeros writes n zero bytes to w, with buf, which it clears.
+func zeros(w io.Writer, n int64, buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+	for n > 0 {
+		m := int64(len(buf))
+		if m > n {
+			m = n
+		}
+		w.Write(buf[:m])
+		n -= m
+	}
+}
@@ -0,0 +1,82 @@
+package fsync
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestSparse(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	// 4 MiB, with data at 1 MiB and a hole at the end
+	name := filepath.Join(src, "image")
+	f, err := os.Create(name)
+	check(err)
+	_, err = f.WriteAt(bytes.Repeat([]byte("data"), 1024), 1<<20)
+	check(err)
+	check(f.Truncate(4 << 20))
+	check(f.Close())
+	blocks := func(name string) int64 {
+		fi, err := os.Stat(name)
+		check(err)
+		return fi.Sys().(*syscall.Stat_t).Blocks
+	}
+	if blocks(name) >= 4<<20/512 {
+		t.Skip("the file system doesn't support holes")
+	}
+	want, err := ioutil.ReadFile(name)
+	check(err)
+
+	s := NewSyncer()
+	s.Sparse = true
+	s.Recheck = 1
+	stats, err := s.SyncStats(dst, src)
+	check(err)
+	testFile(filepath.Join(dst, "image"), want, t)
+	if stats.Bytes != 4<<20 || stats.Inconsistent != 0 {
+		t.Errorf("expecting 4 MiB copied and checked, got %+v", stats)
+	}
+	if b := blocks(filepath.Join(dst, "image")); b > blocks(name) {
+		t.Errorf("expecting the copy to take at most %d blocks, got %d", blocks(name), b)
+	}
+}
+
+func TestSparseShrunk(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "image")
+	f, err := os.Create(name)
+	check(err)
+	_, err = f.WriteAt(bytes.Repeat([]byte("data"), 1024), 1<<20)
+	check(err)
+	check(f.Truncate(4 << 20))
+	check(f.Close())
+	sf, err := os.Open(name)
+	check(err)
+	defer sf.Close()
+	df, err := os.Create(filepath.Join(dir, "copy"))
+	check(err)
+	defer df.Close()
+
+	// the reader ends early, as if the file was truncated while copied
+	s := NewSyncer()
+	s.Sparse = true
+	r := &run{Syncer: s}
+	_, sparse, err := r.sparse(df, sf, io.LimitReader(sf, 100), nil)
+	if !sparse {
+		t.Skip("the file system doesn't support holes")
+	}
+	if !os.IsNotExist(err) {
+		t.Errorf("expecting the file to be reported vanished, got %v", err)
+	}
+}
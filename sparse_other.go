@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package fsync
+
+// Holes can't be found on this platform.
+const (
+	seekData = -1
+	seekHole = -1
+)
+
+func noData(err error) bool { return false }
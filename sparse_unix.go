@@ -0,0 +1,21 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package fsync
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	seekData = unix.SEEK_DATA
+	seekHole = unix.SEEK_HOLE
+)
+
+// noData returns true if err is that of seeking to data past the last of
+// a file.
+func noData(err error) bool {
+	return errors.Is(err, unix.ENXIO)
+}
@@ -0,0 +1,88 @@
+package fsync
+
+import (
+	"errors"
+	"os"
+)
+
+var (
+	ErrSpecial    = errors.New("fsync: special files aren't copied with FailOnSpecials")
+	ErrNoSpecials = errors.New("fsync: the destination can't make special files")
+)
+
+// Specials decides what's done with the special files of the source: named
+// pipes, sockets and devices, which can't be read as files are.
+type Specials int
+
+const (
+	// SkipSpecials leaves them out, and reports them with an OpSkip event
+	// for Special.
+	SkipSpecials Specials = iota
+	// RecreateSpecials makes them in the destination, as mkfifo and mknod
+	// do, if it's a SpecialMaker, and fails with ErrNoSpecials otherwise.
+	// Devices are usually only made by root.
+	RecreateSpecials
+	// FailOnSpecials fails them with ErrSpecial, which ContinueOnError
+	// and OnError may skip.
+	FailOnSpecials
+)
+
+// SpecialMaker is implemented by file systems that can make special files,
+// which RecreateSpecials uses. The local file system does on Unix.
+type SpecialMaker interface {
+	// MakeSpecial makes name a special file of the type, device and
+	// permissions of fi, the file info of one in another file system.
+	MakeSpecial(name string, fi os.FileInfo) error
+}
+
+// isSpecial returns true if fi is the file info of a special file, after
+// symbolic links are followed.
+func isSpecial(fi os.FileInfo) bool {
+	return !fi.Mode().IsRegular() && !fi.IsDir()
+}
+
+// special syncs the destination name dst with the source special file src,
+// with the file info sstat, as Specials decides, where dstat is the file
+// info of dst, if it exists. It returns true if dst was made again.
+func (r *run) special(dst, src string, dstat, sstat os.FileInfo) bool {
+	switch r.Specials {
+	case SkipSpecials:
+		r.emit(Verbose, src, Event{Op: OpSkip, Reason: Special})
+		return false
+	case FailOnSpecials:
+		panic(ErrSpecial)
+	}
+	if dstat != nil && sameSpecial(dstat, sstat) {
+		r.stats.Unchanged++
+		r.emit(Trace, src, Event{Op: OpSkip, Reason: SameContent})
+		return true
+	}
+	sm, ok := r.dfs.(SpecialMaker)
+	if !ok {
+		panic(ErrNoSpecials)
+	}
+	if dstat != nil {
+		r.stats.Deleted++
+		r.emit(Verbose, src, Event{Op: OpDelete, Item: itemDeleting})
+	}
+	r.stats.Specials++
+	r.emit(Verbose, src, Event{Op: OpMknod, Item: itemSpecial})
+	if !r.DryRun {
+		if dstat != nil {
+			r.discard(dst)
+		}
+		check(sm.MakeSpecial(dst, sstat))
+	}
+	return true
+}
+
+// sameSpecial returns true if the file infos a and b are of special files
+// of the same type and device.
+func sameSpecial(a, b os.FileInfo) bool {
+	if !isSpecial(a) || a.Mode()&os.ModeType != b.Mode()&os.ModeType {
+		return false
+	}
+	da, ok1 := rdev(a)
+	db, ok2 := rdev(b)
+	return ok1 == ok2 && da == db
+}
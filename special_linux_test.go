@@ -0,0 +1,57 @@
+package fsync
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestSpecials(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("file a"), 0644))
+	check(syscall.Mkfifo(filepath.Join(src, "p"), 0640))
+
+	// skipped by default, rather than read
+	var skipped []string
+	s := NewSyncer()
+	s.Verbosity = Verbose
+	s.OnEvent = func(e Event) {
+		if e.Op == OpSkip && e.Reason == Special {
+			skipped = append(skipped, e.Path)
+		}
+	}
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "a"), []byte("file a"), t)
+	testExistence(filepath.Join(dst, "p"), false, t)
+	if len(skipped) != 1 || skipped[0] != "p" {
+		t.Errorf("expecting p to be skipped, got %v", skipped)
+	}
+
+	s.Specials = FailOnSpecials
+	if err := s.Sync(dst, src); !errors.Is(err, ErrSpecial) {
+		t.Errorf("expecting ErrSpecial, got %v", err)
+	}
+
+	s.Specials = RecreateSpecials
+	s.Strict = true
+	stats, err := s.SyncStats(dst, src)
+	check(err)
+	fi, err := os.Stat(filepath.Join(dst, "p"))
+	check(err)
+	if stats.Specials != 1 || fi.Mode()&os.ModeNamedPipe == 0 || fi.Mode().Perm() != 0640 {
+		t.Errorf("expecting p to be made a named pipe, got %v and %+v", fi.Mode(), stats)
+	}
+	stats, err = s.SyncStats(dst, src)
+	check(err)
+	if stats.Specials != 0 || stats.Unchanged != 2 {
+		t.Errorf("expecting p to be left alone, got %+v", stats)
+	}
+}
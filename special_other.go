@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package fsync
+
+import "os"
+
+// rdev returns the device of the special file described by fi, if the
+// platform exposes it.
+func rdev(fi os.FileInfo) (uint64, bool) {
+	return 0, false
+}
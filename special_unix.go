@@ -0,0 +1,42 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package fsync
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func (osFS) MakeSpecial(name string, fi os.FileInfo) error {
+	mode := uint32(fi.Mode().Perm())
+	switch m := fi.Mode(); {
+	case m&os.ModeNamedPipe != 0:
+		mode |= unix.S_IFIFO
+	case m&os.ModeSocket != 0:
+		mode |= unix.S_IFSOCK
+	case m&os.ModeCharDevice != 0:
+		mode |= unix.S_IFCHR
+	case m&os.ModeDevice != 0:
+		mode |= unix.S_IFBLK
+	default:
+		return &os.PathError{Op: "mknod", Path: name, Err: syscall.EINVAL}
+	}
+	dev, _ := rdev(fi)
+	if err := mknod(name, mode, dev); err != nil {
+		return &os.PathError{Op: "mknod", Path: name, Err: err}
+	}
+	return nil
+}
+
+// rdev returns the device of the special file described by fi, if the
+// platform exposes it.
+func rdev(fi os.FileInfo) (uint64, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Rdev), true
+}
@@ -0,0 +1,470 @@
+package fsync
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+)
+
+// mergeFan is how many spilled files of entries are merged into one at a
+// time, so that there are few left to merge in the end.
+const mergeFan = 8
+
+// stateEntries are the entries of a state by path. Up to limit of them are
+// kept in memory, and the rest in files of entries sorted by path, which
+// are merged in order.
+type stateEntries struct {
+	limit int // entries in memory; 0 for no limit
+	mem   map[string]*StateEntry
+	files []*entryFile // oldest first; later ones win
+
+	// set by seal, for get
+	index []entryMark
+	every int // entries per mark
+}
+
+// entryFile is a temporary file of entries sorted by path, one JSON object
+// a line.
+type entryFile struct {
+	f     *os.File
+	n     int // entries
+	level int // times merged
+}
+
+// entryMark is where a block of entries starts in a sealed file.
+type entryMark struct {
+	path string
+	off  int64
+}
+
+// spilledEntry is a line of an entryFile.
+type spilledEntry struct {
+	Path  string      `json:"path"`
+	Entry *StateEntry `json:"entry"`
+}
+
+func newStateEntries(limit int) *stateEntries {
+	return &stateEntries{limit: limit, mem: make(map[string]*StateEntry)}
+}
+
+// loadEntries reads the entries of the state file in path, converting it
+// from earlier versions, and seals them. A missing file has none.
+func loadEntries(path string, limit int) (*stateEntries, error) {
+	es := newStateEntries(limit)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return es, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	err = es.decode(json.NewDecoder(bufio.NewReader(f)), path)
+	if err == nil {
+		err = es.seal()
+	}
+	if err != nil {
+		es.close()
+		return nil, err
+	}
+	return es, nil
+}
+
+// decode adds the entries of a state file read with dec, one at a time.
+func (es *stateEntries) decode(dec *json.Decoder, path string) error {
+	version := 0
+	if t, err := dec.Token(); err != nil {
+		return err
+	} else if t != json.Delim('{') {
+		return fmt.Errorf("fsync: state file %s isn't a JSON object", path)
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case "version":
+			err = dec.Decode(&version)
+		case "files", "entries": // entries in version 0
+			err = es.decodeFiles(dec)
+		default:
+			var v json.RawMessage
+			err = dec.Decode(&v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	if version != 0 && version != StateVersion {
+		return fmt.Errorf("fsync: unknown version %d of state file %s", version, path)
+	}
+	return nil
+}
+
+func (es *stateEntries) decodeFiles(dec *json.Decoder) error {
+	t, err := dec.Token()
+	if err != nil || t == nil {
+		return err
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var e *StateEntry
+		if err := dec.Decode(&e); err != nil {
+			return err
+		}
+		if p, ok := t.(string); ok && e != nil {
+			if err := es.add(p, e); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// add sets the entry of p, spilling the entries in memory to a file if
+// there are more than limit.
+func (es *stateEntries) add(p string, e *StateEntry) error {
+	es.mem[p] = e
+	if es.limit <= 0 || len(es.mem) <= es.limit {
+		return nil
+	}
+	ef, err := writeEntries(es.readers(nil), nil)
+	if err != nil {
+		return err
+	}
+	es.mem = make(map[string]*StateEntry)
+	es.files = append(es.files, ef)
+	for n := len(es.files); n >= mergeFan; n = len(es.files) {
+		last := es.files[n-mergeFan:]
+		if last[0].level != last[mergeFan-1].level {
+			break
+		}
+		merged, err := writeEntries(fileReaders(last), nil)
+		if err != nil {
+			return err
+		}
+		merged.level = last[0].level + 1
+		closeEntryFiles(last)
+		es.files = append(es.files[:n-mergeFan], merged)
+	}
+	return nil
+}
+
+// spilled returns true if some entries are in files.
+func (es *stateEntries) spilled() bool {
+	return len(es.files) > 0
+}
+
+// seal merges the spilled entries into a single file, with an index of
+// up to limit marks for get. Entries mustn't be added after.
+func (es *stateEntries) seal() error {
+	if !es.spilled() {
+		return nil
+	}
+	n := len(es.mem)
+	for _, ef := range es.files {
+		n += ef.n
+	}
+	es.every = n/es.limit + 1
+	if es.every < 16 {
+		es.every = 16
+	}
+	es.index = nil
+	merged, err := writeEntries(es.readers(es.files), func(i int, p string, off int64) {
+		if i%es.every == 0 {
+			es.index = append(es.index, entryMark{p, off})
+		}
+	})
+	if err != nil {
+		return err
+	}
+	closeEntryFiles(es.files)
+	es.files = []*entryFile{merged}
+	es.mem = make(map[string]*StateEntry)
+	return nil
+}
+
+// get returns the entry of p, or nil if there's none. Spilled entries are
+// only found once sealed.
+func (es *stateEntries) get(p string) (*StateEntry, error) {
+	if e, ok := es.mem[p]; ok || es.index == nil {
+		return e, nil
+	}
+	i := sort.Search(len(es.index), func(i int) bool { return es.index[i].path > p }) - 1
+	if i < 0 {
+		return nil, nil
+	}
+	r := es.files[0].reader(es.index[i].off)
+	for j := 0; j < es.every; j++ {
+		if err := r.next(); err != nil || !r.ok || r.path > p {
+			return nil, err
+		} else if r.path == p {
+			return r.entry, nil
+		}
+	}
+	return nil, nil
+}
+
+// len returns how many entries there are, once sealed.
+func (es *stateEntries) len() int {
+	if es.spilled() {
+		return es.files[0].n
+	}
+	return len(es.mem)
+}
+
+// each calls f with each entry, in order of path.
+func (es *stateEntries) each(f func(p string, e *StateEntry) error) error {
+	return merge(es.readers(es.files), f)
+}
+
+// save writes the entries to the state file in path, as State.Save does,
+// a few at a time.
+func (es *stateEntries) save(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, `{"version":%d,"files":{`, StateVersion)
+	sep := ""
+	err = es.each(func(p string, e *StateEntry) error {
+		k, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		v, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		w.WriteString(sep)
+		w.Write(k)
+		w.WriteByte(':')
+		_, err = w.Write(v)
+		sep = ","
+		return err
+	})
+	if err == nil {
+		_, err = w.WriteString("}}")
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// close removes the spilled files.
+func (es *stateEntries) close() {
+	closeEntryFiles(es.files)
+	es.files = nil
+	es.index = nil
+}
+
+// readers returns readers of files and of the entries in memory, in this
+// order.
+func (es *stateEntries) readers(files []*entryFile) []*entryReader {
+	paths := make([]string, 0, len(es.mem))
+	for p := range es.mem {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return append(fileReaders(files), &entryReader{paths: paths, mem: es.mem})
+}
+
+func fileReaders(files []*entryFile) []*entryReader {
+	var rs []*entryReader
+	for _, ef := range files {
+		rs = append(rs, ef.reader(0))
+	}
+	return rs
+}
+
+// writeEntries writes the entries merged from rs to a new entryFile. mark,
+// if set, is called with the index, path and offset of each.
+func writeEntries(rs []*entryReader, mark func(i int, p string, off int64)) (*entryFile, error) {
+	f, err := ioutil.TempFile("", "fsync-state")
+	if err != nil {
+		return nil, err
+	}
+	ef := &entryFile{f: f}
+	w := bufio.NewWriter(f)
+	var off int64
+	err = merge(rs, func(p string, e *StateEntry) error {
+		line, err := json.Marshal(spilledEntry{p, e})
+		if err != nil {
+			return err
+		}
+		if mark != nil {
+			mark(ef.n, p, off)
+		}
+		ef.n++
+		off += int64(len(line)) + 1
+		w.Write(line)
+		return w.WriteByte('\n')
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		closeEntryFiles([]*entryFile{ef})
+		return nil, err
+	}
+	return ef, nil
+}
+
+// reader returns a reader of the entries of ef from the offset off.
+func (ef *entryFile) reader(off int64) *entryReader {
+	return &entryReader{br: bufio.NewReader(io.NewSectionReader(ef.f, off, math.MaxInt64-off))}
+}
+
+func closeEntryFiles(files []*entryFile) {
+	for _, ef := range files {
+		ef.f.Close()
+		os.Remove(ef.f.Name())
+	}
+}
+
+// entryReader reads entries in order of path, from a file or from memory.
+type entryReader struct {
+	br    *bufio.Reader
+	paths []string // with mem, instead of br
+	mem   map[string]*StateEntry
+
+	// the current entry, if ok
+	path  string
+	entry *StateEntry
+	ok    bool
+}
+
+// next reads the next entry.
+func (r *entryReader) next() error {
+	r.ok = false
+	if r.br == nil {
+		if len(r.paths) > 0 {
+			r.path, r.entry, r.ok = r.paths[0], r.mem[r.paths[0]], true
+			r.paths = r.paths[1:]
+		}
+		return nil
+	}
+	line, err := r.br.ReadBytes('\n')
+	if err == io.EOF && len(line) == 0 {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var se spilledEntry
+	if err := json.Unmarshal(line, &se); err != nil {
+		return err
+	}
+	r.path, r.entry, r.ok = se.Path, se.Entry, true
+	return nil
+}
+
+// merge calls f with the entries of rs in order of path. Of entries with
+// the same path, that of the last reader wins.
+func merge(rs []*entryReader, f func(p string, e *StateEntry) error) error {
+	for _, r := range rs {
+		if err := r.next(); err != nil {
+			return err
+		}
+	}
+	for {
+		var least *entryReader
+		for _, r := range rs {
+			if r.ok && (least == nil || r.path <= least.path) {
+				least = r
+			}
+		}
+		if least == nil {
+			return nil
+		}
+		p, e := least.path, least.entry
+		for _, r := range rs {
+			if r.ok && r.path == p {
+				if err := r.next(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := f(p, e); err != nil {
+			return err
+		}
+	}
+}
+
+// jobList is a list of jobs. Up to limit of them are kept in memory, and
+// the ones before in a temporary file.
+type jobList struct {
+	limit int // 0 for no limit
+	jobs  []job
+	f     *os.File
+	w     *bufio.Writer
+}
+
+// add appends j to the list.
+func (l *jobList) add(j job) {
+	l.jobs = append(l.jobs, j)
+	if l.limit <= 0 || len(l.jobs) <= l.limit {
+		return
+	}
+	if l.f == nil {
+		f, err := ioutil.TempFile("", "fsync-jobs")
+		check(err)
+		l.f, l.w = f, bufio.NewWriter(f)
+	}
+	for _, j := range l.jobs {
+		// names can't have NULs in them
+		l.w.WriteString(j.dst + "\x00" + j.src + "\x00")
+	}
+	l.jobs = l.jobs[:0]
+}
+
+// each calls f with each job, in order.
+func (l *jobList) each(f func(j job)) {
+	if l.f != nil {
+		check(l.w.Flush())
+		br := bufio.NewReader(io.NewSectionReader(l.f, 0, math.MaxInt64))
+		for {
+			dst, err := br.ReadString(0)
+			if err == io.EOF {
+				break
+			}
+			check(err)
+			src, err := br.ReadString(0)
+			check(err)
+			f(job{dst[:len(dst)-1], src[:len(src)-1]})
+		}
+	}
+	for _, j := range l.jobs {
+		f(j)
+	}
+}
+
+// close empties the list and removes its file.
+func (l *jobList) close() {
+	if l.f != nil {
+		l.f.Close()
+		os.Remove(l.f.Name())
+	}
+	*l = jobList{limit: l.limit}
+}
@@ -0,0 +1,252 @@
+package fsync
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// StateVersion is the version of the state files written by this package.
+const StateVersion = 1
+
+var (
+	ErrNoStateFile = errors.New("fsync: StateFile isn't set")
+)
+
+// State is the trees of a source and destination as of the end of the last
+// successful sync, as recorded in StateFile or by SyncBoth and Sync3. It's
+// stored as JSON.
+type State struct {
+	Version int                    `json:"version"`
+	Files   map[string]*StateEntry `json:"files"` // by slash-separated path relative to the roots
+}
+
+// StateEntry is a file or directory in a State.
+type StateEntry struct {
+	Dir     bool        `json:"dir,omitempty"`
+	Size    int64       `json:"size"`
+	Hash    string      `json:"hash,omitempty"` // hex SHA-256; empty if unknown
+	Mode    os.FileMode `json:"mode,omitempty"` // permissions, with setuid, setgid and sticky
+	SrcTime time.Time   `json:"src_time"`
+	DstTime time.Time   `json:"dst_time"`
+}
+
+// LoadState reads the state file in path, converting it from earlier
+// versions. A missing file is an empty state.
+func LoadState(path string) (*State, error) {
+	es, err := loadEntries(path, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &State{Version: StateVersion, Files: es.mem}, nil
+}
+
+// Save writes st to the file path, replacing it at once.
+func (st *State) Save(path string) error {
+	st.Version = StateVersion
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// MigrateState rewrites the state file in path in the current version.
+func MigrateState(path string) error {
+	st, err := LoadState(path)
+	if err != nil {
+		return err
+	}
+	return st.Save(path)
+}
+
+// RebuildState writes StateFile as if dst had just been synced with src,
+// recording the files that are equal in both. It's useful when StateFile was
+// lost, or before using it with a destination that's already in sync, as
+// nothing is deleted without it.
+func (s *Syncer) RebuildState(dst, src string) (err error) {
+	if s.StateFile == "" {
+		return ErrNoStateFile
+	}
+	r, dst, src, err := s.newRun(dst, src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := r.close(); err == nil {
+			err = err2
+		}
+	}()
+	if err := checkPatterns(s.Exclude, s.Include); err != nil {
+		return err
+	}
+	r.root = src
+	st := &State{Files: make(map[string]*StateEntry)}
+	var walk func(dst, src string)
+	walk = func(dst, src string) {
+		sstat, err := r.sfs.Stat(src)
+		check(err)
+		dstat, err := r.dfs.Stat(dst)
+		if os.IsNotExist(err) || err == nil && sstat.IsDir() != dstat.IsDir() {
+			return
+		}
+		check(err)
+		e := &StateEntry{Dir: sstat.IsDir(), Mode: sstat.Mode() & permBits, SrcTime: sstat.ModTime(), DstTime: dstat.ModTime()}
+		if !e.Dir {
+			if r.same(dst, src) == Copied {
+				return
+			}
+			e.Size = sstat.Size()
+			e.Hash = hex.EncodeToString(r.srcSum(src, sstat))
+		}
+		st.Files[r.rel(src)] = e
+		if !e.Dir {
+			return
+		}
+		files, err := r.sfs.ReadDir(src)
+		check(err)
+		for _, fi := range files {
+			src2 := filepath.Join(src, fi.Name())
+			if !r.excluded(src2, fi) || r.descend(src2, fi) {
+				walk(filepath.Join(dst, fi.Name()), src2)
+			}
+		}
+	}
+	if err := catch(func() { walk(dst, src) }); err != nil {
+		return err
+	}
+	return st.Save(s.StateFile)
+}
+
+// rel returns the slash-separated path of the source name src relative to
+// the source of r.
+func (r *run) rel(src string) string {
+	rel, err := filepath.Rel(r.root, src)
+	if err != nil {
+		return filepath.ToSlash(src)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// stateRun records the trees of a run with StateFile as they're synced. A
+// nil *stateRun does nothing. Both states keep up to limit entries in
+// memory, and spill the rest to temporary files.
+type stateRun struct {
+	path string
+	old  *stateEntries
+
+	mu     sync.Mutex // the workers record too
+	files  *stateEntries
+	hashes map[string]string // of the files being copied, by path
+	idle   bool              // nothing changed, so old stands
+}
+
+// loadStateRun reads the state in path for a run.
+func loadStateRun(path string, limit int) (*stateRun, error) {
+	old, err := loadEntries(path, limit)
+	if err != nil {
+		return nil, err
+	}
+	return &stateRun{
+		path:   path,
+		old:    old,
+		files:  newStateEntries(limit),
+		hashes: make(map[string]string),
+	}, nil
+}
+
+// save writes the trees as recorded in the run.
+func (st *stateRun) save() error {
+	if st == nil || st.idle {
+		return nil
+	} else if st.files.spilled() {
+		return st.files.save(st.path)
+	}
+	return (&State{Files: st.files.mem}).Save(st.path)
+}
+
+// close removes the files the states spilled to.
+func (st *stateRun) close() {
+	if st != nil {
+		st.old.close()
+		st.files.close()
+	}
+}
+
+// entry returns the entry of the path p in the old state, or nil.
+func (st *stateRun) entry(p string) *StateEntry {
+	e, err := st.old.get(p)
+	check(err)
+	return e
+}
+
+// unchanged returns true if the source name src and its destination, with
+// the file infos sstat and dstat, didn't change since the last sync.
+func (r *run) unchanged(src string, sstat, dstat os.FileInfo) bool {
+	if r.state == nil {
+		return false
+	}
+	e := r.state.entry(r.rel(src))
+	return e != nil && !e.Dir && e.Size == sstat.Size() && e.Size == dstat.Size() &&
+		r.sameTime(e.SrcTime, sstat.ModTime()) && r.sameTime(e.DstTime, dstat.ModTime())
+}
+
+// deletable returns true if the file or directory in the destination with
+// the file info dstat, whose counterpart is the source name src, was put
+// there by the last sync and didn't change since.
+func (r *run) deletable(src string, dstat os.FileInfo) bool {
+	if r.state == nil {
+		return true
+	}
+	e := r.state.entry(r.rel(src))
+	switch {
+	case e == nil || e.Dir != dstat.IsDir():
+		return false
+	case e.Dir:
+		return true
+	}
+	return e.Size == dstat.Size() && r.sameTime(e.DstTime, dstat.ModTime())
+}
+
+// hashed records the checksum of the source name src, computed as it was
+// copied.
+func (r *run) hashed(src string, sum []byte) {
+	if r.state == nil {
+		return
+	}
+	r.state.mu.Lock()
+	r.state.hashes[r.rel(src)] = hex.EncodeToString(sum)
+	r.state.mu.Unlock()
+}
+
+// record records the source name src, with the file info sstat, as synced
+// to a destination last modified at dtime.
+func (r *run) record(src string, sstat os.FileInfo, dtime time.Time) {
+	if r.state == nil {
+		return
+	}
+	p := r.rel(src)
+	e := &StateEntry{Dir: sstat.IsDir(), Mode: sstat.Mode() & permBits, SrcTime: sstat.ModTime(), DstTime: dtime}
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+	if !e.Dir {
+		e.Size = sstat.Size()
+		if h, ok := r.state.hashes[p]; ok {
+			e.Hash = h
+			delete(r.state.hashes, p)
+		} else if old := r.state.entry(p); old != nil && !old.Dir && old.Size == e.Size &&
+			r.sameTime(old.SrcTime, e.SrcTime) {
+			e.Hash = old.Hash
+		}
+	}
+	check(r.state.files.add(p, e))
+}
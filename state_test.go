@@ -0,0 +1,89 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateFile(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	state := filepath.Join(dir, "state")
+	check(os.MkdirAll(src, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("file a"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "b"), []byte("file b"), 0644))
+
+	s := NewSyncer()
+	s.StateFile = state
+	s.Delete = true
+	check(s.Sync(dst, src))
+	st, err := LoadState(state)
+	check(err)
+	if e := st.Files["a"]; e == nil || e.Size != 6 || e.Hash == "" {
+		t.Errorf("wrong entry for a: %+v", e)
+	}
+
+	// files unchanged since are skipped without being compared
+	fi, err := os.Stat(filepath.Join(dst, "a"))
+	check(err)
+	check(ioutil.WriteFile(filepath.Join(dst, "a"), []byte("file A"), 0644))
+	check(os.Chtimes(filepath.Join(dst, "a"), fi.ModTime(), fi.ModTime()))
+	var why Reason
+	s.Verbosity = Trace
+	s.OnEvent = func(e Event) {
+		if e.Op == OpSkip && e.Path == "a" {
+			why = e.Reason
+		}
+	}
+	// only files the last sync put there are deleted
+	check(ioutil.WriteFile(filepath.Join(dst, "extra"), nil, 0644))
+	check(os.Remove(filepath.Join(src, "b")))
+	check(s.Sync(dst, src))
+	if why != SameState {
+		t.Errorf("expecting a to be skipped for %v, got %v", SameState, why)
+	}
+	testFile(filepath.Join(dst, "a"), []byte("file A"), t)
+	testFile(filepath.Join(dst, "extra"), nil, t)
+	testDirContents(dst, 2, t)
+
+	// a rebuilt state only has the files equal on both sides
+	check(os.Remove(state))
+	check(s.RebuildState(dst, src))
+	st, err = LoadState(state)
+	check(err)
+	if len(st.Files) != 1 || st.Files["."] == nil {
+		t.Errorf("wrong rebuilt state: %v", st.Files)
+	}
+	s.StateFile = ""
+	if err := s.RebuildState(dst, src); err != ErrNoStateFile {
+		t.Errorf("expecting ErrNoStateFile, got %v", err)
+	}
+}
+
+func TestMigrateState(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "state")
+	tt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	check(ioutil.WriteFile(name, []byte(`{"entries":{"a":{"size":3,"src_time":"2020-01-02T03:04:05Z"}}}`), 0644))
+	check(MigrateState(name))
+	data, err := ioutil.ReadFile(name)
+	check(err)
+	st, err := LoadState(name)
+	check(err)
+	if e := st.Files["a"]; st.Version != StateVersion || e == nil || e.Size != 3 || !e.SrcTime.Equal(tt) {
+		t.Errorf("wrong migrated state: %s", data)
+	}
+
+	check(ioutil.WriteFile(name, []byte(`{"version":99}`), 0644))
+	if _, err := LoadState(name); err == nil {
+		t.Error("expecting an error for an unknown version")
+	}
+}
@@ -0,0 +1,58 @@
+package fsync
+
+// Stats counts what a sync did, or would do in a dry run.
+type Stats struct {
+	Files     int   `json:"files"`     // files copied
+	Bytes     int64 `json:"bytes"`     // size of the files copied
+	Dirs      int   `json:"dirs"`      // directories created
+	Deleted   int   `json:"deleted"`   // files and directories deleted, not counting their contents
+	Unchanged int   `json:"unchanged"` // files that were up to date
+	Moved     int   `json:"moved"`     // files moved instead of copied, with DetectRenames
+	Specials  int   `json:"specials"`  // special files made, with RecreateSpecials
+	Linked    int   `json:"linked"`    // files hard-linked instead of copied, with PreserveHardlinks or LinkDest
+	// Created and Updated split Files into the files that weren't in the
+	// destination and those copied over older versions.
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	// Rechecked is the number of files written that Recheck checked
+	// again, and Inconsistent the number of those that had changed.
+	Rechecked    int `json:"rechecked"`
+	Inconsistent int `json:"inconsistent"`
+	// Interfered is the number of files found changed by something else
+	// before they were written, with Interference.
+	Interfered int `json:"interfered"`
+	// Pending is the number of writes a destination that's a Queuer was
+	// still doing in the background when the sync returned.
+	Pending int `json:"pending"`
+	// Seed is what the random choices of the sync were seeded with; see
+	// Syncer.Seed.
+	Seed int64 `json:"seed"`
+	// Failed is the number of files and directories that failed, with
+	// ContinueOnError.
+	Failed int `json:"failed"`
+	// UpToDate is set when a sync with StateFile stopped early, as nothing
+	// changed on either side since the last one.
+	UpToDate bool `json:"up_to_date"`
+	// Last is, when a sync fails, the path of the file or directory it got
+	// to last, relative to the source and slash-separated. With Workers,
+	// files before it may not have been copied yet.
+	Last string `json:"last,omitempty"`
+	// Stopped is why a sync that failed stopped early, if it's one of
+	// the StopCause constants.
+	Stopped StopCause `json:"stopped,omitempty"`
+}
+
+// result returns the Stats of r.
+func (r *run) result() Stats {
+	st := r.stats
+	p := r.progress.get(r.clock().Now())
+	st.Files, st.Bytes = p.Files, p.Bytes
+	st.Created = r.progress.news()
+	st.Updated = st.Files - st.Created
+	st.Interfered = r.expected.count
+	st.Failed = r.failures.count()
+	if q, ok := r.dfs.(Queuer); ok {
+		st.Pending = q.Pending()
+	}
+	return st
+}
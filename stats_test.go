@@ -0,0 +1,54 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "a/b"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a/b/c"), []byte("file c"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "d"), []byte("file d"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "e"), []byte("file e"), 0644))
+	check(os.MkdirAll(filepath.Join(dst, "e"), 0755))
+	check(ioutil.WriteFile(filepath.Join(dst, "a"), []byte("file a"), 0644))
+	check(ioutil.WriteFile(filepath.Join(dst, "d"), []byte("file d"), 0644))
+	check(ioutil.WriteFile(filepath.Join(dst, "f"), []byte("file f"), 0644))
+
+	s := NewSyncer()
+	s.Delete = true
+	s.DryRun = true
+	s.Seed = 1
+	dry, err := s.SyncStats(dst, src)
+	check(err)
+	// nothing changed
+	testDirContents(dst, 4, t)
+	testFile(filepath.Join(dst, "a"), []byte("file a"), t)
+
+	s.DryRun = false
+	stats, err := s.SyncStats(dst, src)
+	check(err)
+	want := Stats{Files: 2, Bytes: 12, Dirs: 2, Deleted: 3, Unchanged: 1, Created: 2, Seed: 1}
+	if stats != want {
+		t.Errorf("expecting %+v, got %+v", want, stats)
+	}
+	if dry != stats {
+		t.Errorf("dry run expected %+v, sync did %+v", dry, stats)
+	}
+	testFile(filepath.Join(dst, "a/b/c"), []byte("file c"), t)
+
+	check(ioutil.WriteFile(filepath.Join(src, "d"), []byte("new d"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "g"), []byte("file g"), 0644))
+	stats, err = s.SyncStats(dst, src)
+	check(err)
+	if stats.Files != 2 || stats.Created != 1 || stats.Updated != 1 {
+		t.Errorf("expecting g created and d updated, got %+v", stats)
+	}
+}
@@ -0,0 +1,117 @@
+package fsync
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+var (
+	ErrMaxBytes = errors.New("fsync: more to copy than MaxBytes allows")
+)
+
+// StopCause is why a sync stopped before it was done, as told by
+// Stats.Stopped, so that automation can tell whether to try again.
+type StopCause int
+
+const (
+	NotStopped      StopCause = iota // the sync is done, or failed otherwise
+	StopCanceled                     // the context of SyncContext was canceled
+	StopDeadline                     // the deadline of the context passed
+	StopMaxDelete                    // more was to be deleted than MaxDelete allows
+	StopMaxBytes                     // more was to be copied than MaxBytes allows
+	StopUnavailable                  // the destination couldn't be reached anymore
+)
+
+var stopNames = [...]string{"", "canceled", "deadline", "max delete", "max bytes", "unavailable"}
+
+func (c StopCause) String() string {
+	if c < 0 || int(c) >= len(stopNames) {
+		return "unknown"
+	}
+	return stopNames[c]
+}
+
+func (c StopCause) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// Retryable returns true if trying again, later or with more time, may get
+// further: after a deadline, MaxBytes or an unavailable destination, but not
+// after being canceled or stopped by MaxDelete, which need a decision.
+func (c StopCause) Retryable() bool {
+	return c == StopDeadline || c == StopMaxBytes || c == StopUnavailable
+}
+
+// stopCause returns why err stopped the sync, unless the destination is
+// to blame, which stopped finds out.
+func stopCause(err error) StopCause {
+	switch {
+	case err == nil:
+		return NotStopped
+	case errors.Is(err, context.Canceled):
+		return StopCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return StopDeadline
+	case errors.Is(err, ErrMaxDelete):
+		return StopMaxDelete
+	case errors.Is(err, ErrMaxBytes):
+		return StopMaxBytes
+	}
+	return NotStopped
+}
+
+// stopped returns why err stopped the sync, checking that the destination
+// can still be reached if it failed otherwise: one that was there when the
+// run began and now fails for another reason than not existing, as with a
+// lost connection. A destination the run was to make, as in a dry run, is
+// never unavailable.
+func (r *run) stopped(err error) StopCause {
+	c := stopCause(err)
+	if c == NotStopped && err != nil && r.dstRoot != "" && r.dstFound {
+		if _, err := r.dfs.Stat(r.dstRoot); err != nil && !os.IsNotExist(err) {
+			return StopUnavailable
+		}
+	}
+	return c
+}
+
+// SyncContext is like SyncStats, but stops when ctx is done, failing with
+// ctx.Err(). Files being copied are finished, as with Pause, and no more
+// are started.
+func (s *Syncer) SyncContext(ctx context.Context, dst, src string) (Stats, error) {
+	return s.syncContext(ctx, dst, src, nil)
+}
+
+// syncContext is SyncContext, with setup, if not nil, called to change the
+// run before it starts.
+func (s *Syncer) syncContext(ctx context.Context, dst, src string, setup func(r *run)) (Stats, error) {
+	if err := s.confirm(dst, src); err != nil {
+		return Stats{}, err
+	}
+	r, dst, src, err := s.newRun(dst, src)
+	if err != nil {
+		return Stats{}, err
+	}
+	r.ctx = ctx
+	if setup != nil {
+		setup(r)
+	}
+	err = r.do(dst, src)
+	stats := r.result()
+	if err != nil {
+		r.emit(Quiet, "", Event{Op: OpError, Err: err})
+		if r.last != "" {
+			stats.Last = r.rel(r.last)
+		}
+	}
+	return stats, err
+}
+
+// budget panics with ErrMaxBytes if copying size more bytes goes over
+// MaxBytes.
+func (r *run) budget(size int64) {
+	if r.MaxBytes > 0 && r.progress.get(r.clock().Now()).TotalBytes+size > r.MaxBytes {
+		panic(ErrMaxBytes)
+	}
+}
@@ -0,0 +1,95 @@
+package fsync
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStopCauses(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	for i := 0; i < 3; i++ {
+		check(ioutil.WriteFile(filepath.Join(src, fmt.Sprint(i)), []byte("file"), 0644))
+	}
+
+	// canceled after the first file, which isn't a failure of the next
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewSyncer()
+	s.ContinueOnError = true
+	s.Verbosity = Verbose
+	s.OnEvent = func(e Event) {
+		if e.Op == OpCopy {
+			cancel()
+		}
+	}
+	stats, err := s.SyncContext(ctx, dst, src)
+	if err != context.Canceled || stats.Stopped != StopCanceled || stats.Files != 1 || stats.Failed != 0 {
+		t.Errorf("expecting to be canceled after a file, got %v and %+v", err, stats)
+	}
+	if stats.Stopped.Retryable() {
+		t.Errorf("expecting %v not to be retryable", stats.Stopped)
+	}
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	s.OnEvent = nil
+	stats, err = s.SyncContext(ctx, dst, src)
+	if err != context.DeadlineExceeded || stats.Stopped != StopDeadline {
+		t.Errorf("expecting the deadline to be passed, got %v and %+v", err, stats)
+	}
+
+	s.MaxBytes = 5
+	stats, err = s.SyncStats(dst, src)
+	if err != ErrMaxBytes || stats.Stopped != StopMaxBytes || stats.Files != 1 || !stats.Stopped.Retryable() {
+		t.Errorf("expecting MaxBytes to stop after a file, got %v and %+v", err, stats)
+	}
+	stats, err = s.SyncStats(dst, src)
+	check(err)
+	if stats.Files != 1 || stats.Stopped != NotStopped {
+		t.Errorf("expecting the last file to be copied, got %+v", stats)
+	}
+
+	s = NewSyncer()
+	s.Delete = true
+	s.MaxDelete = 1
+	check(os.Remove(filepath.Join(src, "0")))
+	check(os.Remove(filepath.Join(src, "1")))
+	if stats, err = s.SyncStats(dst, src); stats.Stopped != StopMaxDelete {
+		t.Errorf("expecting MaxDelete to stop the sync, got %v and %+v", err, stats)
+	}
+
+	// the destination can't be reached after the first file
+	mnt := filepath.Join(dir, "mnt")
+	dst = filepath.Join(mnt, "dst")
+	check(os.MkdirAll(dst, 0755))
+	s = NewSyncer()
+	s.Verbosity = Verbose
+	s.OnEvent = func(e Event) {
+		if e.Op == OpCopy {
+			check(os.RemoveAll(mnt))
+			check(ioutil.WriteFile(mnt, nil, 0644))
+		}
+	}
+	check(ioutil.WriteFile(filepath.Join(src, "0"), []byte("file"), 0644))
+	if stats, err = s.SyncStats(dst, src); err == nil || stats.Stopped != StopUnavailable {
+		t.Errorf("expecting the destination to be unavailable, got %v and %+v", err, stats)
+	}
+
+	// a destination that was never made isn't unavailable
+	check(os.Symlink(filepath.Join(src, "0"), filepath.Join(src, "link")))
+	s = NewSyncer()
+	s.DryRun = true
+	s.Strict = true
+	if stats, err = s.SyncStats(filepath.Join(dir, "dst3"), src); err == nil || stats.Stopped != NotStopped || stats.Stopped.Retryable() {
+		t.Errorf("expecting a failure that isn't a stop, got %v and %+v", err, stats)
+	}
+}
@@ -0,0 +1,69 @@
+package fsync
+
+import (
+	"os"
+	"strings"
+)
+
+// LossError is the error of a Strict sync that would lose something a
+// file in the source has.
+type LossError struct {
+	Path string // relative to the source, slash-separated
+	Loss string // what would be lost
+}
+
+func (e *LossError) Error() string {
+	return "fsync: " + e.Path + ": would lose " + e.Loss
+}
+
+// xattrLister is implemented by file systems that can list the extended
+// attributes of files, which a sync doesn't copy.
+type xattrLister interface {
+	xattrs(name string) ([]string, error)
+}
+
+// strict panics with a LossError if the source name src, with the file
+// info sstat, has what a sync drops, with Strict.
+func (r *run) strict(src string, sstat os.FileInfo) {
+	if !r.Strict {
+		return
+	}
+	loss := ""
+	if sl, ok := r.sfs.(Symlinker); ok && src != r.root {
+		fi, err := sl.Lstat(src)
+		check(err)
+		if fi.Mode()&os.ModeSymlink != 0 {
+			loss = "a symbolic link, by copying what it points to"
+		}
+	}
+	if loss == "" && isSpecial(sstat) && r.Specials != RecreateSpecials {
+		loss = "a special file"
+	}
+	if xl, ok := r.sfs.(xattrLister); ok && loss == "" {
+		names, err := xl.xattrs(src)
+		check(err)
+		if len(names) > 0 {
+			loss = "extended attributes " + strings.Join(names, ", ")
+		}
+	}
+	if loss != "" {
+		panic(&LossError{Path: r.rel(src), Loss: loss})
+	}
+}
+
+// strictStats panics with a LossError if the destination name dst doesn't
+// have the permissions and modification time of the source name src, with
+// the file info sstat, after they were synced, with Strict.
+func (r *run) strictStats(dst, src string, sstat os.FileInfo) {
+	if !r.Strict {
+		return
+	}
+	dstat, err := r.dfs.Stat(dst)
+	check(err)
+	switch {
+	case r.permDiffers(dstat, sstat):
+		panic(&LossError{Path: r.rel(src), Loss: "permissions " + r.perm(sstat).String()})
+	case !r.NoTimes && !dstat.ModTime().Equal(sstat.ModTime()):
+		panic(&LossError{Path: r.rel(src), Loss: "the precision of its modification time"})
+	}
+}
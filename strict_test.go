@@ -0,0 +1,47 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// secondsFS keeps modification times to the second.
+type secondsFS struct{ osFS }
+
+func (fs secondsFS) Chtimes(name string, atime, mtime time.Time) error {
+	return fs.osFS.Chtimes(name, atime, mtime.Truncate(time.Second))
+}
+
+func TestStrict(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("file a"), 0644))
+	check(os.Chtimes(filepath.Join(src, "a"), time.Now(), time.Unix(1e9, 5e8)))
+	check(os.Chtimes(src, time.Now(), time.Unix(1e9, 0)))
+
+	s := NewSyncer()
+	s.Strict = true
+	check(s.Sync(dst, src))
+
+	s.DstFS = secondsFS{}
+	check(os.RemoveAll(dst))
+	err = s.Sync(dst, src)
+	if le, ok := err.(*LossError); !ok || le.Path != "a" || !strings.Contains(le.Loss, "time") {
+		t.Errorf("expecting the time of a to be lost, got %v", err)
+	}
+
+	s.DstFS = nil
+	check(os.Symlink("a", filepath.Join(src, "b")))
+	err = s.Sync(dst, src)
+	if le, ok := err.(*LossError); !ok || le.Path != "b" {
+		t.Errorf("expecting the link b to be lost, got %v", err)
+	}
+}
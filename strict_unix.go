@@ -0,0 +1,34 @@
+//go:build linux || darwin || freebsd || netbsd
+// +build linux darwin freebsd netbsd
+
+package fsync
+
+import (
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+func (osFS) xattrs(name string) ([]string, error) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := unix.Listxattr(name, buf)
+		if err == unix.ERANGE {
+			buf = make([]byte, 2*len(buf))
+			continue
+		} else if err == unix.ENOTSUP {
+			return nil, nil
+		} else if err != nil {
+			return nil, &os.PathError{Op: "listxattr", Path: name, Err: err}
+		}
+		var names []string
+		for _, a := range strings.Split(string(buf[:n]), "\x00") {
+			// SELinux labels are set by the destination's own policy
+			if a != "" && a != "security.selinux" {
+				names = append(names, a)
+			}
+		}
+		return names, nil
+	}
+}
@@ -0,0 +1,93 @@
+package fsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrSwapState = errors.New("fsync: Swap can't be used with StateFile")
+	ErrNotLink   = errors.New("fsync: the destination of SwapLink isn't a symbolic link")
+)
+
+// Swap is how a sync replaces the whole destination tree at once, so that
+// what serves it never sees it half updated. The tree is synced next to the
+// destination and then swapped in. The two trees take turns, so a sync
+// copies what changed since the one before the last. Use it with Delete, or
+// files deleted from the source stay in the trees.
+type Swap int
+
+const (
+	// NoSwap syncs the destination in place.
+	NoSwap Swap = iota
+	// SwapRename syncs into a directory named after the destination with
+	// ".fsync-staging" appended, and then swaps the two by renaming them.
+	// On file systems that are Exchangers, such as local ones on Linux, the
+	// swap is atomic; on others, the destination is briefly missing.
+	SwapRename
+	// SwapLink keeps the destination as a symbolic link to one of two
+	// directories named after it with ".blue" and ".green" appended,
+	// syncs into the other one, and then points the link at it, which is
+	// atomic. The destination file system must be a Symlinker.
+	SwapLink
+)
+
+// Exchanger is implemented by file systems that can swap two names
+// atomically, which SwapRename uses.
+type Exchanger interface {
+	Exchange(a, b string) error
+}
+
+// stage returns the directory to sync into instead of dst with Swap.
+func (r *run) stage(dst string) (string, error) {
+	if r.Swap == SwapRename {
+		return dst + ".fsync-staging", nil
+	}
+	dl, ok := r.dfs.(Symlinker)
+	if !ok {
+		return "", ErrNoSymlinks
+	}
+	fi, err := dl.Lstat(dst)
+	if os.IsNotExist(err) {
+		return dst + ".blue", nil
+	} else if err != nil {
+		return "", err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return "", ErrNotLink
+	}
+	target, err := dl.Readlink(dst)
+	if err != nil {
+		return "", err
+	}
+	if filepath.Base(target) == filepath.Base(dst)+".blue" {
+		return dst + ".green", nil
+	}
+	return dst + ".blue", nil
+}
+
+// swap makes the directory staged, synced by stage, the destination dst.
+func (r *run) swap(dst, staged string) error {
+	if r.Swap == SwapLink {
+		tmp := tempName(dst)
+		if err := r.dfs.(Symlinker).Symlink(filepath.Base(staged), tmp); err != nil {
+			return err
+		}
+		return r.dfs.Rename(tmp, dst)
+	}
+	if _, err := r.dfs.Stat(dst); os.IsNotExist(err) {
+		return r.dfs.Rename(staged, dst)
+	}
+	if x, ok := r.dfs.(Exchanger); ok {
+		return x.Exchange(staged, dst)
+	}
+	old := tempName(dst)
+	if err := r.dfs.Rename(dst, old); err != nil {
+		return err
+	}
+	if err := r.dfs.Rename(staged, dst); err != nil {
+		return err
+	}
+	return r.dfs.Rename(old, staged)
+}
@@ -0,0 +1,15 @@
+package fsync
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func (osFS) Exchange(a, b string) error {
+	err := unix.Renameat2(unix.AT_FDCWD, a, unix.AT_FDCWD, b, unix.RENAME_EXCHANGE)
+	if err != nil {
+		return &os.LinkError{Op: "exchange", Old: a, New: b, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSwap(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	check(os.MkdirAll(src, 0755))
+
+	s := NewSyncer()
+	s.Delete = true
+	for _, swap := range []Swap{SwapRename, SwapLink} {
+		dst := filepath.Join(dir, "dst")
+		s.Swap = swap
+		for _, data := range []string{"v1", "v2", "v3"} {
+			check(ioutil.WriteFile(filepath.Join(src, "a"), []byte(data), 0644))
+			check(s.Sync(dst, src))
+			testFile(filepath.Join(dst, "a"), []byte(data), t)
+		}
+		// the other tree is a version behind
+		other := dst + ".fsync-staging"
+		if swap == SwapLink {
+			target, err := os.Readlink(dst)
+			check(err)
+			other = dst + ".green"
+			if target == "dst.green" {
+				other = dst + ".blue"
+			}
+		}
+		testFile(filepath.Join(other, "a"), []byte("v2"), t)
+		check(os.RemoveAll(dst))
+	}
+
+	// a destination that isn't a link can't be swapped by SwapLink
+	dst := filepath.Join(dir, "plain")
+	check(os.MkdirAll(dst, 0755))
+	if err := s.Sync(dst, src); err != ErrNotLink {
+		t.Errorf("expecting ErrNotLink, got %v", err)
+	}
+}
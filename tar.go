@@ -0,0 +1,158 @@
+package fsync
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// TarManifest lists the entries of an archive written by SyncToTarSince, so
+// that the next archive only needs the changes. It can be saved with
+// encoding/json.
+type TarManifest struct {
+	Entries map[string]TarEntry // by name in the archive
+}
+
+// TarEntry describes a file or directory in a TarManifest.
+type TarEntry struct {
+	Size    int64
+	Mode    os.FileMode // permission bits and os.ModeDir
+	ModTime time.Time
+}
+
+func (e TarEntry) equal(f TarEntry) bool {
+	return e.Size == f.Size && e.Mode == f.Mode && e.ModTime.Equal(f.ModTime)
+}
+
+// SyncToTar writes the tree src to w as a tar archive.
+func SyncToTar(w io.Writer, src string) error {
+	return NewSyncer().SyncToTar(w, src)
+}
+
+// SyncToTar writes the tree src to w as a tar archive, with names relative
+// to src. The archive is deterministic: entries are sorted by name, owners
+// are left out and modification times are truncated to seconds, or left out
+// with NoTimes, so the same tree always gives the same archive. Only regular
+// files and directories are archived, and what Exclude, Include and Filter
+// leave out isn't, as in a sync.
+func (s *Syncer) SyncToTar(w io.Writer, src string) error {
+	_, err := s.SyncToTarSince(w, src, nil)
+	return err
+}
+
+// SyncToTarSince is like SyncToTar, but only archives the entries that are
+// new or changed, by size, permissions or modification time, since prev,
+// which a previous call returned. Extracting the archives in order gives the
+// tree as of the last one. Files removed since prev can't be recorded in a
+// tar archive; they are missing in the returned manifest. If prev is nil,
+// the whole tree is archived.
+func (s *Syncer) SyncToTarSince(w io.Writer, src string, prev *TarManifest) (*TarManifest, error) {
+	if err := checkPatterns(s.Exclude, s.Include); err != nil {
+		return nil, err
+	}
+	sfs, src, err := openFS(s.SrcFS, src)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFS(sfs, s.SrcFS)
+	a := &archive{
+		run:  &run{Syncer: s, sfs: sfs, root: src},
+		fs:   sfs,
+		tw:   tar.NewWriter(w),
+		m:    &TarManifest{Entries: make(map[string]TarEntry)},
+		prev: prev,
+	}
+	err = catch(func() {
+		fi, err := sfs.Stat(src)
+		check(err)
+		if fi.IsDir() {
+			a.dir(src, "")
+		} else if fi.Mode().IsRegular() {
+			a.add(src, fi.Name(), fi)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := a.tw.Close(); err != nil {
+		return nil, err
+	}
+	return a.m, nil
+}
+
+// archive holds the state of a call to SyncToTarSince.
+type archive struct {
+	*run    // for filters
+	fs      FS
+	tw      *tar.Writer
+	m, prev *TarManifest
+}
+
+// dir archives the contents of the directory src, named name in the archive.
+func (a *archive) dir(src, name string) {
+	infos, err := a.fs.ReadDir(src)
+	check(err)
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	for _, fi := range infos {
+		file := filepath.Join(src, fi.Name())
+		sub := path.Join(name, fi.Name())
+		excluded := a.excluded(file, fi)
+		if excluded && !a.descend(file, fi) {
+			continue
+		}
+		if fi.IsDir() {
+			if !excluded {
+				a.add(file, sub, fi)
+			}
+			a.dir(file, sub)
+		} else if fi.Mode().IsRegular() {
+			a.add(file, sub, fi)
+		}
+	}
+}
+
+// add archives the file or directory src, named name in the archive, unless
+// it didn't change since the previous archive.
+func (a *archive) add(src, name string, fi os.FileInfo) {
+	e := TarEntry{
+		Mode:    fi.Mode() & (os.ModeDir | os.ModePerm),
+		ModTime: time.Unix(0, 0).UTC(),
+	}
+	if !a.NoTimes {
+		e.ModTime = fi.ModTime().Truncate(time.Second).UTC()
+	}
+	if !fi.IsDir() {
+		e.Size = fi.Size()
+	}
+	a.m.Entries[name] = e
+	if a.prev != nil {
+		if old, ok := a.prev.Entries[name]; ok && old.equal(e) {
+			return
+		}
+	}
+
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     e.Size,
+		Mode:     int64(e.Mode.Perm()),
+		ModTime:  e.ModTime,
+	}
+	if fi.IsDir() {
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+	}
+	check(a.tw.WriteHeader(hdr))
+	if fi.IsDir() {
+		return
+	}
+	f, err := a.fs.Open(src)
+	check(err)
+	defer f.Close()
+	_, err = io.CopyN(a.tw, f, e.Size)
+	check(err)
+}
@@ -0,0 +1,101 @@
+package fsync
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// tarNames returns the names of the entries in the archive data.
+func tarNames(data []byte) []string {
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		check(err)
+		names = append(names, hdr.Name)
+	}
+}
+
+func TestSyncToTar(t *testing.T) {
+	src, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(src)
+	check(os.MkdirAll(filepath.Join(src, "b"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "b/c"), []byte("file c"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("file a"), 0644))
+
+	var buf1, buf2 bytes.Buffer
+	check(SyncToTar(&buf1, src))
+	time.Sleep(10 * time.Millisecond)
+	check(SyncToTar(&buf2, src))
+	if !bytes.Equal(buf1.Bytes(), buf2.Bytes()) {
+		t.Error("archives of the same tree differ")
+	}
+	want := []string{"a", "b/", "b/c"}
+	if names := tarNames(buf1.Bytes()); !reflect.DeepEqual(names, want) {
+		t.Errorf("expecting %v, got %v", want, names)
+	}
+
+	// only changes are archived, with a manifest read back from JSON
+	s := NewSyncer()
+	var full bytes.Buffer
+	m, err := s.SyncToTarSince(&full, src, nil)
+	check(err)
+	data, err := json.Marshal(m)
+	check(err)
+	prev := &TarManifest{}
+	check(json.Unmarshal(data, prev))
+	tt := time.Now().Add(time.Hour)
+	check(os.Chtimes(filepath.Join(src, "b/c"), tt, tt))
+	check(os.Remove(filepath.Join(src, "a")))
+	var inc bytes.Buffer
+	m, err = s.SyncToTarSince(&inc, src, prev)
+	check(err)
+	if names := tarNames(inc.Bytes()); !reflect.DeepEqual(names, []string{"b/c"}) {
+		t.Errorf("expecting only b/c, got %v", names)
+	}
+	if _, ok := m.Entries["a"]; ok || len(m.Entries) != 2 {
+		t.Errorf("wrong manifest: %v", m.Entries)
+	}
+}
+
+func TestSyncToTarFilters(t *testing.T) {
+	src, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(src)
+	check(os.MkdirAll(filepath.Join(src, "b/d"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a.tmp"), []byte("file a"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "b/c"), []byte("file c"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "b/d/e"), []byte("file e"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "f"), []byte("file f"), 0644))
+
+	s := NewSyncer()
+	s.Exclude = []string{"*.tmp", "b/d"}
+	var buf bytes.Buffer
+	check(s.SyncToTar(&buf, src))
+	want := []string{"b/", "b/c", "f"}
+	if names := tarNames(buf.Bytes()); !reflect.DeepEqual(names, want) {
+		t.Errorf("expecting %v, got %v", want, names)
+	}
+
+	// Include reaches into an excluded directory
+	s.Exclude = []string{"b"}
+	s.Include = []string{"b/d/e"}
+	buf.Reset()
+	check(s.SyncToTar(&buf, src))
+	want = []string{"a.tmp", "b/d/e", "f"}
+	if names := tarNames(buf.Bytes()); !reflect.DeepEqual(names, want) {
+		t.Errorf("expecting %v, got %v", want, names)
+	}
+}
@@ -0,0 +1,436 @@
+// Package tierfs provides a tiered fsync destination, which writes to a
+// local file system and copies what's written to a remote one in the
+// background:
+//
+//	fs := tierfs.New(fsync.OS, "/var/cache/site", remote, "/site")
+//	s := fsync.NewSyncer()
+//	s.DstFS = fs
+//	stats, err := s.SyncStats("/var/cache/site", "site")
+//	// the local tier is synced; stats.Pending changes are on their way
+//	err = fs.Flush()
+//	// the remote tier is synced too
+//
+// Everything is read from the local tier. Changes are made to the remote
+// tier in the order they were made to the local one, one at a time, so the
+// changes to each path are in order too. Files are uploaded as they were
+// when closed, from a copy kept until then, so that renaming or removing
+// them right after, as Atomic syncs do, doesn't lose them. The queue of
+// changes can be kept in a journal, with the copies, so that it survives
+// restarts; see Options.
+package tierfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mostafah/fsync"
+)
+
+// FS is a tiered fsync.FS. It implements fsync.Queuer.
+type FS struct {
+	local, remote         fsync.FS
+	localRoot, remoteRoot string
+	opts                  Options
+
+	mu      sync.Mutex
+	cond    sync.Cond
+	queue   []entry // the first one is being done
+	seq     int64   // of the last entry queued
+	journal *os.File
+	spool   string // directory of the copies of files to upload
+	err     error  // first error since the last Flush
+	closed  bool
+}
+
+// Options configure an FS.
+type Options struct {
+	// Journal is the file the queue is kept in, so that the changes not
+	// made to the remote tier yet are made after a restart, and the
+	// copies of the files to upload are kept in the directory named as it
+	// with ".spool" added. Changes that fail are left in it, to be tried
+	// again then. If it's empty, the queue is only kept in memory.
+	Journal string
+	// Retries is how many more times a change that fails is tried before
+	// it's given up on, waiting RetryDelay before the first retry and
+	// twice as long before each of the next ones.
+	Retries    int
+	RetryDelay time.Duration
+}
+
+// Op is a change queued for the remote tier. Names are local.
+type Op struct {
+	Op      string      `json:"op"` // "upload", "mkdir", "remove", "removeall", "rename", "chmod" or "chtimes"
+	Name    string      `json:"name"`
+	NewName string      `json:"new_name,omitempty"` // for "rename"
+	Spool   string      `json:"spool,omitempty"`    // for "upload", the copy to upload
+	Mode    os.FileMode `json:"mode,omitempty"`     // for "mkdir" and "chmod"
+	Atime   time.Time   `json:"atime,omitempty"`    // for "chtimes"
+	Mtime   time.Time   `json:"mtime,omitempty"`
+}
+
+type entry struct {
+	Seq  int64 `json:"seq"`
+	Op   *Op   `json:"op,omitempty"`
+	Done bool  `json:"done,omitempty"` // a record of the entry Seq being done
+}
+
+// New returns an FS writing names under localRoot to local, and copying
+// them to remote, under remoteRoot, with no retries and no journal.
+func New(local fsync.FS, localRoot string, remote fsync.FS, remoteRoot string) *FS {
+	fs, _ := NewWithOptions(local, localRoot, remote, remoteRoot, Options{})
+	return fs
+}
+
+// NewWithOptions is like New with options. The changes left in the journal
+// are queued first.
+func NewWithOptions(local fsync.FS, localRoot string, remote fsync.FS, remoteRoot string, opts Options) (*FS, error) {
+	fs := &FS{local: local, remote: remote, localRoot: localRoot, remoteRoot: remoteRoot, opts: opts}
+	fs.cond.L = &fs.mu
+	if opts.Journal != "" {
+		if err := fs.openJournal(); err != nil {
+			return nil, err
+		}
+	}
+	go fs.run()
+	return fs, nil
+}
+
+// openJournal queues the changes left in the journal, and rewrites it with
+// only them, removing the copies in the spool that no change needs.
+func (fs *FS) openJournal() error {
+	data, err := ioutil.ReadFile(fs.opts.Journal)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	done := make(map[int64]bool)
+	var entries []entry
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var e entry
+		if err := dec.Decode(&e); err != nil {
+			break // at the end, or cut short by a crash
+		}
+		if e.Done {
+			done[e.Seq] = true
+		} else if e.Op != nil {
+			entries = append(entries, e)
+		}
+	}
+	fs.spool = fs.opts.Journal + ".spool"
+	if err := os.MkdirAll(fs.spool, 0755); err != nil {
+		return err
+	}
+	spooled := make(map[string]bool)
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if !done[e.Seq] {
+			e.Seq = fs.seq + 1
+			fs.seq = e.Seq
+			fs.queue = append(fs.queue, e)
+			enc.Encode(e)
+			spooled[e.Op.Spool] = true
+		}
+	}
+	files, err := ioutil.ReadDir(fs.spool)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if name := filepath.Join(fs.spool, fi.Name()); !spooled[name] {
+			os.Remove(name)
+		}
+	}
+	tmp := fs.opts.Journal + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, fs.opts.Journal); err != nil {
+		return err
+	}
+	fs.journal, err = os.OpenFile(fs.opts.Journal, os.O_WRONLY|os.O_APPEND, 0)
+	return err
+}
+
+// record appends e to the journal, if any. fs.mu must be held.
+func (fs *FS) record(e entry) error {
+	if fs.journal == nil {
+		return nil
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fs.journal.Write(append(data, '\n'))
+	return err
+}
+
+// run makes the queued changes. Those that fail are left in the journal.
+func (fs *FS) run() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for {
+		for len(fs.queue) == 0 && !fs.closed {
+			fs.cond.Wait()
+		}
+		if len(fs.queue) == 0 {
+			return
+		}
+		e := fs.queue[0]
+		fs.mu.Unlock()
+		err := fs.apply(e.Op)
+		delay := fs.opts.RetryDelay
+		for i := 0; i < fs.opts.Retries && err != nil && !os.IsNotExist(err); i++ {
+			time.Sleep(delay)
+			delay *= 2
+			err = fs.apply(e.Op)
+		}
+		fs.mu.Lock()
+		fs.queue = fs.queue[1:]
+		if err == nil {
+			err = fs.record(entry{Seq: e.Seq, Done: true})
+		}
+		if e.Op.Spool != "" && (err == nil || fs.journal == nil) {
+			os.Remove(e.Op.Spool)
+		}
+		if err != nil && fs.err == nil {
+			fs.err = err
+		}
+		fs.cond.Broadcast()
+	}
+}
+
+// apply makes the change op to the remote tier.
+func (fs *FS) apply(op *Op) error {
+	name := fs.remoteName(op.Name)
+	switch op.Op {
+	case "upload":
+		return fs.upload(op)
+	case "mkdir":
+		return fs.remote.MkdirAll(name, op.Mode)
+	case "remove":
+		if err := fs.remote.Remove(name); !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	case "removeall":
+		return fs.remote.RemoveAll(name)
+	case "rename":
+		return fs.remote.Rename(name, fs.remoteName(op.NewName))
+	case "chmod":
+		return fs.remote.Chmod(name, op.Mode)
+	case "chtimes":
+		return fs.remote.Chtimes(name, op.Atime, op.Mtime)
+	}
+	return fmt.Errorf("tierfs: unknown op %q", op.Op)
+}
+
+// enqueue queues op for the remote tier.
+func (fs *FS) enqueue(op Op) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.seq++
+	e := entry{Seq: fs.seq, Op: &op}
+	if err := fs.record(e); err != nil {
+		return err
+	}
+	fs.queue = append(fs.queue, e)
+	fs.cond.Broadcast()
+	return nil
+}
+
+// Pending returns the number of changes not made to the remote tier yet.
+func (fs *FS) Pending() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return len(fs.queue)
+}
+
+// Queue returns the changes not made to the remote tier yet, in order,
+// starting with the one being made.
+func (fs *FS) Queue() []Op {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	ops := make([]Op, len(fs.queue))
+	for i, e := range fs.queue {
+		ops[i] = *e.Op
+	}
+	return ops
+}
+
+// Flush drains the queue: it waits until all the changes are made to the
+// remote tier, or given up on, and returns the first error making them
+// since the last Flush.
+func (fs *FS) Flush() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for len(fs.queue) > 0 {
+		fs.cond.Wait()
+	}
+	err := fs.err
+	fs.err = nil
+	return err
+}
+
+// Close flushes fs and stops it.
+func (fs *FS) Close() error {
+	fs.mu.Lock()
+	fs.closed = true
+	fs.cond.Broadcast()
+	fs.mu.Unlock()
+	err := fs.Flush()
+	if fs.journal != nil {
+		if err2 := fs.journal.Close(); err == nil {
+			err = err2
+		}
+	}
+	if fs.spool != "" && fs.journal == nil {
+		os.RemoveAll(fs.spool)
+	}
+	return err
+}
+
+// remoteName returns the name in the remote tier of the local name.
+func (fs *FS) remoteName(name string) string {
+	rel, err := filepath.Rel(fs.localRoot, name)
+	if err != nil {
+		return name
+	}
+	return filepath.Join(fs.remoteRoot, rel)
+}
+
+func (fs *FS) Stat(name string) (os.FileInfo, error)      { return fs.local.Stat(name) }
+func (fs *FS) ReadDir(name string) ([]os.FileInfo, error) { return fs.local.ReadDir(name) }
+func (fs *FS) Open(name string) (io.ReadCloser, error)    { return fs.local.Open(name) }
+
+// Create creates name in the local tier. What's written is copied to the
+// spool as well, and uploaded to the remote tier once closed.
+func (fs *FS) Create(name string) (io.WriteCloser, error) {
+	sp, err := fs.spoolFile()
+	if err != nil {
+		return nil, err
+	}
+	f, err := fs.local.Create(name)
+	if err != nil {
+		sp.Close()
+		os.Remove(sp.Name())
+		return nil, err
+	}
+	return &file{f, sp, fs, name}, nil
+}
+
+// spoolFile creates a file in the spool, making it first if needed.
+func (fs *FS) spoolFile() (*os.File, error) {
+	fs.mu.Lock()
+	if fs.spool == "" {
+		dir, err := ioutil.TempDir("", "tierfs")
+		if err != nil {
+			fs.mu.Unlock()
+			return nil, err
+		}
+		fs.spool = dir
+	}
+	dir := fs.spool
+	fs.mu.Unlock()
+	return ioutil.TempFile(dir, "upload")
+}
+
+type file struct {
+	io.WriteCloser
+	spool *os.File
+	fs    *FS
+	name  string
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	n, err := f.WriteCloser.Write(p)
+	if _, err2 := f.spool.Write(p[:n]); err == nil {
+		err = err2
+	}
+	return n, err
+}
+
+// Close queues the upload of f, the first time it's called.
+func (f *file) Close() error {
+	err := f.WriteCloser.Close()
+	if f.spool == nil {
+		return err
+	}
+	sp := f.spool
+	f.spool = nil
+	if err2 := sp.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = f.fs.enqueue(Op{Op: "upload", Name: f.name, Spool: sp.Name()})
+	}
+	if err != nil {
+		os.Remove(sp.Name())
+	}
+	return err
+}
+
+// upload copies the file of op to the remote tier, from its spooled copy,
+// or from the local tier if it has none.
+func (fs *FS) upload(op *Op) error {
+	var in io.ReadCloser
+	var err error
+	if op.Spool != "" {
+		in, err = os.Open(op.Spool)
+	} else {
+		in, err = fs.local.Open(op.Name)
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := fs.remote.Create(fs.remoteName(op.Name))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// do makes a change to the local tier, and queues op for the remote one if
+// it's made.
+func (fs *FS) do(err error, op Op) error {
+	if err != nil {
+		return err
+	}
+	return fs.enqueue(op)
+}
+
+func (fs *FS) MkdirAll(name string, perm os.FileMode) error {
+	return fs.do(fs.local.MkdirAll(name, perm), Op{Op: "mkdir", Name: name, Mode: perm})
+}
+
+func (fs *FS) Remove(name string) error {
+	return fs.do(fs.local.Remove(name), Op{Op: "remove", Name: name})
+}
+
+func (fs *FS) RemoveAll(name string) error {
+	return fs.do(fs.local.RemoveAll(name), Op{Op: "removeall", Name: name})
+}
+
+func (fs *FS) Rename(oldname, newname string) error {
+	return fs.do(fs.local.Rename(oldname, newname), Op{Op: "rename", Name: oldname, NewName: newname})
+}
+
+func (fs *FS) Chmod(name string, mode os.FileMode) error {
+	return fs.do(fs.local.Chmod(name, mode), Op{Op: "chmod", Name: name, Mode: mode})
+}
+
+func (fs *FS) Chtimes(name string, atime, mtime time.Time) error {
+	return fs.do(fs.local.Chtimes(name, atime, mtime), Op{Op: "chtimes", Name: name, Atime: atime, Mtime: mtime})
+}
@@ -0,0 +1,291 @@
+package tierfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mostafah/fsync"
+)
+
+// gateFS is a local fsync.FS that creates files once its gate is open.
+type gateFS struct {
+	fsync.FS
+	gate chan struct{}
+}
+
+func (fs gateFS) Create(name string) (io.WriteCloser, error) {
+	<-fs.gate
+	return fs.FS.Create(name)
+}
+
+// failFS is a local fsync.FS that fails to create the first fails files.
+type failFS struct {
+	fsync.FS
+	fails int
+}
+
+func (fs *failFS) Create(name string) (io.WriteCloser, error) {
+	if fs.fails > 0 {
+		fs.fails--
+		return nil, errors.New("failed")
+	}
+	return fs.FS.Create(name)
+}
+
+func TestFS(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "tierfs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	local := filepath.Join(dir, "local")
+	remote := filepath.Join(dir, "remote")
+	if err := os.MkdirAll(filepath.Join(src, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "a/b"), []byte("file b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gate := make(chan struct{})
+	fs := New(fsync.OS, local, gateFS{fsync.OS, gate}, remote)
+	defer fs.Close()
+	s := fsync.NewSyncer()
+	s.DstFS = fs
+	stats, err := s.SyncStats(local, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Pending == 0 {
+		t.Error("expecting changes to be pending")
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(local, "a/b")); err != nil || string(data) != "file b" {
+		t.Errorf("wrong local a/b: %q, %v", data, err)
+	}
+	close(gate)
+	if err := fs.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if fs.Pending() != 0 {
+		t.Error("expecting nothing to be pending after Flush")
+	}
+	fi, err := os.Stat(filepath.Join(remote, "a/b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sfi, err := os.Stat(filepath.Join(src, "a/b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 6 || !fi.ModTime().Equal(sfi.ModTime()) {
+		t.Errorf("wrong remote a/b: %d bytes, modified %v", fi.Size(), fi.ModTime())
+	}
+
+	// removals reach the remote tier too
+	if err := os.RemoveAll(filepath.Join(src, "a")); err != nil {
+		t.Fatal(err)
+	}
+	s.Delete = true
+	if err := s.Sync(local, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(remote, "a")); !os.IsNotExist(err) {
+		t.Errorf("expecting a to be removed from the remote tier, got %v", err)
+	}
+}
+
+func TestAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "tierfs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	local := filepath.Join(dir, "local")
+	remote := filepath.Join(dir, "remote")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("f%d", i)
+		if err := ioutil.WriteFile(filepath.Join(src, name), []byte("file "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the temporary files are renamed before they're uploaded
+	gate := make(chan struct{})
+	fs := New(fsync.OS, local, gateFS{fsync.OS, gate}, remote)
+	defer fs.Close()
+	s := fsync.NewSyncer()
+	s.DstFS = fs
+	s.Atomic = true
+	if err := s.Sync(local, src); err != nil {
+		t.Fatal(err)
+	}
+	close(gate)
+	if err := fs.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 5 {
+		t.Errorf("expecting 5 files in the remote tier, got %d", len(files))
+	}
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("f%d", i)
+		if data, err := ioutil.ReadFile(filepath.Join(remote, name)); err != nil || string(data) != "file "+name {
+			t.Errorf("wrong remote %s: %q, %v", name, data, err)
+		}
+	}
+
+	// changes to files missing from the remote tier are reported
+	if err := os.Remove(filepath.Join(remote, "f0")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Chmod(filepath.Join(local, "f0"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Flush(); !os.IsNotExist(err) {
+		t.Errorf("expecting the chmod to fail, got %v", err)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "tierfs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, "local")
+	remote := filepath.Join(dir, "remote")
+	opts := Options{Journal: filepath.Join(dir, "journal")}
+
+	// the changes stuck in one FS are made by the next one
+	gate := make(chan struct{})
+	fs, err := NewWithOptions(fsync.OS, local, gateFS{fsync.OS, gate}, remote, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.MkdirAll(local, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.Create(filepath.Join(local, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("file a"))
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		close(gate)
+		fs.Close()
+	}()
+
+	remoteFS := &failFS{fsync.OS, 2}
+	opts.Retries, opts.RetryDelay = 2, time.Millisecond
+	fs2, err := NewWithOptions(fsync.OS, local, remoteFS, remote, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := fs2.Queue(); len(q) != 2 || q[0].Op != "mkdir" || q[1].Op != "upload" {
+		t.Errorf("wrong queue: %+v", q)
+	}
+	if err := fs2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(remote, "a")); err != nil || string(data) != "file a" {
+		t.Errorf("wrong remote a: %q, %v", data, err)
+	}
+
+	// the changes that were made aren't made again
+	fs3, err := NewWithOptions(fsync.OS, local, remoteFS, remote, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := fs3.Pending(); n != 0 {
+		t.Errorf("expecting nothing pending, got %d", n)
+	}
+	fs3.Close()
+}
+
+func TestJournalReplay(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "tierfs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, "local")
+	remote := filepath.Join(dir, "remote")
+	opts := Options{Journal: filepath.Join(dir, "journal")}
+
+	// a file renamed before it's uploaded is replayed from its copy
+	gate := make(chan struct{})
+	fs, err := NewWithOptions(fsync.OS, local, gateFS{fsync.OS, gate}, remote, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		close(gate)
+		fs.Close()
+	}()
+	if err := fs.MkdirAll(local, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.Create(filepath.Join(local, ".a.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("file a"))
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename(filepath.Join(local, ".a.tmp"), filepath.Join(local, "a")); err != nil {
+		t.Fatal(err)
+	}
+
+	fs2, err := NewWithOptions(fsync.OS, local, fsync.OS, remote, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs2.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(remote, "a")); err != nil || string(data) != "file a" {
+		t.Errorf("wrong remote a: %q, %v", data, err)
+	}
+	if files, err := ioutil.ReadDir(opts.Journal + ".spool"); err != nil || len(files) != 0 {
+		t.Errorf("expecting the spool to be empty, got %v, %v", files, err)
+	}
+
+	// changes that fail are reported, and left for the next start
+	if err := ioutil.WriteFile(filepath.Join(local, "b"), []byte("file b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs2.Chmod(filepath.Join(local, "b"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs2.Close(); !os.IsNotExist(err) {
+		t.Errorf("expecting the chmod to fail, got %v", err)
+	}
+	fs3, err := NewWithOptions(fsync.OS, local, fsync.OS, remote, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs3.Close(); !os.IsNotExist(err) {
+		t.Errorf("expecting the chmod to be tried again, got %v", err)
+	}
+}
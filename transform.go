@@ -0,0 +1,48 @@
+package fsync
+
+import "io"
+
+// Transformer changes the contents of the files matching Pattern, as in
+// Exclude, as they're copied.
+type Transformer struct {
+	Pattern string
+	// Transform returns the changed contents of the file read from r.
+	Transform func(r io.Reader) io.Reader
+}
+
+// transformed returns true if Transformers or LineEndings change the
+// source file src.
+func (r *run) transformed(src string) bool {
+	if r.LineEndings != PreserveLineEndings {
+		return true
+	}
+	for _, t := range r.Transformers {
+		if matchPattern(t.Pattern, r.rel(src), false) {
+			return true
+		}
+	}
+	return false
+}
+
+// transform returns the contents of the source file src, read from in, as
+// changed by Transformers and then LineEndings.
+func (r *run) transform(src string, in io.Reader) io.Reader {
+	for _, t := range r.Transformers {
+		if matchPattern(t.Pattern, r.rel(src), false) {
+			in = t.Transform(in)
+		}
+	}
+	if r.LineEndings != PreserveLineEndings {
+		in = newlines(in, r.LineEndings == WindowsLineEndings)
+	}
+	return in
+}
+
+// transformerPatterns returns the patterns of Transformers.
+func (s *Syncer) transformerPatterns() []string {
+	var patterns []string
+	for _, t := range s.Transformers {
+		patterns = append(patterns, t.Pattern)
+	}
+	return patterns
+}
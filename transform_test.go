@@ -0,0 +1,50 @@
+package fsync
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// upper is a Transform that changes letters to upper case.
+func upper(r io.Reader) io.Reader {
+	data, err := ioutil.ReadAll(r)
+	check(err)
+	return bytes.NewReader(bytes.ToUpper(data))
+}
+
+func TestTransformers(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a.txt"), []byte("file a"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "b"), []byte("file b"), 0644))
+
+	s := NewSyncer()
+	s.Transformers = []Transformer{{Pattern: "*.txt", Transform: upper}}
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "a.txt"), []byte("FILE A"), t)
+	testFile(filepath.Join(dst, "b"), []byte("file b"), t)
+
+	// files are compared as changed
+	stats, err := s.SyncStats(dst, src)
+	check(err)
+	if stats.Files != 0 || stats.Unchanged != 2 {
+		t.Errorf("expecting nothing to be copied, got %+v", stats)
+	}
+	check(ioutil.WriteFile(filepath.Join(src, "a.txt"), []byte("file A"), 0644))
+	stats, err = s.SyncStats(dst, src)
+	check(err)
+	if stats.Files != 0 {
+		t.Errorf("expecting nothing to be copied, got %+v", stats)
+	}
+	check(ioutil.WriteFile(filepath.Join(src, "a.txt"), []byte("file a, longer"), 0644))
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "a.txt"), []byte("FILE A, LONGER"), t)
+}
@@ -0,0 +1,51 @@
+package fsync
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrNoTrash = errors.New("fsync: no trash for the destination")
+)
+
+// Trash, as DeleteTo, makes deleted files be moved to the trash of the
+// user. Only local destinations have one, and not on all platforms.
+const Trash = "trash:"
+
+// quarantine moves dst, which is about to be deleted, to DeleteTo, and
+// returns true if it did.
+func (r *run) quarantine(dst string) bool {
+	switch r.DeleteTo {
+	case "":
+		return false
+	case Trash:
+		if _, ok := r.dfs.(osFS); !ok {
+			panic(ErrNoTrash)
+		}
+		check(trash(dst))
+		return true
+	}
+	rel, err := filepath.Rel(r.dstRoot, dst)
+	check(err)
+	name := filepath.Join(r.deleteTo(), rel)
+	r.mkdirAll(filepath.Dir(name))
+	for i := 1; ; i++ {
+		if _, err := r.dfs.Stat(name); os.IsNotExist(err) {
+			break
+		}
+		name = fmt.Sprintf("%s.%d", filepath.Join(r.deleteTo(), rel), i)
+	}
+	check(r.dfs.Rename(dst, name))
+	return true
+}
+
+// deleteTo returns the name of DeleteTo in the destination file system.
+func (r *run) deleteTo() string {
+	if filepath.IsAbs(r.DeleteTo) {
+		return r.DeleteTo
+	}
+	return filepath.Join(r.dstRoot, r.DeleteTo)
+}
@@ -0,0 +1,23 @@
+package fsync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// trash moves name to the trash of the user.
+func trash(name string) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	to := filepath.Join(home, ".Trash", filepath.Base(name))
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(to); os.IsNotExist(err) {
+			break
+		}
+		to = filepath.Join(home, ".Trash", fmt.Sprintf("%s.%d", filepath.Base(name), i))
+	}
+	return os.Rename(name, to)
+}
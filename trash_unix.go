@@ -0,0 +1,59 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package fsync
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// trash moves name to the trash, as in the FreeDesktop.org Trash
+// specification.
+func trash(name string) error {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	dir = filepath.Join(dir, "Trash")
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return err
+	}
+	for _, d := range []string{"files", "info"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0700); err != nil {
+			return err
+		}
+	}
+	base := filepath.Base(name)
+	for i := 1; ; i++ {
+		// the info file reserves the name
+		info := filepath.Join(dir, "info", base+".trashinfo")
+		f, err := os.OpenFile(info, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			base = fmt.Sprintf("%s.%d", filepath.Base(name), i)
+			continue
+		} else if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(f, "[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+			(&url.URL{Path: abs}).EscapedPath(), time.Now().Format("2006-01-02T15:04:05"))
+		if err2 := f.Close(); err == nil {
+			err = err2
+		}
+		if err == nil {
+			err = os.Rename(name, filepath.Join(dir, "files", base))
+		}
+		if err != nil {
+			os.Remove(info)
+		}
+		return err
+	}
+}
@@ -0,0 +1,4 @@
+package fsync
+
+// trash fails, as the Recycle Bin is only reachable through the shell.
+func trash(name string) error { return ErrNoTrash }
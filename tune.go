@@ -0,0 +1,117 @@
+package fsync
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SetWorkers sets Workers, changing the number of workers of the syncs in
+// progress too. Extra workers quit after the file they are copying. Syncs
+// that were started without workers keep copying files one at a time, and
+// those started with workers keep at least one. It's safe to call while
+// Sync runs in another goroutine, unlike setting Workers.
+func (s *Syncer) SetWorkers(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Workers = n
+	for r := range s.runs {
+		if r.jobs != nil {
+			r.resize(n)
+		}
+	}
+}
+
+// SetRateLimit sets RateLimit, changing the limit of the syncs in progress
+// too. It's safe to call while Sync runs in another goroutine, unlike
+// setting RateLimit.
+func (s *Syncer) SetRateLimit(bytesPerSec int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.RateLimit = bytesPerSec
+	for r := range s.runs {
+		r.limit.set(bytesPerSec)
+	}
+}
+
+// SetVerbosity sets Verbosity, changing the verbosity of the syncs in
+// progress too. It's safe to call while Sync runs in another goroutine,
+// unlike setting Verbosity.
+func (s *Syncer) SetVerbosity(v Verbosity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Verbosity = v
+	for r := range s.runs {
+		atomic.StoreInt32(&r.verbosity, int32(v))
+	}
+}
+
+// limiter spreads the bytes read by the copies of a run over time.
+type limiter struct {
+	mu    sync.Mutex
+	rate  int64     // bytes per second; zero for no limit
+	next  time.Time // when the bytes read so far are due
+	clock Clock
+}
+
+func (l *limiter) set(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+}
+
+// limited returns true if there is a limit.
+func (l *limiter) limited() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate > 0
+}
+
+// burst returns how many bytes may be read at once: a tenth of a second's
+// worth, so that changes of the limit soon take effect.
+func (l *limiter) burst() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+	if n := l.rate / 10; n < 512 {
+		return 512
+	} else if n < 1<<20 {
+		return int(n)
+	}
+	return 1 << 20
+}
+
+// wait sleeps until reading n more bytes is within the limit.
+func (l *limiter) wait(n int) {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	now := l.clock.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(l.rate))
+	d := l.next.Sub(now)
+	l.mu.Unlock()
+	<-l.clock.After(d)
+}
+
+// limitReader reads from r within the limit of l.
+type limitReader struct {
+	r io.Reader
+	l *limiter
+}
+
+func (lr *limitReader) Read(p []byte) (int, error) {
+	if max := lr.l.burst(); max > 0 && len(p) > max {
+		p = p[:max]
+	}
+	n, err := lr.r.Read(p)
+	lr.l.wait(n)
+	return n, err
+}
@@ -0,0 +1,100 @@
+package fsync
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockFS counts the files being opened and blocks them until release is
+// closed.
+type blockFS struct {
+	FS
+	mu      sync.Mutex
+	open    int
+	release chan bool
+}
+
+func (fs *blockFS) Open(name string) (io.ReadCloser, error) {
+	fs.mu.Lock()
+	fs.open++
+	fs.mu.Unlock()
+	<-fs.release
+	return fs.FS.Open(name)
+}
+
+func (fs *blockFS) opened() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.open
+}
+
+func TestSetWorkers(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	for i := 0; i < 5; i++ {
+		check(ioutil.WriteFile(filepath.Join(src, fmt.Sprint(i)), []byte("file"), 0644))
+	}
+
+	fs := &blockFS{FS: OS, release: make(chan bool)}
+	s := NewSyncer()
+	s.Workers = 1
+	s.SrcFS = fs
+	done := make(chan error)
+	go func() { done <- s.Sync(dst, src) }()
+	// wait for the only worker, then add two
+	for fs.opened() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	s.SetWorkers(3)
+	for start := time.Now(); fs.opened() < 3; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("expecting 3 files copied at once, got %d", fs.opened())
+		}
+	}
+	close(fs.release)
+	check(<-done)
+	testDirContents(dst, 5, t)
+}
+
+func TestSetRateLimit(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), make([]byte, 20000), 0644))
+
+	s := NewSyncer()
+	s.RateLimit = 100000
+	start := time.Now()
+	check(s.Sync(dst, src))
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("20000 bytes copied in %v at 100000 bytes per second", d)
+	}
+
+	// lifting the limit during a sync takes effect right away
+	check(ioutil.WriteFile(filepath.Join(src, "b"), make([]byte, 100000), 0644))
+	s.RateLimit = 1000
+	done := make(chan error)
+	go func() { done <- s.Sync(dst, src) }()
+	time.Sleep(100 * time.Millisecond)
+	s.SetRateLimit(0)
+	select {
+	case err := <-done:
+		check(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("sync still limited")
+	}
+	testFile(filepath.Join(dst, "b"), make([]byte, 100000), t)
+}
@@ -0,0 +1,117 @@
+package fsync
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Usage reports the space a tree of files takes up.
+type Usage struct {
+	Path string
+	// Size is the total size of the regular files in the tree, counting
+	// files hard-linked more than once in the tree only once. Unique is the
+	// part of it in files that are not linked from outside the tree, which
+	// is the space that removing the tree frees. Shared is the rest.
+	Size, Unique, Shared int64
+}
+
+// DiskUsage reports the space used by each of trees. Files hard-linked
+// between the trees, or to files elsewhere, count as shared. The trees should
+// not overlap.
+func DiskUsage(trees ...string) ([]Usage, error) {
+	usage := newSpaceUsage()
+	for i, tree := range trees {
+		if err := usage.add(i, tree); err != nil {
+			return nil, err
+		}
+	}
+	us := make([]Usage, len(trees))
+	for i, tree := range trees {
+		us[i] = usage.usage(i)
+		us[i].Path = tree
+	}
+	return us, nil
+}
+
+// spaceUsage records which trees use which files, so that space shared
+// through hard links is only counted once.
+type spaceUsage struct {
+	files map[fileID]*usedFile
+	next  uint64 // fake inode numbers for platforms without them
+}
+
+type usedFile struct {
+	size  int64
+	nlink uint64       // link count reported by the file system
+	found uint64       // links found in the trees
+	trees map[int]bool // trees that link to the file
+}
+
+func newSpaceUsage() *spaceUsage {
+	return &spaceUsage{files: make(map[fileID]*usedFile)}
+}
+
+// add walks the tree at path and records its regular files as used by tree.
+func (u *spaceUsage) add(tree int, path string) error {
+	return filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		id, nlink, ok := inode(info)
+		if !ok {
+			u.next++
+			id, nlink = fileID{^uint64(0), u.next}, 1
+		}
+		f := u.files[id]
+		if f == nil {
+			f = &usedFile{size: info.Size(), nlink: nlink, trees: make(map[int]bool)}
+			u.files[id] = f
+		}
+		f.found++
+		f.trees[tree] = true
+		return nil
+	})
+}
+
+// size returns the total size of the files tree uses.
+func (u *spaceUsage) size(tree int) int64 {
+	var n int64
+	for _, f := range u.files {
+		if f.trees[tree] {
+			n += f.size
+		}
+	}
+	return n
+}
+
+// usage returns the usage of tree.
+func (u *spaceUsage) usage(tree int) Usage {
+	size := u.size(tree)
+	unique := u.reclaimable(map[int]bool{tree: true})
+	return Usage{Size: size, Unique: unique, Shared: size - unique}
+}
+
+// reclaimable returns the size of the files that are only used by trees, and
+// not linked from outside of the recorded trees either.
+func (u *spaceUsage) reclaimable(trees map[int]bool) int64 {
+	var n int64
+	for _, f := range u.files {
+		if f.found < f.nlink {
+			continue
+		}
+		only := len(f.trees) > 0
+		for t := range f.trees {
+			if !trees[t] {
+				only = false
+				break
+			}
+		}
+		if only {
+			n += f.size
+		}
+	}
+	return n
+}
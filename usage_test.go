@@ -0,0 +1,39 @@
+package fsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskUsage(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	check(os.MkdirAll(filepath.Join(a, "sub"), 0755))
+	check(os.MkdirAll(b, 0755))
+
+	// a has a file linked twice inside it, b has a file linked from a
+	// file outside both trees, and they share one file
+	check(ioutil.WriteFile(filepath.Join(a, "own"), make([]byte, 100), 0644))
+	check(os.Link(filepath.Join(a, "own"), filepath.Join(a, "sub/own")))
+	check(ioutil.WriteFile(filepath.Join(dir, "outside"), make([]byte, 20), 0644))
+	check(os.Link(filepath.Join(dir, "outside"), filepath.Join(b, "linked")))
+	check(ioutil.WriteFile(filepath.Join(a, "shared"), make([]byte, 5), 0644))
+	check(os.Link(filepath.Join(a, "shared"), filepath.Join(b, "shared")))
+
+	us, err := DiskUsage(a, b)
+	check(err)
+	testUsage(us[0], 105, 100, t)
+	testUsage(us[1], 25, 0, t)
+}
+
+func testUsage(u Usage, size, unique int64, t *testing.T) {
+	if u.Size != size || u.Unique != unique || u.Shared != size-unique {
+		t.Errorf("%s uses %d bytes (%d unique, %d shared), should use %d "+
+			"(%d unique).\n", u.Path, u.Size, u.Unique, u.Shared, size, unique)
+	}
+}
@@ -0,0 +1,51 @@
+package fsync
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// vanishFS deletes the files named "gone" when they're opened.
+type vanishFS struct{ osFS }
+
+func (fs vanishFS) Open(name string) (io.ReadCloser, error) {
+	if filepath.Base(name) == "gone" {
+		check(os.Remove(name))
+	}
+	return fs.osFS.Open(name)
+}
+
+func TestVanished(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "a"), 0755))
+	check(os.MkdirAll(filepath.Join(src, "b"), 0755))
+	check(os.MkdirAll(filepath.Join(dst, "b"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a/gone"), []byte("file a"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "b/gone"), []byte("file b"), 0644))
+	check(ioutil.WriteFile(filepath.Join(dst, "b/gone"), []byte("file x"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "c"), []byte("file c"), 0644))
+
+	s := NewSyncer()
+	s.SrcFS = vanishFS{}
+	s.Verbosity = Verbose
+	var vanished []string
+	s.OnEvent = func(e Event) {
+		if e.Op == OpSkip && e.Reason == Vanished {
+			vanished = append(vanished, e.Path)
+		}
+	}
+	check(s.Sync(dst, src))
+	if len(vanished) != 2 || vanished[0] != "a/gone" || vanished[1] != "b/gone" {
+		t.Errorf("expecting a/gone and b/gone to vanish, got %v", vanished)
+	}
+	testExistence(filepath.Join(dst, "a/gone"), false, t)
+	testFile(filepath.Join(dst, "b/gone"), []byte("file x"), t)
+	testFile(filepath.Join(dst, "c"), []byte("file c"), t)
+}
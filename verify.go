@@ -0,0 +1,279 @@
+package fsync
+
+import (
+	"math"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// verifyTolerance is the fraction of differing files that Verification
+// Confidence is given for.
+const verifyTolerance = 0.01
+
+// Verification is the result of Verify.
+type Verification struct {
+	// Files is the number of files in the source.
+	Files int
+	// Checked is the number of files that were compared.
+	Checked int
+	// Mismatches lists the files, by slash-separated path relative to the
+	// source, that are missing in the destination or differ from the source.
+	Mismatches []string
+	// Full is true if every file was compared, either because percent was
+	// 100 or because the sample had mismatches.
+	Full bool
+	// Confidence is the probability that the sample would have contained a
+	// mismatch if 1% of the files differed. It's 1 for full verifications.
+	Confidence float64
+	// Seed is what the sample was drawn with; see Syncer.Seed.
+	Seed int64
+}
+
+// Verify compares the contents of a sample of files in src with their copies
+// in dst.
+func Verify(dst, src string, percent float64) (*Verification, error) {
+	return NewSyncer().Verify(dst, src, percent)
+}
+
+// Verify compares the contents of percent% of the files in src, at least one,
+// with their copies in dst. The sample is weighted towards recently modified
+// and larger files, which are more likely to have changed or been damaged.
+// If any of them differ, the rest of the files are compared too.
+//
+// Files are always compared by content, or by checksum if dst or src is a
+// Hasher, regardless of the Comparison field.
+func (s *Syncer) Verify(dst, src string, percent float64) (*Verification, error) {
+	r, dst, src, err := s.newRun(dst, src)
+	if err != nil {
+		return nil, err
+	}
+	defer r.close()
+	r.cmp = CompareContent
+
+	var files []sourceFile
+	if err := catch(func() { files = r.files(src, "") }); err != nil {
+		return nil, err
+	}
+	v := &Verification{Files: len(files), Seed: r.stats.Seed}
+	n := int(math.Ceil(float64(len(files)) * percent / 100))
+	if n < 1 {
+		n = 1
+	}
+	if n >= len(files) {
+		n = len(files)
+		v.Full = true
+	}
+	sample(files, n, r.clock().Now(), r.rnd)
+	err = catch(func() {
+		for i, f := range files {
+			if i == n && (v.Full || len(v.Mismatches) == 0) {
+				break
+			}
+			if i == n {
+				v.Full = true // escalate
+			}
+			name := filepath.FromSlash(f.path)
+			if !r.equal(filepath.Join(dst, name), filepath.Join(src, name)) {
+				v.Mismatches = append(v.Mismatches, f.path)
+			}
+			v.Checked++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	v.Confidence = confidence(v.Files, v.Checked)
+	return v, nil
+}
+
+// sourceFile is a file found by run.files.
+type sourceFile struct {
+	path string // slash-separated, relative to the source
+	info os.FileInfo
+	key  float64 // sampling key
+}
+
+// files lists the regular files in the tree src, with slash-separated paths
+// relative to it. rel is the path of src in the tree being listed.
+func (r *run) files(src, rel string) []sourceFile {
+	fi, err := r.sfs.Stat(src)
+	check(err)
+	if !fi.IsDir() {
+		return []sourceFile{{path: rel, info: fi}}
+	}
+	infos, err := r.sfs.ReadDir(src)
+	check(err)
+	var files []sourceFile
+	for _, fi := range infos {
+		name := fi.Name()
+		if fi.IsDir() {
+			files = append(files, r.files(filepath.Join(src, name),
+				path.Join(rel, name))...)
+		} else if fi.Mode().IsRegular() {
+			files = append(files, sourceFile{path: path.Join(rel, name), info: fi})
+		}
+	}
+	return files
+}
+
+// sample moves a weighted random sample of n files to the front of files.
+// Files modified in the last month weigh about twice as much as older ones,
+// and weights grow with the logarithm of the size.
+func sample(files []sourceFile, n int, now time.Time, rnd *rand.Rand) {
+	const month = 30 * 24 * time.Hour
+	for i := range files {
+		fi := files[i].info
+		age := now.Sub(fi.ModTime())
+		if age < 0 {
+			age = 0
+		}
+		w := math.Log2(float64(fi.Size())+2) *
+			(1 + math.Exp2(-float64(age)/float64(month)))
+		// weighted sampling without replacement takes the n largest
+		// keys u^(1/w) (Efraimidis and Spirakis)
+		files[i].key = math.Pow(rnd.Float64(), 1/w)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].key > files[j].key
+	})
+}
+
+// confidence returns the probability that checking n of total files finds at
+// least one mismatch if verifyTolerance of them differ.
+func confidence(total, n int) float64 {
+	if n >= total {
+		return 1
+	}
+	bad := int(math.Ceil(float64(total) * verifyTolerance))
+	// probability of drawing only good files, without replacement
+	miss := 1.0
+	for i := 0; i < n; i++ {
+		miss *= float64(total-bad-i) / float64(total-i)
+		if miss <= 0 {
+			return 1
+		}
+	}
+	return 1 - miss
+}
+
+// MismatchKind is how a file differs between the source and destination.
+type MismatchKind int
+
+const (
+	Missing        MismatchKind = iota // it's missing in the destination
+	Extra                              // it's only in the destination
+	ContentDiffers                     // the contents differ
+	PermDiffers                        // the permissions differ
+	TypeDiffers                        // it's a file on one side and a directory on the other
+)
+
+var mismatchNames = [...]string{"missing", "extra", "content differs", "permissions differ", "type differs"}
+
+func (k MismatchKind) String() string {
+	if k < 0 || int(k) >= len(mismatchNames) {
+		return "unknown"
+	}
+	return mismatchNames[k]
+}
+
+// Mismatch is a difference VerifyAll found.
+type Mismatch struct {
+	Path string // relative to the source, slash-separated
+	Kind MismatchKind
+}
+
+// DiffReport is the result of VerifyAll.
+type DiffReport struct {
+	// Checked is the number of files and directories in the source that
+	// were compared.
+	Checked int
+	// Mismatches lists the differences, in the order of the paths.
+	Mismatches []Mismatch
+}
+
+// VerifyAll compares all the files in src with their copies in dst.
+func VerifyAll(dst, src string) (*DiffReport, error) {
+	return NewSyncer().VerifyAll(dst, src)
+}
+
+// VerifyAll compares all the files and directories in src with their
+// copies in dst, by content and permissions, and reports all the
+// differences, without changing anything. Files excluded are left out, and
+// so are their counterparts in dst, as in a sync. Unlike Verify, nothing is
+// left to chance, so it reads every file.
+func (s *Syncer) VerifyAll(dst, src string) (*DiffReport, error) {
+	r, dst, src, err := s.newRun(dst, src)
+	if err != nil {
+		return nil, err
+	}
+	defer r.close()
+	if err := checkPatterns(s.Exclude, s.Include); err != nil {
+		return nil, err
+	}
+	r.cmp = CompareContent
+	r.root, r.dstRoot = src, dst
+	d := &DiffReport{}
+	if err := catch(func() { r.verifyAll(d, dst, src) }); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(d.Mismatches, func(i, j int) bool {
+		return d.Mismatches[i].Path < d.Mismatches[j].Path
+	})
+	return d, nil
+}
+
+// verifyAll adds the differences between dst and src to d.
+func (r *run) verifyAll(d *DiffReport, dst, src string) {
+	sstat, err := r.sfs.Stat(src)
+	check(err)
+	dstat, err := r.dfs.Stat(dst)
+	if err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
+	d.Checked++
+	mismatch := func(k MismatchKind) {
+		d.Mismatches = append(d.Mismatches, Mismatch{Path: r.rel(src), Kind: k})
+	}
+	switch {
+	case dstat == nil:
+		mismatch(Missing)
+		return
+	case dstat.IsDir() != sstat.IsDir():
+		mismatch(TypeDiffers)
+		return
+	case !sstat.IsDir() && !r.equal(dst, src):
+		mismatch(ContentDiffers)
+	}
+	if r.permDiffers(dstat, sstat) {
+		mismatch(PermDiffers)
+	}
+	if !sstat.IsDir() {
+		return
+	}
+
+	files, err := r.sfs.ReadDir(src)
+	check(err)
+	inSrc := make(map[string]bool, len(files))
+	for _, file := range files {
+		src2 := filepath.Join(src, file.Name())
+		inSrc[file.Name()] = true
+		if r.excluded(src2, file) && !r.descend(src2, file) {
+			continue
+		}
+		r.verifyAll(d, filepath.Join(dst, file.Name()), src2)
+	}
+	extras, err := r.dfs.ReadDir(dst)
+	check(err)
+	for _, file := range extras {
+		dst2 := filepath.Join(dst, file.Name())
+		src2 := filepath.Join(src, file.Name())
+		if inSrc[file.Name()] || isTemp(file.Name()) || r.isBackup(dst2) || r.excluded(src2, file) {
+			continue
+		}
+		d.Mismatches = append(d.Mismatches, Mismatch{Path: r.rel(src2), Kind: Extra})
+	}
+}
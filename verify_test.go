@@ -0,0 +1,130 @@
+package fsync
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "a"), 0755))
+	for i := 0; i < 100; i++ {
+		name := filepath.Join(src, fmt.Sprintf("%02d", i))
+		if i%2 == 0 {
+			name = filepath.Join(src, "a", fmt.Sprintf("%02d", i))
+		}
+		check(ioutil.WriteFile(name, []byte(name), 0644))
+	}
+	check(Sync(dst, src))
+
+	v, err := Verify(dst, src, 10)
+	check(err)
+	if v.Files != 100 || v.Checked != 10 || v.Full || len(v.Mismatches) != 0 {
+		t.Errorf("unexpected verification %+v.\n", v)
+	}
+	if v.Confidence < 0.09 || v.Confidence > 0.11 {
+		t.Errorf("expecting confidence 0.1, got %v.\n", v.Confidence)
+	}
+
+	// damage every copy so that the sample is bound to find one
+	check(filepath.Walk(dst, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			err = ioutil.WriteFile(path, []byte("damaged"), 0644)
+		}
+		return err
+	}))
+	check(os.Remove(filepath.Join(dst, "01")))
+	v, err = Verify(dst, src, 1)
+	check(err)
+	if !v.Full || v.Checked != 100 || len(v.Mismatches) != 100 || v.Confidence != 1 {
+		t.Errorf("expecting escalation to full verification, got %+v.\n", v)
+	}
+	sort.Strings(v.Mismatches)
+	if v.Mismatches[0] != "01" || v.Mismatches[50] != "a/00" {
+		t.Errorf("expecting slash-separated paths, got %v and %v", v.Mismatches[0], v.Mismatches[50])
+	}
+}
+
+func TestSampleSeed(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	for i := 0; i < 100; i++ {
+		check(ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%02d", i)), []byte("file"), 0644))
+	}
+	r, _, src, err := NewSyncer().newRun("", dir)
+	check(err)
+	defer r.close()
+	all := r.files(src, "")
+	now := time.Now()
+	draw := func(seed int64) []string {
+		files := append([]sourceFile(nil), all...)
+		sample(files, 10, now, rand.New(rand.NewSource(seed)))
+		var paths []string
+		for _, f := range files[:10] {
+			paths = append(paths, f.path)
+		}
+		return paths
+	}
+	a, b := draw(1), draw(1)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("samples with the same seed differ: %v and %v", a, b)
+	}
+	if c := draw(2); reflect.DeepEqual(a, c) {
+		t.Errorf("samples with different seeds are the same: %v", a)
+	}
+}
+
+func TestVerifyAll(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "a"), 0755))
+	for _, name := range []string{"a/x", "a/y", "b", "c", "d", "e.tmp"} {
+		check(ioutil.WriteFile(filepath.Join(src, name), []byte("file "+name), 0644))
+	}
+	check(Sync(dst, src))
+	d, err := VerifyAll(dst, src)
+	check(err)
+	if d.Checked != 8 || len(d.Mismatches) != 0 {
+		t.Errorf("expecting no mismatches, got %+v", d)
+	}
+
+	check(os.Remove(filepath.Join(dst, "a/x")))
+	check(ioutil.WriteFile(filepath.Join(dst, "a/y"), []byte("file a/z"), 0644))
+	for _, name := range []string{"a/y", "b"} {
+		check(os.Chmod(filepath.Join(dst, name), 0600))
+	}
+	check(os.Remove(filepath.Join(dst, "c")))
+	check(os.Mkdir(filepath.Join(dst, "c"), 0755))
+	check(ioutil.WriteFile(filepath.Join(dst, "f"), []byte("file f"), 0644))
+	check(ioutil.WriteFile(filepath.Join(dst, "g.tmp"), []byte("file g"), 0644))
+	s := NewSyncer()
+	s.Exclude = []string{"*.tmp"}
+	d, err = s.VerifyAll(dst, src)
+	check(err)
+	want := []Mismatch{
+		{"a/x", Missing},
+		{"a/y", ContentDiffers},
+		{"a/y", PermDiffers},
+		{"b", PermDiffers},
+		{"c", TypeDiffers},
+		{"f", Extra},
+	}
+	if !reflect.DeepEqual(d.Mismatches, want) {
+		t.Errorf("expecting %v, got %v", want, d.Mismatches)
+	}
+}
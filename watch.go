@@ -0,0 +1,198 @@
+package fsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultWatchDelay is the WatchDelay used when it's zero.
+const DefaultWatchDelay = 100 * time.Millisecond
+
+var (
+	ErrNotLocal = errors.New("fsync: Watch needs a local source")
+)
+
+// Watch syncs dst with src, and then keeps it in sync as files in src change,
+// until ctx is done, when it returns ctx.Err(). Changes are picked up with
+// fsnotify, so src must be on the local file system. They are collected
+// until none came for WatchDelay, so that bursts such as editors saving
+// files are synced at once, and then only the files that changed are
+// synced. Errors of those syncs are reported to OnEvent and don't stop
+// Watch.
+func (s *Syncer) Watch(ctx context.Context, dst, src string) error {
+	if s.SrcFS != nil && s.SrcFS != OS {
+		return ErrNotLocal
+	}
+	if fs, _, err := openFS(nil, src); err != nil {
+		return err
+	} else if fs != OS {
+		closeFS(fs, nil)
+		return ErrNotLocal
+	}
+	src = filepath.Clean(src)
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	// watch before the first sync, so that no change is missed
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		err = watchTree(w, src)
+	} else {
+		err = w.Add(filepath.Dir(src))
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.Sync(dst, src); err != nil {
+		return err
+	}
+
+	delay := s.WatchDelay
+	if delay <= 0 {
+		delay = DefaultWatchDelay
+	}
+	changed := make(map[string]bool)
+	clock := s.clock()
+	var first time.Time       // of the changes collected
+	var wait <-chan time.Time // until they're synced
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-w.Errors:
+			return err
+		case e := <-w.Events:
+			if !within(e.Name, src) {
+				continue // a sibling of a source file
+			}
+			if e.Op&fsnotify.Create != 0 {
+				if fi, err := os.Stat(e.Name); err == nil && fi.IsDir() {
+					watchTree(w, e.Name) // errors show up as missed files
+				}
+			}
+			if len(changed) == 0 {
+				first = clock.Now()
+			}
+			changed[e.Name] = true
+			// wait for the burst to end, but not forever
+			if d := first.Add(10 * delay).Sub(clock.Now()); d < delay {
+				wait = clock.After(d)
+			} else {
+				wait = clock.After(delay)
+			}
+		case <-wait:
+			wait = nil
+			s.syncChanges(dst, src, changed)
+			changed = make(map[string]bool)
+		}
+	}
+}
+
+// watchTree adds the directory dir and the directories in it to w.
+func watchTree(w *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.IsDir() {
+			return err
+		}
+		return w.Add(name)
+	})
+}
+
+// syncChanges syncs the files and directories in changed, which are names
+// in src, with their counterparts in dst.
+func (s *Syncer) syncChanges(dst, src string, changed map[string]bool) {
+	var names []string
+	for name := range changed {
+		names = append(names, name)
+	}
+	sort.Strings(names) // directories go before what's in them
+	r, dst, src, err := s.newRun(dst, src)
+	if err != nil {
+		if s.OnEvent != nil {
+			s.OnEvent(Event{Op: OpError, Err: err})
+		}
+		return
+	}
+	r.start(src)
+	err = r.scan(func() {
+		last := ""
+		for _, name := range names {
+			if last != "" && within(name, last) {
+				continue // synced with last
+			}
+			if r.excludedPath(name) {
+				continue
+			}
+			last = name
+			rel, err := filepath.Rel(src, name)
+			check(err)
+			d := filepath.Join(dst, rel)
+			if _, err := r.sfs.Stat(name); os.IsNotExist(err) {
+				if r.Delete {
+					r.stats.Deleted++
+					r.emit(Verbose, name, Event{Op: OpDelete, Item: itemDeleting})
+					if !r.DryRun {
+						check(r.dfs.RemoveAll(d))
+					}
+				}
+				continue
+			}
+			parents := r.mkdirs(filepath.Dir(d), filepath.Dir(name))
+			r.sync(d, name)
+			// after what's created in them
+			if r.jobs != nil {
+				r.dirs = append(r.dirs, parents...)
+			} else {
+				for _, p := range parents {
+					r.syncstats(p.dst, p.src)
+				}
+			}
+		}
+	})
+	if err == nil {
+		err = catch(r.removePending)
+	}
+	r.finish()
+	if err2 := r.close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		r.emit(Quiet, "", Event{Op: OpError, Err: err})
+	}
+}
+
+// mkdirs creates the directory dst and its parents that are missing in the
+// destination, as their counterparts in the source, of which src is that of
+// dst, and returns them, so that their stats are synced.
+func (r *run) mkdirs(dst, src string) []job {
+	var missing []job
+	for d, sd := dst, src; within(sd, r.root); d, sd = filepath.Dir(d), filepath.Dir(sd) {
+		if _, err := r.dfs.Stat(d); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			panic(err)
+		}
+		missing = append(missing, job{d, sd})
+		r.stats.Dirs++
+		r.emit(Verbose, sd, Event{Op: OpMkdir, Item: itemNewDir})
+		if sd == r.root {
+			break
+		}
+	}
+	if !r.DryRun {
+		r.mkdirAll(dst) // permissions will be synced later
+	}
+	return missing
+}
@@ -0,0 +1,96 @@
+package fsync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitFor calls f until it returns true, and fails the test if it doesn't
+// within a few seconds.
+func waitFor(what string, f func() bool, t *testing.T) {
+	for start := time.Now(); !f(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(src, 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("file a"), 0644))
+
+	s := NewSyncer()
+	s.Delete = true
+	s.Exclude = []string{"*.tmp"}
+	s.WatchDelay = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Watch(ctx, dst, src) }()
+	waitFor("the first sync", func() bool { return exists(filepath.Join(dst, "a")) }, t)
+
+	check(ioutil.WriteFile(filepath.Join(src, "a"), []byte("file a changed"), 0644))
+	check(os.MkdirAll(filepath.Join(src, "b/c"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "b/c/d"), []byte("file d"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "b/e.tmp"), nil, 0644))
+	waitFor("b/c/d", func() bool { return exists(filepath.Join(dst, "b/c/d")) }, t)
+	waitFor("a to change", func() bool {
+		data, _ := ioutil.ReadFile(filepath.Join(dst, "a"))
+		return string(data) == "file a changed"
+	}, t)
+	// files in directories created during the watch are watched too
+	check(ioutil.WriteFile(filepath.Join(src, "b/c/f"), []byte("file f"), 0644))
+	waitFor("b/c/f", func() bool { return exists(filepath.Join(dst, "b/c/f")) }, t)
+	check(os.Remove(filepath.Join(src, "a")))
+	waitFor("a to be deleted", func() bool { return !exists(filepath.Join(dst, "a")) }, t)
+	if exists(filepath.Join(dst, "b/e.tmp")) {
+		t.Error("excluded file was synced")
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expecting context.Canceled, got %v", err)
+	}
+}
+
+func TestSyncChangesParents(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "a/b"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a/b/c"), []byte("file c"), 0644))
+	check(os.Chmod(filepath.Join(src, "a"), 0700))
+	check(os.Chmod(filepath.Join(src, "a/b"), 0750))
+	mtime := time.Unix(1e9, 0)
+	check(os.Chtimes(filepath.Join(src, "a"), mtime, mtime))
+	check(os.MkdirAll(dst, 0755))
+
+	s := NewSyncer()
+	s.syncChanges(dst, src, map[string]bool{filepath.Join(src, "a/b/c"): true})
+	testFile(filepath.Join(dst, "a/b/c"), []byte("file c"), t)
+	for name, perm := range map[string]os.FileMode{"a": 0700, "a/b": 0750} {
+		fi, err := os.Stat(filepath.Join(dst, name))
+		check(err)
+		if fi.Mode().Perm() != perm {
+			t.Errorf("%s has permissions %v, expecting %v", name, fi.Mode().Perm(), perm)
+		}
+		if name == "a" && !fi.ModTime().Equal(mtime) {
+			t.Errorf("a has modification time %v, expecting %v", fi.ModTime(), mtime)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package fsync
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// queueSize is how many files the scan may get ahead of the workers. It's
+// large enough for Boost to have pending files to move.
+const queueSize = 4096
+
+// job is a pair of destination and source names.
+type job struct {
+	dst, src string
+}
+
+// workers copies files in the background for a run with Workers.
+type workers struct {
+	jobs *queue // files to copy; nil without Workers
+	dirs []job  // directories to sync stats of after the copies
+	wg   sync.WaitGroup
+}
+
+// queue holds the files waiting for the workers.
+type queue struct {
+	mu      sync.Mutex
+	cond    sync.Cond // signaled when jobs or closed change
+	jobs    []job
+	closed  bool
+	err     error          // the first error of the workers
+	pending map[string]int // files queued or being copied, by destination directory
+	want    int            // number of workers asked for
+	limit   int            // number of jobs queued at most
+	running int            // number of workers
+}
+
+// startWorkers starts n goroutines that copy the files passed to enqueue.
+func (r *run) startWorkers(n int) {
+	q := &queue{limit: r.queueLimit()}
+	q.cond.L = &q.mu
+	r.jobs = q
+	r.resize(n)
+}
+
+// resize changes the number of workers to n, or 1 if n is smaller. Extra
+// workers quit after the file they are copying.
+func (r *run) resize(n int) {
+	if n < 1 {
+		n = 1
+	}
+	q := r.jobs
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed && q.running == 0 {
+		return // stopWorkers isn't waiting for new workers
+	}
+	q.want = n
+	for ; q.running < n; q.running++ {
+		r.wg.Add(1)
+		go r.work(q)
+	}
+	q.cond.Broadcast()
+}
+
+// work copies files from q until it's closed or the worker isn't needed.
+func (r *run) work(q *queue) {
+	defer r.wg.Done()
+	for {
+		j, ok := q.next()
+		if !ok {
+			return
+		}
+		err := catch(func() {
+			r.wait()
+			r.try(j.src, func() {
+				if r.copy(j.dst, j.src) {
+					r.syncstats(j.dst, j.src)
+				}
+			})
+		})
+		q.done(j)
+		if err != nil {
+			q.fail(err)
+		}
+	}
+}
+
+// enqueue passes a file to the workers to copy. Files under paths passed to
+// Boost go before the others. It panics with the error of a worker if one
+// has failed.
+func (r *run) enqueue(dst, src string) {
+	q := r.jobs
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.jobs) >= q.limit && q.err == nil {
+		q.cond.Wait()
+	}
+	if q.err != nil {
+		panic(q.err)
+	}
+	q.jobs = append(q.jobs, job{dst, src})
+	if q.pending == nil {
+		q.pending = make(map[string]int)
+	}
+	q.pending[filepath.Dir(dst)]++
+	if r.boosted(src) {
+		q.promote(r.boosted)
+	}
+	q.cond.Broadcast()
+}
+
+// next waits for a file to copy. It returns false when the queue is closed
+// and empty, or when there are more workers than asked for, in which case
+// the caller must quit.
+func (q *queue) next() (job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.jobs) == 0 && !q.closed && q.running <= q.want {
+		q.cond.Wait()
+	}
+	if q.running > q.want || len(q.jobs) == 0 {
+		q.running--
+		return job{}, false
+	}
+	j := q.jobs[0]
+	q.jobs = q.jobs[1:]
+	q.cond.Broadcast()
+	return j, true
+}
+
+// done records that the copy of j is over.
+func (q *queue) done(j job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	dir := filepath.Dir(j.dst)
+	if q.pending[dir]--; q.pending[dir] == 0 {
+		delete(q.pending, dir)
+	}
+}
+
+// copying returns true if files are queued or being copied into the
+// destination directory dir.
+func (r *run) copying(dir string) bool {
+	if r.jobs == nil {
+		return false
+	}
+	r.jobs.mu.Lock()
+	defer r.jobs.mu.Unlock()
+	return r.jobs.pending[dir] > 0
+}
+
+// fail records the first error of the workers and drops the queued files.
+func (q *queue) fail(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err == nil {
+		q.err = err
+		q.jobs = nil
+		q.cond.Broadcast()
+	}
+}
+
+// promote moves the jobs whose sources match to the front of the queue,
+// keeping their order. It must be called with mu held.
+func (q *queue) promote(match func(src string) bool) {
+	var front, back []job
+	for _, j := range q.jobs {
+		if match(j.src) {
+			front = append(front, j)
+		} else {
+			back = append(back, j)
+		}
+	}
+	q.jobs = append(front, back...)
+}
+
+// stopWorkers waits for the queued copies to finish and returns the first
+// error of the workers.
+func (r *run) stopWorkers() error {
+	q := r.jobs
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	r.wg.Wait()
+	return q.err
+}
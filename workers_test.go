@@ -0,0 +1,46 @@
+package fsync
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWorkers(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	tt := time.Now().Add(-time.Hour)
+	for i := 0; i < 10; i++ {
+		sub := filepath.Join(src, fmt.Sprint(i))
+		check(os.MkdirAll(sub, 0755))
+		for j := 0; j < 10; j++ {
+			name := filepath.Join(sub, fmt.Sprint(j))
+			check(ioutil.WriteFile(name, []byte(name), 0644))
+			check(os.Chtimes(name, tt, tt))
+		}
+		check(os.Chtimes(sub, tt, tt))
+	}
+	check(os.Chtimes(src, tt, tt))
+
+	s := NewSyncer()
+	s.Workers = 4
+	check(s.Sync(dst, src))
+	for i := 0; i < 10; i++ {
+		sub := fmt.Sprint(i)
+		testDirContents(filepath.Join(dst, sub), 10, t)
+		for j := 0; j < 10; j++ {
+			name := filepath.Join(sub, fmt.Sprint(j))
+			testFile(filepath.Join(dst, name), []byte(filepath.Join(src, name)), t)
+			testModTime(filepath.Join(dst, name), tt, t)
+		}
+		// directories are finished after the files copied into them
+		testModTime(filepath.Join(dst, sub), tt, t)
+	}
+	testModTime(dst, tt, t)
+}
@@ -0,0 +1,105 @@
+package fsync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// dstSums keeps what WriteSums needs to write the SumsFile of each
+// destination directory.
+type dstSums struct {
+	mu   sync.Mutex
+	dirs map[string]string // source directories by destination
+	sums map[string][]byte // checksums of the files copied, by destination
+}
+
+// summing records the destination directory dst, synced from src, to
+// write its SumsFile in.
+func (r *run) summing(dst, src string) {
+	if !r.WriteSums || r.DryRun {
+		return
+	}
+	r.dstSums.mu.Lock()
+	defer r.dstSums.mu.Unlock()
+	if r.dstSums.dirs == nil {
+		r.dstSums.dirs = make(map[string]string)
+	}
+	r.dstSums.dirs[dst] = src
+}
+
+// summed records the checksum of the destination file dst, computed as it
+// was copied.
+func (r *run) summed(dst string, sum []byte) {
+	if !r.WriteSums {
+		return
+	}
+	r.dstSums.mu.Lock()
+	defer r.dstSums.mu.Unlock()
+	if r.dstSums.sums == nil {
+		r.dstSums.sums = make(map[string][]byte)
+	}
+	r.dstSums.sums[dst] = sum
+}
+
+// writeSums writes the SumsFile of the destination directories that need
+// it, and syncs their modification times again.
+func (r *run) writeSums() {
+	for dst, src := range r.dstSums.dirs {
+		dst, src := dst, src
+		r.try(src, func() {
+			if r.writeDirSums(dst) {
+				r.syncstats(dst, src)
+			}
+		})
+	}
+}
+
+// writeDirSums writes the SumsFile of the destination directory dst, unless
+// it's up to date, and returns true if it did. Checksums of the files that
+// weren't copied are taken from the old one, unless they changed since.
+func (r *run) writeDirSums(dst string) bool {
+	files, err := r.dfs.ReadDir(dst)
+	if os.IsNotExist(err) {
+		return false
+	}
+	check(err)
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	name := filepath.Join(dst, SumsFile)
+	old := readSums(r.dfs, name)
+	var buf bytes.Buffer
+	same := old != nil
+	n := 0
+	for _, fi := range files {
+		dst2 := filepath.Join(dst, fi.Name())
+		if !fi.Mode().IsRegular() || fi.Name() == SumsFile || isTemp(fi.Name()) || r.isBackup(dst2) {
+			continue
+		}
+		r.dstSums.mu.Lock()
+		sum := r.dstSums.sums[dst2]
+		r.dstSums.mu.Unlock()
+		if sum == nil && old != nil && !old.time.Before(fi.ModTime()) {
+			sum = old.sums[fi.Name()]
+		}
+		if sum == nil {
+			sum = hashFile(r.dfs, dst2, sha256.New())
+		}
+		same = same && bytes.Equal(sum, old.sums[fi.Name()])
+		n++
+		fmt.Fprintf(&buf, "%x  %s\n", sum, fi.Name())
+	}
+	if same && n == len(old.sums) {
+		return false
+	}
+	f, err := r.dfs.Create(name)
+	check(err)
+	defer f.Close()
+	_, err = f.Write(buf.Bytes())
+	check(err)
+	check(f.Close())
+	return true
+}
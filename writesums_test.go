@@ -0,0 +1,52 @@
+package fsync
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteSums(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "fsync_test")
+	check(err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	check(os.MkdirAll(filepath.Join(src, "a"), 0755))
+	check(ioutil.WriteFile(filepath.Join(src, "a/x"), []byte("file x"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "a/y"), []byte("file y"), 0644))
+	check(ioutil.WriteFile(filepath.Join(src, "b"), []byte("file b"), 0644))
+	line := func(data, name string) string {
+		return fmt.Sprintf("%x  %s\n", sha256.Sum256([]byte(data)), name)
+	}
+
+	s := NewSyncer()
+	s.WriteSums = true
+	s.Delete = true
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, SumsFile), []byte(line("file b", "b")), t)
+	testFile(filepath.Join(dst, "a", SumsFile), []byte(line("file x", "x")+line("file y", "y")), t)
+	sfi, err := os.Stat(filepath.Join(src, "a"))
+	check(err)
+	dfi, err := os.Stat(filepath.Join(dst, "a"))
+	check(err)
+	if !sfi.ModTime().Equal(dfi.ModTime()) {
+		t.Errorf("expecting the modification time of a to be synced")
+	}
+
+	// changes are written, and the rest is left alone
+	later := time.Now().Add(time.Hour)
+	check(os.Chtimes(filepath.Join(dst, SumsFile), later, later))
+	check(ioutil.WriteFile(filepath.Join(src, "a/y"), []byte("new file y"), 0644))
+	check(s.Sync(dst, src))
+	testFile(filepath.Join(dst, "a", SumsFile), []byte(line("file x", "x")+line("new file y", "y")), t)
+	fi, err := os.Stat(filepath.Join(dst, SumsFile))
+	check(err)
+	if !fi.ModTime().Equal(later) {
+		t.Errorf("%s shouldn't be written again", SumsFile)
+	}
+}
@@ -0,0 +1,40 @@
+//go:build linux || darwin || freebsd || netbsd
+// +build linux darwin freebsd netbsd
+
+package fsync
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func (fs osFS) ListXattrs(name string) ([]string, error) { return fs.xattrs(name) }
+
+func (osFS) GetXattr(name, attr string) ([]byte, error) {
+	buf := make([]byte, 256)
+	for {
+		n, err := unix.Getxattr(name, attr, buf)
+		if err == unix.ERANGE {
+			buf = make([]byte, 2*len(buf))
+			continue
+		} else if err != nil {
+			return nil, &os.PathError{Op: "getxattr", Path: name, Err: err}
+		}
+		return buf[:n], nil
+	}
+}
+
+func (osFS) SetXattr(name, attr string, value []byte) error {
+	if err := unix.Setxattr(name, attr, value, 0); err != nil {
+		return &os.PathError{Op: "setxattr", Path: name, Err: err}
+	}
+	return nil
+}
+
+func (osFS) RemoveXattr(name, attr string) error {
+	if err := unix.Removexattr(name, attr); err != nil {
+		return &os.PathError{Op: "removexattr", Path: name, Err: err}
+	}
+	return nil
+}